@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+)
+
+// Role is what an authenticated client of the management API may do.
+type Role string
+
+// Roles of management API clients
+const (
+	// ReadRole may only read, with GET and HEAD requests
+	ReadRole Role = "read"
+	// AdminRole may make any request
+	AdminRole Role = "admin"
+)
+
+// ParseRole parses the name of a role.
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case ReadRole, AdminRole:
+		return role, nil
+	default:
+		return "", fmt.Errorf("invalid role %q: must be read or admin", name)
+	}
+}
+
+// allows reports whether the role permits a request of method.
+func (role Role) allows(method string) bool {
+	return role == AdminRole || method == http.MethodGet || method == http.MethodHead
+}
+
+type apiKey struct {
+	role Role
+	sum  [sha256.Size]byte
+}
+
+// authConfig holds the credentials accepted by the management API. With none
+// configured, the management API is not authenticated.
+type authConfig struct {
+	apiKeys   []apiKey
+	jwtSecret []byte
+	clientCAs map[Role]*x509.CertPool
+}
+
+var managementAuth authConfig
+
+// AddAPIKey accepts the static API key as a bearer token of role.
+func AddAPIKey(role Role, key string) {
+	managementAuth.apiKeys = append(managementAuth.apiKeys, apiKey{role: role, sum: sha256.Sum256([]byte(key))})
+}
+
+// SetJWTSecret accepts bearer tokens that are JWTs signed with HS256 using
+// secret. The role of a JWT is given by its "role" claim.
+func SetJWTSecret(secret []byte) {
+	managementAuth.jwtSecret = secret
+}
+
+// AddClientCAs accepts client certificates of role that chain to a CA in
+// pool. Listeners must request client certificates for them to be presented.
+func AddClientCAs(role Role, pool *x509.CertPool) {
+	if managementAuth.clientCAs == nil {
+		managementAuth.clientCAs = make(map[Role]*x509.CertPool)
+	}
+	managementAuth.clientCAs[role] = pool
+}
+
+// AuthEnabled reports whether the management API requires authentication.
+func AuthEnabled() bool {
+	return len(managementAuth.apiKeys) > 0 || managementAuth.jwtSecret != nil || len(managementAuth.clientCAs) > 0
+}
+
+// ClientCertsEnabled reports whether client certificates authenticate
+// management API requests.
+func ClientCertsEnabled() bool {
+	return len(managementAuth.clientCAs) > 0
+}
+
+var errUnauthenticated = errors.New("no valid credentials")
+
+// authMiddleware authenticates requests before any routing, so that requests
+// without valid credentials are answered with the same 401 whether or not
+// they name an existing resource. Authenticated clients whose role does not
+// permit the request are answered with 403.
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AuthEnabled() {
+			next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), UnauthenticatedActor)))
+			return
+		}
+		role, actor, err := authenticate(r)
+		if err != nil {
+			slog.Debug("Management API authentication failed", "remote", r.RemoteAddr, "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fdo"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !role.allows(r.Method) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(audit.WithActor(r.Context(), actor)))
+	})
+}
+
+// UnauthenticatedActor is the actor of audited management API requests when
+// authentication is not configured.
+const UnauthenticatedActor = "unauthenticated"
+
+// authenticate returns the role of the credentials of a request and the
+// actor they identify for auditing: the subject of a client certificate or
+// JWT, or a fingerprint of an API key. A verified client certificate takes
+// precedence over a bearer token.
+func authenticate(r *http.Request) (Role, string, error) {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		if role, ok := verifyClientCert(r.TLS.PeerCertificates); ok {
+			return role, "cert:" + r.TLS.PeerCertificates[0].Subject.CommonName, nil
+		}
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", "", errUnauthenticated
+	}
+	sum := sha256.Sum256([]byte(token))
+	for _, key := range managementAuth.apiKeys {
+		if subtle.ConstantTimeCompare(sum[:], key.sum[:]) == 1 {
+			return key.role, "api-key:" + hex.EncodeToString(sum[:4]), nil
+		}
+	}
+	if managementAuth.jwtSecret != nil && strings.Count(token, ".") == 2 {
+		role, subject, err := verifyJWT(token, managementAuth.jwtSecret, time.Now())
+		return role, "jwt:" + subject, err
+	}
+	return "", "", errUnauthenticated
+}
+
+// verifyClientCert returns the role whose CAs a client certificate chains to,
+// preferring the admin role.
+func verifyClientCert(chain []*x509.Certificate) (Role, bool) {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	for _, role := range []Role{AdminRole, ReadRole} {
+		pool, ok := managementAuth.clientCAs[role]
+		if !ok {
+			continue
+		}
+		if _, err := chain[0].Verify(x509.VerifyOptions{
+			Roots:         pool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}); err == nil {
+			return role, true
+		}
+	}
+	return "", false
+}
+
+// verifyJWT verifies a JWT signed with HS256 and returns the role and subject
+// of its claims. Tokens past their "exp" or before their "nbf" are rejected.
+func verifyJWT(token string, secret []byte, now time.Time) (Role, string, error) {
+	parts := strings.Split(token, ".")
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid JWT header: %w", err)
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return "", "", fmt.Errorf("invalid JWT header: %w", err)
+	}
+	// Only HS256 is accepted, in particular not "none"
+	if h.Alg != "HS256" {
+		return "", "", fmt.Errorf("unsupported JWT algorithm %q", h.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid JWT signature: %w", err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", "", errors.New("invalid JWT signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	var claims struct {
+		Role      string `json:"role"`
+		Subject   string `json:"sub"`
+		ExpiresAt *int64 `json:"exp"`
+		NotBefore *int64 `json:"nbf"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", "", fmt.Errorf("invalid JWT claims: %w", err)
+	}
+	if claims.ExpiresAt != nil && !now.Before(time.Unix(*claims.ExpiresAt, 0)) {
+		return "", "", errors.New("JWT has expired")
+	}
+	if claims.NotBefore != nil && now.Before(time.Unix(*claims.NotBefore, 0)) {
+		return "", "", errors.New("JWT is not valid yet")
+	}
+	role, err := ParseRole(claims.Role)
+	return role, claims.Subject, err
+}
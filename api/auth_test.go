@@ -0,0 +1,230 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func signJWT(t *testing.T, secret []byte, alg, claims string) string {
+	t.Helper()
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"`+alg+`","typ":"JWT"}`)) +
+		"." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	defer func() { managementAuth = authConfig{} }()
+
+	const knownGUID = "00112233445566778899aabbccddeeff"
+	routes := http.NewServeMux()
+	routes.HandleFunc("/api/v1/owner/devices/{guid}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("guid") != knownGUID {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := authMiddleware(routes)
+
+	do := func(method, path string, prepare func(*http.Request)) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if prepare != nil {
+			prepare(r)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+	known := "/api/v1/owner/devices/" + knownGUID + "/timeline"
+	unknown := "/api/v1/owner/devices/ffeeddccbbaa99887766554433221100/timeline"
+
+	// Without credentials configured, requests are not authenticated
+	if w := do(http.MethodGet, known, nil); w.Code != http.StatusOK {
+		t.Fatalf("got status %d without authentication configured", w.Code)
+	}
+
+	AddAPIKey(ReadRole, "read-key")
+	AddAPIKey(AdminRole, "admin-key")
+	secret := []byte("jwt-secret")
+	SetJWTSecret(secret)
+
+	t.Run("unauthorized responses do not reveal resources", func(t *testing.T) {
+		var responses []*httptest.ResponseRecorder
+		for _, path := range []string{known, unknown, "/api/v1/no-such-route"} {
+			for _, prepare := range []func(*http.Request){nil, bearer("wrong-key")} {
+				responses = append(responses, do(http.MethodGet, path, prepare))
+			}
+		}
+		for _, w := range responses {
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("got status %d, want 401", w.Code)
+			}
+			if w.Body.String() != responses[0].Body.String() || w.Header().Get("WWW-Authenticate") != responses[0].Header().Get("WWW-Authenticate") {
+				t.Errorf("401 responses differ: %q and %q", w.Body, responses[0].Body)
+			}
+		}
+	})
+
+	t.Run("api keys", func(t *testing.T) {
+		if w := do(http.MethodGet, known, bearer("read-key")); w.Code != http.StatusOK {
+			t.Errorf("read key GET: got status %d", w.Code)
+		}
+		if w := do(http.MethodGet, unknown, bearer("read-key")); w.Code != http.StatusNotFound {
+			t.Errorf("read key GET of unknown device: got status %d", w.Code)
+		}
+		if w := do(http.MethodDelete, known, bearer("read-key")); w.Code != http.StatusForbidden {
+			t.Errorf("read key DELETE: got status %d, want 403", w.Code)
+		}
+		if w := do(http.MethodDelete, known, bearer("admin-key")); w.Code != http.StatusOK {
+			t.Errorf("admin key DELETE: got status %d", w.Code)
+		}
+	})
+
+	t.Run("jwt", func(t *testing.T) {
+		future, past := time.Now().Add(time.Hour).Unix(), time.Now().Add(-time.Hour).Unix()
+		for _, tt := range []struct {
+			name  string
+			token string
+			want  int
+		}{
+			{"admin", signJWT(t, secret, "HS256", `{"role":"admin"}`), http.StatusOK},
+			{"unexpired", signJWT(t, secret, "HS256", `{"role":"read","exp":`+strconv.FormatInt(future, 10)+`}`), http.StatusForbidden},
+			{"expired", signJWT(t, secret, "HS256", `{"role":"admin","exp":`+strconv.FormatInt(past, 10)+`}`), http.StatusUnauthorized},
+			{"not yet valid", signJWT(t, secret, "HS256", `{"role":"admin","nbf":`+strconv.FormatInt(future, 10)+`}`), http.StatusUnauthorized},
+			{"wrong secret", signJWT(t, []byte("other"), "HS256", `{"role":"admin"}`), http.StatusUnauthorized},
+			{"alg none", signJWT(t, secret, "none", `{"role":"admin"}`), http.StatusUnauthorized},
+			{"unknown role", signJWT(t, secret, "HS256", `{"role":"root"}`), http.StatusUnauthorized},
+		} {
+			if w := do(http.MethodPost, known, bearer(tt.token)); w.Code != tt.want {
+				t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.want)
+			}
+		}
+	})
+
+	t.Run("client certificates", func(t *testing.T) {
+		newCert := func(template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parent == nil {
+				parent, parentKey = template, key
+			}
+			der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return cert, key
+		}
+		newCA := func(name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+			return newCert(&x509.Certificate{
+				SerialNumber:          big.NewInt(1),
+				Subject:               pkix.Name{CommonName: name},
+				NotBefore:             time.Now().Add(-time.Hour),
+				NotAfter:              time.Now().Add(time.Hour),
+				BasicConstraintsValid: true,
+				IsCA:                  true,
+				KeyUsage:              x509.KeyUsageCertSign,
+			}, nil, nil)
+		}
+		clientTemplate := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "client"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+
+		adminCA, adminCAKey := newCA("admin CA")
+		otherCA, otherCAKey := newCA("other CA")
+		pool := x509.NewCertPool()
+		pool.AddCert(adminCA)
+		AddClientCAs(AdminRole, pool)
+
+		adminClient, _ := newCert(clientTemplate, adminCA, adminCAKey)
+		otherClient, _ := newCert(clientTemplate, otherCA, otherCAKey)
+		withCert := func(cert *x509.Certificate) func(*http.Request) {
+			return func(r *http.Request) { r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}} }
+		}
+		if w := do(http.MethodPost, known, withCert(adminClient)); w.Code != http.StatusOK {
+			t.Errorf("admin client certificate: got status %d", w.Code)
+		}
+		if w := do(http.MethodPost, known, withCert(otherClient)); w.Code != http.StatusUnauthorized {
+			t.Errorf("untrusted client certificate: got status %d, want 401", w.Code)
+		}
+	})
+}
+
+func TestManagementRoutesAuthenticated(t *testing.T) {
+	defer func() { managementAuth = authConfig{} }()
+	AddAPIKey(AdminRole, "admin-key")
+
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	var rvInfo [][]protocol.RvInstruction
+	h := NewHTTPHandler(nil, &rvInfo, state)
+
+	for name, routes := range map[string]http.Handler{
+		"all":        h.RegisterRoutes(),
+		"management": h.ManagementRoutes(),
+	} {
+		var first *httptest.ResponseRecorder
+		for _, path := range []string{
+			"/api/v1/rvinfo",
+			"/api/v1/vouchers?guid=0102030405060708090a0b0c0d0e0f10",
+			"/api/v1/owner/devices/0102030405060708090a0b0c0d0e0f10/timeline",
+			"/api/v1/owner/devices/ffffffffffffffffffffffffffffffff/timeline",
+			"/api/v1/owner/device-cas",
+		} {
+			w := httptest.NewRecorder()
+			routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s routes: GET %s: got status %d, want 401", name, path, w.Code)
+			}
+			if first == nil {
+				first = w
+			} else if w.Body.String() != first.Body.String() {
+				t.Errorf("%s routes: GET %s: 401 response differs", name, path)
+			}
+		}
+
+		// The root path stays open for discovery
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s routes: GET /: got status %d", name, w.Code)
+		}
+	}
+}
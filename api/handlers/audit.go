@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// Page sizes of the audit log
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// AuditLogResponse is a page of the audit log. Next is the after parameter
+// of the following page, and is omitted on the last page.
+type AuditLogResponse struct {
+	Events []audit.Event `json:"events"`
+	Next   *int64        `json:"next,omitempty"`
+}
+
+// AuditLogHandler responds with the audit events selected by the optional
+// query parameters guid and operation, oldest first. Pages of up to limit
+// events are fetched by passing the next value of a page as after.
+func AuditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter db.AuditFilter
+	if guidHex := query.Get("guid"); guidHex != "" {
+		guid, err := hex.DecodeString(guidHex)
+		if !utils.IsValidGUID(guidHex) || err != nil {
+			http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+			return
+		}
+		filter.GUID = guid
+	}
+	filter.Operation = query.Get("operation")
+
+	filter.Limit = defaultAuditPageSize
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxAuditPageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditPageSize), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if after := query.Get("after"); after != "" {
+		id, err := strconv.ParseInt(after, 10, 64)
+		if err != nil || id < 0 {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		filter.After = id
+	}
+
+	// Fetch one more event than requested to tell whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	events, err := db.FetchAuditEvents(filter)
+	if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching audit events", err)
+		return
+	}
+	response := AuditLogResponse{Events: events}
+	if len(events) > limit {
+		response.Events = events[:limit]
+		next := events[limit-1].ID
+		response.Next = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// maxBulkStatusGUIDs bounds the GUIDs of a bulk onboarding status request, so
+// that each request is answered by a single query.
+const maxBulkStatusGUIDs = 1000
+
+// DeviceOnboardingStatus is the onboarding status of one device of a bulk
+// status request. Known is false for GUIDs without a voucher or onboarding
+// record.
+type DeviceOnboardingStatus struct {
+	GUID           string     `json:"guid"`
+	Known          bool       `json:"known"`
+	TO2Completed   bool       `json:"to2_completed"`
+	TO2CompletedAt *time.Time `json:"to2_completed_at,omitempty"`
+	NewGUID        string     `json:"new_guid,omitempty"`
+}
+
+// BulkOnboardingStatusResponse lists the onboarding status of each requested
+// device in the order of the request.
+type BulkOnboardingStatusResponse struct {
+	Devices []DeviceOnboardingStatus `json:"devices"`
+}
+
+// BulkOnboardingStatusHandler responds with whether each device of the body
+// {"guids": [...]} has completed TO2, and the GUID it was assigned if so.
+// Devices are found by their original or replacement GUID.
+func BulkOnboardingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		GUIDs []string `json:"guids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(request.GUIDs) > maxBulkStatusGUIDs {
+		http.Error(w, fmt.Sprintf("Too many GUIDs: at most %d are allowed", maxBulkStatusGUIDs), http.StatusBadRequest)
+		return
+	}
+	guids := make([][]byte, len(request.GUIDs))
+	for i, guidHex := range request.GUIDs {
+		guid, err := hex.DecodeString(guidHex)
+		if !utils.IsValidGUID(guidHex) || err != nil {
+			http.Error(w, fmt.Sprintf("Invalid GUID: %q", guidHex), http.StatusBadRequest)
+			return
+		}
+		guids[i] = guid
+	}
+
+	onboardings, err := db.FetchDeviceOnboardings(guids)
+	if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching onboarding status", err)
+		return
+	}
+	vouchers, err := db.FetchVoucherGUIDs(guids)
+	if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching vouchers", err)
+		return
+	}
+
+	response := BulkOnboardingStatusResponse{Devices: make([]DeviceOnboardingStatus, len(guids))}
+	for i, guid := range guids {
+		status := DeviceOnboardingStatus{GUID: hex.EncodeToString(guid), Known: vouchers[string(guid)]}
+		if onboarding, ok := onboardings[string(guid)]; ok {
+			status.Known = true
+			status.TO2Completed = onboarding.TO2Completed
+			if onboarding.TO2Completed {
+				status.TO2CompletedAt = onboarding.TO2CompletedAt
+				status.NewGUID = hex.EncodeToString(onboarding.NewGUID)
+			}
+		}
+		response.Devices[i] = status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
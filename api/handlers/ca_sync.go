@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// CASync keeps a trusted CA store in sync with a PEM bundle published by an
+// external source, such as a central PKI endpoint. The bundle is fetched with
+// the timeout and size limit of CA bundles imported by URL. Since the URL is
+// configured by the operator, it does not need to be in the allowlist.
+type CASync struct {
+	Store    db.TrustedCertStore
+	URL      string
+	Interval time.Duration
+	// Prune stops trusting CAs that are no longer in the bundle
+	Prune bool
+}
+
+// Run syncs the store immediately and then at every interval until ctx is
+// done. Failed syncs are logged and leave the store unchanged.
+func (s CASync) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		added, removed, err := s.Sync(ctx)
+		if err != nil {
+			slog.Error("Error syncing trusted CAs", "url", s.URL, "error", err)
+		} else if added > 0 || removed > 0 {
+			slog.Info("Synced trusted CAs", "url", s.URL, "added", added, "removed", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync fetches the bundle once and reconciles the store with it. It returns
+// how many CAs were added and removed.
+func (s CASync) Sync(ctx context.Context) (added, removed int, err error) {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+	fetcher := caFetch
+	fetcher.allowlist = []string{u.Host}
+	body, err := fetcher.fetch(ctx, s.URL)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var certs []*x509.Certificate
+	for blk, rest := pem.Decode(body); blk != nil; blk, rest = pem.Decode(rest) {
+		if blk.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return 0, 0, fmt.Errorf("error parsing CA: %w", err)
+		}
+		if !cert.IsCA {
+			return 0, 0, fmt.Errorf("certificate is not a CA: %s", cert.Subject)
+		}
+		certs = append(certs, cert)
+	}
+	// An empty bundle is more likely a broken source than a revocation of
+	// every CA, so it never prunes the store
+	if len(certs) == 0 {
+		return 0, 0, fmt.Errorf("no PEM encoded certificate found at %s", s.URL)
+	}
+	return s.Store.Sync(certs, s.Prune)
+}
@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// voucherFields names the elements of the CBOR array of a voucher.
+var voucherFields = []string{"Version", "Header", "Hmac", "CertChain", "Entries"}
+
+// voucherDecodeError is returned when a voucher of an import request cannot
+// be decoded. Index is the position of the voucher in the request.
+type voucherDecodeError struct {
+	Index int
+	Err   error
+}
+
+func (e *voucherDecodeError) Error() string {
+	return fmt.Sprintf("unable to decode CBOR in voucher %d: %v", e.Index, e.Err)
+}
+
+func (e *voucherDecodeError) Unwrap() error { return e.Err }
+
+// cborSyntaxError locates where the structure of CBOR data is malformed.
+type cborSyntaxError struct {
+	// Offset is the byte offset of the data item that could not be read
+	Offset int
+	// Path is the position of the data item, such as Entries[1][0]
+	Path string
+	// Decoded lists the top level fields that were read completely
+	Decoded []string
+	Reason  string
+}
+
+func (e *cborSyntaxError) Error() string {
+	msg := fmt.Sprintf("%s at byte offset %d", e.Reason, e.Offset)
+	if e.Path != "" {
+		msg += " in " + e.Path
+	}
+	if len(e.Decoded) > 0 {
+		msg += " after decoding " + strings.Join(e.Decoded, ", ")
+	}
+	return msg
+}
+
+// diagnoseVoucherCBOR walks the structure of the data items of a voucher,
+// without decoding their values, and returns where it is malformed or
+// truncated. It returns nil for well-formed CBOR, in which case a decode
+// failure is caused by the types of the values instead.
+func diagnoseVoucherCBOR(data []byte) *cborSyntaxError {
+	d := &cborWalker{data: data}
+	if err := d.item(nil); err != nil {
+		return err
+	}
+	if d.pos < len(data) {
+		return &cborSyntaxError{Offset: d.pos, Decoded: d.decoded, Reason: "unexpected trailing bytes"}
+	}
+	return nil
+}
+
+type cborWalker struct {
+	data    []byte
+	pos     int
+	decoded []string
+}
+
+func (d *cborWalker) fail(start int, path []string, reason string) *cborSyntaxError {
+	return &cborSyntaxError{Offset: start, Path: strings.Join(path, ""), Decoded: d.decoded, Reason: reason}
+}
+
+// head reads the initial byte and argument of a data item. Indefinite length
+// items have an argument of -1.
+func (d *cborWalker) head(path []string) (major byte, arg int64, err *cborSyntaxError) {
+	start := d.pos
+	if d.pos >= len(d.data) {
+		return 0, 0, d.fail(start, path, "unexpected end of data")
+	}
+	major, info := d.data[d.pos]>>5, d.data[d.pos]&0x1f
+	d.pos++
+	switch {
+	case info < 24:
+		return major, int64(info), nil
+	case info <= 27:
+		n := 1 << (info - 24)
+		if d.pos+n > len(d.data) {
+			return 0, 0, d.fail(start, path, "unexpected end of data")
+		}
+		var buf [8]byte
+		copy(buf[8-n:], d.data[d.pos:d.pos+n])
+		d.pos += n
+		v := binary.BigEndian.Uint64(buf[:])
+		if v > 1<<62 {
+			return 0, 0, d.fail(start, path, "argument too large")
+		}
+		return major, int64(v), nil
+	case info == 31 && major >= 2 && major <= 5:
+		return major, -1, nil
+	case info == 31 && major == 7:
+		return 0, 0, d.fail(start, path, "unexpected break")
+	default:
+		return 0, 0, d.fail(start, path, fmt.Sprintf("invalid additional information %d", info))
+	}
+}
+
+func (d *cborWalker) item(path []string) *cborSyntaxError {
+	start := d.pos
+	major, arg, err := d.head(path)
+	if err != nil {
+		return err
+	}
+	switch major {
+	case 0, 1, 7:
+		return nil
+	case 2, 3:
+		if arg < 0 {
+			return d.chunks(major, path)
+		}
+		if arg > int64(len(d.data)-d.pos) {
+			return d.fail(start, path, fmt.Sprintf("string of %d bytes exceeds the remaining %d bytes", arg, len(d.data)-d.pos))
+		}
+		d.pos += int(arg)
+		return nil
+	case 6:
+		return d.item(path)
+	default: // arrays and maps
+		n := arg
+		if major == 5 && n > 0 {
+			n *= 2
+		}
+		for i := int64(0); arg < 0 || i < n; i++ {
+			if arg < 0 && d.pos < len(d.data) && d.data[d.pos] == 0xff {
+				d.pos++
+				return nil
+			}
+			if err := d.item(append(path, d.elementName(path, i))); err != nil {
+				return err
+			}
+			if len(path) == 0 && int(i) < len(voucherFields) {
+				d.decoded = append(d.decoded, voucherFields[i])
+			}
+		}
+		return nil
+	}
+}
+
+// chunks reads the chunks of an indefinite length string.
+func (d *cborWalker) chunks(major byte, path []string) *cborSyntaxError {
+	for {
+		if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+			d.pos++
+			return nil
+		}
+		start := d.pos
+		chunkMajor, arg, err := d.head(path)
+		if err != nil {
+			return err
+		}
+		if chunkMajor != major || arg < 0 {
+			return d.fail(start, path, "invalid indefinite length string chunk")
+		}
+		if arg > int64(len(d.data)-d.pos) {
+			return d.fail(start, path, "unexpected end of data")
+		}
+		d.pos += int(arg)
+	}
+}
+
+func (d *cborWalker) elementName(path []string, i int64) string {
+	if len(path) == 0 && int(i) < len(voucherFields) {
+		return voucherFields[i]
+	}
+	return fmt.Sprintf("[%d]", i)
+}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// voucherFilter parses the device_info, search and completed query parameters
+// that select stored vouchers.
+func voucherFilter(r *http.Request) (db.VoucherFilter, error) {
+	query := r.URL.Query()
+	filter := db.VoucherFilter{
+		DeviceInfo: query.Get("device_info"),
+		Search:     query.Get("search"),
+	}
+	if completed := query.Get("completed"); completed != "" {
+		value, err := strconv.ParseBool(completed)
+		if err != nil {
+			return filter, fmt.Errorf("invalid completed parameter: %w", err)
+		}
+		filter.Completed = &value
+	}
+	return filter, nil
+}
+
+// VoucherCountHandler returns the number of stored vouchers matching the
+// device_info, search and completed query parameters.
+func VoucherCountHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := voucherFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	total, err := db.CountVouchers(filter)
+	if err != nil {
+		slog.Debug("Error counting vouchers", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total int `json:"total"`
+	}{
+		Total: total,
+	})
+}
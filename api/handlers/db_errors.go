@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// writeDBError responds to a failed database query other than a missing
+// record. If the database cannot be reached, it responds with 503 Service
+// Unavailable so that clients retry instead of taking the failure for a
+// missing or broken record. Otherwise it responds with 500 and message,
+// without revealing the error.
+func writeDBError(w http.ResponseWriter, state *db.State, message string, err error) {
+	if pingErr := state.Ping(); pingErr != nil {
+		slog.Error("Database unavailable", "error", pingErr)
+		http.Error(w, "Database unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	slog.Debug("Error querying database", "error", err)
+	http.Error(w, message, http.StatusInternalServerError)
+}
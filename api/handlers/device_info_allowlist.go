@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// DeviceInfoAllowlistHandler reads and replaces the allowlist of device info
+// accepted at DI when the allowlist is enforced. The body is a JSON array of
+// exact values or patterns.
+func DeviceInfoAllowlistHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getDeviceInfoAllowlist(w, r)
+	case http.MethodPut:
+		updateDeviceInfoAllowlist(w, r)
+	default:
+		slog.Debug("Method not allowed", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getDeviceInfoAllowlist(w http.ResponseWriter, _ *http.Request) {
+	entries, err := db.FetchDeviceInfoAllowlist()
+	if err != nil {
+		slog.Debug("Error fetching device info allowlist", "error", err)
+		http.Error(w, "Error fetching device info allowlist", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func updateDeviceInfoAllowlist(w http.ResponseWriter, r *http.Request) {
+	var entries []string
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil || entries == nil {
+		slog.Debug("Invalid device info allowlist", "error", err)
+		http.Error(w, "Invalid device info allowlist: must be a JSON array of strings", http.StatusBadRequest)
+		return
+	}
+
+	if err := db.UpdateDeviceInfoAllowlist(entries); errors.Is(err, db.ErrInvalidAllowlistEntry) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		slog.Debug("Error updating device info allowlist", "error", err)
+		http.Error(w, "Error updating device info allowlist", http.StatusInternalServerError)
+		return
+	}
+	getDeviceInfoAllowlist(w, r)
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// Page sizes of the device inventory
+const (
+	defaultDevicePageSize = 100
+	maxDevicePageSize     = 1000
+)
+
+// DeviceListResponse is a page of the device inventory. Next is the after
+// parameter of the following page, and is omitted on the last page.
+type DeviceListResponse struct {
+	Devices []db.Device `json:"devices"`
+	Next    string      `json:"next,omitempty"`
+}
+
+// ListDevicesHandler responds with the devices that reported their devmod in
+// TO2, selected by the optional query parameters os, arch and version and
+// ordered by GUID. Pages of up to limit devices are fetched by passing the
+// next value of a page as after.
+func ListDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := db.DeviceFilter{
+		OS:      query.Get("os"),
+		Arch:    query.Get("arch"),
+		Version: query.Get("version"),
+	}
+
+	filter.Limit = defaultDevicePageSize
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxDevicePageSize {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDevicePageSize), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+	if after := query.Get("after"); after != "" {
+		guid, err := hex.DecodeString(after)
+		if !utils.IsValidGUID(after) || err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		filter.After = guid
+	}
+
+	// Fetch one more device than requested to tell whether there is a next page
+	limit := filter.Limit
+	filter.Limit++
+	devices, err := db.FetchDevices(filter)
+	if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching devices", err)
+		return
+	}
+	response := DeviceListResponse{Devices: devices}
+	if len(devices) > limit {
+		response.Devices = devices[:limit]
+		response.Next = devices[limit-1].GUID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DeviceHandler responds with the devmod and supported service info modules
+// reported by the device with the original or replacement GUID in the path.
+func DeviceHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+
+	device, err := db.FetchDevice(guid)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching device", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(device)
+}
@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// DeviceBundle aggregates everything known about a device for support handoff.
+type DeviceBundle struct {
+	GUID                string      `json:"guid"`
+	DeviceInfo          string      `json:"device_info"`
+	Voucher             string      `json:"voucher"`
+	OwnerKeyFingerprint string      `json:"owner_key_fingerprint,omitempty"`
+	DeviceCertChain     string      `json:"device_cert_chain,omitempty"`
+	RvInfo              interface{} `json:"rvinfo,omitempty"`
+	OwnerRedirect       interface{} `json:"owner_redirect,omitempty"`
+	// Devmod and Modules are known once the device has run TO2
+	Devmod  *db.Device      `json:"devmod,omitempty"`
+	Modules []ModuleOutcome `json:"modules,omitempty"`
+}
+
+// Outcomes of the service info modules of a device
+const (
+	// ModuleStarted is the outcome of a module started in an onboarding that
+	// has not completed, as it is still running or it failed
+	ModuleStarted = "started"
+	// ModuleCompleted is the outcome of a module of a completed onboarding
+	ModuleCompleted = "completed"
+)
+
+// ModuleOutcome is the outcome of a service info module run for a device.
+type ModuleOutcome struct {
+	Module    string    `json:"module"`
+	Outcome   string    `json:"outcome"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// DeviceBundleHandler responds with the onboarding bundle of a device
+func DeviceBundleHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+
+	voucher, err := db.FetchVoucher(guid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			slog.Debug("Voucher not found", "GUID", guidHex)
+			http.Error(w, "Device not found", http.StatusNotFound)
+		} else {
+			writeDBError(w, db.DefaultState(), "Error fetching device", err)
+		}
+		return
+	}
+
+	bundle, err := buildDeviceBundle(guidHex, voucher)
+	if err != nil {
+		slog.Debug("Error building device bundle", "GUID", guidHex, "error", err)
+		http.Error(w, "Error building device bundle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+guidHex+".json\"")
+	json.NewEncoder(w).Encode(bundle)
+}
+
+func buildDeviceBundle(guidHex string, voucher db.Voucher) (*DeviceBundle, error) {
+	ov, err := db.ParseVoucher(voucher)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &DeviceBundle{
+		GUID:       guidHex,
+		DeviceInfo: ov.Header.Val.DeviceInfo,
+		Voucher: string(pem.EncodeToMemory(&pem.Block{
+			Type:  "OWNERSHIP VOUCHER",
+			Bytes: voucher.CBOR,
+		})),
+	}
+
+	// A voucher with an unparsable owner key is still worth handing off
+	if ownerPub, err := ov.OwnerPublicKey(); err != nil {
+		slog.Debug("Error parsing owner public key", "GUID", guidHex, "error", err)
+	} else if ownerPub != nil {
+		if bundle.OwnerKeyFingerprint, err = utils.PublicKeyFingerprint(ownerPub); err != nil {
+			slog.Debug("Error fingerprinting owner public key", "GUID", guidHex, "error", err)
+		}
+	}
+
+	if ov.CertChain != nil {
+		var chain bytes.Buffer
+		for _, cert := range *ov.CertChain {
+			if err := pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				return nil, err
+			}
+		}
+		bundle.DeviceCertChain = chain.String()
+	}
+
+	device, err := db.FetchDevice(voucher.GUID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil {
+		bundle.Devmod = &device
+	}
+	events, err := db.FetchDeviceTimeline(voucher.GUID)
+	if err != nil {
+		return nil, err
+	}
+	bundle.Modules = moduleOutcomes(events)
+
+	rvData, err := db.FetchData("rvinfo")
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	bundle.RvInfo = rvData.Value
+
+	ownerData, err := db.FetchData("owner_info")
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	bundle.OwnerRedirect = ownerData.Value
+
+	return bundle, nil
+}
+
+// moduleOutcomes returns the outcome of each service info module started in
+// the onboarding timeline of a device, in the order modules were first
+// started. A module started again in a later onboarding takes the outcome of
+// that onboarding. A failing module ends TO2, so all modules started before
+// TO2 completes have completed.
+func moduleOutcomes(events []db.TimelineEvent) []ModuleOutcome {
+	var outcomes []ModuleOutcome
+	index := make(map[string]int)
+	for _, event := range events {
+		switch event.Event {
+		case db.ModuleStartedEvent:
+			outcome := ModuleOutcome{Module: event.Detail, Outcome: ModuleStarted, StartedAt: event.Time}
+			if i, ok := index[event.Detail]; ok {
+				outcomes[i] = outcome
+				continue
+			}
+			index[event.Detail] = len(outcomes)
+			outcomes = append(outcomes, outcome)
+		case db.TO2CompletedEvent:
+			for i := range outcomes {
+				outcomes[i].Outcome = ModuleCompleted
+			}
+		}
+	}
+	return outcomes
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// facetsCacheTTL is how long device info facets are served from cache.
+const facetsCacheTTL = 10 * time.Second
+
+var facetsCache struct {
+	sync.Mutex
+	facets  []db.DeviceInfoFacet
+	expires time.Time
+}
+
+// VoucherFacetsHandler returns the distinct device info values of stored
+// vouchers with their counts.
+func VoucherFacetsHandler(w http.ResponseWriter, r *http.Request) {
+	facetsCache.Lock()
+	defer facetsCache.Unlock()
+
+	if time.Now().After(facetsCache.expires) {
+		facets, err := db.FetchDeviceInfoFacets()
+		if err != nil {
+			slog.Debug("Error fetching device info facets", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		facetsCache.facets = facets
+		facetsCache.expires = time.Now().Add(facetsCacheTTL)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		DeviceInfo []db.DeviceInfoFacet `json:"device_info"`
+	}{
+		DeviceInfo: facetsCache.facets,
+	})
+}
@@ -6,11 +6,18 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
 )
 
 type HealthResponse struct {
-	Version string `json:"version"`
-	Status  string `json:"status"`
+	Version        string `json:"version"`
+	Status         string `json:"status"`
+	ProtocolErrors uint64 `json:"protocol_errors"`
+	TO1BlobHits    uint64 `json:"to1_blob_hits"`
+	TO1BlobMisses  uint64 `json:"to1_blob_misses"`
+	TO0Accepted    uint64 `json:"to0_accepted"`
+	TO0Rejected    uint64 `json:"to0_rejected"`
 }
 
 // HealthHandler responds with the version and status
@@ -21,8 +28,13 @@ func HealthHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	response := HealthResponse{
-		Version: "1.1",
-		Status:  "OK",
+		Version:        Version,
+		Status:         "OK",
+		ProtocolErrors: metrics.ProtocolErrors.Load(),
+		TO1BlobHits:    metrics.TO1BlobHits.Load(),
+		TO1BlobMisses:  metrics.TO1BlobMisses.Load(),
+		TO0Accepted:    metrics.TO0Accepted.Load(),
+		TO0Rejected:    metrics.TO0Rejected.Load(),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
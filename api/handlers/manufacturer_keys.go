@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ManufacturerKeyStore looks up manufacturer keys by type, such as sqlite.DB.
+type ManufacturerKeyStore interface {
+	ManufacturerKey(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
+}
+
+// ManufacturerKey describes the public part of a manufacturer key. The public
+// key and the certificate chain are PEM encoded.
+type ManufacturerKey struct {
+	Type        string `json:"type"`
+	PublicKey   string `json:"public_key"`
+	Fingerprint string `json:"fingerprint"`
+	CertChain   string `json:"cert_chain"`
+}
+
+// ManufacturerKeyHandler responds with the public key and certificate chain of
+// the manufacturer key of the type in the path, so that owners can trust the
+// CA of the chain. The chain alone is returned as PEM when requested with
+// Accept: application/x-pem-file.
+func ManufacturerKeyHandler(store ManufacturerKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keyType, err := protocol.ParseKeyType(r.PathValue("type"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, chain, err := store.ManufacturerKey(keyType)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, fdo.ErrNotFound) || (err == nil && key == nil) {
+			http.Error(w, "Manufacturer key not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Debug("Error querying manufacturer key", "type", keyType, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		var chainPEM bytes.Buffer
+		for _, cert := range chain {
+			if err := pem.Encode(&chainPEM, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if negotiateContentType(r, "application/json", "application/x-pem-file") == "application/x-pem-file" {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Write(chainPEM.Bytes())
+			return
+		}
+
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			slog.Debug("Error encoding manufacturer public key", "type", keyType, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		fingerprint, err := utils.PublicKeyFingerprint(key.Public())
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ManufacturerKey{
+			Type:        keyType.String(),
+			PublicKey:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			Fingerprint: fingerprint,
+			CertChain:   chainPEM.String(),
+		})
+	}
+}
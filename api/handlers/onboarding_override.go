@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// UpdateOnboardingStatusHandler overrides whether the device with the GUID in
+// the path has completed TO2, e.g. to stop or restart onboarding during
+// recovery. The body is {"to2_completed": bool}.
+func UpdateOnboardingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+
+	var request struct {
+		TO2Completed *bool `json:"to2_completed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.TO2Completed == nil {
+		http.Error(w, "Invalid request payload: to2_completed is required", http.StatusBadRequest)
+		return
+	}
+
+	// Devices are known by their voucher or, once onboarded with a new GUID,
+	// by their onboarding record
+	if _, err := db.FetchVoucher(guid); errors.Is(err, sql.ErrNoRows) {
+		if _, err := db.FetchDeviceOnboarding(guid); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Device not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			slog.Debug("Error fetching onboarding status", "GUID", guidHex, "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	} else if err != nil {
+		slog.Debug("Error fetching voucher", "GUID", guidHex, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Warn("Manually overriding onboarding status", "GUID", guidHex, "to2_completed", *request.TO2Completed, "remote", r.RemoteAddr)
+	if err := db.SetTO2Completed(guid, *request.TO2Completed); err != nil {
+		slog.Debug("Error updating onboarding status", "GUID", guidHex, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	onboarding, err := db.FetchDeviceOnboarding(guid)
+	if errors.Is(err, sql.ErrNoRows) {
+		onboarding = db.DeviceOnboarding{GUID: guid}
+	} else if err != nil {
+		slog.Debug("Error fetching onboarding status", "GUID", guidHex, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(onboarding)
+}
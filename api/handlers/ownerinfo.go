@@ -6,12 +6,14 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
 
 	"log/slog"
 
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/ownerinfo"
 )
 
 func OwnerInfoHandler(w http.ResponseWriter, r *http.Request) {
@@ -38,8 +40,7 @@ func getOwnerData(w http.ResponseWriter, _ *http.Request) {
 			slog.Debug("No ownerData found")
 			http.Error(w, "No ownerData found", http.StatusNotFound)
 		} else {
-			slog.Debug("Error fetching ownerData", "error", err)
-			http.Error(w, "Error fetching ownerData", http.StatusInternalServerError)
+			writeDBError(w, db.DefaultState(), "Error fetching ownerData", err)
 		}
 		return
 	}
@@ -58,6 +59,9 @@ func createOwnerData(w http.ResponseWriter, r *http.Request, mu *sync.Mutex) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if !checkOwnerData(w, ownerData) {
+		return
+	}
 
 	if exists, err := db.CheckDataExists("owner_info"); err != nil {
 		slog.Debug("Error checking ownerData existence", "error", err)
@@ -92,6 +96,9 @@ func updateOwnerData(w http.ResponseWriter, r *http.Request, mu *sync.Mutex) {
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	if !checkOwnerData(w, ownerData) {
+		return
+	}
 
 	if exists, err := db.CheckDataExists("owner_info"); err != nil {
 		slog.Debug("Error checking ownerData existence", "error", err)
@@ -114,3 +121,34 @@ func updateOwnerData(w http.ResponseWriter, r *http.Request, mu *sync.Mutex) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(ownerData)
 }
+
+// checkOwnerData rejects RVTO2Addrs of owner data that devices cannot reach in
+// production mode, and applies the TLS mismatch policy to them, adding a
+// Warning header for each mismatching address. It returns false after
+// responding with an error if the owner data is rejected. Owner data that is
+// not a list of RVTO2Addrs is not checked.
+func checkOwnerData(w http.ResponseWriter, ownerData db.Data) bool {
+	values, ok := ownerData.Value.([]interface{})
+	if !ok {
+		return true
+	}
+	rvTO2Addrs, err := ownerinfo.ParseRvTO2Addr(values)
+	if err != nil {
+		return true
+	}
+	if err := ownerinfo.CheckReachableAddresses(rvTO2Addrs); err != nil {
+		slog.Debug("Rejected ownerData", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	warnings, err := ownerinfo.CheckTransportProtocols(rvTO2Addrs)
+	if err != nil {
+		slog.Debug("Rejected ownerData", "error", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	for _, warning := range warnings {
+		w.Header().Add("Warning", fmt.Sprintf("199 - %q", warning))
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Version is the server version reported by the health and root endpoints.
+const Version = "1.1"
+
+// RootResponse identifies the server and the role of the listener serving the
+// root path.
+type RootResponse struct {
+	Service  string   `json:"service"`
+	Role     string   `json:"role"`
+	Version  string   `json:"version"`
+	APIPaths []string `json:"api_paths"`
+}
+
+// RootHandler responds with the role of the listener, the server version and
+// the base paths of the APIs the listener serves, so that operators can tell
+// which server and listener they reached.
+func RootHandler(role string, apiPaths []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(RootResponse{
+			Service:  "go-fdo-server",
+			Role:     role,
+			Version:  Version,
+			APIPaths: apiPaths,
+		})
+	}
+}
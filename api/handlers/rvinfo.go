@@ -45,8 +45,7 @@ func getRvData(w http.ResponseWriter, _ *http.Request) {
 			slog.Debug("No rvData found")
 			http.Error(w, "No rvData found", http.StatusNotFound)
 		} else {
-			slog.Debug("Error fetching rvData", "error", err)
-			http.Error(w, "Error fetching rvData", http.StatusInternalServerError)
+			writeDBError(w, db.DefaultState(), "Error fetching rvData", err)
 		}
 		return
 	}
@@ -65,6 +64,12 @@ func createRvData(w http.ResponseWriter, r *http.Request, rvInfo *[][]protocol.R
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	var parsed [][]protocol.RvInstruction
+	if err := rvinfo.ParseRvInfo(rvData.Value, &parsed); err != nil {
+		slog.Debug("Error parsing RV info", "error", err)
+		http.Error(w, "Invalid RV info: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if exists, err := db.CheckDataExists("rvinfo"); err != nil {
 		slog.Debug("Error checking rvData existence", "error", err)
@@ -105,6 +110,12 @@ func updateRvData(w http.ResponseWriter, r *http.Request, rvInfo *[][]protocol.R
 		http.Error(w, "Invalid input", http.StatusBadRequest)
 		return
 	}
+	var parsed [][]protocol.RvInstruction
+	if err := rvinfo.ParseRvInfo(rvData.Value, &parsed); err != nil {
+		slog.Debug("Error parsing RV info", "error", err)
+		http.Error(w, "Invalid RV info: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if exists, err := db.CheckDataExists("rvinfo"); err != nil {
 		slog.Debug("Error checking rvData existence", "error", err)
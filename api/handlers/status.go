@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// OnboardingStatusResponse is the device-facing onboarding status. Devices that
+// have not completed TO2 and GUIDs the server does not know get the same
+// "unknown" status so that the endpoint cannot be used to enumerate vouchers.
+type OnboardingStatusResponse struct {
+	Status      string     `json:"status"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+const (
+	onboardedStatus = "onboarded"
+	unknownStatus   = "unknown"
+)
+
+// OnboardingStatusHandler responds with whether the device with the GUID in
+// the path has completed TO2
+func OnboardingStatusHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+
+	response := OnboardingStatusResponse{Status: unknownStatus}
+	onboarding, err := db.FetchDeviceOnboarding(guid)
+	if err == nil && onboarding.TO2Completed {
+		response.Status = onboardedStatus
+		response.CompletedAt = onboarding.TO2CompletedAt
+	} else if err != nil && err != sql.ErrNoRows {
+		writeDBError(w, db.DefaultState(), "Error fetching onboarding status", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
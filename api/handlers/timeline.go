@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// DeviceTimeline lists the onboarding events of a device in chronological
+// order.
+type DeviceTimeline struct {
+	GUID   string             `json:"guid"`
+	Events []db.TimelineEvent `json:"events"`
+}
+
+// DeviceTimelineHandler responds with the onboarding timeline of the device
+// with the original or replacement GUID in the path.
+func DeviceTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+
+	events, err := db.FetchDeviceTimeline(guid)
+	if err != nil {
+		slog.Debug("Error fetching device timeline", "GUID", guidHex, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(w, "Device not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceTimeline{GUID: guidHex, Events: events})
+}
@@ -4,10 +4,17 @@
 package handlers
 
 import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"log/slog"
 	"net/http"
 	"path"
 
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/rvinfo"
 	"github.com/fido-device-onboard/go-fdo-server/internal/to0"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
@@ -28,7 +35,10 @@ func To0Handler(rvInfo *[][]protocol.RvInstruction, state *sqlite.DB) http.Handl
 
 		if to0Guid != "" {
 			err := to0.RegisterRvBlob(*rvInfo, to0Guid, state)
-			if err != nil {
+			if errors.Is(err, to0.ErrRegistrationInProgress) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -38,3 +48,67 @@ func To0Handler(rvInfo *[][]protocol.RvInstruction, state *sqlite.DB) http.Handl
 		w.Write([]byte(to0Guid))
 	}
 }
+
+// RegisterRvBlobFunc registers the RV blob of the device with the given GUID
+// at the RV server described by rvInfo.
+type RegisterRvBlobFunc func(rvInfo [][]protocol.RvInstruction, guid string, state *sqlite.DB) error
+
+// RecomputeRvInfoHandler re-registers the RV blob of a stored voucher using
+// the RV info in the request body instead of the server's configured RV info,
+// and records the override.
+func RecomputeRvInfoHandler(register RegisterRvBlobFunc, state *sqlite.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		guidHex := r.PathValue("guid")
+		if !utils.IsValidGUID(guidHex) {
+			http.Error(w, "GUID is not a valid GUID", http.StatusBadRequest)
+			return
+		}
+		guid, err := hex.DecodeString(guidHex)
+		if err != nil {
+			http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := db.FetchVoucher(guid); err != nil {
+			if err == sql.ErrNoRows {
+				slog.Debug("Voucher not found", "GUID", guidHex)
+				http.Error(w, "Voucher not found", http.StatusNotFound)
+			} else {
+				writeDBError(w, db.DefaultState(), "Error fetching voucher", err)
+			}
+			return
+		}
+
+		rvData, err := parseRequestBody(r)
+		if err != nil {
+			slog.Debug("Error parsing request body", "error", err)
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		var override [][]protocol.RvInstruction
+		if err := rvinfo.ParseRvInfo(rvData.Value, &override); err != nil || len(override) == 0 {
+			slog.Debug("Error parsing RV info override", "error", err)
+			http.Error(w, "Invalid RV info", http.StatusBadRequest)
+			return
+		}
+
+		if err := register(override, guidHex, state); errors.Is(err, to0.ErrRegistrationInProgress) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			slog.Debug("Error registering RV blob with override", "GUID", guidHex, "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := db.InsertRvInfoOverride(guid, rvData); err != nil {
+			slog.Debug("Error recording RV info override", "GUID", guidHex, "error", err)
+			http.Error(w, "Error recording RV info override", http.StatusInternalServerError)
+			return
+		}
+		slog.Debug("RV blob registered with RV info override", "GUID", guidHex)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rvData)
+	}
+}
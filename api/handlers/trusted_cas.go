@@ -0,0 +1,272 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"bytes"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// TrustedCA describes a trusted CA certificate.
+type TrustedCA struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	Expired     bool      `json:"expired"`
+}
+
+// TrustedCAImportResponse lists the fingerprints of imported CA certificates.
+// Certificates that were already trusted are reported as skipped. The counts
+// always cover every certificate, but at most the configured number of
+// fingerprints are listed, with Summary telling how many were left out.
+type TrustedCAImportResponse struct {
+	Imported      []string `json:"imported"`
+	Skipped       []string `json:"skipped"`
+	ImportedCount int      `json:"imported_count"`
+	SkippedCount  int      `json:"skipped_count"`
+	Summary       string   `json:"summary,omitempty"`
+}
+
+// DefaultCAImportFingerprints is the number of fingerprints listed in CA
+// import responses unless SetCAImportFingerprints is called.
+const DefaultCAImportFingerprints = 100
+
+var caImportFingerprints = DefaultCAImportFingerprints
+
+// SetCAImportFingerprints limits the number of fingerprints listed in CA
+// import responses, so that responses stay small for large bundles. A limit
+// of 0 lists none, leaving only the counts, and a negative limit lists all.
+func SetCAImportFingerprints(limit int) {
+	caImportFingerprints = limit
+}
+
+var caFetch = urlFetcher{
+	content: "CA bundle",
+	timeout: 30 * time.Second,
+	maxSize: 1 << 20,
+}
+
+// SetCAFetchConfig configures fetching CA bundles imported by URL. Only http
+// and https URLs whose host or host:port is in the allowlist are fetched, so
+// an empty allowlist disables importing by URL.
+func SetCAFetchConfig(timeout time.Duration, maxSize int64, allowlist []string) {
+	caFetch.timeout = timeout
+	caFetch.maxSize = maxSize
+	caFetch.allowlist = allowlist
+}
+
+var (
+	fingerprintRegex       = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	fingerprintPrefixRegex = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+)
+
+func newTrustedCA(cert *x509.Certificate) TrustedCA {
+	return TrustedCA{
+		Fingerprint: db.CertFingerprint(cert),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		Expired:     time.Now().After(cert.NotAfter),
+	}
+}
+
+// TrustedCAsHandler lists the CAs of a trusted certificate store on GET and
+// imports PEM encoded CA certificates on POST, either from the request body
+// or from a bundle fetched from the URL of a JSON body {"url": "..."}. Only unexpired CAs are listed
+// when the valid query parameter is true, and only CAs whose fingerprint
+// starts with the fingerprint_prefix query parameter when it is given.
+func TrustedCAsHandler(store db.TrustedCertStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			listTrustedCAs(w, r, store)
+		case http.MethodPost:
+			importTrustedCAs(w, r, store)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func listTrustedCAs(w http.ResponseWriter, r *http.Request, store db.TrustedCertStore) {
+	list := store.List
+	if prefix := r.URL.Query().Get("fingerprint_prefix"); prefix != "" {
+		prefix = strings.ToLower(prefix)
+		if !fingerprintPrefixRegex.MatchString(prefix) {
+			http.Error(w, "Fingerprint prefix is not a hex encoded SHA-256 hash prefix", http.StatusBadRequest)
+			return
+		}
+		list = func() ([]*x509.Certificate, error) { return store.ListByFingerprintPrefix(prefix) }
+	}
+	certs, err := list()
+	if err != nil {
+		slog.Debug("Error fetching trusted CAs", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	validOnly := r.URL.Query().Get("valid") == "true"
+
+	cas := []TrustedCA{}
+	for _, cert := range certs {
+		ca := newTrustedCA(cert)
+		if validOnly && ca.Expired {
+			continue
+		}
+		cas = append(cas, ca)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cas)
+}
+
+func importTrustedCAs(w http.ResponseWriter, r *http.Request, store db.TrustedCertStore) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		http.Error(w, "Empty request body", http.StatusBadRequest)
+		return
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var request struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil || request.URL == "" {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		body, err = caFetch.fetch(r.Context(), request.URL)
+		if errors.Is(err, errURLNotAllowed) {
+			slog.Debug("CA bundle URL rejected", "error", err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
+			slog.Debug("Error fetching CA bundle", "error", err)
+			http.Error(w, "Error fetching CA bundle", http.StatusBadGateway)
+			return
+		}
+	}
+
+	var certs []*x509.Certificate
+	for blk, rest := pem.Decode(body); blk != nil; blk, rest = pem.Decode(rest) {
+		if blk.Type != "CERTIFICATE" {
+			http.Error(w, "Expected PEM blocks of certificate type, found "+blk.Type, http.StatusBadRequest)
+			return
+		}
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			slog.Debug("Error parsing trusted CA", "error", err)
+			http.Error(w, "Invalid certificate", http.StatusBadRequest)
+			return
+		}
+		if !cert.IsCA {
+			http.Error(w, "Certificate is not a CA: "+cert.Subject.String(), http.StatusBadRequest)
+			return
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		http.Error(w, "No PEM encoded certificate found", http.StatusBadRequest)
+		return
+	}
+
+	response := TrustedCAImportResponse{Imported: []string{}, Skipped: []string{}}
+	for _, cert := range certs {
+		inserted, err := store.Insert(cert)
+		if err != nil {
+			slog.Debug("Error storing trusted CA", "error", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		listed := caImportFingerprints < 0 || len(response.Imported)+len(response.Skipped) < caImportFingerprints
+		if inserted {
+			response.ImportedCount++
+			if listed {
+				response.Imported = append(response.Imported, db.CertFingerprint(cert))
+			}
+		} else {
+			response.SkippedCount++
+			if listed {
+				response.Skipped = append(response.Skipped, db.CertFingerprint(cert))
+			}
+		}
+	}
+	if omitted := response.ImportedCount + response.SkippedCount - len(response.Imported) - len(response.Skipped); omitted > 0 {
+		response.Summary = fmt.Sprintf("and %d more", omitted)
+	}
+
+	status := http.StatusOK
+	if response.ImportedCount > 0 {
+		status = http.StatusCreated
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// TrustedCAHandler returns the CA of a trusted certificate store with the
+// fingerprint in the path on GET, as PEM if requested by the Accept header and
+// as JSON otherwise, and stops trusting it on DELETE.
+func TrustedCAHandler(store db.TrustedCertStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trustedCAHandler(w, r, store)
+	}
+}
+
+func trustedCAHandler(w http.ResponseWriter, r *http.Request, store db.TrustedCertStore) {
+	fingerprint := strings.ToLower(r.PathValue("fingerprint"))
+	if !fingerprintRegex.MatchString(fingerprint) {
+		http.Error(w, "Fingerprint is not a hex encoded SHA-256 hash", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		cert, err := store.Get(fingerprint)
+		if err == sql.ErrNoRows {
+			http.Error(w, "CA not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeDBError(w, db.DefaultState(), "Internal server error", err)
+			return
+		}
+		if negotiateContentType(r, "application/json", "application/x-pem-file") == "application/x-pem-file" {
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newTrustedCA(cert))
+
+	case http.MethodDelete:
+		if err := store.Delete(fingerprint); err == sql.ErrNoRows {
+			http.Error(w, "CA not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			writeDBError(w, db.DefaultState(), "Internal server error", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// defaultExpiringWithin is how far ahead expiring vouchers are listed when
+// the within query parameter is not given.
+const defaultExpiringWithin = 7 * 24 * time.Hour
+
+// ExpiringVouchersHandler lists the vouchers that the retention policy
+// removes within the duration in the within query parameter, soonest first.
+// Vouchers that already expired and are waiting for the reaper are included.
+func ExpiringVouchersHandler(w http.ResponseWriter, r *http.Request) {
+	within := defaultExpiringWithin
+	if param := r.URL.Query().Get("within"); param != "" {
+		d, err := time.ParseDuration(param)
+		if err != nil || d < 0 {
+			http.Error(w, "Invalid within parameter", http.StatusBadRequest)
+			return
+		}
+		within = d
+	}
+
+	expiring, err := db.FetchExpiringVouchers(time.Now().Add(within))
+	if err != nil {
+		writeDBError(w, db.DefaultState(), "Error fetching expiring vouchers", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Vouchers []db.VoucherExpiry `json:"vouchers"`
+	}{
+		Vouchers: expiring,
+	})
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// Formats of a voucher export
+const (
+	pemExportFormat   = "pem"
+	tarGzExportFormat = "tar.gz"
+)
+
+// ExportVouchersHandler streams the stored vouchers selected by the
+// device_info, search and completed query parameters, for migrating them to
+// another owner server. The format query parameter selects concatenated PEM,
+// the default, or a tar.gz archive with a PEM file named by the GUID of each
+// voucher. Both can be imported again as they are, the archive after
+// extracting it.
+//
+// Vouchers are written as they are read from the database, so an error after
+// the response started truncates it: a truncated PEM export ends in the
+// middle of a voucher or misses vouchers, and a truncated archive fails to
+// decompress.
+func ExportVouchersHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := voucherFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = pemExportFormat
+	case pemExportFormat, tarGzExportFormat:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid format: %s", format), http.StatusBadRequest)
+		return
+	}
+	// Check the database before the response starts, so that an unavailable
+	// database is reported with a status code
+	if err := db.DefaultState().Ping(); err != nil {
+		writeDBError(w, db.DefaultState(), "Error exporting vouchers", err)
+		return
+	}
+
+	var exported int
+	switch format {
+	case pemExportFormat:
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Header().Set("Content-Disposition", `attachment; filename="vouchers.pem"`)
+		err = db.ExportVouchers(filter, func(voucher db.Voucher) error {
+			exported++
+			return pem.Encode(w, &pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: voucher.CBOR})
+		})
+	case tarGzExportFormat:
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", `attachment; filename="vouchers.tar.gz"`)
+		err = exportTarGz(w, filter, &exported)
+	}
+	if err != nil {
+		slog.Error("Error exporting vouchers", "exported", exported, "error", err)
+		return
+	}
+	slog.Info("Exported vouchers", "format", format, "exported", exported)
+}
+
+// exportTarGz writes the vouchers selected by filter to w as a tar.gz archive,
+// counting them in exported. The archive is only completed when all vouchers
+// were written.
+func exportTarGz(w io.Writer, filter db.VoucherFilter, exported *int) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	modTime := time.Now()
+	if err := db.ExportVouchers(filter, func(voucher db.Voucher) error {
+		data := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: voucher.CBOR})
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     hex.EncodeToString(voucher.GUID) + ".pem",
+			Mode:     0o600,
+			Size:     int64(len(data)),
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+		*exported++
+		_, err := tw.Write(data)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
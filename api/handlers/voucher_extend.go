@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// maxNextOwnerKeySize bounds the request body of a voucher extension, which
+// holds a public key or a short certificate chain.
+const maxNextOwnerKeySize = 64 << 10
+
+var (
+	// errNotVoucherOwner is returned when extending a voucher whose owner key
+	// is not an owner key of this server.
+	errNotVoucherOwner = errors.New("voucher is not owned by an owner key of this server")
+	// errExtensionDepth is returned when extending a voucher that has the
+	// maximum number of entries.
+	errExtensionDepth = errors.New("voucher has reached the maximum resale depth")
+)
+
+// ExtendVoucher extends the stored voucher with the GUID in the path to the
+// next owner key in the request body and stops owning it, as the -resale-guid
+// flag does. The body is a PEM encoded public key, or a certificate chain
+// whose first certificate has the next owner key. The voucher is removed only
+// once it was extended.
+//
+// The voucher of a device that completed TO2 is only extended with the force
+// query parameter set to true. The extended voucher is returned as JSON or,
+// when the Accept header prefers it, as PEM.
+func (s *VoucherServer) ExtendVoucher(w http.ResponseWriter, r *http.Request) {
+	guidHex := r.PathValue("guid")
+	if !utils.IsValidGUID(guidHex) {
+		http.Error(w, fmt.Sprintf("Invalid GUID: %s", guidHex), http.StatusBadRequest)
+		return
+	}
+	guid, err := hex.DecodeString(guidHex)
+	if err != nil {
+		http.Error(w, "Invalid GUID format", http.StatusBadRequest)
+		return
+	}
+	var force bool
+	if param := r.URL.Query().Get("force"); param != "" {
+		if force, err = strconv.ParseBool(param); err != nil {
+			http.Error(w, "Invalid force parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxNextOwnerKeySize))
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	nextOwner, nextOwnerPub, err := parseNextOwnerKey(body)
+	if err != nil {
+		http.Error(w, "Invalid next owner key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !force {
+		completed, err := db.IsTO2Completed(guid)
+		if err != nil {
+			writeDBError(w, s.State, "Error checking onboarding status", err)
+			return
+		}
+		if completed {
+			http.Error(w, fmt.Sprintf("Device %s has already completed TO2; set force=true to extend its voucher anyway", guidHex), http.StatusConflict)
+			return
+		}
+	}
+
+	var detail string
+	extended, err := s.State.ResellVoucher(guid, func(ov *fdo.Voucher) (*fdo.Voucher, error) {
+		if s.MaxEntries > 0 && len(ov.Entries) >= s.MaxEntries {
+			return nil, fmt.Errorf("%w: %d entries", errExtensionDepth, len(ov.Entries))
+		}
+		owner, err := s.voucherOwnerKey(ov)
+		if err != nil {
+			return nil, err
+		}
+		if detail, err = audit.ExtensionDetail(owner.Public(), nextOwnerPub); err != nil {
+			return nil, err
+		}
+		return extendVoucher(ov, owner, nextOwner)
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		audit.Record(r.Context(), audit.VoucherResold, guid, err, detail)
+	}
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "Voucher not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNotVoucherOwner) || errors.Is(err, errExtensionDepth):
+		http.Error(w, fmt.Sprintf("Voucher %s: %v", guidHex, err), http.StatusConflict)
+		return
+	case errors.Is(err, errVoucherExtension):
+		slog.Debug("Error extending voucher", "GUID", guidHex, "error", err)
+		http.Error(w, fmt.Sprintf("Voucher %s: %v", guidHex, err), http.StatusBadRequest)
+		return
+	case err != nil:
+		writeDBError(w, s.State, "Error extending voucher", err)
+		return
+	}
+	slog.Info("Extended voucher to next owner", "GUID", guidHex, "detail", detail)
+
+	data, err := cbor.Marshal(extended)
+	if err != nil {
+		slog.Error("Error encoding extended voucher", "GUID", guidHex, "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	pemVoucher := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: data})
+	if negotiateContentType(r, "application/json", "application/x-pem-file") == "application/x-pem-file" {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(pemVoucher)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		GUID    string `json:"guid"`
+		Entries int    `json:"entries"`
+		Voucher string `json:"voucher"`
+	}{
+		GUID:    guidHex,
+		Entries: len(extended.Entries),
+		Voucher: string(pemVoucher),
+	})
+}
+
+// voucherOwnerKey returns the owner key of this server that owns ov. Vouchers
+// are only extended with keys of the type of their manufacturer key, so that
+// is the type of its owner key.
+func (s *VoucherServer) voucherOwnerKey(ov *fdo.Voucher) (crypto.Signer, error) {
+	ownerPub, err := ov.OwnerPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("%w: error parsing owner public key: %v", errVoucherExtension, err)
+	}
+	owner, _, err := s.OwnerKeys.OwnerKey(ov.Header.Val.ManufacturerKey.Type)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errNotVoucherOwner, err)
+	}
+	if pub, ok := owner.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(ownerPub) {
+		return nil, errNotVoucherOwner
+	}
+	return owner, nil
+}
+
+// errVoucherExtension wraps errors of extending a voucher to the next owner
+// key, such as a key of another type than the manufacturer key.
+var errVoucherExtension = errors.New("error extending voucher")
+
+// extendVoucher extends ov to nextOwner, which is a parsed public key or
+// certificate chain.
+func extendVoucher(ov *fdo.Voucher, owner crypto.Signer, nextOwner any) (*fdo.Voucher, error) {
+	var extended *fdo.Voucher
+	var err error
+	switch nextOwner := nextOwner.(type) {
+	case *ecdsa.PublicKey:
+		extended, err = fdo.ExtendVoucher(ov, owner, nextOwner, nil)
+	case *rsa.PublicKey:
+		extended, err = fdo.ExtendVoucher(ov, owner, nextOwner, nil)
+	case []*x509.Certificate:
+		extended, err = fdo.ExtendVoucher(ov, owner, nextOwner, nil)
+	default:
+		err = fmt.Errorf("unsupported key type: %T", nextOwner)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errVoucherExtension, err)
+	}
+	return extended, nil
+}
+
+// parseNextOwnerKey parses a PEM encoded public key or certificate chain. It
+// returns the key or chain to extend a voucher to, and the next owner public
+// key.
+func parseNextOwnerKey(data []byte) (any, crypto.PublicKey, error) {
+	blk, rest := pem.Decode(data)
+	if blk == nil {
+		return nil, nil, errors.New("no PEM block found")
+	}
+	switch blk.Type {
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(blk.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pub, pub, nil
+	case "CERTIFICATE":
+		var chain []*x509.Certificate
+		for ; blk != nil; blk, rest = pem.Decode(rest) {
+			if blk.Type != "CERTIFICATE" {
+				return nil, nil, fmt.Errorf("unexpected PEM block of type %s in certificate chain", blk.Type)
+			}
+			cert, err := x509.ParseCertificate(blk.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			chain = append(chain, cert)
+		}
+		return chain, chain[0].PublicKey, nil
+	default:
+		return nil, nil, fmt.Errorf("expected PEM block of public key or certificate type, found %s", blk.Type)
+	}
+}
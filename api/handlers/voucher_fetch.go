@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// errURLNotAllowed is returned for import URLs whose host is not in the fetch
+// allowlist.
+var errURLNotAllowed = errors.New("URL is not allowed")
+
+// urlFetcher downloads content imported by URL. Only http and https URLs whose
+// host or host:port is in the allowlist are fetched, so an empty allowlist
+// disables importing by URL.
+type urlFetcher struct {
+	// content names what is fetched in errors
+	content   string
+	timeout   time.Duration
+	maxSize   int64
+	allowlist []string
+}
+
+var voucherFetch = urlFetcher{
+	content: "voucher",
+	timeout: 30 * time.Second,
+	maxSize: 1 << 20,
+}
+
+// SetVoucherFetchConfig configures fetching vouchers imported by URL. Only
+// http and https URLs whose host or host:port is in the allowlist are fetched,
+// so an empty allowlist disables importing by URL.
+func SetVoucherFetchConfig(timeout time.Duration, maxSize int64, allowlist []string) {
+	voucherFetch.timeout = timeout
+	voucherFetch.maxSize = maxSize
+	voucherFetch.allowlist = allowlist
+}
+
+func (f *urlFetcher) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: unsupported scheme %q", errURLNotAllowed, u.Scheme)
+	}
+	if !slices.Contains(f.allowlist, u.Host) && !slices.Contains(f.allowlist, u.Hostname()) {
+		return fmt.Errorf("%w: host %q is not in the allowlist", errURLNotAllowed, u.Host)
+	}
+	return nil
+}
+
+// fetch downloads the body at rawURL. Redirects are checked against the
+// allowlist as well.
+func (f *urlFetcher) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errURLNotAllowed, err)
+	}
+	if err := f.check(u); err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout: f.timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return f.check(req.URL)
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > f.maxSize {
+		return nil, fmt.Errorf("fetching %s: %s exceeds %d bytes", rawURL, f.content, f.maxSize)
+	}
+	return body, nil
+}
+
+// fetchVoucherURLs fetches and parses the vouchers referenced by a request.
+func (request *voucherRequest) fetchVoucherURLs(ctx context.Context) error {
+	for _, rawURL := range request.URLs {
+		body, err := voucherFetch.fetch(ctx, rawURL)
+		if err != nil {
+			return err
+		}
+		// Fetched content must contain vouchers, not more URLs
+		if sniffVoucherFormat(body) == jsonVoucherFormat {
+			return fmt.Errorf("%w: %s", errUnsupportedVoucherFormat, rawURL)
+		}
+		fetched, err := parseVoucherRequest(body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rawURL, err)
+		}
+		request.Vouchers = append(request.Vouchers, fetched.Vouchers...)
+		request.Warnings = append(request.Warnings, fetched.Warnings...)
+	}
+	return nil
+}
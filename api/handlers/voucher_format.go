@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+var errUnsupportedVoucherFormat = errors.New("unsupported voucher format")
+
+type voucherFormat int
+
+const (
+	unknownVoucherFormat voucherFormat = iota
+	pemVoucherFormat
+	cborVoucherFormat
+	jsonVoucherFormat
+)
+
+// voucherRequest is the normalized form of a voucher import request body.
+type voucherRequest struct {
+	Vouchers  []db.Voucher
+	OwnerKeys []db.OwnerKey
+	// URLs reference vouchers to fetch, see fetchVoucherURLs
+	URLs []string
+	// Warnings describe content that was ignored without failing the import
+	Warnings []string
+}
+
+// remainingPEMWarning is reported when bytes that are not a PEM block follow
+// the last voucher of a PEM import.
+const remainingPEMWarning = "Unable to decode remaining PEM content"
+
+// sniffVoucherFormat detects the encoding of a voucher import body from its
+// content. The declared Content-Type is ignored because clients such as
+// `curl -d @file` routinely send the wrong one.
+func sniffVoucherFormat(body []byte) voucherFormat {
+	if len(body) == 0 {
+		return unknownVoucherFormat
+	}
+	// A voucher is a CBOR array: major type 4 in the high 3 bits
+	if body[0]>>5 == 4 {
+		return cborVoucherFormat
+	}
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN")):
+		return pemVoucherFormat
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return jsonVoucherFormat
+	default:
+		return unknownVoucherFormat
+	}
+}
+
+// parseVoucherRequest parses a PEM, CBOR or JSON voucher import body.
+func parseVoucherRequest(body []byte) (*voucherRequest, error) {
+	switch sniffVoucherFormat(body) {
+	case pemVoucherFormat:
+		vouchers, warnings, err := parsePEMVouchers(body)
+		if err != nil {
+			return nil, err
+		}
+		return &voucherRequest{Vouchers: vouchers, Warnings: warnings}, nil
+
+	case cborVoucherFormat:
+		voucher, err := parseCBORVoucher(0, body)
+		if err != nil {
+			return nil, err
+		}
+		return &voucherRequest{Vouchers: []db.Voucher{voucher}}, nil
+
+	case jsonVoucherFormat:
+		var request struct {
+			Voucher   db.Voucher    `json:"voucher"`
+			OwnerKeys []db.OwnerKey `json:"owner_keys"`
+			URL       string        `json:"url"`
+			URLs      []string      `json:"urls"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, fmt.Errorf("error decoding JSON: %w", err)
+		}
+		if request.URL != "" || len(request.URLs) > 0 {
+			urls := request.URLs
+			if request.URL != "" {
+				urls = append([]string{request.URL}, urls...)
+			}
+			return &voucherRequest{URLs: urls, OwnerKeys: request.OwnerKeys}, nil
+		}
+		return &voucherRequest{
+			Vouchers:  []db.Voucher{request.Voucher},
+			OwnerKeys: request.OwnerKeys,
+		}, nil
+
+	default:
+		return nil, errUnsupportedVoucherFormat
+	}
+}
+
+func parsePEMVouchers(body []byte) ([]db.Voucher, []string, error) {
+	var vouchers []db.Voucher
+	rest := body
+	for {
+		var blk *pem.Block
+		blk, rest = pem.Decode(rest)
+		if blk == nil {
+			break
+		}
+		if blk.Type != "OWNERSHIP VOUCHER" {
+			return nil, nil, fmt.Errorf("expected PEM block of ownership voucher type, found %s", blk.Type)
+		}
+		voucher, err := parseCBORVoucher(len(vouchers), blk.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		vouchers = append(vouchers, voucher)
+	}
+	if len(vouchers) == 0 {
+		return nil, nil, errors.New("no ownership voucher found in PEM content")
+	}
+
+	var warnings []string
+	if len(bytes.TrimSpace(rest)) > 0 {
+		warnings = append(warnings, remainingPEMWarning)
+	}
+	return vouchers, warnings, nil
+}
+
+// parseCBORVoucher parses the voucher at index of an import request. When the
+// CBOR is malformed, the error locates the failure.
+func parseCBORVoucher(index int, data []byte) (db.Voucher, error) {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(data, &ov); err != nil {
+		if diag := diagnoseVoucherCBOR(data); diag != nil {
+			err = fmt.Errorf("%w: %w", err, diag)
+		}
+		return db.Voucher{}, &voucherDecodeError{Index: index, Err: err}
+	}
+	guid := ov.Header.Val.GUID
+	return db.Voucher{GUID: guid[:], CBOR: data}, nil
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DecodedVoucher is the decoded content of a voucher, so that clients can
+// show its provenance without decoding its CBOR.
+type DecodedVoucher struct {
+	Version             uint16               `json:"version"`
+	GUID                string               `json:"guid"`
+	DeviceInfo          string               `json:"device_info"`
+	RvInfo              []VoucherRvDirective `json:"rv_info"`
+	ManufacturerKey     VoucherPublicKey     `json:"manufacturer_key"`
+	CertChainHash       *VoucherHash         `json:"cert_chain_hash,omitempty"`
+	HeaderHmacAlgorithm string               `json:"header_hmac_algorithm"`
+	DeviceCertChain     []VoucherCert        `json:"device_cert_chain"`
+	Entries             []VoucherEntry       `json:"entries"`
+}
+
+// VoucherRvDirective is a rendezvous directive of the voucher header.
+type VoucherRvDirective struct {
+	URLs      []string `json:"urls"`
+	Bypass    bool     `json:"bypass"`
+	DelaySecs int64    `json:"delay_secs,omitempty"`
+}
+
+// VoucherPublicKey describes a public key of a voucher. The fingerprint is
+// omitted when the key cannot be decoded.
+type VoucherPublicKey struct {
+	Type        string `json:"type"`
+	Encoding    string `json:"encoding"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// VoucherHash is a hash of a voucher, its value hex encoded.
+type VoucherHash struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// VoucherEntry is an entry of a voucher, extending it to the owner of its
+// public key. Each entry is signed by the key of the previous owner, the
+// manufacturer for the first entry.
+type VoucherEntry struct {
+	PreviousHash        VoucherHash      `json:"previous_hash"`
+	HeaderHash          VoucherHash      `json:"header_hash"`
+	PublicKey           VoucherPublicKey `json:"public_key"`
+	SignedByKeyType     string           `json:"signed_by_key_type"`
+	SignedByFingerprint string           `json:"signed_by_fingerprint,omitempty"`
+}
+
+// decodeVoucher returns the decoded content of a parsed voucher.
+func decodeVoucher(ov *fdo.Voucher) DecodedVoucher {
+	header := ov.Header.Val
+	decoded := DecodedVoucher{
+		Version:             ov.Version,
+		GUID:                hex.EncodeToString(header.GUID[:]),
+		DeviceInfo:          header.DeviceInfo,
+		RvInfo:              []VoucherRvDirective{},
+		ManufacturerKey:     voucherPublicKey(header.ManufacturerKey),
+		HeaderHmacAlgorithm: hashAlgName(ov.Hmac.Algorithm),
+		DeviceCertChain:     decodeCertChain(ov),
+		Entries:             []VoucherEntry{},
+	}
+	for _, directive := range protocol.ParseDeviceRvInfo(header.RvInfo) {
+		urls := []string{}
+		for _, url := range directive.URLs {
+			urls = append(urls, url.String())
+		}
+		decoded.RvInfo = append(decoded.RvInfo, VoucherRvDirective{
+			URLs:      urls,
+			Bypass:    directive.Bypass,
+			DelaySecs: int64(directive.Delay.Seconds()),
+		})
+	}
+	if header.CertChainHash != nil {
+		hash := voucherHash(*header.CertChainHash)
+		decoded.CertChainHash = &hash
+	}
+
+	signer := decoded.ManufacturerKey
+	for _, entry := range ov.Entries {
+		if entry.Payload == nil {
+			continue
+		}
+		payload := entry.Payload.Val
+		publicKey := voucherPublicKey(payload.PublicKey)
+		decoded.Entries = append(decoded.Entries, VoucherEntry{
+			PreviousHash:        voucherHash(payload.PreviousHash),
+			HeaderHash:          voucherHash(payload.HeaderHash),
+			PublicKey:           publicKey,
+			SignedByKeyType:     signer.Type,
+			SignedByFingerprint: signer.Fingerprint,
+		})
+		signer = publicKey
+	}
+	return decoded
+}
+
+func voucherPublicKey(key protocol.PublicKey) VoucherPublicKey {
+	decoded := VoucherPublicKey{
+		Type:     key.Type.String(),
+		Encoding: key.Encoding.String(),
+	}
+	if pub, err := key.Public(); err == nil {
+		decoded.Fingerprint, _ = utils.PublicKeyFingerprint(pub)
+	}
+	return decoded
+}
+
+func voucherHash(hash protocol.Hash) VoucherHash {
+	return VoucherHash{
+		Algorithm: hashAlgName(hash.Algorithm),
+		Value:     hex.EncodeToString(hash.Value),
+	}
+}
+
+// hashAlgName names the hash algorithm, or returns its number when unknown,
+// as HashAlg.String panics on algorithms it does not know.
+func hashAlgName(alg protocol.HashAlg) string {
+	switch alg {
+	case protocol.Sha256Hash, protocol.Sha384Hash, protocol.HmacSha256Hash, protocol.HmacSha384Hash:
+		return alg.String()
+	}
+	return strconv.FormatInt(int64(alg), 10)
+}
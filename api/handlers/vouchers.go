@@ -4,27 +4,119 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/x509"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"log/slog"
 
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo-server/internal/cbordiag"
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/logging"
 	"github.com/fido-device-onboard/go-fdo-server/internal/rvinfo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 )
 
+// VoucherServer serves the voucher API from the database of State.
+type VoucherServer struct {
+	State *db.State
+	// RvInfo is updated to the RV info of the last imported voucher
+	RvInfo *[][]protocol.RvInstruction
+	// OwnerKeys sign the extensions of vouchers to their next owner
+	OwnerKeys fdo.OwnerKeyPersistentState
+	// MaxEntries refuses to extend vouchers with that many entries, if set
+	MaxEntries int
+}
+
+// VoucherCert describes a certificate of the device certificate chain of a
+// voucher.
+type VoucherCert struct {
+	Fingerprint string    `json:"fingerprint"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// voucherCertChain returns the device certificate chain embedded in the
+// voucher, device certificate first.
+func voucherCertChain(voucher db.Voucher) ([]VoucherCert, error) {
+	ov, err := db.ParseVoucher(voucher)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCertChain(ov), nil
+}
+
+// decodeCertChain returns the device certificate chain of a parsed voucher,
+// device certificate first.
+func decodeCertChain(ov *fdo.Voucher) []VoucherCert {
+	chain := []VoucherCert{}
+	if ov.CertChain == nil {
+		return chain
+	}
+	for _, cert := range *ov.CertChain {
+		cert := (*x509.Certificate)(cert)
+		chain = append(chain, VoucherCert{
+			Fingerprint: db.CertFingerprint(cert),
+			Subject:     cert.Subject.String(),
+			Issuer:      cert.Issuer.String(),
+			NotBefore:   cert.NotBefore.UTC(),
+			NotAfter:    cert.NotAfter.UTC(),
+		})
+	}
+	return chain
+}
+
+// GetVoucherHandler serves GetVoucher from the database given to db.InitDb.
 func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
+	(&VoucherServer{State: db.DefaultState()}).GetVoucher(w, r)
+}
+
+// InsertVoucherHandler serves InsertVoucher from the database given to
+// db.InitDb.
+func InsertVoucherHandler(rvInfo *[][]protocol.RvInstruction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		(&VoucherServer{State: db.DefaultState(), RvInfo: rvInfo}).InsertVoucher(w, r)
+	}
+}
+
+// GetVoucher responds with the voucher with the GUID in the guid query
+// parameter. The JSON response includes the decoded voucher, and also the
+// parsed device certificate chain when the include query parameter lists
+// device_cert_chain.
+func (s *VoucherServer) GetVoucher(w http.ResponseWriter, r *http.Request) {
 	guidHex := r.URL.Query().Get("guid")
 	if guidHex == "" {
 		http.Error(w, "GUID is required", http.StatusBadRequest)
 		return
 	}
 
+	var includeChain bool
+	for _, include := range r.URL.Query()["include"] {
+		for _, field := range strings.Split(include, ",") {
+			switch field {
+			case "device_cert_chain":
+				includeChain = true
+			default:
+				http.Error(w, fmt.Sprintf("Invalid include: %s", field), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
 	if !utils.IsValidGUID(guidHex) {
 		http.Error(w, fmt.Sprintf("Invalid GUID: %s", guidHex), http.StatusBadRequest)
 		return
@@ -36,32 +128,58 @@ func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	voucher, err := db.FetchVoucher(guid)
+	voucher, err := s.State.FetchVoucher(guid)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			slog.Debug("Voucher not found", "GUID", guidHex)
 			http.Error(w, "Voucher not found", http.StatusNotFound)
 		} else {
-			slog.Debug("Error querying database", "error", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeDBError(w, s.State, "Error fetching voucher", err)
+		}
+		return
+	}
+
+	if r.URL.Query().Get("format") == "diag" || negotiateContentType(r, "application/json", "application/cbor-diagnostic") == "application/cbor-diagnostic" {
+		diag, err := cbordiag.Diagnose(voucher.CBOR)
+		if err != nil {
+			slog.Debug("Error decoding voucher CBOR", "GUID", guidHex, "error", err)
+			http.Error(w, "Error decoding voucher CBOR", http.StatusInternalServerError)
+			return
 		}
+		w.Header().Set("Content-Type", "application/cbor-diagnostic")
+		w.Write([]byte(diag + "\n"))
 		return
 	}
 
-	ownerKeys, err := db.FetchOwnerKeys()
+	ownerKeys, err := s.State.FetchOwnerKeys()
 	if err != nil {
-		slog.Debug("Error querying owner_keys", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDBError(w, s.State, "Error fetching owner keys", err)
 		return
 	}
 
 	response := struct {
-		Voucher   db.Voucher    `json:"voucher"`
-		OwnerKeys []db.OwnerKey `json:"owner_keys"`
+		Voucher         db.Voucher      `json:"voucher"`
+		OwnerKeys       []db.OwnerKey   `json:"owner_keys"`
+		DeviceCertChain []VoucherCert   `json:"device_cert_chain,omitempty"`
+		Decoded         *DecodedVoucher `json:"decoded,omitempty"`
 	}{
 		Voucher:   voucher,
 		OwnerKeys: ownerKeys,
 	}
+	// The stored voucher is returned as is when it cannot be decoded
+	if ov, err := db.ParseVoucher(voucher); err != nil {
+		slog.Debug("Error decoding voucher", "GUID", guidHex, "error", err)
+	} else {
+		decoded := decodeVoucher(ov)
+		response.Decoded = &decoded
+	}
+	if includeChain {
+		if response.DeviceCertChain, err = voucherCertChain(voucher); err != nil {
+			slog.Debug("Error parsing voucher", "GUID", guidHex, "error", err)
+			http.Error(w, "Error parsing voucher", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	data, err := json.Marshal(response)
 	if err != nil {
@@ -73,40 +191,172 @@ func GetVoucherHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
-func InsertVoucherHandler(rvInfo *[][]protocol.RvInstruction) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		var request struct {
-			Voucher   db.Voucher    `json:"voucher"`
-			OwnerKeys []db.OwnerKey `json:"owner_keys"`
-		}
+// VoucherImportResponse summarizes a voucher import. Warnings report content
+// that was ignored without failing the import.
+type VoucherImportResponse struct {
+	Detected int      `json:"detected"`
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	GUIDs    []string `json:"guids"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+var importExtender func(*fdo.Voucher) (*fdo.Voucher, error)
+
+// SetImportExtender sets a function that extends vouchers before they are
+// imported, such as to the owner key of this server. A nil function imports
+// vouchers as they are.
+func SetImportExtender(extend func(*fdo.Voucher) (*fdo.Voucher, error)) {
+	importExtender = extend
+}
+
+// extendImportedVoucher applies the import extender to a voucher, returning
+// it with the CBOR of the extended voucher.
+func extendImportedVoucher(voucher db.Voucher) (db.Voucher, error) {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+		return db.Voucher{}, err
+	}
+	extended, err := importExtender(&ov)
+	if err != nil {
+		return db.Voucher{}, err
+	}
+	if extended == &ov {
+		return voucher, nil
+	}
+	if voucher.CBOR, err = cbor.Marshal(extended); err != nil {
+		return db.Voucher{}, err
+	}
+	return voucher, nil
+}
+
+// InsertVoucher imports the vouchers in the request body.
+func (s *VoucherServer) InsertVoucher(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	// An empty body is most likely a file that failed to upload
+	if len(bytes.TrimSpace(body)) == 0 {
+		http.Error(w, "Empty request body", http.StatusBadRequest)
+		return
+	}
 
-		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	request, err := parseVoucherRequest(body)
+	if errors.Is(err, errUnsupportedVoucherFormat) {
+		slog.Debug("Unsupported voucher format", "content-type", r.Header.Get("Content-Type"))
+		http.Error(w, "Unsupported voucher format", http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		slog.Debug("Error parsing vouchers", "error", err)
+		var decodeErr *voucherDecodeError
+		if errors.As(err, &decodeErr) {
+			http.Error(w, "Invalid request payload: "+decodeErr.Error(), http.StatusBadRequest)
 			return
 		}
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
 
-		guidHex := hex.EncodeToString(request.Voucher.GUID)
-		slog.Debug("Inserting voucher", "GUID", guidHex)
+	if err := request.fetchVoucherURLs(r.Context()); errors.Is(err, errURLNotAllowed) {
+		slog.Debug("Voucher URL rejected", "error", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if errors.Is(err, errUnsupportedVoucherFormat) {
+		slog.Debug("Unsupported voucher format", "error", err)
+		http.Error(w, "Unsupported voucher format", http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		slog.Debug("Error fetching vouchers", "error", err)
+		http.Error(w, "Error fetching vouchers", http.StatusBadGateway)
+		return
+	}
 
-		if err := db.InsertVoucher(request.Voucher); err != nil {
-			slog.Debug("Error inserting into database", "error", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
+	for _, warning := range request.Warnings {
+		slog.Debug("Voucher import warning", "warning", warning)
+	}
+
+	response := VoucherImportResponse{
+		Detected: len(request.Vouchers),
+		Warnings: request.Warnings,
+	}
+	for i, voucher := range request.Vouchers {
+		guidHex := hex.EncodeToString(voucher.GUID)
+		logging.Sampled().Debug("Inserting voucher", "GUID", guidHex)
+
+		if importExtender != nil {
+			if request.Vouchers[i], err = extendImportedVoucher(voucher); err != nil {
+				slog.Debug("Error extending voucher", "GUID", guidHex, "error", err)
+				http.Error(w, fmt.Sprintf("Voucher %s cannot be extended to the owner key", guidHex), http.StatusBadRequest)
+				return
+			}
+		}
+		if warning := db.RVBypassWarning(request.Vouchers[i].CBOR); warning != "" {
+			response.Warnings = append(response.Warnings, warning)
 		}
+	}
 
-		if err := db.UpdateOwnerKeys(request.OwnerKeys); err != nil {
-			slog.Debug("Error updating owner key in database", "error", err)
+	stored, err := s.State.ImportVouchers(request.Vouchers)
+	for i, ok := range stored {
+		if ok {
+			response.Imported++
+			audit.Record(r.Context(), audit.VoucherImported, request.Vouchers[i].GUID, nil, "")
+		} else {
+			response.Skipped++
+		}
+		response.GUIDs = append(response.GUIDs, hex.EncodeToString(request.Vouchers[i].GUID))
+	}
+	if err != nil {
+		var guidHex string
+		var importErr *db.VoucherImportError
+		if errors.As(err, &importErr) {
+			guidHex = hex.EncodeToString(request.Vouchers[importErr.Index].GUID)
+			err = importErr.Err
+			audit.Record(r.Context(), audit.VoucherImported, request.Vouchers[importErr.Index].GUID, err, "")
+		}
+		if errors.Is(err, db.ErrVoucherExists) {
+			slog.Debug("Voucher already exists", "GUID", guidHex)
+			http.Error(w, fmt.Sprintf("Voucher %s already exists (not overwriting)", guidHex), http.StatusConflict)
+		} else if errors.Is(err, db.ErrUntrustedManufacturer) || errors.Is(err, db.ErrUntrustedDevice) || errors.Is(err, db.ErrInvalidVoucherHMAC) || errors.Is(err, db.ErrRVBypassVoucher) {
+			slog.Debug("Rejecting voucher", "GUID", guidHex, "error", err)
+			http.Error(w, fmt.Sprintf("Voucher %s: %v", guidHex, err), http.StatusBadRequest)
+		} else {
+			slog.Debug("Error inserting into database", "GUID", guidHex, "error", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
-			return
 		}
+		return
+	}
 
-		newRvInfo, err := rvinfo.GetRvInfoFromVoucher(request.Voucher.CBOR)
-		if err != nil {
-			fmt.Println("Error:", err)
-			return
-		}
-		*rvInfo = newRvInfo
+	err = s.State.UpdateOwnerKeys(request.OwnerKeys)
+	if len(request.OwnerKeys) > 0 {
+		audit.Record(r.Context(), audit.OwnerKeyUpdated, nil, err, fmt.Sprintf("%d owner keys", len(request.OwnerKeys)))
+	}
+	if err != nil {
+		slog.Debug("Error updating owner key in database", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	newRvInfo, err := rvinfo.GetRvInfoFromVoucher(request.Vouchers[len(request.Vouchers)-1].CBOR)
+	if err != nil {
+		slog.Debug("Error reading RV info of voucher", "error", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if s.RvInfo != nil {
+		*s.RvInfo = newRvInfo
+	}
+
+	if negotiateContentType(r, "text/plain", "application/json") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(guidHex))
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	for _, warning := range response.Warnings {
+		w.Header().Add("Warning", fmt.Sprintf("199 - %q", warning))
 	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strings.Join(response.GUIDs, "\n")))
 }
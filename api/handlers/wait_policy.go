@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// WaitPolicyHandler reads and updates the bounds of the wait time granted to
+// RV blobs registered by TO0.
+func WaitPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getWaitPolicy(w, r)
+	case http.MethodPut:
+		updateWaitPolicy(w, r)
+	default:
+		slog.Debug("Method not allowed", "method", r.Method, "path", r.URL.Path)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getWaitPolicy(w http.ResponseWriter, _ *http.Request) {
+	policy, err := db.FetchWaitPolicy()
+	if err != nil {
+		slog.Debug("Error fetching wait policy", "error", err)
+		http.Error(w, "Error fetching wait policy", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+func updateWaitPolicy(w http.ResponseWriter, r *http.Request) {
+	// Both bounds are required; negative values fail to decode
+	var request struct {
+		MinWaitSecs *uint32 `json:"min_wait_secs"`
+		MaxWaitSecs *uint32 `json:"max_wait_secs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.MinWaitSecs == nil || request.MaxWaitSecs == nil {
+		slog.Debug("Invalid wait policy", "error", err)
+		http.Error(w, "Invalid wait policy: min_wait_secs and max_wait_secs must be non-negative integers", http.StatusBadRequest)
+		return
+	}
+	policy := db.WaitPolicy{MinWaitSecs: *request.MinWaitSecs, MaxWaitSecs: *request.MaxWaitSecs}
+
+	if err := db.UpdateWaitPolicy(policy); errors.Is(err, db.ErrInvalidWaitPolicy) {
+		http.Error(w, "Invalid wait policy: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		slog.Debug("Error updating wait policy", "error", err)
+		http.Error(w, "Error updating wait policy", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
@@ -0,0 +1,103 @@
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestAuditLogHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	guid := protocol.GUID{0xa0, 0x01}
+	guidHex := hex.EncodeToString(guid[:])
+	body := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: newTestVoucher(t, guid, "audited-device")})
+	response, err := http.Post(server.URL+"/api/v1/owner/vouchers", "application/x-pem-file", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Status code of voucher import is %v", response.StatusCode)
+	}
+	for range 3 {
+		audit.Record(context.Background(), audit.VoucherRemoved, []byte{0xa0, 0x02}, nil, "")
+	}
+
+	get := func(t *testing.T, query string) handlers.AuditLogResponse {
+		t.Helper()
+		response, err := http.Get(server.URL + "/api/v1/audit" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var page handlers.AuditLogResponse
+		if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	t.Run("filter by GUID", func(t *testing.T) {
+		page := get(t, "?guid="+guidHex)
+		if len(page.Events) != 1 || page.Next != nil {
+			t.Fatalf("Unexpected page %+v", page)
+		}
+		if e := page.Events[0]; e.Operation != audit.VoucherImported || e.Actor != api.UnauthenticatedActor || e.Result != audit.Success {
+			t.Errorf("Unexpected event %+v", e)
+		}
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		first := get(t, "?operation="+audit.VoucherRemoved+"&limit=2")
+		if len(first.Events) != 2 || first.Next == nil {
+			t.Fatalf("Unexpected first page %+v", first)
+		}
+		second := get(t, "?operation="+audit.VoucherRemoved+"&limit=2&after="+strconv.FormatInt(*first.Next, 10))
+		if len(second.Events) != 1 || second.Next != nil || second.Events[0].ID <= first.Events[1].ID {
+			t.Fatalf("Unexpected second page %+v", second)
+		}
+	})
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"?guid=xyz", "?limit=0", "?limit=100000", "?after=-1"} {
+			response, err := http.Get(server.URL + "/api/v1/audit" + query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: status code is %v", query, response.StatusCode)
+			}
+		}
+	})
+
+	t.Run("events are immutable", func(t *testing.T) {
+		if _, err := state.DB().Exec("UPDATE audit_events SET actor = 'someone else'"); err == nil {
+			t.Error("Expected audit events to reject updates")
+		}
+		if _, err := state.DB().Exec("DELETE FROM audit_events"); err == nil {
+			t.Error("Expected audit events to reject deletes")
+		}
+	})
+}
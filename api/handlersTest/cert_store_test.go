@@ -0,0 +1,105 @@
+package handlersTest
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestTrustedCertStores(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]db.TrustedCertStore{
+		"manufacturer": db.TrustedManufacturerCAs,
+		"device":       db.TrustedDeviceCAs,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if pool, err := store.Pool(); err != nil || pool != nil {
+				t.Fatalf("expected no pool for an empty store, got %v, %v", pool, err)
+			}
+
+			first, _ := newTestManufacturer(t)
+			second, _ := newTestManufacturer(t)
+			for i, test := range []struct {
+				cert *x509.Certificate
+				want bool
+			}{{first, true}, {second, true}, {first, false}} {
+				inserted, err := store.Insert(test.cert)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if inserted != test.want {
+					t.Errorf("insert %d returned %v", i, inserted)
+				}
+			}
+
+			certs, err := store.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(certs) != 2 {
+				t.Fatalf("expected 2 certificates, got %d", len(certs))
+			}
+			if db.CertFingerprint(certs[0]) > db.CertFingerprint(certs[1]) {
+				t.Error("certificates are not ordered by fingerprint")
+			}
+
+			got, err := store.Get(db.CertFingerprint(first))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(first) {
+				t.Error("Get returned the wrong certificate")
+			}
+
+			pool, err := store.Pool()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pool == nil || !pool.Equal(func() *x509.CertPool {
+				p := x509.NewCertPool()
+				p.AddCert(first)
+				p.AddCert(second)
+				return p
+			}()) {
+				t.Error("pool does not contain the stored certificates")
+			}
+
+			if err := store.Delete(db.CertFingerprint(first)); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Delete(db.CertFingerprint(first)); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("deleting twice returned %v", err)
+			}
+			if _, err := store.Get(db.CertFingerprint(first)); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("Get after delete returned %v", err)
+			}
+		})
+	}
+
+	t.Run("stores are independent", func(t *testing.T) {
+		ca, _ := newTestManufacturer(t)
+		if _, err := db.TrustedManufacturerCAs.Insert(ca); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.TrustedDeviceCAs.Get(db.CertFingerprint(ca)); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("manufacturer CA is visible in the device CA store: %v", err)
+		}
+	})
+}
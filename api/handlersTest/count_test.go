@@ -0,0 +1,97 @@
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestVoucherCountHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.VoucherCountHandler)
+	defer server.Close()
+	defer state.Close()
+
+	for i, deviceInfo := range []string{"gateway", "sensor", "gateway", "camera", "gateway", "sensor"} {
+		guid := protocol.GUID{0xfc, byte(i)}
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, deviceInfo)}); err != nil {
+			t.Fatal(err)
+		}
+		// Every other device has been onboarded
+		if i%2 == 0 {
+			if err := db.RecordTO2Completed(guid[:], guid[:]); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	facets, err := db.FetchDeviceInfoFacets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	facetTotal := func(deviceInfo string) int {
+		for _, facet := range facets {
+			if facet.DeviceInfo == deviceInfo {
+				return facet.Count
+			}
+		}
+		return 0
+	}
+
+	for _, test := range []struct {
+		query string
+		want  int
+	}{
+		{"", 6},
+		{"?device_info=gateway", facetTotal("gateway")},
+		{"?device_info=sensor", facetTotal("sensor")},
+		{"?device_info=missing", 0},
+		{"?search=SENS", facetTotal("sensor")},
+		{"?search=fc03", 1},
+		{"?completed=true", 3},
+		{"?completed=false", 3},
+		{"?device_info=gateway&completed=true", 3},
+		{"?device_info=sensor&completed=true", 0},
+	} {
+		t.Run(test.query, func(t *testing.T) {
+			response, err := http.Get(server.URL + test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var result struct {
+				Total int `json:"total"`
+			}
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Total != test.want {
+				t.Errorf("total is %d, want %d", result.Total, test.want)
+			}
+		})
+	}
+
+	t.Run("invalid completed", func(t *testing.T) {
+		response, err := http.Get(server.URL + "?completed=maybe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
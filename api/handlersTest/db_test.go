@@ -0,0 +1,49 @@
+package handlersTest
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestFetchVouchersOrder(t *testing.T) {
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// Vouchers are inserted out of GUID order
+	guids := []protocol.GUID{{0x03}, {0x01}, {0x04}, {0x02}}
+	for _, guid := range guids {
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "test-device")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 3 {
+		vouchers, err := db.FetchVouchers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(vouchers) != len(guids) {
+			t.Fatalf("fetched %d vouchers, want %d", len(vouchers), len(guids))
+		}
+		for i, voucher := range vouchers {
+			want := protocol.GUID{byte(i + 1)}
+			if !bytes.Equal(voucher.GUID, want[:]) {
+				t.Fatalf("voucher %d has GUID %x, want %x", i, voucher.GUID, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package handlersTest
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDatabaseUnavailable(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	// The handlers cannot reach a closed database
+	if err := state.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	guid := "00000000000000000000000000000001"
+	for _, path := range []string{
+		"/api/v1/vouchers?guid=" + guid,
+		"/api/v1/owner/devices/" + guid + "/bundle",
+		"/fdo/status/" + guid,
+		"/api/v1/rvinfo",
+		"/api/v1/owner/redirect",
+		"/api/v1/owner/device-cas/" + strings.Repeat("ab", 32),
+	} {
+		t.Run(path, func(t *testing.T) {
+			response, err := http.Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusServiceUnavailable {
+				t.Errorf("Status code is %v, want %v", response.StatusCode, http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// newTestExpiredDeviceCA returns a device CA that expired at notAfter and a
+// voucher whose device certificate it issued before expiring.
+func newTestExpiredDeviceCA(t *testing.T, guid protocol.GUID, notAfter time.Time) (*x509.Certificate, []byte) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Expired Device CA"},
+		NotBefore:             notAfter.Add(-30 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(newTestVoucher(t, guid, "grace-device"), &ov); err != nil {
+		t.Fatal(err)
+	}
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "grace-device"},
+		NotBefore:    notAfter.Add(-7 * 24 * time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+	der, err = x509.CreateCertificate(rand.Reader, deviceTemplate, ca, deviceKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov.CertChain = &[]*cbor.X509Certificate{(*cbor.X509Certificate)(device), (*cbor.X509Certificate)(ca)}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, data
+}
+
+func TestInsertVoucherDeviceCAGracePeriod(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+	defer db.SetDeviceCAGracePeriod(0)
+
+	for i, test := range []struct {
+		name    string
+		expired time.Duration
+		grace   time.Duration
+		want    int
+	}{
+		{"no grace period", time.Hour, 0, http.StatusBadRequest},
+		{"within grace period", time.Hour, 48 * time.Hour, http.StatusOK},
+		{"beyond grace period", 72 * time.Hour, 48 * time.Hour, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			guid := protocol.GUID{0x9c, byte(i)}
+			ca, ovCBOR := newTestExpiredDeviceCA(t, guid, time.Now().Add(-test.expired))
+			if _, err := db.TrustedDeviceCAs.Insert(ca); err != nil {
+				t.Fatal(err)
+			}
+			defer func() { _ = db.TrustedDeviceCAs.Delete(db.CertFingerprint(ca)) }()
+			db.SetDeviceCAGracePeriod(test.grace)
+
+			response := postVoucher(t, server.URL, "application/cbor", ovCBOR)
+			defer response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+			_, err := db.FetchVoucher(guid[:])
+			if stored := err == nil; stored != (test.want == http.StatusOK) {
+				t.Errorf("Voucher stored is %v", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// newTestUnusualDeviceVoucher returns a voucher whose device certificate,
+// issued by ca, only allows client authentication and has a critical vendor
+// extension, both of which default verification rejects.
+func newTestUnusualDeviceVoucher(t *testing.T, guid protocol.GUID, ca *x509.Certificate, caKey *ecdsa.PrivateKey) []byte {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(newTestVoucher(t, guid, "unusual-device"), &ov); err != nil {
+		t.Fatal(err)
+	}
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "unusual-device"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{
+			Id:       asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1},
+			Critical: true,
+			Value:    []byte{0x05, 0x00},
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, deviceKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov.CertChain = &[]*cbor.X509Certificate{(*cbor.X509Certificate)(device), (*cbor.X509Certificate)(ca)}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInsertVoucherDeviceCertVerifyOptions(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+	defer db.SetDeviceCertVerifyOptions(nil)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCert(t, "Device CA", true, caKey.Public(), nil, caKey)
+	if _, err := db.TrustedDeviceCAs.Insert(ca); err != nil {
+		t.Fatal(err)
+	}
+
+	relaxed := &db.DeviceCertVerifyOptions{
+		KeyUsages:                []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		IgnoreCriticalExtensions: true,
+	}
+	rsaOnly := &db.DeviceCertVerifyOptions{
+		KeyUsages:                []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		SignatureAlgorithms:      []x509.SignatureAlgorithm{x509.SHA256WithRSA},
+		IgnoreCriticalExtensions: true,
+	}
+	for i, test := range []struct {
+		name string
+		opts *db.DeviceCertVerifyOptions
+		want int
+	}{
+		{"strict", nil, http.StatusBadRequest},
+		{"client auth only", &db.DeviceCertVerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}, http.StatusBadRequest},
+		{"relaxed", relaxed, http.StatusOK},
+		{"disallowed signature algorithm", rsaOnly, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db.SetDeviceCertVerifyOptions(test.opts)
+			guid := protocol.GUID{0x9d, byte(i)}
+			response := postVoucher(t, server.URL, "application/cbor", newTestUnusualDeviceVoucher(t, guid, ca, caKey))
+			defer response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlersTest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func TestDeviceInfoAllowlistHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.DeviceInfoAllowlistHandler)
+	defer server.Close()
+	defer state.Close()
+
+	put := func(t *testing.T, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("not enforced", func(t *testing.T) {
+		if err := db.CheckDeviceInfoAllowed("unknown-device"); err != nil {
+			t.Errorf("device was rejected without enforcement: %v", err)
+		}
+	})
+
+	db.SetDeviceInfoAllowlistEnforced(true)
+	defer db.SetDeviceInfoAllowlistEnforced(false)
+
+	t.Run("empty allowlist", func(t *testing.T) {
+		if err := db.CheckDeviceInfoAllowed("gateway-01"); !errors.Is(err, db.ErrDeviceInfoNotAllowed) {
+			t.Errorf("got error %v, want %v", err, db.ErrDeviceInfoNotAllowed)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		response := put(t, `["sensor-0042","gateway-*"]`)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var entries []string
+		if err := json.NewDecoder(response.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"gateway-*", "sensor-0042"}; !slices.Equal(entries, want) {
+			t.Errorf("got allowlist %v, want %v", entries, want)
+		}
+	})
+
+	for _, test := range []struct {
+		info    string
+		allowed bool
+	}{
+		{"gateway-01", true},
+		{"sensor-0042", true},
+		{"sensor-0043", false},
+		{"camera", false},
+	} {
+		t.Run("DI "+test.info, func(t *testing.T) {
+			err := db.CheckDeviceInfoAllowed(test.info)
+			if test.allowed && err != nil {
+				t.Errorf("allowlisted device was rejected: %v", err)
+			}
+			if !test.allowed && !errors.Is(err, db.ErrDeviceInfoNotAllowed) {
+				t.Errorf("got error %v, want %v", err, db.ErrDeviceInfoNotAllowed)
+			}
+		})
+	}
+
+	t.Run("invalid entries", func(t *testing.T) {
+		for _, body := range []string{`["gateway-["]`, `[""]`, `{"entries":[]}`} {
+			response := put(t, body)
+			response.Body.Close()
+			if response.StatusCode != http.StatusBadRequest {
+				t.Errorf("%s: status code is %v", body, response.StatusCode)
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func TestDeviceInventory(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	for i, devmod := range []db.Device{
+		{OS: "Linux", Arch: "x86_64", Version: "6.1", Device: "gateway", Modules: []string{"devmod", "fdo.download"}},
+		{OS: "Linux", Arch: "aarch64", Version: "6.1", Device: "camera", Modules: []string{"devmod"}},
+		{OS: "Linux", Arch: "x86_64", Version: "6.6", Device: "gateway", Modules: []string{"devmod", "fdo.upload"}},
+		{OS: "Windows", Arch: "x86_64", Version: "11", Device: "kiosk", Modules: []string{"devmod"}},
+	} {
+		guid := []byte{0xde, byte(i), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		if err := db.RecordDevice(guid, devmod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first device onboarded with a replacement GUID
+	if err := db.RecordTO2Completed(
+		[]byte{0xde, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0xdf, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(t *testing.T, query string) (int, handlers.DeviceListResponse) {
+		response, err := http.Get(server.URL + "/api/v1/owner/devices" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var body handlers.DeviceListResponse
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response.StatusCode, body
+	}
+	guids := func(devices []db.Device) (guids []string) {
+		for _, device := range devices {
+			guids = append(guids, device.GUID)
+		}
+		return guids
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		status, body := list(t, "?os=Linux&arch=x86_64")
+		if status != http.StatusOK {
+			t.Fatalf("Status code is %v", status)
+		}
+		want := []string{"de000000000000000000000000000000", "de020000000000000000000000000000"}
+		if got := guids(body.Devices); !slices.Equal(got, want) {
+			t.Errorf("Devices are %v, want %v", got, want)
+		}
+		if _, body := list(t, "?version=11"); len(body.Devices) != 1 || body.Devices[0].Device != "kiosk" {
+			t.Errorf("Devices of version 11 are %+v", body.Devices)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		var all []string
+		query := "?limit=3"
+		for pages := 0; ; pages++ {
+			if pages > 2 {
+				t.Fatal("Too many pages")
+			}
+			status, body := list(t, query)
+			if status != http.StatusOK {
+				t.Fatalf("Status code is %v", status)
+			}
+			all = append(all, guids(body.Devices)...)
+			if body.Next == "" {
+				break
+			}
+			query = "?limit=3&after=" + body.Next
+		}
+		if len(all) != 4 || !slices.IsSorted(all) {
+			t.Errorf("Paged devices are %v", all)
+		}
+		if status, _ := list(t, "?limit=0"); status != http.StatusBadRequest {
+			t.Errorf("Invalid limit status code is %v", status)
+		}
+		if status, _ := list(t, "?after=xyz"); status != http.StatusBadRequest {
+			t.Errorf("Invalid after status code is %v", status)
+		}
+	})
+
+	get := func(t *testing.T, guid string) (int, db.Device) {
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/" + guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var device db.Device
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&device); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response.StatusCode, device
+	}
+
+	t.Run("device", func(t *testing.T) {
+		for _, guid := range []string{"de000000000000000000000000000000", "df000000000000000000000000000000"} {
+			status, device := get(t, guid)
+			if status != http.StatusOK {
+				t.Fatalf("Status code of %s is %v", guid, status)
+			}
+			if device.GUID != "de000000000000000000000000000000" || device.Arch != "x86_64" || !slices.Equal(device.Modules, []string{"devmod", "fdo.download"}) {
+				t.Errorf("Device %s is %+v", guid, device)
+			}
+		}
+		if status, _ := get(t, "de090000000000000000000000000000"); status != http.StatusNotFound {
+			t.Errorf("Unknown device status code is %v", status)
+		}
+		if status, _ := get(t, "not-a-guid"); status != http.StatusBadRequest {
+			t.Errorf("Invalid GUID status code is %v", status)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// newTestVoucher builds a structurally valid, unsigned voucher carrying a
+// self-signed device certificate. It is only suitable for handlers that do
+// not verify voucher signatures.
+func newTestVoucher(t *testing.T, guid protocol.GUID, deviceInfo string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: deviceInfo},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ov := fdo.Voucher{
+		Version: 101,
+		Header: cbor.Bstr[fdo.VoucherHeader]{Val: fdo.VoucherHeader{
+			Version:    101,
+			GUID:       guid,
+			DeviceInfo: deviceInfo,
+			ManufacturerKey: protocol.PublicKey{
+				Type:     protocol.Secp256r1KeyType,
+				Encoding: protocol.X509KeyEnc,
+				Body:     utils.MustMarshal([]byte{}),
+			},
+		}},
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: make([]byte, 32)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(cert)},
+	}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func setupTestRoutes(t *testing.T) (*httptest.Server, *sqlite.DB) {
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+	var rvInfo [][]protocol.RvInstruction
+	server := httptest.NewServer(api.NewHTTPHandler(nil, &rvInfo, state).RegisterRoutes())
+	return server, state
+}
+
+func TestDeviceBundleHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	guid := protocol.GUID{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10}
+	ovCBOR := newTestVoucher(t, guid, "test-device")
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovCBOR}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("GET bundle", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/0102030405060708090a0b0c0d0e0f10/bundle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+
+		var bundle handlers.DeviceBundle
+		if err := json.NewDecoder(response.Body).Decode(&bundle); err != nil {
+			t.Fatalf("Unable to parse bundle response %v", err)
+		}
+		if bundle.DeviceInfo != "test-device" {
+			t.Errorf("Wrong device info %q", bundle.DeviceInfo)
+		}
+		blk, _ := pem.Decode([]byte(bundle.Voucher))
+		if blk == nil || blk.Type != "OWNERSHIP VOUCHER" {
+			t.Errorf("Bundle does not contain a PEM voucher")
+		}
+		blk, _ = pem.Decode([]byte(bundle.DeviceCertChain))
+		if blk == nil || blk.Type != "CERTIFICATE" {
+			t.Errorf("Bundle does not contain the device certificate chain")
+		}
+	})
+
+	t.Run("GET bundle for unknown GUID", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/ffffffffffffffffffffffffffffffff/bundle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestDeviceBundleOwnerKeyFingerprint(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgCert := newTestCert(t, "Manufacturer", false, mfgKey.Public(), nil, mfgKey)
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceCert := newTestCert(t, "rotated-device", false, deviceKey.Public(), mfgCert, mfgKey)
+
+	// Several owner keys are configured during a key rotation
+	var ownerKeys []*ecdsa.PrivateKey
+	for range 3 {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ownerKeys = append(ownerKeys, key)
+	}
+
+	guid := protocol.GUID{0x0f, 0x01}
+	ov := fdo.Voucher{
+		Version: 101,
+		Header: cbor.Bstr[fdo.VoucherHeader]{Val: fdo.VoucherHeader{
+			Version:    101,
+			GUID:       guid,
+			DeviceInfo: "rotated-device",
+			ManufacturerKey: protocol.PublicKey{
+				Type:     protocol.Secp256r1KeyType,
+				Encoding: protocol.X5ChainKeyEnc,
+				Body:     utils.MustMarshal([]*cbor.X509Certificate{(*cbor.X509Certificate)(mfgCert)}),
+			},
+		}},
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: make([]byte, 32)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCert), (*cbor.X509Certificate)(mfgCert)},
+	}
+	extended, err := fdo.ExtendVoucher(&ov, mfgKey, &ownerKeys[1].PublicKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ovCBOR, err := cbor.Marshal(extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovCBOR}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "/api/v1/owner/devices/0f010000000000000000000000000000/bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Status code is %v", response.StatusCode)
+	}
+	var bundle handlers.DeviceBundle
+	if err := json.NewDecoder(response.Body).Decode(&bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range ownerKeys {
+		fingerprint, err := utils.PublicKeyFingerprint(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reported := bundle.OwnerKeyFingerprint == fingerprint; reported != (i == 1) {
+			t.Errorf("owner key %d reported is %v", i, reported)
+		}
+	}
+}
+
+func TestDeviceBundleModules(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	// One device has completed TO2 and another is still onboarding
+	onboarded, newGUID := protocol.GUID{0x0d, 0x01}, protocol.GUID{0x0d, 0x02}
+	onboarding := protocol.GUID{0x0d, 0x03}
+	for _, guid := range []protocol.GUID{onboarded, onboarding} {
+		if _, err := db.ImportVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "module-device")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.RecordDevice(guid[:], db.Device{
+			OS:      "linux",
+			Arch:    "arm64",
+			Version: "6.1",
+			Device:  "gateway",
+			Modules: []string{"devmod", "fdo.download", "fdo.command"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for _, module := range []string{"fdo.download", "fdo.command"} {
+			if err := db.RecordDeviceEvent(guid[:], db.ModuleStartedEvent, module); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := db.RecordTO2Completed(onboarded[:], newGUID[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		guid    protocol.GUID
+		outcome string
+	}{
+		{"completed TO2", onboarded, handlers.ModuleCompleted},
+		{"incomplete TO2", onboarding, handlers.ModuleStarted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := http.Get(server.URL + "/api/v1/owner/devices/" + hex.EncodeToString(test.guid[:]) + "/bundle")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var bundle handlers.DeviceBundle
+			if err := json.NewDecoder(response.Body).Decode(&bundle); err != nil {
+				t.Fatal(err)
+			}
+
+			if bundle.Devmod == nil {
+				t.Fatal("Bundle does not contain the devmod")
+			}
+			if bundle.Devmod.OS != "linux" || bundle.Devmod.Arch != "arm64" || bundle.Devmod.Device != "gateway" {
+				t.Errorf("Wrong devmod %+v", bundle.Devmod)
+			}
+			if !slices.Equal(bundle.Devmod.Modules, []string{"devmod", "fdo.download", "fdo.command"}) {
+				t.Errorf("Wrong supported modules %v", bundle.Devmod.Modules)
+			}
+
+			var got []string
+			for _, module := range bundle.Modules {
+				got = append(got, module.Module+":"+module.Outcome)
+			}
+			want := []string{"fdo.download:" + test.outcome, "fdo.command:" + test.outcome}
+			if !slices.Equal(got, want) {
+				t.Errorf("got module outcomes %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("no TO2", func(t *testing.T) {
+		guid := protocol.GUID{0x0d, 0x04}
+		if _, err := db.ImportVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "module-device")}); err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/" + hex.EncodeToString(guid[:]) + "/bundle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var bundle handlers.DeviceBundle
+		if err := json.NewDecoder(response.Body).Decode(&bundle); err != nil {
+			t.Fatal(err)
+		}
+		if bundle.Devmod != nil || len(bundle.Modules) != 0 {
+			t.Errorf("Bundle of a device without TO2 has devmod %+v and modules %v", bundle.Devmod, bundle.Modules)
+		}
+	})
+}
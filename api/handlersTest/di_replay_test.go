@@ -0,0 +1,73 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestCheckDIRequest(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+	defer db.SetDIReplayWindow(0)
+
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+
+	t.Run("disabled", func(t *testing.T) {
+		if err := db.CheckDIRequest(deviceKey.Public(), start.Add(24*time.Hour)); err != nil {
+			t.Errorf("DI request rejected without a window: %v", err)
+		}
+	})
+
+	db.SetDIReplayWindow(time.Hour)
+
+	t.Run("first request", func(t *testing.T) {
+		if err := db.CheckDIRequest(deviceKey.Public(), start); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("retry in window", func(t *testing.T) {
+		if err := db.CheckDIRequest(deviceKey.Public(), start.Add(30*time.Minute)); err != nil {
+			t.Errorf("DI request in the window rejected: %v", err)
+		}
+	})
+
+	t.Run("stale replay", func(t *testing.T) {
+		err := db.CheckDIRequest(deviceKey.Public(), start.Add(2*time.Hour))
+		if !errors.Is(err, db.ErrStaleDIRequest) {
+			t.Errorf("expected ErrStaleDIRequest, got %v", err)
+		}
+	})
+
+	t.Run("other device", func(t *testing.T) {
+		if err := db.CheckDIRequest(otherKey.Public(), start.Add(2*time.Hour)); err != nil {
+			t.Errorf("DI request of another device rejected: %v", err)
+		}
+	})
+}
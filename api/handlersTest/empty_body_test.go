@@ -0,0 +1,46 @@
+package handlersTest
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportEmptyBody(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	for _, path := range []string{
+		"/api/v1/owner/vouchers",
+		"/api/v1/owner/manufacturer-cas",
+		"/api/v1/owner/device-cas",
+	} {
+		for name, body := range map[string]string{
+			"empty":           "",
+			"whitespace-only": " \r\n\t\n",
+		} {
+			t.Run(path+" "+name, func(t *testing.T) {
+				response := postVoucher(t, server.URL+path, "application/x-pem-file", []byte(body))
+				defer response.Body.Close()
+
+				if response.StatusCode != http.StatusBadRequest {
+					t.Errorf("Status code is %v", response.StatusCode)
+				}
+				msg, err := io.ReadAll(response.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.Contains(string(msg), "Empty request body") {
+					t.Errorf("Unexpected error message %q", msg)
+				}
+			})
+		}
+	}
+}
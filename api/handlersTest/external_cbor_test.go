@@ -0,0 +1,180 @@
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+type memoryCBORStore struct {
+	mu       sync.Mutex
+	vouchers map[string][]byte
+}
+
+func (m *memoryCBORStore) PutVoucherCBOR(guid, cbor []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.vouchers[string(guid)] = cbor
+	return nil
+}
+
+func (m *memoryCBORStore) VoucherCBOR(guid []byte) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.vouchers[string(guid)]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return data, nil
+}
+
+func (m *memoryCBORStore) DeleteVoucherCBOR(guid []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.vouchers, string(guid))
+	return nil
+}
+
+func TestExternalVoucherCBOR(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.GetVoucherHandler)
+	defer server.Close()
+	defer state.Close()
+
+	// A voucher stored before enabling the external store stays readable
+	inline := protocol.GUID{0xec, 0xff}
+	inlineCBOR := newTestVoucher(t, inline, "sensor")
+	if err := db.InsertVoucher(db.Voucher{GUID: inline[:], CBOR: inlineCBOR}); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &memoryCBORStore{vouchers: make(map[string][]byte)}
+	db.SetVoucherCBORStore(store)
+	defer db.SetVoucherCBORStore(nil)
+
+	vouchers := make(map[protocol.GUID][]byte)
+	for i, deviceInfo := range []string{"gateway", "sensor", "gateway"} {
+		guid := protocol.GUID{0xec, byte(i)}
+		vouchers[guid] = newTestVoucher(t, guid, deviceInfo)
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: vouchers[guid]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("metadata only in database", func(t *testing.T) {
+		for guid, want := range vouchers {
+			var stored []byte
+			if err := state.DB().QueryRow("SELECT cbor FROM owner_vouchers WHERE guid = ?", guid[:]).Scan(&stored); err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != 0 {
+				t.Errorf("Voucher %x CBOR is stored in the database", guid[:])
+			}
+			if !bytes.Equal(store.vouchers[string(guid[:])], want) {
+				t.Errorf("Voucher %x CBOR is not stored externally", guid[:])
+			}
+		}
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		for guid, want := range vouchers {
+			voucher, err := db.FetchVoucher(guid[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(voucher.CBOR, want) {
+				t.Errorf("Voucher %x CBOR was not fetched externally", guid[:])
+			}
+		}
+		voucher, err := db.FetchVoucher(inline[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(voucher.CBOR, inlineCBOR) {
+			t.Error("Voucher stored in the database was not fetched")
+		}
+	})
+
+	t.Run("metadata queries", func(t *testing.T) {
+		facets, err := db.FetchDeviceInfoFacets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []db.DeviceInfoFacet{
+			{DeviceInfo: "gateway", Count: 2},
+			{DeviceInfo: "sensor", Count: 2},
+		}
+		if !reflect.DeepEqual(facets, want) {
+			t.Errorf("got facets %+v, want %+v", facets, want)
+		}
+
+		count, err := db.CountVouchers(db.VoucherFilter{DeviceInfo: "gateway"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2 {
+			t.Errorf("got count %d, want 2", count)
+		}
+
+		var exported int
+		if err := db.ExportVouchers(db.VoucherFilter{DeviceInfo: "gateway"}, func(db.Voucher) error {
+			exported++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if exported != 2 {
+			t.Errorf("exported %d vouchers, want 2", exported)
+		}
+	})
+
+	t.Run("DI state", func(t *testing.T) {
+		// DI stores vouchers it extends to an owner key as owner vouchers
+		guid := protocol.GUID{0xec, 0xd1}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(newTestVoucher(t, guid, "gateway"), &ov); err != nil {
+			t.Fatal(err)
+		}
+		ov.Entries = append(ov.Entries, cose.Sign1Tag[fdo.VoucherEntryPayload, []byte]{})
+		if err := db.ManufacturerVouchers(state).NewVoucher(context.Background(), &ov); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.vouchers[string(guid[:])]; !ok {
+			t.Fatal("Extended DI voucher CBOR is not stored externally")
+		}
+		if _, err := db.OwnerVouchers(state).Voucher(context.Background(), guid); err != nil {
+			t.Errorf("Extended DI voucher is not an owner voucher: %v", err)
+		}
+	})
+
+	t.Run("protocol state", func(t *testing.T) {
+		guid := protocol.GUID{0xec, 0}
+		ovs := db.OwnerVouchers(state)
+		if _, err := ovs.Voucher(context.Background(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ovs.RemoveVoucher(context.Background(), guid); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := store.vouchers[string(guid[:])]; ok {
+			t.Error("Removed voucher CBOR is still stored externally")
+		}
+		if _, err := ovs.Voucher(context.Background(), guid); !errors.Is(err, fdo.ErrNotFound) {
+			t.Errorf("got error %v, want %v", err, fdo.ErrNotFound)
+		}
+	})
+}
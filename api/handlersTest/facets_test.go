@@ -0,0 +1,66 @@
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestDeviceInfoFacets(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.VoucherFacetsHandler)
+	defer server.Close()
+	defer state.Close()
+
+	for i, deviceInfo := range []string{"gateway", "sensor", "gateway", "camera", "gateway", "sensor"} {
+		guid := protocol.GUID{0xfa, byte(i)}
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, deviceInfo)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []db.DeviceInfoFacet{
+		{DeviceInfo: "gateway", Count: 3},
+		{DeviceInfo: "sensor", Count: 2},
+		{DeviceInfo: "camera", Count: 1},
+	}
+
+	t.Run("state", func(t *testing.T) {
+		facets, err := db.FetchDeviceInfoFacets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(facets, want) {
+			t.Errorf("got facets %+v, want %+v", facets, want)
+		}
+	})
+
+	t.Run("GET", func(t *testing.T) {
+		response, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var result struct {
+			DeviceInfo []db.DeviceInfoFacet `json:"device_info"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result.DeviceInfo, want) {
+			t.Errorf("got facets %+v, want %+v", result.DeviceInfo, want)
+		}
+	})
+}
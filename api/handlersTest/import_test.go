@@ -0,0 +1,119 @@
+package handlersTest
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func newTestVoucherBundle(t *testing.T, n int, prefix byte) ([]protocol.GUID, []byte) {
+	var guids []protocol.GUID
+	var bundle bytes.Buffer
+	for i := range n {
+		guid := protocol.GUID{prefix, byte(i >> 8), byte(i)}
+		guids = append(guids, guid)
+		pem.Encode(&bundle, &pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: newTestVoucher(t, guid, "test-device")})
+	}
+	return guids, bundle.Bytes()
+}
+
+func newTestState(tb testing.TB) (*db.State, *sqlite.DB) {
+	state, err := sqlite.Open(filepath.Join(tb.TempDir(), "test.db"), "")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	store := db.NewState(state)
+	if err := store.Init(); err != nil {
+		tb.Fatal(err)
+	}
+	return store, state
+}
+
+func TestInsertVoucherHandlerBatches(t *testing.T) {
+	defer db.SetImportBatchSize(db.DefaultImportBatchSize)
+	db.SetImportBatchSize(100)
+
+	store, state := newTestState(t)
+	defer state.Close()
+	server := http.HandlerFunc((&handlers.VoucherServer{State: store}).InsertVoucher)
+
+	t.Run("large bundle", func(t *testing.T) {
+		guids, bundle := newTestVoucherBundle(t, 250, 0xd0)
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(bundle)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status code is %v: %s", rec.Code, rec.Body)
+		}
+		for _, guid := range guids {
+			if _, err := store.FetchVoucher(guid[:]); err != nil {
+				t.Fatalf("Voucher %x was not stored: %v", guid[:], err)
+			}
+		}
+	})
+
+	t.Run("failed batch", func(t *testing.T) {
+		guids, bundle := newTestVoucherBundle(t, 250, 0xd1)
+		conflict := guids[150]
+		if err := store.InsertVoucher(db.Voucher{GUID: conflict[:], CBOR: newTestVoucher(t, conflict, "original")}); err != nil {
+			t.Fatal(err)
+		}
+
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(bundle)))
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("Status code is %v", rec.Code)
+		}
+		if body := rec.Body.String(); !strings.Contains(body, hex.EncodeToString(conflict[:])) {
+			t.Errorf("Error is not attributed to voucher %x: %s", conflict[:], body)
+		}
+		// Vouchers before the conflict are kept, including those of the
+		// failed batch
+		for i, guid := range guids {
+			if i == 150 {
+				continue
+			}
+			_, err := store.FetchVoucher(guid[:])
+			if stored := err == nil; stored != (i < 150) {
+				t.Errorf("Voucher %d: stored = %v", i, stored)
+			}
+		}
+	})
+}
+
+func BenchmarkImportVouchers(b *testing.B) {
+	for _, size := range []int{1, 100} {
+		b.Run("batch size "+strconv.Itoa(size), func(b *testing.B) {
+			defer db.SetImportBatchSize(db.DefaultImportBatchSize)
+			db.SetImportBatchSize(size)
+
+			store, state := newTestState(b)
+			defer state.Close()
+
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var vouchers []db.Voucher
+				for j := range 1000 {
+					guid := protocol.GUID{0xd2, byte(i >> 8), byte(i), byte(j >> 8), byte(j)}
+					vouchers = append(vouchers, db.Voucher{GUID: guid[:], CBOR: guid[:]})
+				}
+				b.StartTimer()
+
+				if _, err := store.ImportVouchers(vouchers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
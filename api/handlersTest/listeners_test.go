@@ -0,0 +1,81 @@
+package handlersTest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestSeparateManagementListener(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	var rvInfo [][]protocol.RvInstruction
+	routes := api.NewHTTPHandler(nil, &rvInfo, state)
+	fdoServer := httptest.NewServer(routes.ProtocolRoutes())
+	defer fdoServer.Close()
+	mgmtServer := httptest.NewServer(routes.ManagementRoutes())
+	defer mgmtServer.Close()
+
+	for _, test := range []struct {
+		name   string
+		server *httptest.Server
+		method string
+		path   string
+		found  bool
+	}{
+		{"FDO message on FDO listener", fdoServer, http.MethodPost, "/fdo/101/msg/30", true},
+		{"FDO status on FDO listener", fdoServer, http.MethodGet, "/fdo/status/00000000000000000000000000000000", true},
+		{"management API on FDO listener", fdoServer, http.MethodGet, "/api/v1/owner/vouchers/count", false},
+		{"RV info on FDO listener", fdoServer, http.MethodGet, "/api/v1/rvinfo", false},
+		{"FDO message on management listener", mgmtServer, http.MethodPost, "/fdo/101/msg/30", false},
+		{"FDO status on management listener", mgmtServer, http.MethodGet, "/fdo/status/00000000000000000000000000000000", false},
+		{"management API on management listener", mgmtServer, http.MethodGet, "/api/v1/owner/vouchers/count", true},
+		{"health on FDO listener", fdoServer, http.MethodGet, "/health", true},
+		{"health on management listener", mgmtServer, http.MethodGet, "/health", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.found {
+				// Only check that the route is registered, without
+				// running handlers that need an FDO responder
+				mux := routes.ManagementRoutes()
+				if test.server == fdoServer {
+					mux = routes.ProtocolRoutes()
+				}
+				if _, pattern := mux.Handler(httptest.NewRequest(test.method, test.path, nil)); pattern == "" {
+					t.Errorf("%s %s is not routed", test.method, test.path)
+				}
+				return
+			}
+
+			req, err := http.NewRequest(test.method, test.server.URL+test.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusNotFound {
+				t.Errorf("Status code is %v, want %v", response.StatusCode, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestManufacturerKeyHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestCert(t, "Manufacturer CA", true, key.Public(), nil, key)
+	if err := state.AddManufacturerKey(protocol.Secp256r1KeyType, key, []*x509.Certificate{ca}); err != nil {
+		t.Fatal(err)
+	}
+	keysURL := server.URL + "/api/v1/manufacturing/keys/"
+
+	t.Run("present", func(t *testing.T) {
+		response, err := http.Get(keysURL + "secp256r1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var mfgKey handlers.ManufacturerKey
+		if err := json.NewDecoder(response.Body).Decode(&mfgKey); err != nil {
+			t.Fatal(err)
+		}
+		if want, _ := utils.PublicKeyFingerprint(key.Public()); mfgKey.Fingerprint != want {
+			t.Errorf("Fingerprint is %q, want %q", mfgKey.Fingerprint, want)
+		}
+		if blk, _ := pem.Decode([]byte(mfgKey.CertChain)); blk == nil || string(blk.Bytes) != string(ca.Raw) {
+			t.Errorf("Unexpected certificate chain %s", mfgKey.CertChain)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, keysURL+"secp256r1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/x-pem-file")
+		response, err = http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != mfgKey.CertChain {
+			t.Errorf("PEM chain is %s, want %s", body, mfgKey.CertChain)
+		}
+	})
+
+	for _, test := range []struct {
+		name    string
+		keyType string
+		want    int
+	}{
+		{"absent", "secp384r1", http.StatusNotFound},
+		{"invalid", "dsa", http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := http.Get(keysURL + test.keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Errorf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+		})
+	}
+}
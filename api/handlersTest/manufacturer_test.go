@@ -0,0 +1,511 @@
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func newTestCert(t *testing.T, cn string, isCA bool, pub crypto.PublicKey, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// newTestManufacturer returns a manufacturer root CA and a manufacturer
+// certificate chain issued by it.
+func newTestManufacturer(t *testing.T) (*x509.Certificate, []*x509.Certificate) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := newTestCert(t, "Manufacturer Root CA", true, rootKey.Public(), nil, rootKey)
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfg := newTestCert(t, "Manufacturer", false, mfgKey.Public(), root, rootKey)
+	return root, []*x509.Certificate{mfg, root}
+}
+
+func newTestVoucherFromManufacturer(t *testing.T, guid protocol.GUID, mfgChain []*x509.Certificate) []byte {
+	chain := make([]*cbor.X509Certificate, len(mfgChain))
+	for i, cert := range mfgChain {
+		chain[i] = (*cbor.X509Certificate)(cert)
+	}
+	ov := fdo.Voucher{
+		Version: 101,
+		Header: cbor.Bstr[fdo.VoucherHeader]{Val: fdo.VoucherHeader{
+			Version:    101,
+			GUID:       guid,
+			DeviceInfo: "test-device",
+			ManufacturerKey: protocol.PublicKey{
+				Type:     protocol.Secp256r1KeyType,
+				Encoding: protocol.X5ChainKeyEnc,
+				Body:     utils.MustMarshal(chain),
+			},
+		}},
+		Hmac: protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: make([]byte, 32)},
+	}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInsertVoucherHandlerTrustedManufacturer(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	trustedRoot, trustedChain := newTestManufacturer(t)
+	_, untrustedChain := newTestManufacturer(t)
+
+	t.Run("no trusted CAs", func(t *testing.T) {
+		guid := protocol.GUID{0xab, 0x01}
+		response := postVoucher(t, server.URL, "application/cbor", newTestVoucherFromManufacturer(t, guid, untrustedChain))
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+
+	if _, err := db.TrustedManufacturerCAs.Insert(trustedRoot); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("trusted manufacturer", func(t *testing.T) {
+		guid := protocol.GUID{0xab, 0x02}
+		response := postVoucher(t, server.URL, "application/cbor", newTestVoucherFromManufacturer(t, guid, trustedChain))
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		if _, err := db.FetchVoucher(guid[:]); err != nil {
+			t.Errorf("Voucher was not stored: %v", err)
+		}
+	})
+
+	t.Run("untrusted manufacturer", func(t *testing.T) {
+		guid := protocol.GUID{0xab, 0x03}
+		response := postVoucher(t, server.URL, "application/cbor", newTestVoucherFromManufacturer(t, guid, untrustedChain))
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+		if _, err := db.FetchVoucher(guid[:]); err == nil {
+			t.Error("Voucher from untrusted manufacturer was stored")
+		}
+	})
+}
+
+func TestManufacturerCAHandlers(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	root, _ := newTestManufacturer(t)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	fingerprint := db.CertFingerprint(root)
+	casURL := server.URL + "/api/v1/owner/manufacturer-cas"
+
+	do := func(t *testing.T, method, url, accept string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("import", func(t *testing.T) {
+		for _, want := range []struct {
+			status   int
+			imported int
+		}{{http.StatusCreated, 1}, {http.StatusOK, 0}} {
+			response := do(t, http.MethodPost, casURL, "", rootPEM)
+			defer response.Body.Close()
+			if response.StatusCode != want.status {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var result handlers.TrustedCAImportResponse
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Imported) != want.imported || len(result.Imported)+len(result.Skipped) != 1 {
+				t.Errorf("Unexpected import result %+v", result)
+			}
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		response := do(t, http.MethodGet, casURL+"?valid=true", "", nil)
+		defer response.Body.Close()
+		var cas []handlers.TrustedCA
+		if err := json.NewDecoder(response.Body).Decode(&cas); err != nil {
+			t.Fatal(err)
+		}
+		if len(cas) != 1 || cas[0].Fingerprint != fingerprint || cas[0].Expired {
+			t.Errorf("Unexpected CAs %+v", cas)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		response := do(t, http.MethodGet, casURL+"/"+fingerprint, "", nil)
+		defer response.Body.Close()
+		var ca handlers.TrustedCA
+		if err := json.NewDecoder(response.Body).Decode(&ca); err != nil {
+			t.Fatal(err)
+		}
+		if ca.Fingerprint != fingerprint || ca.Subject != root.Subject.String() {
+			t.Errorf("Unexpected CA %+v", ca)
+		}
+
+		response = do(t, http.MethodGet, casURL+"/"+fingerprint, "application/x-pem-file", nil)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, rootPEM) {
+			t.Errorf("Unexpected PEM %s", body)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		response := do(t, http.MethodDelete, casURL+"/"+fingerprint, "", nil)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusNoContent {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+
+		response = do(t, http.MethodGet, casURL+"/"+fingerprint, "", nil)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("Status code after delete is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestDeviceCAFingerprintPrefix(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	var cas []*x509.Certificate
+	for _, cn := range []string{"Device CA 1", "Device CA 2"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca := newTestCert(t, cn, true, key.Public(), nil, key)
+		if _, err := db.TrustedDeviceCAs.Insert(ca); err != nil {
+			t.Fatal(err)
+		}
+		cas = append(cas, ca)
+	}
+	fingerprint := db.CertFingerprint(cas[1])
+
+	list := func(prefix string) (int, []handlers.TrustedCA) {
+		response, err := http.Get(server.URL + "/api/v1/owner/device-cas?fingerprint_prefix=" + prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var found []handlers.TrustedCA
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&found); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response.StatusCode, found
+	}
+
+	for _, prefix := range []string{fingerprint[:8], strings.ToUpper(fingerprint[:8]), fingerprint} {
+		status, found := list(prefix)
+		if status != http.StatusOK {
+			t.Fatalf("Status code is %v", status)
+		}
+		if len(found) != 1 || found[0].Fingerprint != fingerprint || found[0].Subject != cas[1].Subject.String() {
+			t.Errorf("Prefix %s found %+v", prefix, found)
+		}
+	}
+
+	if status, _ := list("zz"); status != http.StatusBadRequest {
+		t.Errorf("Status code of an invalid prefix is %v", status)
+	}
+}
+
+func TestManufacturerCAImportLimit(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+	defer handlers.SetCAImportFingerprints(handlers.DefaultCAImportFingerprints)
+
+	var bundle bytes.Buffer
+	for range 12 {
+		root, _ := newTestManufacturer(t)
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	}
+
+	importBundle := func(t *testing.T) handlers.TrustedCAImportResponse {
+		response, err := http.Post(server.URL+"/api/v1/owner/manufacturer-cas", "application/x-pem-file", bytes.NewReader(bundle.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var result handlers.TrustedCAImportResponse
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	t.Run("capped", func(t *testing.T) {
+		handlers.SetCAImportFingerprints(5)
+		result := importBundle(t)
+		if len(result.Imported) != 5 || result.ImportedCount != 12 {
+			t.Errorf("listed %d of %d imported CAs, want 5 of 12", len(result.Imported), result.ImportedCount)
+		}
+		if result.Summary != "and 7 more" {
+			t.Errorf("summary is %q", result.Summary)
+		}
+	})
+
+	t.Run("counts only", func(t *testing.T) {
+		handlers.SetCAImportFingerprints(0)
+		result := importBundle(t)
+		if len(result.Imported)+len(result.Skipped) != 0 || result.SkippedCount != 12 {
+			t.Errorf("unexpected import result %+v", result)
+		}
+		if result.Summary != "and 12 more" {
+			t.Errorf("summary is %q", result.Summary)
+		}
+	})
+
+	t.Run("no limit", func(t *testing.T) {
+		handlers.SetCAImportFingerprints(-1)
+		result := importBundle(t)
+		if len(result.Skipped) != 12 || result.Summary != "" {
+			t.Errorf("unexpected import result %+v", result)
+		}
+	})
+}
+
+func TestDeviceCAImportURL(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	var bundle bytes.Buffer
+	for range 3 {
+		root, _ := newTestManufacturer(t)
+		pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	}
+	pki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bundle.Bytes())
+	}))
+	defer pki.Close()
+
+	pkiURL, err := url.Parse(pki.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetCAFetchConfig(5*time.Second, 1<<20, []string{pkiURL.Host})
+	defer handlers.SetCAFetchConfig(30*time.Second, 1<<20, nil)
+
+	importURL := func(t *testing.T, rawURL string) *http.Response {
+		body, _ := json.Marshal(map[string]string{"url": rawURL})
+		response, err := http.Post(server.URL+"/api/v1/owner/device-cas", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("allowed URL", func(t *testing.T) {
+		response := importURL(t, pki.URL+"/device-cas.pem")
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusCreated {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var result handlers.TrustedCAImportResponse
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.ImportedCount != 3 {
+			t.Errorf("imported %d CAs, want 3", result.ImportedCount)
+		}
+		certs, err := db.TrustedDeviceCAs.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(certs) != 3 {
+			t.Errorf("%d device CAs are trusted, want 3", len(certs))
+		}
+	})
+
+	t.Run("URL not in allowlist", func(t *testing.T) {
+		response := importURL(t, "http://169.254.169.254/latest/meta-data")
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestDeviceCASync(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	first, _ := newTestManufacturer(t)
+	second, _ := newTestManufacturer(t)
+	var published []*x509.Certificate
+	pki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, cert := range published {
+			pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		}
+	}))
+	defer pki.Close()
+
+	trusted := func(t *testing.T) []string {
+		t.Helper()
+		certs, err := db.TrustedDeviceCAs.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fingerprints []string
+		for _, cert := range certs {
+			fingerprints = append(fingerprints, db.CertFingerprint(cert))
+		}
+		return fingerprints
+	}
+
+	for _, cycle := range []struct {
+		name      string
+		prune     bool
+		published []*x509.Certificate
+		added     int
+		removed   int
+		want      []*x509.Certificate
+	}{
+		{"initial", true, []*x509.Certificate{first}, 1, 0, []*x509.Certificate{first}},
+		{"CA added", true, []*x509.Certificate{first, second}, 1, 0, []*x509.Certificate{first, second}},
+		{"CA removed", true, []*x509.Certificate{second}, 0, 1, []*x509.Certificate{second}},
+		{"CA added without pruning", false, []*x509.Certificate{first}, 1, 0, []*x509.Certificate{first, second}},
+	} {
+		t.Run(cycle.name, func(t *testing.T) {
+			published = cycle.published
+			sync := handlers.CASync{Store: db.TrustedDeviceCAs, URL: pki.URL + "/device-cas.pem", Interval: time.Hour, Prune: cycle.prune}
+			added, removed, err := sync.Sync(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added != cycle.added || removed != cycle.removed {
+				t.Errorf("added %d and removed %d CAs, want %d and %d", added, removed, cycle.added, cycle.removed)
+			}
+			got := trusted(t)
+			if len(got) != len(cycle.want) {
+				t.Fatalf("%d device CAs are trusted, want %d", len(got), len(cycle.want))
+			}
+			for _, cert := range cycle.want {
+				if !slices.Contains(got, db.CertFingerprint(cert)) {
+					t.Errorf("CA %s is not trusted", cert.Subject)
+				}
+			}
+		})
+	}
+
+	t.Run("empty bundle", func(t *testing.T) {
+		published = nil
+		sync := handlers.CASync{Store: db.TrustedDeviceCAs, URL: pki.URL + "/device-cas.pem", Interval: time.Hour, Prune: true}
+		if _, _, err := sync.Sync(context.Background()); err == nil {
+			t.Error("Syncing an empty bundle succeeded")
+		}
+		if got := trusted(t); len(got) != 2 {
+			t.Errorf("%d device CAs are trusted after an empty bundle, want 2", len(got))
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package handlersTest
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+)
+
+func TestMaxMessageSize(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+	defer api.SetMaxMessageSize(api.DefaultMaxMessageSize)
+	api.SetMaxMessageSize(1024)
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	response, err := http.Post(server.URL+"/fdo/101/msg/60", "application/cbor", bytes.NewReader(make([]byte, 1025)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Status code is %v", response.StatusCode)
+	}
+	if msgType := response.Header.Get("Message-Type"); msgType != "255" {
+		t.Errorf("Message-Type is %q, expected an FDO error message", msgType)
+	}
+}
@@ -3,13 +3,16 @@ package handlersTest
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/ownerinfo"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
 )
 
@@ -94,3 +97,118 @@ func TestOwnerInfoHandler(t *testing.T) {
 	})
 
 }
+
+func TestOwnerInfoHandlerTLSMismatch(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.OwnerInfoHandler)
+	defer server.Close()
+	defer state.Close()
+	defer ownerinfo.SetTLSMismatchPolicy(ownerinfo.WarnTLSMismatch)
+	defer ownerinfo.SetServerTLS(false)
+
+	// Transport protocol 3 is HTTP and 5 is HTTPS
+	httpAddr := `{"value": [[null, "owner.example.com", 8043, 3]]}`
+	httpsAddr := `{"value": [[null, "owner.example.com", 8043, 5]]}`
+	if err := db.InsertData(db.Data{Value: []interface{}{}}, "owner_info"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		serverTLS bool
+		policy    ownerinfo.TLSMismatchPolicy
+		body      string
+		want      int
+		warning   bool
+	}{
+		{"matching http", false, ownerinfo.RejectTLSMismatch, httpAddr, http.StatusOK, false},
+		{"matching https", true, ownerinfo.RejectTLSMismatch, httpsAddr, http.StatusOK, false},
+		{"mismatching https warned", false, ownerinfo.WarnTLSMismatch, httpsAddr, http.StatusOK, true},
+		{"mismatching http rejected", true, ownerinfo.RejectTLSMismatch, httpAddr, http.StatusBadRequest, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ownerinfo.SetServerTLS(test.serverTLS)
+			ownerinfo.SetTLSMismatchPolicy(test.policy)
+
+			req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(test.body)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v: %s", response.StatusCode, test.want, body)
+			}
+			if test.want == http.StatusBadRequest && !strings.Contains(string(body), ownerinfo.ErrTLSMismatch.Error()) {
+				t.Errorf("Rejection reason is %q, want %q", body, ownerinfo.ErrTLSMismatch)
+			}
+			if warned := response.Header.Get("Warning") != ""; warned != test.warning {
+				t.Errorf("Warning header is %q", response.Header.Get("Warning"))
+			}
+		})
+	}
+}
+
+func TestOwnerInfoHandlerProductionMode(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.OwnerInfoHandler)
+	defer server.Close()
+	defer state.Close()
+	defer ownerinfo.SetProductionMode(false)
+
+	// Transport protocol 3 is HTTP
+	loopbackAddr := `{"value": [["127.0.0.1", null, 8043, 3]]}`
+	if err := db.InsertData(db.Data{Value: []interface{}{}}, "owner_info"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		production bool
+		want       int
+	}{
+		{"loopback accepted in dev mode", false, http.StatusOK},
+		{"loopback rejected in production mode", true, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ownerinfo.SetProductionMode(test.production)
+
+			req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(loopbackAddr)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v: %s", response.StatusCode, test.want, body)
+			}
+			if test.want == http.StatusBadRequest && !strings.Contains(string(body), ownerinfo.ErrUnreachableAddress.Error()) {
+				t.Errorf("Rejection reason is %q, want %q", body, ownerinfo.ErrUnreachableAddress)
+			}
+		})
+	}
+}
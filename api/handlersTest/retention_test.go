@@ -0,0 +1,96 @@
+package handlersTest
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestVoucherRetention(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	db.SetRetentionPolicy(db.RetentionPolicy{MaxAge: 30 * 24 * time.Hour, MaxPerDeviceInfo: 2})
+	defer db.SetRetentionPolicy(db.RetentionPolicy{})
+
+	now := time.Now()
+	guids := []protocol.GUID{{0xe0, 0x01}, {0xe0, 0x02}, {0xe0, 0x03}, {0xe0, 0x04}}
+	for i, storedAt := range []time.Time{
+		now.Add(-40 * 24 * time.Hour), // expired
+		now.Add(-29 * 24 * time.Hour), // expires within 2 days
+		now.Add(-2 * time.Hour),
+		now.Add(-time.Hour),
+	} {
+		deviceInfo := "retained-device"
+		if i == 0 {
+			deviceInfo = "expired-device"
+		}
+		guid := guids[i]
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, deviceInfo)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := state.DB().Exec("UPDATE voucher_expiry SET stored_at = ? WHERE guid = ?", storedAt.Unix(), guid[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("list expiring vouchers", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/vouchers/expiring?within=48h")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var page struct {
+			Vouchers []db.VoucherExpiry `json:"vouchers"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Vouchers) != 2 || page.Vouchers[0].GUID != hex.EncodeToString(guids[0][:]) || page.Vouchers[1].GUID != hex.EncodeToString(guids[1][:]) {
+			t.Errorf("Unexpected expiring vouchers %+v", page.Vouchers)
+		}
+	})
+
+	t.Run("invalid within", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/vouchers/expiring?within=soon")
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+
+	t.Run("apply retention", func(t *testing.T) {
+		removed, err := db.NewState(state).ApplyRetention(context.Background(), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The expired voucher and the oldest of three vouchers of a device info
+		if removed != 2 {
+			t.Errorf("Removed %d vouchers, want 2", removed)
+		}
+		for i, guid := range guids {
+			_, err := db.FetchVoucher(guid[:])
+			if kept := err == nil; kept != (i >= 2) {
+				t.Errorf("Voucher %x kept is %v", guid, kept)
+			}
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package handlersTest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestRootHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	var rvInfo [][]protocol.RvInstruction
+	routes := api.NewHTTPHandler(nil, &rvInfo, state)
+	for _, test := range []struct {
+		role    string
+		handler http.Handler
+		path    string
+	}{
+		{api.AllRoutesRole, routes.RegisterRoutes(), "/api/v1"},
+		{api.ProtocolRoutesRole, routes.ProtocolRoutes(), "/fdo/101/msg"},
+		{api.ManagementRoutesRole, routes.ManagementRoutes(), "/api/v1"},
+	} {
+		t.Run(test.role, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			response, err := http.Get(server.URL + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var body handlers.RootResponse
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Role != test.role {
+				t.Errorf("Role is %q, want %q", body.Role, test.role)
+			}
+			if body.Version != handlers.Version {
+				t.Errorf("Version is %q, want %q", body.Version, handlers.Version)
+			}
+			if !slices.Contains(body.APIPaths, test.path) {
+				t.Errorf("API paths %v do not contain %s", body.APIPaths, test.path)
+			}
+
+			// Only the root path itself is served
+			response, err = http.Get(server.URL + "/unknown")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusNotFound {
+				t.Errorf("Status code of an unknown path is %v", response.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package handlersTest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// newTestBypassVoucher returns a voucher whose RV info sets RV bypass.
+func newTestBypassVoucher(t *testing.T, guid protocol.GUID) []byte {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(newTestVoucher(t, guid, "bypass-device"), &ov); err != nil {
+		t.Fatal(err)
+	}
+	ov.Header.Val.RvInfo = [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInsertVoucherHandlerRVBypass(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+	defer db.SetRVBypassPolicy(db.WarnRVBypass)
+
+	for _, test := range []struct {
+		policy  db.RVBypassPolicy
+		guid    protocol.GUID
+		want    int
+		warning bool
+	}{
+		{db.AllowRVBypass, protocol.GUID{0xb9, 1}, http.StatusOK, false},
+		{db.WarnRVBypass, protocol.GUID{0xb9, 2}, http.StatusOK, true},
+		{db.RejectRVBypass, protocol.GUID{0xb9, 3}, http.StatusBadRequest, false},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			db.SetRVBypassPolicy(test.policy)
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(newTestBypassVoucher(t, test.guid)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/cbor")
+			req.Header.Set("Accept", "application/json")
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+			_, err = db.FetchVoucher(test.guid[:])
+			if stored := err == nil; stored != (test.want == http.StatusOK) {
+				t.Errorf("Voucher stored is %v", stored)
+			}
+			if test.want != http.StatusOK {
+				return
+			}
+			var result handlers.VoucherImportResponse
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if warned := len(result.Warnings) > 0; warned != test.warning {
+				t.Errorf("Warnings are %v", result.Warnings)
+			}
+		})
+	}
+}
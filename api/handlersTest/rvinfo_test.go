@@ -3,9 +3,11 @@ package handlersTest
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
@@ -45,7 +47,7 @@ func TestRVInfoHandler(t *testing.T) {
 	defer state.Close()
 
 	t.Run("POST RVInfo", func(t *testing.T) {
-		requestBody := bytes.NewReader([]byte(`[["3","127.0.0.1"],["5","localhost"],["2","8043"]]`))
+		requestBody := bytes.NewReader([]byte(`[[[2,"127.0.0.1"],[3,8043]],[[5,"localhost"],[3,8043]],[[5,"rv.example.com"],[3,8043]]]`))
 
 		// Perform the POST request
 		response, err := http.Post(server.URL, "text/plain", requestBody)
@@ -79,7 +81,7 @@ func TestRVInfoHandler(t *testing.T) {
 	})
 
 	t.Run("PUT ownerinfo", func(t *testing.T) {
-		requestBody := bytes.NewReader([]byte(`[["3","127.1.1.1"],["5","localhost"],["2","8080"]]`))
+		requestBody := bytes.NewReader([]byte(`[[[2,"127.1.1.1"],[3,8080]],[[5,"localhost"],[3,8080]],[[5,"rv.example.com"],[3,8080]]]`))
 
 		// Create a PUT request
 		req, _ := http.NewRequest(http.MethodPut, server.URL, requestBody)
@@ -99,4 +101,34 @@ func TestRVInfoHandler(t *testing.T) {
 		}
 	})
 
+	t.Run("PUT malformed RVInfo", func(t *testing.T) {
+		requestBody := bytes.NewReader([]byte(`[[[2,"127.1.1.1"],[3,8080]],[["5","localhost"]]]`))
+
+		req, _ := http.NewRequest(http.MethodPut, server.URL, requestBody)
+		req.Header.Set("Content-Type", "text/plain")
+
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+		msg, _ := io.ReadAll(response.Body)
+		if !strings.Contains(string(msg), "RV directive 1, pair 0") {
+			t.Errorf("Error does not locate the malformed directive: %s", msg)
+		}
+
+		// The stored RV info is unchanged
+		stored, err := db.FetchData("rvinfo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values, _ := stored.Value.([]interface{}); len(values) != 3 {
+			t.Errorf("Stored RV info changed to %v", stored.Value)
+		}
+	})
+
 }
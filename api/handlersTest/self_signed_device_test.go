@@ -0,0 +1,60 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestInsertVoucherSelfSignedDeviceCert(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+	defer db.SetAllowSelfSignedDeviceCerts(false)
+
+	// Device certificates are only verified while a device CA is trusted
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TrustedDeviceCAs.Insert(newTestCert(t, "Device CA", true, caKey.Public(), nil, caKey)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, test := range []struct {
+		name  string
+		allow bool
+		want  int
+	}{
+		{"rejected by default", false, http.StatusBadRequest},
+		{"accepted in dev mode", true, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			db.SetAllowSelfSignedDeviceCerts(test.allow)
+
+			// The device certificate of test vouchers is self-signed
+			guid := protocol.GUID{0x5e, byte(i)}
+			response := postVoucher(t, server.URL, "application/cbor", newTestVoucher(t, guid, "self-signed-device"))
+			defer response.Body.Close()
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+			_, err := db.FetchVoucher(guid[:])
+			if stored := err == nil; stored != (test.want == http.StatusOK) {
+				t.Errorf("Voucher stored is %v", stored)
+			}
+		})
+	}
+}
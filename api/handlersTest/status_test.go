@@ -0,0 +1,225 @@
+package handlersTest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestOnboardingStatusHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	completed := protocol.GUID{0xee, 0x01}
+	replacement := protocol.GUID{0xee, 0x02}
+	pending := protocol.GUID{0xee, 0x03}
+	if err := db.InsertVoucher(db.Voucher{GUID: pending[:], CBOR: newTestVoucher(t, pending, "pending")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordTO2Completed(completed[:], replacement[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	getStatus := func(t *testing.T, guid string) (handlers.OnboardingStatusResponse, []byte) {
+		response, err := http.Get(server.URL + "/fdo/status/" + guid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status handlers.OnboardingStatusResponse
+		if err := json.Unmarshal(body, &status); err != nil {
+			t.Fatalf("Unable to parse status response %v", err)
+		}
+		return status, body
+	}
+
+	t.Run("completed", func(t *testing.T) {
+		for _, guid := range []string{"ee010000000000000000000000000000", "ee020000000000000000000000000000"} {
+			status, _ := getStatus(t, guid)
+			if status.Status != "onboarded" || status.CompletedAt == nil {
+				t.Errorf("Wrong status for %s: %+v", guid, status)
+			}
+		}
+	})
+
+	t.Run("pending and unknown are indistinguishable", func(t *testing.T) {
+		pendingStatus, pendingBody := getStatus(t, "ee030000000000000000000000000000")
+		_, unknownBody := getStatus(t, "ffffffffffffffffffffffffffffffff")
+		if pendingStatus.Status != "unknown" {
+			t.Errorf("Wrong status for pending device: %+v", pendingStatus)
+		}
+		if string(pendingBody) != string(unknownBody) {
+			t.Errorf("Pending and unknown responses differ: %s != %s", pendingBody, unknownBody)
+		}
+	})
+}
+
+func TestUpdateOnboardingStatusHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	device := protocol.GUID{0xef, 0x01}
+	if err := db.InsertVoucher(db.Voucher{GUID: device[:], CBOR: newTestVoucher(t, device, "device")}); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(t *testing.T, guid protocol.GUID, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/v1/owner/devices/%x/onboarding-status", server.URL, guid[:]), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	for _, completed := range []bool{true, false, true} {
+		t.Run(fmt.Sprintf("to2_completed %v", completed), func(t *testing.T) {
+			response := put(t, device, fmt.Sprintf(`{"to2_completed":%v}`, completed))
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var onboarding db.DeviceOnboarding
+			if err := json.NewDecoder(response.Body).Decode(&onboarding); err != nil {
+				t.Fatal(err)
+			}
+			if onboarding.TO2Completed != completed {
+				t.Errorf("response has to2_completed %v", onboarding.TO2Completed)
+			}
+
+			// Consumers of the onboarding state see the override
+			if stored, err := db.IsTO2Completed(device[:]); err != nil || stored != completed {
+				t.Errorf("stored to2_completed is %v (%v)", stored, err)
+			}
+			status, err := http.Get(fmt.Sprintf("%s/fdo/status/%x", server.URL, device[:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer status.Body.Close()
+			var statusResponse handlers.OnboardingStatusResponse
+			if err := json.NewDecoder(status.Body).Decode(&statusResponse); err != nil {
+				t.Fatal(err)
+			}
+			if (statusResponse.Status == "onboarded") != completed {
+				t.Errorf("device status is %q", statusResponse.Status)
+			}
+		})
+	}
+
+	t.Run("unknown device", func(t *testing.T) {
+		response := put(t, protocol.GUID{0xef, 0xff}, `{"to2_completed":true}`)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+
+	t.Run("missing to2_completed", func(t *testing.T) {
+		response := put(t, device, `{}`)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestBulkOnboardingStatusHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	completed := protocol.GUID{0xed, 0x01}
+	replacement := protocol.GUID{0xed, 0x02}
+	pending := protocol.GUID{0xed, 0x03}
+	unknown := protocol.GUID{0xed, 0xff}
+	if err := db.InsertVoucher(db.Voucher{GUID: pending[:], CBOR: newTestVoucher(t, pending, "pending")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordTO2Completed(completed[:], replacement[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(t *testing.T, body string) *http.Response {
+		response, err := http.Post(server.URL+"/api/v1/owner/devices/status", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("mixed", func(t *testing.T) {
+		guids := []protocol.GUID{completed, pending, unknown, replacement}
+		response := post(t, fmt.Sprintf(`{"guids":["%x","%x","%x","%x"]}`, guids[0][:], guids[1][:], guids[2][:], guids[3][:]))
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		var statuses handlers.BulkOnboardingStatusResponse
+		if err := json.NewDecoder(response.Body).Decode(&statuses); err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses.Devices) != len(guids) {
+			t.Fatalf("got %d statuses, want %d", len(statuses.Devices), len(guids))
+		}
+		for i, want := range []struct {
+			known, completed bool
+			newGUID          string
+		}{
+			{true, true, fmt.Sprintf("%x", replacement[:])},
+			{true, false, ""},
+			{false, false, ""},
+			{true, true, fmt.Sprintf("%x", replacement[:])},
+		} {
+			got := statuses.Devices[i]
+			if got.GUID != fmt.Sprintf("%x", guids[i][:]) {
+				t.Errorf("status %d is for %s", i, got.GUID)
+			}
+			if got.Known != want.known || got.TO2Completed != want.completed || got.NewGUID != want.newGUID {
+				t.Errorf("wrong status for %s: %+v", got.GUID, got)
+			}
+			if (got.TO2CompletedAt != nil) != want.completed {
+				t.Errorf("wrong completion time for %s: %v", got.GUID, got.TO2CompletedAt)
+			}
+		}
+	})
+
+	t.Run("invalid GUID", func(t *testing.T) {
+		response := post(t, `{"guids":["not-a-guid"]}`)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package handlersTest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestDeviceTimelineHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	// Take the device through the full flow
+	guid := protocol.GUID{0x7e, 0x01}
+	newGUID := protocol.GUID{0x7e, 0x02}
+	if _, err := db.ImportVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "timeline-device")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RecordDeviceEvent(guid[:], db.TO0RegisteredEvent, "127.0.0.1:8041"); err != nil {
+		t.Fatal(err)
+	}
+	for _, module := range []string{"fdo.download", "fdo.command"} {
+		if err := db.RecordDeviceEvent(guid[:], db.ModuleStartedEvent, module); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RecordTO2Completed(guid[:], newGUID[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		db.VoucherImportedEvent + ":",
+		db.TO0RegisteredEvent + ":127.0.0.1:8041",
+		db.ModuleStartedEvent + ":fdo.download",
+		db.ModuleStartedEvent + ":fdo.command",
+		db.TO2CompletedEvent + ":new GUID " + hex.EncodeToString(newGUID[:]),
+	}
+
+	for name, guid := range map[string]protocol.GUID{"original GUID": guid, "replacement GUID": newGUID} {
+		t.Run(name, func(t *testing.T) {
+			response, err := http.Get(server.URL + "/api/v1/owner/devices/" + hex.EncodeToString(guid[:]) + "/timeline")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var timeline handlers.DeviceTimeline
+			if err := json.NewDecoder(response.Body).Decode(&timeline); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for i, event := range timeline.Events {
+				got = append(got, event.Event+":"+event.Detail)
+				if i > 0 && event.Time.Before(timeline.Events[i-1].Time) {
+					t.Errorf("event %d is out of order", i)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got events %v, want %v", got, want)
+			}
+		})
+	}
+
+	t.Run("unknown GUID", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/ffffffffffffffffffffffffffffffff/timeline")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package handlersTest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestRecomputeRvInfoHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	state, err := sqlite.Open("test.db", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer state.Close()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	var registered [][]protocol.RvInstruction
+	var registeredGUID string
+	register := func(rvInfo [][]protocol.RvInstruction, guid string, _ *sqlite.DB) error {
+		registered, registeredGUID = rvInfo, guid
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/v1/owner/vouchers/{guid}/recompute-rvinfo", handlers.RecomputeRvInfoHandler(register, state))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	guid := protocol.GUID{0xcc, 0x01}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "test-device")}); err != nil {
+		t.Fatal(err)
+	}
+	override := []byte(`[[[5,"rv.example.com"],[3,8041],[12,1],[2,"127.0.0.1"],[4,8041]]]`)
+
+	t.Run("POST override", func(t *testing.T) {
+		url := server.URL + "/api/v1/owner/vouchers/cc010000000000000000000000000000/recompute-rvinfo"
+		response, err := http.Post(url, "text/plain", bytes.NewReader(override))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		if registeredGUID != "cc010000000000000000000000000000" {
+			t.Errorf("Registered wrong GUID %q", registeredGUID)
+		}
+
+		var dns []byte
+		for _, directive := range registered {
+			for _, instruction := range directive {
+				if instruction.Variable == protocol.RVDns {
+					dns = instruction.Value
+				}
+			}
+		}
+		if !bytes.Equal(dns, utils.MustMarshal("rv.example.com")) {
+			t.Errorf("Override RV info was not used for registration: %v", registered)
+		}
+
+		if _, err := db.FetchRvInfoOverride(guid[:]); err != nil {
+			t.Errorf("Override was not recorded: %v", err)
+		}
+	})
+
+	t.Run("POST override for unknown GUID", func(t *testing.T) {
+		url := server.URL + "/api/v1/owner/vouchers/ffffffffffffffffffffffffffffffff/recompute-rvinfo"
+		response, err := http.Post(url, "text/plain", bytes.NewReader(override))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusNotFound {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
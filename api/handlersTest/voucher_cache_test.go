@@ -0,0 +1,96 @@
+package handlersTest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestVoucherCache(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	db.SetVoucherCacheSize(10)
+	defer db.SetVoucherCacheSize(0)
+
+	guid := protocol.GUID{0xca, 0xc4, 0xe0}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "cached-device")}); err != nil {
+		t.Fatal(err)
+	}
+
+	getBundle := func(t *testing.T, guidHex string) (int, handlers.DeviceBundle) {
+		t.Helper()
+		response, err := http.Get(server.URL + "/api/v1/owner/devices/" + guidHex + "/bundle")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var bundle handlers.DeviceBundle
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&bundle); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response.StatusCode, bundle
+	}
+	const guidHex = "cac4e000000000000000000000000000"
+
+	t.Run("second GET hits the cache", func(t *testing.T) {
+		before := db.FetchVoucherCacheStats()
+		for range 2 {
+			if status, _ := getBundle(t, guidHex); status != http.StatusOK {
+				t.Fatalf("Status code is %v", status)
+			}
+		}
+		after := db.FetchVoucherCacheStats()
+		if misses := after.Misses - before.Misses; misses != 1 {
+			t.Errorf("%d cache misses, want 1", misses)
+		}
+		if hits := after.Hits - before.Hits; hits != 1 {
+			t.Errorf("%d cache hits, want 1", hits)
+		}
+	})
+
+	t.Run("update invalidates", func(t *testing.T) {
+		if err := db.UpdateVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "updated-device")}); err != nil {
+			t.Fatal(err)
+		}
+		if entries := db.FetchVoucherCacheStats().Entries; entries != 0 {
+			t.Errorf("%d cached vouchers after update, want 0", entries)
+		}
+		if _, bundle := getBundle(t, guidHex); bundle.DeviceInfo != "updated-device" {
+			t.Errorf("device info is %q after update", bundle.DeviceInfo)
+		}
+	})
+
+	t.Run("replacement at TO2 invalidates", func(t *testing.T) {
+		newGUID := protocol.GUID{0xca, 0xc4, 0xe1}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(newTestVoucher(t, newGUID, "updated-device"), &ov); err != nil {
+			t.Fatal(err)
+		}
+		vouchers := db.OnboardingVouchers{OwnerVoucherPersistentState: state}
+		if err := vouchers.ReplaceVoucher(context.Background(), guid, &ov); err != nil {
+			t.Fatal(err)
+		}
+		if entries := db.FetchVoucherCacheStats().Entries; entries != 0 {
+			t.Errorf("%d cached vouchers after replacement, want 0", entries)
+		}
+		if status, _ := getBundle(t, guidHex); status != http.StatusNotFound {
+			t.Errorf("Status code for the replaced voucher is %v", status)
+		}
+	})
+}
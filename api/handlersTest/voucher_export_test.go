@@ -0,0 +1,108 @@
+package handlersTest
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestExportVouchersHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	vouchers := make(map[string][]byte)
+	for i, deviceInfo := range []string{"export-a", "export-b", "export-a"} {
+		guid := protocol.GUID{0xd0, byte(i)}
+		ovCBOR := newTestVoucher(t, guid, deviceInfo)
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovCBOR}); err != nil {
+			t.Fatal(err)
+		}
+		vouchers[hex.EncodeToString(guid[:])] = ovCBOR
+	}
+
+	get := func(t *testing.T, query string) []byte {
+		t.Helper()
+		response, err := http.Get(server.URL + "/api/v1/owner/vouchers/export" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	t.Run("PEM", func(t *testing.T) {
+		rest := get(t, "")
+		var n int
+		for blk, next := pem.Decode(rest); blk != nil; blk, next = pem.Decode(next) {
+			n++
+			if blk.Type != "OWNERSHIP VOUCHER" {
+				t.Errorf("Unexpected PEM block type %q", blk.Type)
+			}
+		}
+		if n != len(vouchers) {
+			t.Errorf("Exported %d vouchers, want %d", n, len(vouchers))
+		}
+	})
+
+	t.Run("filtered tar.gz", func(t *testing.T) {
+		gz, err := gzip.NewReader(bytes.NewReader(get(t, "?format=tar.gz&device_info=export-a")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		var names []string
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blk, _ := pem.Decode(data)
+			guidHex := hdr.Name[:len(hdr.Name)-len(".pem")]
+			if blk == nil || !bytes.Equal(blk.Bytes, vouchers[guidHex]) {
+				t.Errorf("Unexpected content of %s", hdr.Name)
+			}
+			names = append(names, hdr.Name)
+		}
+		if len(names) != 2 || names[0] != "d0000000000000000000000000000000.pem" || names[1] != "d0020000000000000000000000000000.pem" {
+			t.Errorf("Unexpected files %v", names)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		response, err := http.Get(server.URL + "/api/v1/owner/vouchers/export?format=zip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package handlersTest
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// newTestOwnedVoucher returns the CBOR of a voucher whose owner is mfgKey.
+func newTestOwnedVoucher(t *testing.T, guid protocol.GUID, mfgKey *ecdsa.PrivateKey) []byte {
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgCert := newTestCert(t, "Manufacturer", false, mfgKey.Public(), nil, mfgKey)
+	deviceCert := newTestCert(t, "Device", false, deviceKey.Public(), nil, deviceKey)
+	ov := fdo.Voucher{
+		Version: 101,
+		Header: cbor.Bstr[fdo.VoucherHeader]{Val: fdo.VoucherHeader{
+			Version:    101,
+			GUID:       guid,
+			DeviceInfo: "extended-device",
+			ManufacturerKey: protocol.PublicKey{
+				Type:     protocol.Secp256r1KeyType,
+				Encoding: protocol.X5ChainKeyEnc,
+				Body:     utils.MustMarshal([]*cbor.X509Certificate{(*cbor.X509Certificate)(mfgCert)}),
+			},
+		}},
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: make([]byte, 32)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(deviceCert)},
+	}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExtendVoucher(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestRoutes(t)
+	defer server.Close()
+	defer state.Close()
+
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddOwnerKey(protocol.Secp256r1KeyType, ownerKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owned := protocol.GUID{0xc0, 0x01}
+	notOwned := protocol.GUID{0xc0, 0x02}
+	for guid, key := range map[protocol.GUID]*ecdsa.PrivateKey{owned: ownerKey, notOwned: otherKey} {
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestOwnedVoucher(t, guid, key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nextOwner, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(nextOwner.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextOwnerPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	extend := func(t *testing.T, guid string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/owner/vouchers/"+guid+"/extend", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/x-pem-file")
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("extend to next owner", func(t *testing.T) {
+		response := extend(t, "c0010000000000000000000000000000", nextOwnerPEM)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blk, _ := pem.Decode(body)
+		if blk == nil || blk.Type != "OWNERSHIP VOUCHER" {
+			t.Fatalf("Unexpected response %q", body)
+		}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(blk.Bytes, &ov); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := ov.OwnerPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nextOwner.PublicKey.Equal(pub) {
+			t.Error("Extended voucher is not owned by the next owner")
+		}
+		if _, err := db.FetchVoucher(owned[:]); err == nil {
+			t.Error("Extended voucher is still stored")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			guid   string
+			body   []byte
+			status int
+		}{
+			{"not owned", "c0020000000000000000000000000000", nextOwnerPEM, http.StatusConflict},
+			{"not found", "c0030000000000000000000000000000", nextOwnerPEM, http.StatusNotFound},
+			{"invalid key", "c0020000000000000000000000000000", []byte("not a key"), http.StatusBadRequest},
+			{"invalid GUID", "c002", nextOwnerPEM, http.StatusBadRequest},
+		} {
+			response := extend(t, tc.guid, tc.body)
+			response.Body.Close()
+			if response.StatusCode != tc.status {
+				t.Errorf("%s: status code is %v, want %v", tc.name, response.StatusCode, tc.status)
+			}
+		}
+		if _, err := db.FetchVoucher(notOwned[:]); err != nil {
+			t.Errorf("Voucher that failed to extend was removed: %v", err)
+		}
+	})
+}
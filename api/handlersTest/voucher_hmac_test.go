@@ -0,0 +1,88 @@
+package handlersTest
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// newTestVoucherWithHMAC returns a voucher whose header HMAC is computed with
+// secret. If tamper is set, the header is modified after computing the HMAC.
+func newTestVoucherWithHMAC(t *testing.T, guid protocol.GUID, secret []byte, tamper bool) []byte {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(newTestVoucher(t, guid, "hmac-device"), &ov); err != nil {
+		t.Fatal(err)
+	}
+	header, err := cbor.Marshal(&ov.Header.Val)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(header)
+	ov.Hmac = protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: mac.Sum(nil)}
+	if tamper {
+		ov.Header.Val.DeviceInfo = "tampered-device"
+	}
+	data, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestInsertVoucherHandlerHMAC(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	secrets := t.TempDir()
+	db.SetDeviceSecretSource(db.DirDeviceSecretSource(secrets))
+	defer db.SetDeviceSecretSource(nil)
+
+	secret := []byte("device hmac secret")
+	for _, guid := range []protocol.GUID{{0x4a, 1}, {0x4a, 2}} {
+		if err := os.WriteFile(filepath.Join(secrets, hex.EncodeToString(guid[:])+".secret"), secret, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		guid   protocol.GUID
+		secret []byte
+		tamper bool
+		want   int
+	}{
+		{"valid", protocol.GUID{0x4a, 1}, secret, false, http.StatusOK},
+		{"tampered header", protocol.GUID{0x4a, 2}, secret, true, http.StatusBadRequest},
+		{"no device secret", protocol.GUID{0x4a, 3}, secret, false, http.StatusBadRequest},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			response := postVoucher(t, server.URL, "application/cbor", newTestVoucherWithHMAC(t, test.guid, test.secret, test.tamper))
+			defer response.Body.Close()
+
+			if response.StatusCode != test.want {
+				t.Fatalf("Status code is %v, want %v", response.StatusCode, test.want)
+			}
+			_, err := db.FetchVoucher(test.guid[:])
+			if stored := err == nil; stored != (test.want == http.StatusOK) {
+				t.Errorf("Voucher stored is %v", stored)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlersTest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestGetVoucherHandlerDecoded(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.GetVoucherHandler)
+	defer server.Close()
+	defer state.Close()
+
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextOwner, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guid := protocol.GUID{0xee, 0x04}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(newTestOwnedVoucher(t, guid, mfgKey), &ov); err != nil {
+		t.Fatal(err)
+	}
+	extended, err := fdo.ExtendVoucher(&ov, mfgKey, nextOwner.Public().(*ecdsa.PublicKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cbor.Marshal(extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "?guid=ee040000000000000000000000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Status code is %v", response.StatusCode)
+	}
+	var body struct {
+		Decoded *handlers.DecodedVoucher `json:"decoded"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	decoded := body.Decoded
+	if decoded == nil {
+		t.Fatal("Response has no decoded voucher")
+	}
+
+	if decoded.GUID != "ee040000000000000000000000000000" || decoded.DeviceInfo != "extended-device" {
+		t.Errorf("Decoded header is %q, %q", decoded.GUID, decoded.DeviceInfo)
+	}
+	if decoded.HeaderHmacAlgorithm != protocol.HmacSha256Hash.String() {
+		t.Errorf("Header HMAC algorithm is %q", decoded.HeaderHmacAlgorithm)
+	}
+	mfgFingerprint, err := utils.PublicKeyFingerprint(mfgKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ManufacturerKey.Type != protocol.Secp256r1KeyType.String() || decoded.ManufacturerKey.Fingerprint != mfgFingerprint {
+		t.Errorf("Manufacturer key is %+v, want fingerprint %q", decoded.ManufacturerKey, mfgFingerprint)
+	}
+	if len(decoded.DeviceCertChain) != 1 || decoded.DeviceCertChain[0].Subject != "CN=Device" {
+		t.Errorf("Device cert chain is %+v", decoded.DeviceCertChain)
+	}
+
+	if len(decoded.Entries) != 1 {
+		t.Fatalf("Decoded voucher has %d entries", len(decoded.Entries))
+	}
+	entry := decoded.Entries[0]
+	nextFingerprint, err := utils.PublicKeyFingerprint(nextOwner.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.PublicKey.Fingerprint != nextFingerprint {
+		t.Errorf("Entry public key is %+v, want fingerprint %q", entry.PublicKey, nextFingerprint)
+	}
+	if entry.SignedByFingerprint != mfgFingerprint || entry.SignedByKeyType != protocol.Secp256r1KeyType.String() {
+		t.Errorf("Entry is signed by %q %q, want the manufacturer key", entry.SignedByKeyType, entry.SignedByFingerprint)
+	}
+	if entry.HeaderHash.Value == "" || entry.PreviousHash.Value == "" {
+		t.Errorf("Entry hashes are missing: %+v", entry)
+	}
+}
@@ -0,0 +1,532 @@
+package handlersTest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func postVoucher(t *testing.T, url, contentType string, body []byte) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestInsertVoucherHandlerFormats(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	encode := map[string]func(guid protocol.GUID, ovCBOR []byte) []byte{
+		"PEM": func(_ protocol.GUID, ovCBOR []byte) []byte {
+			return pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ovCBOR})
+		},
+		"CBOR": func(_ protocol.GUID, ovCBOR []byte) []byte {
+			return ovCBOR
+		},
+		"JSON": func(guid protocol.GUID, ovCBOR []byte) []byte {
+			data, err := json.Marshal(map[string]db.Voucher{"voucher": {GUID: guid[:], CBOR: ovCBOR}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		},
+	}
+	declared := map[string]string{
+		"PEM":  "application/x-pem-file",
+		"CBOR": "application/cbor",
+		"JSON": "application/json",
+	}
+
+	var n byte
+	for format, enc := range encode {
+		for _, contentType := range []struct {
+			name  string
+			value string
+		}{
+			{"declared correctly", declared[format]},
+			{"declared wrongly", "application/x-www-form-urlencoded"},
+			{"undeclared", ""},
+		} {
+			n++
+			guid := protocol.GUID{0xaa, n}
+			ovCBOR := newTestVoucher(t, guid, "test-device")
+			body := enc(guid, ovCBOR)
+
+			t.Run(format+" "+contentType.name, func(t *testing.T) {
+				response := postVoucher(t, server.URL, contentType.value, body)
+				defer response.Body.Close()
+
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("Status code is %v", response.StatusCode)
+				}
+				if _, err := db.FetchVoucher(guid[:]); err != nil {
+					t.Errorf("Voucher was not stored: %v", err)
+				}
+			})
+		}
+	}
+
+	t.Run("Unsupported content", func(t *testing.T) {
+		response := postVoucher(t, server.URL, "text/plain", []byte("not a voucher"))
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestInsertVoucherHandlerConflictPolicy(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+	defer db.SetVoucherConflictPolicy(db.RejectConflicts)
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	for i, test := range []struct {
+		policy     db.VoucherConflictPolicy
+		wantStatus int
+		wantStored string
+	}{
+		{db.RejectConflicts, http.StatusConflict, "original"},
+		{db.OverwriteConflicts, http.StatusOK, "reimported"},
+		{db.KeepNewerConflicts, http.StatusOK, "original"},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			db.SetVoucherConflictPolicy(test.policy)
+
+			guid := protocol.GUID{0xbb, byte(i)}
+			original := newTestVoucher(t, guid, "original")
+			if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: original}); err != nil {
+				t.Fatal(err)
+			}
+			reimported := newTestVoucher(t, guid, "reimported")
+
+			response := postVoucher(t, server.URL, "application/cbor", reimported)
+			defer response.Body.Close()
+
+			if response.StatusCode != test.wantStatus {
+				t.Errorf("Status code is %v", response.StatusCode)
+			}
+			stored, err := db.FetchVoucher(guid[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := original
+			if test.wantStored == "reimported" {
+				want = reimported
+			}
+			if !bytes.Equal(stored.CBOR, want) {
+				t.Errorf("Expected the %s voucher to be stored", test.wantStored)
+			}
+		})
+	}
+
+	t.Run("identical re-import", func(t *testing.T) {
+		db.SetVoucherConflictPolicy(db.RejectConflicts)
+
+		guid := protocol.GUID{0xbb, 0xff}
+		ovCBOR := newTestVoucher(t, guid, "original")
+		if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: ovCBOR}); err != nil {
+			t.Fatal(err)
+		}
+
+		response := postVoucher(t, server.URL, "application/cbor", ovCBOR)
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestInsertVoucherHandlerRemainingPEMContent(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	for i, test := range []struct {
+		name        string
+		trailer     string
+		wantWarning bool
+	}{
+		{"trailing garbage", "this is not PEM\n", true},
+		{"trailing whitespace", "\n\n  \n", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var body []byte
+			for j := range 2 {
+				guid := protocol.GUID{0xdd, byte(i), byte(j)}
+				body = append(body, pem.EncodeToMemory(&pem.Block{
+					Type:  "OWNERSHIP VOUCHER",
+					Bytes: newTestVoucher(t, guid, "test-device"),
+				})...)
+			}
+			body = append(body, test.trailer...)
+
+			req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Accept", "application/json")
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			var result handlers.VoucherImportResponse
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Fatalf("Unable to parse import response %v", err)
+			}
+			if result.Detected != 2 || result.Imported != 2 {
+				t.Errorf("Wrong import counts: %+v", result)
+			}
+			if hasWarning := len(result.Warnings) > 0; hasWarning != test.wantWarning {
+				t.Errorf("Unexpected warnings: %v", result.Warnings)
+			}
+		})
+	}
+}
+
+func TestInsertVoucherHandlerTruncatedCBOR(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	valid := newTestVoucher(t, protocol.GUID{0xde, 1}, "test-device")
+	truncated := newTestVoucher(t, protocol.GUID{0xde, 2}, "test-device")
+	truncated = truncated[:len(truncated)/2]
+	var body []byte
+	for _, ov := range [][]byte{valid, truncated} {
+		body = append(body, pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ov})...)
+	}
+
+	response := postVoucher(t, server.URL, "application/x-pem-file", body)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Status code is %v", response.StatusCode)
+	}
+	msg, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg), "voucher 1") {
+		t.Errorf("Error does not name the failing voucher: %s", msg)
+	}
+	if !strings.Contains(string(msg), "byte offset") {
+		t.Errorf("Error does not locate the truncation: %s", msg)
+	}
+}
+
+func TestInsertVoucherHandlerURL(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	guid := protocol.GUID{0xee, 0x01}
+	voucherPEM := pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: newTestVoucher(t, guid, "test-device")})
+	artifacts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(voucherPEM)
+	}))
+	defer artifacts.Close()
+
+	artifactsURL, err := url.Parse(artifacts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetVoucherFetchConfig(5*time.Second, 1<<20, []string{artifactsURL.Host})
+	defer handlers.SetVoucherFetchConfig(30*time.Second, 1<<20, nil)
+
+	t.Run("allowed URL", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{"url": artifacts.URL + "/voucher.pem"})
+		response := postVoucher(t, server.URL, "application/json", body)
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+		if _, err := db.FetchVoucher(guid[:]); err != nil {
+			t.Errorf("Voucher was not stored: %v", err)
+		}
+	})
+
+	t.Run("URL not in allowlist", func(t *testing.T) {
+		body, _ := json.Marshal(map[string]any{"urls": []string{"http://169.254.169.254/latest/meta-data"}})
+		response := postVoucher(t, server.URL, "application/json", body)
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusForbidden {
+			t.Errorf("Status code is %v", response.StatusCode)
+		}
+	})
+}
+
+func TestGetVoucherHandlerDiagnostic(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.GetVoucherHandler)
+	defer server.Close()
+	defer state.Close()
+
+	guid := protocol.GUID{0xee, 0x02}
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: newTestVoucher(t, guid, "diag-device")}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(server.URL + "?guid=ee020000000000000000000000000000&format=diag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Status code is %v", response.StatusCode)
+	}
+	if contentType := response.Header.Get("Content-Type"); contentType != "application/cbor-diagnostic" {
+		t.Errorf("Content-Type is %q", contentType)
+	}
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diag := string(body)
+
+	// A voucher is [protver, bstr .cbor header, hmac, cert chain, entries]
+	// and its header starts with [protver, guid, rvinfo, device info, ...]
+	if want := "[101, <<[101, h'ee020000000000000000000000000000', "; !strings.HasPrefix(diag, want) {
+		t.Errorf("Diagnostic output does not start with %q: %s", want, diag)
+	}
+	if !strings.Contains(diag, `"diag-device"`) {
+		t.Errorf("Diagnostic output does not contain the device info: %s", diag)
+	}
+}
+
+func TestGetVoucherHandlerDeviceCertChain(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.GetVoucherHandler)
+	defer server.Close()
+	defer state.Close()
+
+	guid := protocol.GUID{0xee, 0x03}
+	data := newTestVoucher(t, guid, "chain-device")
+	if err := db.InsertVoucher(db.Voucher{GUID: guid[:], CBOR: data}); err != nil {
+		t.Fatal(err)
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(data, &ov); err != nil {
+		t.Fatal(err)
+	}
+	deviceCert := (*x509.Certificate)((*ov.CertChain)[0])
+
+	type voucherResponse struct {
+		DeviceCertChain []handlers.VoucherCert `json:"device_cert_chain"`
+	}
+	get := func(query string) (int, voucherResponse) {
+		response, err := http.Get(server.URL + "?guid=ee030000000000000000000000000000" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var body voucherResponse
+		if response.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return response.StatusCode, body
+	}
+
+	if status, body := get(""); status != http.StatusOK || body.DeviceCertChain != nil {
+		t.Errorf("Default response is %v with chain %v", status, body.DeviceCertChain)
+	}
+
+	status, body := get("&include=device_cert_chain")
+	if status != http.StatusOK {
+		t.Fatalf("Status code is %v", status)
+	}
+	if len(body.DeviceCertChain) != 1 {
+		t.Fatalf("Device cert chain has %d certificates", len(body.DeviceCertChain))
+	}
+	cert := body.DeviceCertChain[0]
+	if cert.Subject != "CN=chain-device" {
+		t.Errorf("Subject is %q", cert.Subject)
+	}
+	if want := db.CertFingerprint(deviceCert); cert.Fingerprint != want {
+		t.Errorf("Fingerprint is %q, want %q", cert.Fingerprint, want)
+	}
+	if !cert.NotAfter.Equal(deviceCert.NotAfter) {
+		t.Errorf("NotAfter is %v, want %v", cert.NotAfter, deviceCert.NotAfter)
+	}
+
+	if status, _ := get("&include=unknown"); status != http.StatusBadRequest {
+		t.Errorf("Unknown include status code is %v", status)
+	}
+}
+
+func TestVoucherServerParallel(t *testing.T) {
+	for i := range 4 {
+		t.Run(fmt.Sprintf("state %d", i), func(t *testing.T) {
+			t.Parallel()
+
+			dbPath := filepath.Join(t.TempDir(), "test.db")
+			state, err := sqlite.Open(dbPath, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer state.Close()
+			store := db.NewState(state)
+			if err := store.Init(); err != nil {
+				t.Fatal(err)
+			}
+
+			var rvInfo [][]protocol.RvInstruction
+			vouchers := &handlers.VoucherServer{State: store, RvInfo: &rvInfo}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /vouchers", vouchers.GetVoucher)
+			mux.HandleFunc("POST /vouchers", vouchers.InsertVoucher)
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			for j := range 5 {
+				guid := protocol.GUID{0xcf, byte(i), byte(j)}
+				response := postVoucher(t, server.URL+"/vouchers", "application/cbor", newTestVoucher(t, guid, "test-device"))
+				response.Body.Close()
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("Status code is %v", response.StatusCode)
+				}
+
+				response, err := http.Get(fmt.Sprintf("%s/vouchers?guid=%x", server.URL, guid[:]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				response.Body.Close()
+				if response.StatusCode != http.StatusOK {
+					t.Fatalf("Status code is %v", response.StatusCode)
+				}
+			}
+
+			// Vouchers of other states must not be visible
+			other := protocol.GUID{0xcf, byte((i + 1) % 4), 0}
+			if _, err := store.FetchVoucher(other[:]); err == nil {
+				t.Error("Voucher of another state is visible")
+			}
+		})
+	}
+}
+
+func TestInsertVoucherHandlerAccept(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	var rvInfo [][]protocol.RvInstruction
+	server, state := setupTestServer(t, handlers.InsertVoucherHandler(&rvInfo))
+	defer server.Close()
+	defer state.Close()
+
+	for i, test := range []struct {
+		accept   string
+		wantJSON bool
+	}{
+		{"", false},
+		{"application/json", true},
+		{"text/plain", false},
+		{"*/*", false},
+		{"application/*", true},
+		{"text/plain;q=0.5, application/json", true},
+		{"application/json;q=0.1, text/plain", false},
+		{"application/json;q=0", false},
+		{"text/html", false},
+	} {
+		t.Run(fmt.Sprintf("Accept %q", test.accept), func(t *testing.T) {
+			guid := protocol.GUID{0xac, byte(i)}
+			req, err := http.NewRequest(http.MethodPost, server.URL, bytes.NewReader(newTestVoucher(t, guid, "test-device")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Status code is %v", response.StatusCode)
+			}
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var result handlers.VoucherImportResponse
+			isJSON := json.Unmarshal(body, &result) == nil
+			if isJSON != test.wantJSON {
+				t.Errorf("got response %q, want JSON %v", body, test.wantJSON)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package handlersTest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func TestWaitPolicyHandler(t *testing.T) {
+
+	cleanup := func() error { return os.Remove("test.db") }
+	defer cleanup()
+
+	server, state := setupTestServer(t, handlers.WaitPolicyHandler)
+	defer server.Close()
+	defer state.Close()
+
+	put := func(t *testing.T, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader([]byte(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	t.Run("default", func(t *testing.T) {
+		response, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		var policy db.WaitPolicy
+		if err := json.NewDecoder(response.Body).Decode(&policy); err != nil {
+			t.Fatal(err)
+		}
+		if policy != db.DefaultWaitPolicy {
+			t.Errorf("got policy %+v, want %+v", policy, db.DefaultWaitPolicy)
+		}
+		if accept, err := db.AcceptVoucher(context.Background(), fdo.Voucher{}); err != nil || !accept {
+			t.Errorf("voucher was not accepted: %v", err)
+		}
+		if ttl := db.NegotiateTTL(12345, fdo.Voucher{}); ttl != 12345 {
+			t.Errorf("got TTL %d, want the requested TTL", ttl)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		response := put(t, `{"min_wait_secs":3600,"max_wait_secs":86400}`)
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("Status code is %v", response.StatusCode)
+		}
+
+		for _, test := range []struct {
+			requested, want uint32
+		}{
+			{60, 3600},
+			{7200, 7200},
+			{1 << 20, 86400},
+		} {
+			if ttl := db.NegotiateTTL(test.requested, fdo.Voucher{}); ttl != test.want {
+				t.Errorf("requested TTL %d: got %d, want %d", test.requested, ttl, test.want)
+			}
+		}
+	})
+
+	for _, body := range []string{
+		`{"min_wait_secs":86400,"max_wait_secs":3600}`,
+		`{"min_wait_secs":-1,"max_wait_secs":3600}`,
+		`{"min_wait_secs":60}`,
+		`not json`,
+	} {
+		t.Run("invalid "+body, func(t *testing.T) {
+			response := put(t, body)
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusBadRequest {
+				t.Errorf("Status code is %v", response.StatusCode)
+			}
+			policy, err := db.FetchWaitPolicy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != (db.WaitPolicy{MinWaitSecs: 3600, MaxWaitSecs: 86400}) {
+				t.Errorf("Invalid policy was stored: %+v", policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"maps"
+	"net/http"
+)
+
+// DefaultResponseHeaders are the security headers set on every response
+// unless changed with SetResponseHeader. Strict-Transport-Security is only
+// sent over TLS.
+var DefaultResponseHeaders = map[string]string{
+	"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+	"X-Content-Type-Options":    "nosniff",
+	"X-Frame-Options":           "DENY",
+	"Content-Security-Policy":   "default-src 'none'; frame-ancestors 'none'",
+}
+
+var responseHeaders = maps.Clone(DefaultResponseHeaders)
+
+// SetResponseHeader sets a header on every response, replacing any default
+// value. An empty value removes the header.
+func SetResponseHeader(name, value string) {
+	name = http.CanonicalHeaderKey(name)
+	if value == "" {
+		delete(responseHeaders, name)
+		return
+	}
+	responseHeaders[name] = value
+}
+
+// ResponseHeaderMiddleware sets the configured response headers before
+// calling next, so that handlers can still override them.
+func ResponseHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range responseHeaders {
+			// HSTS is ignored by browsers over plain HTTP and only invites
+			// confusion when sent there
+			if name == "Strict-Transport-Security" && r.TLS == nil {
+				continue
+			}
+			w.Header().Set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResponseHeaderMiddleware(t *testing.T) {
+	defer func() { responseHeaders = maps.Clone(DefaultResponseHeaders) }()
+
+	handler := ResponseHeaderMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+
+	get := func(t *testing.T, server *httptest.Server) http.Header {
+		t.Helper()
+		resp, err := server.Client().Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		return resp.Header
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		for _, server := range []*httptest.Server{tlsServer, plainServer} {
+			header := get(t, server)
+			for name, value := range DefaultResponseHeaders {
+				if name == "Strict-Transport-Security" {
+					continue
+				}
+				if got := header.Get(name); got != value {
+					t.Errorf("%s is %q, want %q", name, got, value)
+				}
+			}
+		}
+	})
+
+	t.Run("HSTS over TLS", func(t *testing.T) {
+		if got := get(t, tlsServer).Get("Strict-Transport-Security"); got == "" {
+			t.Error("Strict-Transport-Security is missing over TLS")
+		}
+	})
+
+	t.Run("no HSTS over plain HTTP", func(t *testing.T) {
+		if got := get(t, plainServer).Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("Strict-Transport-Security is %q over plain HTTP", got)
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		SetResponseHeader("strict-transport-security", "max-age=60")
+		SetResponseHeader("X-Frame-Options", "")
+		SetResponseHeader("X-Custom", "value")
+
+		header := get(t, tlsServer)
+		if got := header.Get("Strict-Transport-Security"); got != "max-age=60" {
+			t.Errorf("Strict-Transport-Security is %q, want %q", got, "max-age=60")
+		}
+		if got := header.Get("X-Frame-Options"); got != "" {
+			t.Errorf("X-Frame-Options is %q after removing it", got)
+		}
+		if got := header.Get("X-Custom"); got != "value" {
+			t.Errorf("X-Custom is %q, want %q", got, "value")
+		}
+	})
+}
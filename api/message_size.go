@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// DefaultMaxMessageSize is the largest FDO protocol message body accepted
+// unless SetMaxMessageSize is called. It is the largest message size a device
+// can negotiate for TO2 service info.
+const DefaultMaxMessageSize = 65535
+
+var maxMessageSize int64 = DefaultMaxMessageSize
+
+// SetMaxMessageSize limits the body size of FDO protocol messages. It does
+// not affect the management API. A size of 0 or less disables the limit.
+func SetMaxMessageSize(size int64) {
+	maxMessageSize = size
+}
+
+// messageSizeMiddleware rejects FDO protocol messages whose body is larger
+// than the configured limit with an FDO error message.
+func messageSizeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxMessageSize
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > limit {
+			slog.Debug("Rejecting oversized FDO message", "msg", r.PathValue("msg"), "size", r.ContentLength, "limit", limit)
+			writeErrorMessage(w, r, protocol.MessageBodyErrCode, fmt.Sprintf("message body exceeds %d bytes", limit))
+			return
+		}
+		// Bodies of unknown length fail when reading past the limit
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
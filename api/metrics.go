@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+var metricsEnabled bool
+
+// SetMetricsEnabled serves the metrics of the server in the Prometheus text
+// format at /metrics. Like the management API, the endpoint requires
+// credentials if management API authentication is configured.
+func SetMetricsEnabled(enabled bool) {
+	metricsEnabled = enabled
+}
+
+// metricsHandler writes the metrics of the server for Prometheus to scrape.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := metrics.WritePrometheus(w); err != nil {
+		slog.Debug("Error writing metrics", "error", err)
+	}
+}
+
+// registerMetricsRoute serves /metrics on handler if metrics are enabled.
+func registerMetricsRoute(handler *http.ServeMux) {
+	if metricsEnabled {
+		handler.Handle("GET /metrics", authMiddleware(http.HandlerFunc(metricsHandler)))
+	}
+}
+
+// messageMetricsMiddleware records the duration and result of each FDO
+// protocol message. Messages answered with an FDO error message or an HTTP
+// error status count as errors.
+func messageMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		msgType, err := strconv.ParseUint(r.PathValue("msg"), 10, 8)
+		if err != nil {
+			return
+		}
+		failed := rec.status >= http.StatusBadRequest ||
+			w.Header().Get("Message-Type") == strconv.Itoa(int(protocol.ErrorMsgType))
+		metrics.ObserveMessage(uint8(msgType), failed, time.Since(start))
+	})
+}
+
+// statusRecorder records the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestMessageMetricsMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("POST /fdo/101/msg/{msg}", messageMetricsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("msg") == "62" {
+			w.Header().Set("Message-Type", strconv.Itoa(int(protocol.ErrorMsgType)))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+	for _, msg := range []string{"30", "62"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/fdo/101/msg/"+msg, nil))
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`fdo_messages_total{phase="TO1",msg_type="30",result="success"} 1`,
+		`fdo_messages_total{phase="TO2",msg_type="62",result="error"} 1`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing line %q", line)
+		}
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	defer SetMetricsEnabled(false)
+
+	for _, enabled := range []bool{false, true} {
+		SetMetricsEnabled(enabled)
+		mux := http.NewServeMux()
+		registerMetricsRoute(mux)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if enabled && (w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "# TYPE fdo_messages_total counter")) {
+			t.Errorf("metrics enabled: got status %d and body %q", w.Code, w.Body)
+		}
+		if !enabled && w.Code != http.StatusNotFound {
+			t.Errorf("metrics disabled: got status %d, want 404", w.Code)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// protocolErrorMiddleware logs FDO protocol messages answered with an error,
+// with the message type and session, and counts them. Failures that are not
+// answered with an FDO error message, such as plain 5xx responses or panics
+// of the state layer, are answered with an internal server error message
+// instead, so that devices always receive a protocol response.
+func protocolErrorMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := &bufferedResponse{header: make(http.Header)}
+		if err := serveRecovered(next, resp, r); err != nil {
+			metrics.ProtocolErrors.Add(1)
+			slog.Error("FDO protocol handler failed", protocolErrorAttrs(r, "error", err)...)
+			writeErrorMessage(w, r, protocol.InternalServerErrCode, "internal server error")
+			return
+		}
+
+		if resp.header.Get("Message-Type") == strconv.Itoa(int(protocol.ErrorMsgType)) {
+			metrics.ProtocolErrors.Add(1)
+			var msg protocol.ErrorMessage
+			if err := cbor.Unmarshal(resp.body.Bytes(), &msg); err != nil {
+				slog.Error("Error decoding FDO error message", protocolErrorAttrs(r, "error", err)...)
+			} else if msg.Code == protocol.InternalServerErrCode {
+				slog.Error("FDO protocol error", protocolErrorAttrs(r, "code", msg.Code, "error", msg.ErrString)...)
+			} else {
+				slog.Debug("FDO protocol error", protocolErrorAttrs(r, "code", msg.Code, "error", msg.ErrString)...)
+			}
+		} else if resp.status >= http.StatusInternalServerError {
+			metrics.ProtocolErrors.Add(1)
+			slog.Error("FDO protocol handler failed", protocolErrorAttrs(r, "status", resp.status, "error", strings.TrimSpace(resp.body.String()))...)
+			writeErrorMessage(w, r, protocol.InternalServerErrCode, "internal server error")
+			return
+		}
+
+		for key, values := range resp.header {
+			w.Header()[key] = values
+		}
+		if resp.status != 0 {
+			w.WriteHeader(resp.status)
+		}
+		w.Write(resp.body.Bytes())
+	})
+}
+
+// serveRecovered serves a request, returning a panic of the handler as error.
+func serveRecovered(next http.Handler, w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+	next.ServeHTTP(w, r)
+	return nil
+}
+
+// protocolErrorAttrs returns the log attributes identifying a protocol
+// message followed by args. The session is identified by a hash of its token,
+// which must not be logged itself.
+func protocolErrorAttrs(r *http.Request, args ...any) []any {
+	session := ""
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		sum := sha256.Sum256([]byte(token))
+		session = hex.EncodeToString(sum[:8])
+	}
+	return append([]any{"msg", r.PathValue("msg"), "session", session, "remote", r.RemoteAddr}, args...)
+}
+
+// bufferedResponse holds a response until the protocol handler has finished,
+// so that it can still be replaced with an FDO error message.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// writeErrorMessage responds with an FDO error message, as the protocol
+// handler does for messages it cannot process.
+func writeErrorMessage(w http.ResponseWriter, r *http.Request, code uint16, reason string) {
+	prevMsgType, _ := strconv.ParseUint(r.PathValue("msg"), 10, 8)
+	body, err := cbor.Marshal(protocol.ErrorMessage{
+		Code:        code,
+		PrevMsgType: uint8(prevMsgType),
+		ErrString:   reason,
+	})
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/cbor")
+	w.Header().Set("Message-Type", strconv.Itoa(int(protocol.ErrorMsgType)))
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(body)
+}
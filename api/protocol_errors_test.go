@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+)
+
+func TestProtocolErrorMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	sum := sha256.Sum256([]byte("token"))
+	session := hex.EncodeToString(sum[:8])
+
+	for _, test := range []struct {
+		name    string
+		handler http.HandlerFunc
+		wantLog string
+	}{
+		{"database failure", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "database is locked", http.StatusInternalServerError)
+		}, "database is locked"},
+		{"panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("nil state")
+		}, "nil state"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			logs.Reset()
+			before := metrics.ProtocolErrors.Load()
+
+			mux := http.NewServeMux()
+			mux.Handle("POST /fdo/101/msg/{msg}", protocolErrorMiddleware(test.handler))
+			req := httptest.NewRequest(http.MethodPost, "/fdo/101/msg/62", nil)
+			req.Header.Set("Authorization", "Bearer token")
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Errorf("Status code is %v", rec.Code)
+			}
+			if msgType := rec.Header().Get("Message-Type"); msgType != "255" {
+				t.Errorf("Message-Type is %q, expected an FDO error message", msgType)
+			}
+			for _, want := range []string{test.wantLog, "msg=62", "session=" + session} {
+				if !strings.Contains(logs.String(), want) {
+					t.Errorf("Log does not contain %q: %s", want, logs.String())
+				}
+			}
+			if strings.Contains(logs.String(), "Bearer token") {
+				t.Error("Log contains the session token")
+			}
+			if metrics.ProtocolErrors.Load() != before+1 {
+				t.Error("Protocol error was not counted")
+			}
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		before := metrics.ProtocolErrors.Load()
+		handler := protocolErrorMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Message-Type", "61")
+			w.Write([]byte("response"))
+		}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/fdo/101/msg/60", nil))
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "response" || rec.Header().Get("Message-Type") != "61" {
+			t.Errorf("Response was not passed through: %d %q", rec.Code, rec.Body)
+		}
+		if metrics.ProtocolErrors.Load() != before {
+			t.Error("Successful message was counted as an error")
+		}
+	})
+}
@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package api
+
+import "github.com/fido-device-onboard/go-fdo"
+
+var (
+	ownerKeys      fdo.OwnerKeyPersistentState
+	maxResaleDepth int
+)
+
+// SetOwnerKeys sets the owner keys that sign voucher extensions through the
+// management API, such as keys opened from key URIs. Without them, the owner
+// keys of the database are used.
+func SetOwnerKeys(keys fdo.OwnerKeyPersistentState) {
+	ownerKeys = keys
+}
+
+// SetMaxResaleDepth refuses to extend vouchers through the management API
+// that already have n entries. An n of 0 is no limit.
+func SetMaxResaleDepth(n int) {
+	maxResaleDepth = n
+}
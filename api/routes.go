@@ -8,6 +8,8 @@ import (
 	"net/http"
 
 	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/to0"
 	transport "github.com/fido-device-onboard/go-fdo/http"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
@@ -30,6 +32,20 @@ func rateLimitMiddleware(limiter *rate.Limiter, next http.Handler) http.Handler
 	})
 }
 
+// Roles of the listeners reported at the root path
+const (
+	AllRoutesRole        = "all"
+	ProtocolRoutesRole   = "fdo"
+	ManagementRoutesRole = "management"
+)
+
+// Base paths of the APIs of each listener reported at the root path
+var (
+	allAPIPaths        = []string{"/fdo/101/msg", "/fdo/status", "/api/v1", "/health"}
+	protocolAPIPaths   = []string{"/fdo/101/msg", "/fdo/status", "/health"}
+	managementAPIPaths = []string{"/api/v1", "/health"}
+)
+
 // NewHTTPHandler creates a new HTTPHandler
 func NewHTTPHandler(handler *transport.Handler, rvInfo *[][]protocol.RvInstruction, state *sqlite.DB) *HTTPHandler {
 	return &HTTPHandler{handler: handler, rvInfo: rvInfo, state: state}
@@ -39,23 +55,125 @@ func NewHTTPHandler(handler *transport.Handler, rvInfo *[][]protocol.RvInstructi
 func (h *HTTPHandler) RegisterRoutes() *http.ServeMux {
 	handler := http.NewServeMux()
 	limiter := rate.NewLimiter(2, 10)
+	h.registerProtocolRoutes(handler, limiter)
+	h.registerManagementRoutes(handler, limiter)
+	registerMetricsRoute(handler)
+	handler.HandleFunc("/health", handlers.HealthHandler)
+	handler.HandleFunc("GET /{$}", handlers.RootHandler(AllRoutesRole, allAPIPaths))
+	return handler
+}
+
+// ProtocolRoutes returns a handler of only the device-facing FDO protocol
+// routes, for serving the management API on a separate listener.
+func (h *HTTPHandler) ProtocolRoutes() *http.ServeMux {
+	handler := http.NewServeMux()
+	h.registerProtocolRoutes(handler, rate.NewLimiter(2, 10))
+	handler.HandleFunc("/health", handlers.HealthHandler)
+	handler.HandleFunc("GET /{$}", handlers.RootHandler(ProtocolRoutesRole, protocolAPIPaths))
+	return handler
+}
+
+// ManagementRoutes returns a handler of only the management API routes.
+func (h *HTTPHandler) ManagementRoutes() *http.ServeMux {
+	handler := http.NewServeMux()
+	h.registerManagementRoutes(handler, rate.NewLimiter(2, 10))
+	registerMetricsRoute(handler)
+	handler.HandleFunc("/health", handlers.HealthHandler)
+	handler.HandleFunc("GET /{$}", handlers.RootHandler(ManagementRoutesRole, managementAPIPaths))
+	return handler
+}
 
-	handler.Handle("POST /fdo/101/msg/{msg}", h.handler)
-	handler.HandleFunc("/api/v1/rvinfo", func(w http.ResponseWriter, r *http.Request) {
+func (h *HTTPHandler) registerProtocolRoutes(handler *http.ServeMux, limiter *rate.Limiter) {
+	handler.Handle("POST /fdo/101/msg/{msg}", messageMetricsMiddleware(protocolErrorMiddleware(messageSizeMiddleware(h.handler))))
+	handler.HandleFunc("GET /fdo/status/{guid}", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.OnboardingStatusHandler)).ServeHTTP(w, r)
+	})
+}
+
+func (h *HTTPHandler) registerManagementRoutes(handler *http.ServeMux, limiter *rate.Limiter) {
+	vouchers := &handlers.VoucherServer{State: db.NewState(h.state), RvInfo: h.rvInfo, OwnerKeys: h.state, MaxEntries: maxResaleDepth}
+	if ownerKeys != nil {
+		vouchers.OwnerKeys = ownerKeys
+	}
+	routes := http.NewServeMux()
+
+	routes.HandleFunc("/api/v1/rvinfo", func(w http.ResponseWriter, r *http.Request) {
 		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.RvInfoHandler(h.rvInfo))).ServeHTTP(w, r)
 	})
-	handler.HandleFunc("/api/v1/owner/redirect", func(w http.ResponseWriter, r *http.Request) {
+	routes.HandleFunc("/api/v1/device-info-allowlist", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.DeviceInfoAllowlistHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/rendezvous/wait-policy", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.WaitPolicyHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/owner/redirect", func(w http.ResponseWriter, r *http.Request) {
 		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.OwnerInfoHandler)).ServeHTTP(w, r)
 	})
-	handler.HandleFunc("/api/v1/to0/", func(w http.ResponseWriter, r *http.Request) {
+	routes.HandleFunc("/api/v1/to0/", func(w http.ResponseWriter, r *http.Request) {
 		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.To0Handler(h.rvInfo, h.state))).ServeHTTP(w, r)
 	})
-	handler.HandleFunc("/api/v1/vouchers", func(w http.ResponseWriter, r *http.Request) {
-		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.GetVoucherHandler)).ServeHTTP(w, r)
+	routes.HandleFunc("/api/v1/vouchers", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(vouchers.GetVoucher)).ServeHTTP(w, r)
 	})
-	handler.HandleFunc("/api/v1/owner/vouchers", func(w http.ResponseWriter, r *http.Request) {
-		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.InsertVoucherHandler(h.rvInfo))).ServeHTTP(w, r)
+	routes.HandleFunc("/api/v1/owner/vouchers", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(vouchers.InsertVoucher)).ServeHTTP(w, r)
 	})
-	handler.HandleFunc("/health", handlers.HealthHandler)
-	return handler
+	routes.HandleFunc("GET /api/v1/owner/vouchers/facets", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.VoucherFacetsHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/vouchers/count", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.VoucherCountHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("POST /api/v1/owner/vouchers/{guid}/extend", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(vouchers.ExtendVoucher)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/vouchers/export", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.ExportVouchersHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/vouchers/expiring", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.ExpiringVouchersHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("POST /api/v1/owner/vouchers/{guid}/recompute-rvinfo", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.RecomputeRvInfoHandler(to0.RegisterRvBlob, h.state))).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/devices", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.ListDevicesHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/devices/{guid}", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.DeviceHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("POST /api/v1/owner/devices/status", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.BulkOnboardingStatusHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("PUT /api/v1/owner/devices/{guid}/onboarding-status", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.UpdateOnboardingStatusHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/devices/{guid}/timeline", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.DeviceTimelineHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/owner/devices/{guid}/bundle", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.DeviceBundleHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/audit", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.AuditLogHandler)).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/owner/manufacturer-cas", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.TrustedCAsHandler(db.TrustedManufacturerCAs))).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/owner/manufacturer-cas/{fingerprint}", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.TrustedCAHandler(db.TrustedManufacturerCAs))).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("GET /api/v1/manufacturing/keys/{type}", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.ManufacturerKeyHandler(h.state))).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/owner/device-cas", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.TrustedCAsHandler(db.TrustedDeviceCAs))).ServeHTTP(w, r)
+	})
+	routes.HandleFunc("/api/v1/owner/device-cas/{fingerprint}", func(w http.ResponseWriter, r *http.Request) {
+		rateLimitMiddleware(limiter, http.HandlerFunc(handlers.TrustedCAHandler(db.TrustedDeviceCAs))).ServeHTTP(w, r)
+	})
+
+	// Authenticate before routing, so that unauthenticated requests learn
+	// nothing about which resources exist
+	handler.Handle("/api/v1/", authMiddleware(routes))
 }
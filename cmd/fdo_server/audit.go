@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+)
+
+// setupAuditLog appends audit events to the file at path as JSON lines, in
+// addition to the database. The file is never truncated or rotated.
+func setupAuditLog(path string) (func() error, error) {
+	file, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	audit.SetLog(file)
+	return func() error {
+		audit.SetLog(nil)
+		return file.Close()
+	}, nil
+}
+
+// cliContext returns the context of operations run from the command line,
+// audited as made by the user running the server.
+func cliContext() context.Context {
+	actor := "cli"
+	if u, err := user.Current(); err == nil {
+		actor += ":" + u.Username
+	}
+	return audit.WithActor(context.Background(), actor)
+}
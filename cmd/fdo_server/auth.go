@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo-server/api"
+)
+
+// setManagementAuth configures the credentials accepted by the management API
+// from -api-key, -api-jwt-secret and -api-client-ca. Secrets are read from
+// files so that they do not appear in process listings.
+func setManagementAuth() error {
+	for _, value := range apiKeys {
+		role, path, err := parseRolePath(value)
+		if err != nil {
+			return fmt.Errorf("invalid API key: %w", err)
+		}
+		key, err := readSecretFile(path)
+		if err != nil {
+			return fmt.Errorf("invalid API key: %w", err)
+		}
+		api.AddAPIKey(role, string(key))
+	}
+
+	if apiJWTSecret != "" {
+		secret, err := readSecretFile(apiJWTSecret)
+		if err != nil {
+			return fmt.Errorf("invalid JWT secret: %w", err)
+		}
+		api.SetJWTSecret(secret)
+	}
+
+	for _, value := range apiClientCAs {
+		role, path, err := parseRolePath(value)
+		if err != nil {
+			return fmt.Errorf("invalid API client CA: %w", err)
+		}
+		data, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return fmt.Errorf("error reading API client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no PEM encoded certificates found in %s", path)
+		}
+		api.AddClientCAs(role, pool)
+	}
+
+	return nil
+}
+
+// parseRolePath parses a role=path value.
+func parseRolePath(value string) (api.Role, string, error) {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return "", "", fmt.Errorf("%q must be role=path", value)
+	}
+	role, err := api.ParseRole(name)
+	if err != nil {
+		return "", "", err
+	}
+	return role, path, nil
+}
+
+// readSecretFile reads a secret, ignoring surrounding white space such as a
+// trailing newline.
+func readSecretFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// ownerKeyStore stores owner keys by type, such as sqlite.DB.
+type ownerKeyStore interface {
+	OwnerKey(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
+	AddOwnerKey(protocol.KeyType, crypto.PrivateKey, []*x509.Certificate) error
+}
+
+// ownerKeyGenerators maps owner key types to the -generate-key name of the
+// key generated for them.
+var ownerKeyGenerators = map[protocol.KeyType]string{
+	protocol.Secp256r1KeyType:    "ec256",
+	protocol.Secp384r1KeyType:    "ec384",
+	protocol.Rsa2048RestrKeyType: "rsa2048",
+	protocol.RsaPkcsKeyType:      "rsa3072",
+	protocol.RsaPssKeyType:       "rsa3072",
+}
+
+// ensureOwnerKey returns the owner key of keyType, generating and storing one
+// only if none is stored. It reports whether the key was generated.
+func ensureOwnerKey(store ownerKeyStore, keyType protocol.KeyType) (crypto.Signer, bool, error) {
+	key, _, err := store.OwnerKey(keyType)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, fdo.ErrNotFound) {
+		return nil, false, fmt.Errorf("error looking up owner key: %w", err)
+	}
+	if err == nil && key != nil {
+		return key, false, nil
+	}
+
+	name, ok := ownerKeyGenerators[keyType]
+	if !ok {
+		return nil, false, fmt.Errorf("unsupported owner key type: %s", keyType)
+	}
+	key, err = generatePrivateKey(name)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := store.AddOwnerKey(keyType, key, nil); err != nil {
+		return nil, false, fmt.Errorf("error storing owner key: %w", err)
+	}
+	return key, true, nil
+}
+
+// writePublicKeyPEM writes a public key as PEM to out and its fingerprint to
+// stderr, so that the PEM output remains usable.
+func writePublicKeyPEM(out io.Writer, pub crypto.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	fingerprint, err := utils.PublicKeyFingerprint(pub)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "SHA-256 fingerprint: %s\n", fingerprint)
+	return pem.Encode(out, &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: der,
+	})
+}
+
+func doBootstrapOwnerKey(state *sqlite.DB) error {
+	keyType, err := protocol.ParseKeyType(bootstrapOwnerKey)
+	if err != nil {
+		return fmt.Errorf("%w: see usage", err)
+	}
+	key, created, err := ensureOwnerKey(state, keyType)
+	if err != nil {
+		return err
+	}
+	if created {
+		slog.Info("Generated owner key", "type", keyType.String())
+		if err := db.InitDb(state); err != nil {
+			return err
+		}
+		audit.Record(cliContext(), audit.OwnerKeyCreated, nil, nil, keyType.String())
+	} else {
+		slog.Info("Owner key already exists", "type", keyType.String())
+	}
+	return writePublicKeyPEM(os.Stdout, key.Public())
+}
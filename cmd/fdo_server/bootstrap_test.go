@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// memOwnerKeys is an in-memory ownerKeyStore.
+type memOwnerKeys struct {
+	keys map[protocol.KeyType]crypto.Signer
+	adds int
+}
+
+func (m *memOwnerKeys) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	key, ok := m.keys[keyType]
+	if !ok {
+		return nil, nil, fmt.Errorf("error querying owner key [type=%s]: %w", keyType, sql.ErrNoRows)
+	}
+	return key, nil, nil
+}
+
+func (m *memOwnerKeys) AddOwnerKey(keyType protocol.KeyType, key crypto.PrivateKey, _ []*x509.Certificate) error {
+	m.keys[keyType] = key.(crypto.Signer)
+	m.adds++
+	return nil
+}
+
+func TestEnsureOwnerKey(t *testing.T) {
+	for _, keyType := range []protocol.KeyType{protocol.Secp256r1KeyType, protocol.Secp384r1KeyType, protocol.Rsa2048RestrKeyType} {
+		t.Run(keyType.String(), func(t *testing.T) {
+			store := &memOwnerKeys{keys: make(map[protocol.KeyType]crypto.Signer)}
+
+			first, created, err := ensureOwnerKey(store, keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !created || store.adds != 1 {
+				t.Fatalf("first run generated %v and stored %d keys", created, store.adds)
+			}
+			if gotType, err := getPrivateKeyType(first); err != nil || gotType != keyType {
+				t.Errorf("generated key type is %s, %v", gotType, err)
+			}
+
+			second, created, err := ensureOwnerKey(store, keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if created || store.adds != 1 {
+				t.Errorf("second run generated %v and stored %d keys", created, store.adds)
+			}
+
+			var firstPEM, secondPEM bytes.Buffer
+			if err := writePublicKeyPEM(&firstPEM, first.Public()); err != nil {
+				t.Fatal(err)
+			}
+			if err := writePublicKeyPEM(&secondPEM, second.Public()); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(firstPEM.Bytes(), secondPEM.Bytes()) {
+				t.Error("second run printed a different public key")
+			}
+		})
+	}
+
+	t.Run("lookup error", func(t *testing.T) {
+		failing := ownerKeyLookupError{}
+		if _, _, err := ensureOwnerKey(failing, protocol.Secp256r1KeyType); err == nil {
+			t.Error("expected error when the owner key cannot be looked up")
+		}
+	})
+}
+
+// ownerKeyLookupError fails looking up owner keys, such as a locked database.
+type ownerKeyLookupError struct{}
+
+func (ownerKeyLookupError) OwnerKey(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	return nil, nil, fmt.Errorf("database is locked")
+}
+
+func (ownerKeyLookupError) AddOwnerKey(protocol.KeyType, crypto.PrivateKey, []*x509.Certificate) error {
+	panic("owner key stored after a failed lookup")
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+	"github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/vfs/memdb" // In-memory VFS
+)
+
+// inMemoryDB is the -db path that selects a database held in memory, which is
+// discarded when the server exits. It is meant for tests and other ephemeral
+// runs.
+const inMemoryDB = ":memory:"
+
+// openDatabase opens the SQLite database at path, or a new in-memory database
+// if path is inMemoryDB. The password is not used for in-memory databases.
+func openDatabase(path, password string) (*sqlite.DB, error) {
+	if path != inMemoryDB {
+		return sqlite.Open(path, password)
+	}
+
+	// Each connection to ":memory:" would get a database of its own, so a
+	// uniquely named database of the memdb VFS is shared by all connections
+	var name [8]byte
+	if _, err := rand.Read(name[:]); err != nil {
+		return nil, err
+	}
+	connector, err := (&driver.SQLite{}).OpenConnector("file:/" + hex.EncodeToString(name[:]) + ".db?vfs=memdb&_pragma=foreign_keys(on)")
+	if err != nil {
+		return nil, fmt.Errorf("error creating sqlite connector: %w", err)
+	}
+	db := sql.OpenDB(connector)
+	if err := sqlite.Init(db); err != nil {
+		return nil, err
+	}
+	return sqlite.New(db), nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServerInMemoryDatabase(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listenAddr := lis.Addr().String()
+	_ = lis.Close()
+
+	defer func(path, pass, http, ext string) {
+		dbPath, dbPass, addr, extAddr = path, pass, http, ext
+	}(dbPath, dbPass, addr, extAddr)
+	dbPath, dbPass, addr, extAddr = inMemoryDB, "", listenAddr, ""
+
+	done := make(chan error, 1)
+	go func() { done <- server() }()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(30 * time.Second); ; {
+		select {
+		case err := <-done:
+			t.Fatalf("server exited: %v", err)
+		default:
+		}
+		if resp, err = http.Get("http://" + listenAddr + "/health"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("health status code is %v", resp.StatusCode)
+	}
+
+	if _, err := os.Stat(inMemoryDB); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("in-memory database was written to disk: %v", err)
+	}
+
+	// The server shuts down gracefully on SIGINT
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("server error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("server did not shut down")
+	}
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+func newTestDeviceCA(t *testing.T, cn string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestDeviceCAExportImport(t *testing.T) {
+	initDB := func(t *testing.T) {
+		t.Helper()
+		state, err := openDatabase(inMemoryDB, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = state.Close() })
+		if err := db.InitDb(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fingerprints := func(t *testing.T) []string {
+		t.Helper()
+		certs, err := db.TrustedDeviceCAs.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fingerprints []string
+		for _, cert := range certs {
+			fingerprints = append(fingerprints, db.CertFingerprint(cert))
+		}
+		return fingerprints
+	}
+	defer func(export, imp string) { exportDeviceCAs, importDeviceCAs = export, imp }(exportDeviceCAs, importDeviceCAs)
+	bundle := filepath.Join(t.TempDir(), "device-cas.pem")
+
+	initDB(t)
+	for _, cn := range []string{"Device CA 1", "Device CA 2", "Device CA 3"} {
+		if _, err := db.TrustedDeviceCAs.Insert(newTestDeviceCA(t, cn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported := fingerprints(t)
+	exportDeviceCAs = bundle
+	if err := doExportDeviceCAs(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Import into a fresh store
+	initDB(t)
+	importDeviceCAs = bundle
+	if err := doImportDeviceCAs(); err != nil {
+		t.Fatal(err)
+	}
+	if imported := fingerprints(t); !slices.Equal(imported, exported) {
+		t.Errorf("imported device CAs %v, want %v", imported, exported)
+	}
+
+	// Importing again is a no-op
+	n, err := importTrustedCAFile("device", db.TrustedDeviceCAs, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("reimport trusted %d new CAs", n)
+	}
+}
@@ -0,0 +1,198 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// finding is a problem reported by -doctor.
+type finding struct {
+	Problem     string
+	Remediation string
+}
+
+// doctorConfig is the server configuration and state inspected by -doctor.
+type doctorConfig struct {
+	Now func() time.Time
+	// OwnerKey looks up stored owner keys by type
+	OwnerKey func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)
+	// DeviceCAs are the trusted device CAs, which device certificate chains
+	// of imported vouchers are verified against
+	DeviceCAs []*x509.Certificate
+	// ExtHost is the host of the external address devices connect to
+	ExtHost string
+	// RvHosts are the IP addresses and DNS names of the stored RV info
+	RvHosts []string
+	// VoucherOwners maps the hex GUID of each stored voucher to the public
+	// key of its current owner
+	VoucherOwners map[string]crypto.PublicKey
+	// UnreadableVouchers maps the hex GUID of each stored voucher that cannot
+	// be parsed to the parse error
+	UnreadableVouchers map[string]error
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// diagnose inspects the configuration for common misconfigurations.
+func diagnose(cfg doctorConfig) []finding {
+	var findings []finding
+
+	var ownerKeys []crypto.PublicKey
+	for _, keyType := range ownerKeyTypes {
+		if key, _, err := cfg.OwnerKey(keyType); err == nil {
+			ownerKeys = append(ownerKeys, key.Public())
+		}
+	}
+	if len(ownerKeys) == 0 {
+		findings = append(findings, finding{
+			Problem:     "No owner key is configured",
+			Remediation: "Start the server once with this database to generate owner keys",
+		})
+	}
+
+	if len(cfg.DeviceCAs) == 0 {
+		findings = append(findings, finding{
+			Problem:     "The device CA pool is empty",
+			Remediation: "Trust the device CA of the manufacturing hosts with -trust-device-ca, generating one with -generate-device-ca if needed; until then vouchers of any device are imported",
+		})
+	}
+	for _, deviceCA := range cfg.DeviceCAs {
+		if cfg.Now().After(deviceCA.NotAfter) {
+			findings = append(findings, finding{
+				Problem:     fmt.Sprintf("Device CA %q expired on %s", deviceCA.Subject.CommonName, deviceCA.NotAfter.Format(time.RFC3339)),
+				Remediation: "Trust the renewed device CA with -trust-device-ca; vouchers of devices certified by the expired CA will fail to import",
+			})
+		}
+	}
+
+	if !isLoopbackHost(cfg.ExtHost) {
+		for _, host := range cfg.RvHosts {
+			if isLoopbackHost(host) {
+				findings = append(findings, finding{
+					Problem:     fmt.Sprintf("RV info points at loopback address %s while the external address %s is not local", host, cfg.ExtHost),
+					Remediation: "Update the RV info with POST /api/v1/rvinfo so devices on other hosts can reach the rendezvous server",
+				})
+			}
+		}
+	}
+
+	if len(ownerKeys) > 0 {
+		var guids []string
+		for guid := range cfg.VoucherOwners {
+			guids = append(guids, guid)
+		}
+		slices.Sort(guids)
+		for _, guid := range guids {
+			owner := cfg.VoucherOwners[guid]
+			if !slices.ContainsFunc(ownerKeys, func(key crypto.PublicKey) bool {
+				return key.(interface{ Equal(crypto.PublicKey) bool }).Equal(owner)
+			}) {
+				findings = append(findings, finding{
+					Problem:     fmt.Sprintf("Voucher %s is not owned by any owner key", guid),
+					Remediation: "Extend the voucher to this server's owner key (see -print-owner-public) and import it again",
+				})
+			}
+		}
+	}
+
+	var unreadable []string
+	for guid := range cfg.UnreadableVouchers {
+		unreadable = append(unreadable, guid)
+	}
+	slices.Sort(unreadable)
+	for _, guid := range unreadable {
+		findings = append(findings, finding{
+			Problem:     fmt.Sprintf("Voucher %s cannot be parsed: %v", guid, cfg.UnreadableVouchers[guid]),
+			Remediation: "Export the voucher again from the manufacturer, delete the stored voucher and import the new export",
+		})
+	}
+
+	return findings
+}
+
+// rvHosts returns the IP addresses and DNS names of RV info.
+func rvHosts(rvInfo [][]protocol.RvInstruction) []string {
+	var hosts []string
+	for _, directive := range rvInfo {
+		for _, instruction := range directive {
+			switch instruction.Variable {
+			case protocol.RVIPAddress:
+				var ip net.IP
+				if err := cbor.Unmarshal(instruction.Value, &ip); err == nil {
+					hosts = append(hosts, ip.String())
+				}
+			case protocol.RVDns:
+				var dns string
+				if err := cbor.Unmarshal(instruction.Value, &dns); err == nil {
+					hosts = append(hosts, dns)
+				}
+			}
+		}
+	}
+	return hosts
+}
+
+func doDoctor(keys *externalKeys, rvInfo [][]protocol.RvInstruction, extHost string) error {
+	vouchers, err := db.FetchVouchers()
+	if err != nil {
+		return fmt.Errorf("error fetching vouchers: %w", err)
+	}
+	deviceCAs, err := db.TrustedDeviceCAs.List()
+	if err != nil {
+		return fmt.Errorf("error fetching trusted device CAs: %w", err)
+	}
+	voucherOwners := make(map[string]crypto.PublicKey)
+	unreadable := make(map[string]error)
+	for _, voucher := range vouchers {
+		guid := hex.EncodeToString(voucher.GUID)
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+			unreadable[guid] = err
+			continue
+		}
+		owner, err := ov.OwnerPublicKey()
+		if err != nil {
+			unreadable[guid] = fmt.Errorf("error parsing owner public key: %w", err)
+			continue
+		}
+		voucherOwners[guid] = owner
+	}
+
+	findings := diagnose(doctorConfig{
+		Now:                time.Now,
+		OwnerKey:           keys.OwnerKey,
+		DeviceCAs:          deviceCAs,
+		ExtHost:            extHost,
+		RvHosts:            rvHosts(rvInfo),
+		VoucherOwners:      voucherOwners,
+		UnreadableVouchers: unreadable,
+	})
+	if len(findings) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+	for _, f := range findings {
+		fmt.Printf("Problem: %s\n  Hint: %s\n", f.Problem, f.Remediation)
+	}
+	return fmt.Errorf("found %d problem(s)", len(findings))
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+func TestDiagnose(t *testing.T) {
+	now := time.Now()
+
+	ownerKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceCAKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newDeviceCA := func(notAfter time.Time) *x509.Certificate {
+		template, err := deviceCATemplate("Test Device CA", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.NotBefore, template.NotAfter = notAfter.Add(-time.Hour), notAfter
+		der, err := x509.CreateCertificate(rand.Reader, template, template, deviceCAKey.Public(), deviceCAKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	keys := func(key crypto.Signer, chain []*x509.Certificate) func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+		return func(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+			if key == nil || keyType != protocol.Secp384r1KeyType {
+				return nil, nil, errors.New("not found")
+			}
+			return key, chain, nil
+		}
+	}
+
+	// healthy returns a configuration without any problems
+	healthy := func() doctorConfig {
+		return doctorConfig{
+			Now:           func() time.Time { return now },
+			OwnerKey:      keys(ownerKey, nil),
+			DeviceCAs:     []*x509.Certificate{newDeviceCA(now.Add(time.Hour))},
+			ExtHost:       "fdo.example.com",
+			RvHosts:       []string{"rv.example.com"},
+			VoucherOwners: map[string]crypto.PublicKey{"aa000000000000000000000000000000": ownerKey.Public()},
+		}
+	}
+
+	if findings := diagnose(healthy()); len(findings) != 0 {
+		t.Fatalf("unexpected findings for a healthy configuration: %+v", findings)
+	}
+
+	for _, test := range []struct {
+		name    string
+		seed    func(*doctorConfig)
+		problem string
+	}{
+		{"no owner key", func(cfg *doctorConfig) { cfg.OwnerKey = keys(nil, nil) }, "No owner key"},
+		{"empty device CA pool", func(cfg *doctorConfig) { cfg.DeviceCAs = nil }, "device CA pool is empty"},
+		{"expired device CA", func(cfg *doctorConfig) {
+			cfg.DeviceCAs = append(cfg.DeviceCAs, newDeviceCA(now.Add(-time.Hour)))
+		}, "expired"},
+		{"loopback RV info", func(cfg *doctorConfig) { cfg.RvHosts = []string{"127.0.0.1"} }, "loopback"},
+		{"voucher not owned", func(cfg *doctorConfig) {
+			cfg.VoucherOwners["bb000000000000000000000000000000"] = otherKey.Public()
+		}, "Voucher bb000000000000000000000000000000"},
+		{"unreadable voucher", func(cfg *doctorConfig) {
+			cfg.UnreadableVouchers = map[string]error{"cc000000000000000000000000000000": errors.New("unexpected EOF")}
+		}, "Voucher cc000000000000000000000000000000 cannot be parsed"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := healthy()
+			test.seed(&cfg)
+			findings := diagnose(cfg)
+			if len(findings) != 1 {
+				t.Fatalf("expected one finding, got %+v", findings)
+			}
+			if !strings.Contains(findings[0].Problem, test.problem) {
+				t.Errorf("finding %q does not mention %q", findings[0].Problem, test.problem)
+			}
+			if findings[0].Remediation == "" {
+				t.Error("finding has no remediation hint")
+			}
+		})
+	}
+
+	t.Run("loopback RV info with local external address", func(t *testing.T) {
+		cfg := healthy()
+		cfg.ExtHost, cfg.RvHosts = "127.0.0.1", []string{"localhost"}
+		if findings := diagnose(cfg); len(findings) != 0 {
+			t.Errorf("unexpected findings: %+v", findings)
+		}
+	})
+}
+
+func TestDoctorTrustedDeviceCAs(t *testing.T) {
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = state.Close() }()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddOwnerKey(protocol.Secp384r1KeyType, ownerKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newExternalKeys(state, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The manufacturer keys of the database are not trusted device CAs
+	if err := doDoctor(keys, nil, "fdo.example.com"); err == nil {
+		t.Fatal("empty trusted device CA store not reported")
+	}
+
+	caKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := deviceCATemplate("Test Device CA", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceCA, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TrustedDeviceCAs.Insert(deviceCA); err != nil {
+		t.Fatal(err)
+	}
+	if err := doDoctor(keys, nil, "fdo.example.com"); err != nil {
+		t.Errorf("trusted device CA not found: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// errDownloadChanged is returned when reading a file sent by fdo.download that
+// was modified or replaced on disk after onboarding of a device started.
+var errDownloadChanged = errors.New("download file changed during onboarding")
+
+// downloadFile is a file sent by fdo.download. The file may be read across
+// many TO2 messages, so reads fail once it changes on disk rather than sending
+// a mix of old and new content.
+type downloadFile struct {
+	*os.File
+	name   string
+	opened os.FileInfo
+}
+
+func openDownloadFile(name string) (*downloadFile, error) {
+	f, err := os.Open(filepath.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &downloadFile{File: f, name: name, opened: info}, nil
+}
+
+func (f *downloadFile) Read(p []byte) (int, error) {
+	if err := f.checkUnchanged(); err != nil {
+		slog.Error("Failing fdo.download", "name", f.name, "error", err)
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+// checkUnchanged detects both modification of the open file and replacement
+// of the file at its path.
+func (f *downloadFile) checkUnchanged() error {
+	current, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+	if current.Size() != f.opened.Size() || !current.ModTime().Equal(f.opened.ModTime()) {
+		return fmt.Errorf("%w: %s was modified", errDownloadChanged, f.name)
+	}
+	atPath, err := os.Stat(filepath.Clean(f.name))
+	if err != nil || !os.SameFile(atPath, f.opened) {
+		return fmt.Errorf("%w: %s was replaced or removed", errDownloadChanged, f.name)
+	}
+	return nil
+}
+
+// replacementGUID returns the GUID a device is given at the end of the TO2
+// session in ctx. It is set when the TO2 server is created.
+var replacementGUID func(context.Context) (protocol.GUID, error)
+
+// isDownloadTemplate reports whether a -download path is resolved per device.
+func isDownloadTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// downloadPathData is the data of -download path templates. GUIDs are
+// formatted as lowercase hex.
+type downloadPathData struct {
+	ctx  context.Context
+	GUID string
+}
+
+func (d downloadPathData) ReplacementGUID() (string, error) {
+	if replacementGUID == nil {
+		return "", errors.New("replacement GUID is not available")
+	}
+	guid, err := replacementGUID(d.ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting replacement GUID: %w", err)
+	}
+	return hex.EncodeToString(guid[:]), nil
+}
+
+// resolveDownloadPath returns the file to send a device for a -download path,
+// which may use {{.GUID}} and {{.ReplacementGUID}} to name a file per device.
+func resolveDownloadPath(ctx context.Context, guid protocol.GUID, name string) (string, error) {
+	if !isDownloadTemplate(name) {
+		return name, nil
+	}
+	tmpl, err := template.New("download").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return "", err
+	}
+	var path strings.Builder
+	if err := tmpl.Execute(&path, downloadPathData{ctx: ctx, GUID: hex.EncodeToString(guid[:])}); err != nil {
+		return "", err
+	}
+	return path.String(), nil
+}
+
+// failedDownload fails TO2 for a device whose fdo.download file cannot be
+// opened, instead of sending it a partial configuration.
+type failedDownload struct {
+	name string
+	err  error
+}
+
+func (d failedDownload) HandleInfo(context.Context, string, io.Reader) error {
+	return fmt.Errorf("error opening %q for download FSIM: %w", d.name, d.err)
+}
+
+func (d failedDownload) ProduceInfo(context.Context, *serviceinfo.Producer) (bool, bool, error) {
+	return false, false, fmt.Errorf("error opening %q for download FSIM: %w", d.name, d.err)
+}
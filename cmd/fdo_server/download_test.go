@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestDownloadFileChanged(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		change func(t *testing.T, path string)
+	}{
+		{"modified", func(t *testing.T, path string) {
+			f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if _, err := f.WriteString("new content"); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"replaced", func(t *testing.T, path string) {
+			tmp := path + ".new"
+			if err := os.WriteFile(tmp, []byte("0123456789"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Rename(tmp, path); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"removed", func(t *testing.T, path string) {
+			if err := os.Remove(path); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "firmware.bin")
+			if err := os.WriteFile(path, []byte("0123456789"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			f, err := openDownloadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				t.Fatalf("error reading unchanged file: %v", err)
+			}
+
+			test.change(t, path)
+
+			if _, err := f.Read(buf); !errors.Is(err, errDownloadChanged) {
+				t.Errorf("got error %v, want %v", err, errDownloadChanged)
+			}
+		})
+	}
+}
+
+func TestDownloadPerDevice(t *testing.T) {
+	defer func() { downloads, replacementGUID = nil, nil }()
+
+	dir := t.TempDir()
+	devices := []protocol.GUID{{0xd0, 1}, {0xd0, 2}}
+	for _, guid := range devices {
+		name := filepath.Join(dir, hex.EncodeToString(guid[:])+".yaml")
+		if err := os.WriteFile(name, []byte("config for "+hex.EncodeToString(guid[:])), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// downloaded returns the contents sent to a device and whether onboarding
+	// of the device fails
+	downloaded := func(t *testing.T, guid protocol.GUID) (string, bool) {
+		t.Helper()
+		var contents string
+		var failed bool
+		for name, module := range ownerModules(context.Background(), guid, "test-device", nil, serviceinfo.Devmod{}, []string{"devmod", "fdo.download"}) {
+			if name != "fdo.download" {
+				t.Fatalf("unexpected module %s", name)
+			}
+			download, ok := module.(*fsim.DownloadContents[*downloadFile])
+			if !ok {
+				_, _, err := module.ProduceInfo(context.Background(), &serviceinfo.Producer{})
+				failed = err != nil
+				continue
+			}
+			data, err := io.ReadAll(download.Contents)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents = string(data)
+		}
+		return contents, failed
+	}
+
+	t.Run("GUID", func(t *testing.T) {
+		downloads = stringList{filepath.Join(dir, "{{.GUID}}.yaml")}
+		for _, guid := range devices {
+			contents, failed := downloaded(t, guid)
+			if failed {
+				t.Fatalf("onboarding of %x failed", guid[:])
+			}
+			if want := "config for " + hex.EncodeToString(guid[:]); contents != want {
+				t.Errorf("device %x got %q, want %q", guid[:], contents, want)
+			}
+		}
+	})
+
+	t.Run("replacement GUID", func(t *testing.T) {
+		downloads = stringList{filepath.Join(dir, "{{.ReplacementGUID}}.yaml")}
+		replacementGUID = func(context.Context) (protocol.GUID, error) { return devices[1], nil }
+		contents, failed := downloaded(t, devices[0])
+		if failed {
+			t.Fatal("onboarding failed")
+		}
+		if want := "config for " + hex.EncodeToString(devices[1][:]); contents != want {
+			t.Errorf("got %q, want %q", contents, want)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		downloads = stringList{filepath.Join(dir, "{{.GUID}}.yaml")}
+		if _, failed := downloaded(t, protocol.GUID{0xd0, 3}); !failed {
+			t.Error("onboarding of a device without a config file did not fail")
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/signer"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// externalKeys serves the owner and manufacturer keys opened from the key
+// URIs of -owner-key and -manufacturing-key, and keys of other types from the
+// database. Voucher extension, TO0 and TO2 sign with the keys it returns.
+type externalKeys struct {
+	*sqlite.DB
+	owner map[protocol.KeyType]crypto.Signer
+	mfg   map[protocol.KeyType]externalMfgKey
+}
+
+type externalMfgKey struct {
+	key   crypto.Signer
+	chain []*x509.Certificate
+}
+
+// newExternalKeys opens the keys of -owner-key and -manufacturing-key. Each
+// manufacturer key is certified by its certificate chain of
+// -manufacturing-cert or else by a self-signed certificate, like the
+// manufacturer keys generated in the database.
+func newExternalKeys(state *sqlite.DB, ownerURIs, mfgURIs, mfgCertPaths []string) (*externalKeys, error) {
+	owner, err := openKeyURIs(ownerURIs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner key: %w", err)
+	}
+	mfgSigners, err := openKeyURIs(mfgURIs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manufacturing key: %w", err)
+	}
+	chains, err := readCertChains(mfgCertPaths)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manufacturing certificate: %w", err)
+	}
+	mfg := make(map[protocol.KeyType]externalMfgKey, len(mfgSigners))
+	for keyType, key := range mfgSigners {
+		chain, ok := chains[keyType]
+		if ok {
+			if pub, _ := chain[0].PublicKey.(interface{ Equal(crypto.PublicKey) bool }); pub == nil || !pub.Equal(key.Public()) {
+				return nil, fmt.Errorf("manufacturing certificate of %s does not certify its manufacturing key", keyType)
+			}
+		} else {
+			// Devices initialized after a restart get another certificate
+			slog.Warn("Manufacturing key has no -manufacturing-cert, certifying it with a new self-signed certificate", "type", keyType)
+			if chain, err = selfSignedCA(key); err != nil {
+				return nil, fmt.Errorf("error certifying manufacturing key %s: %w", keyType, err)
+			}
+		}
+		mfg[keyType] = externalMfgKey{key: key, chain: chain}
+	}
+	for keyType := range chains {
+		if _, ok := mfgSigners[keyType]; !ok {
+			return nil, fmt.Errorf("manufacturing certificate of %s has no -manufacturing-key", keyType)
+		}
+	}
+	return &externalKeys{DB: state, owner: owner, mfg: mfg}, nil
+}
+
+// OwnerKey returns the owner key of keyType opened from its key URI, or else
+// the one stored in the database.
+func (k *externalKeys) OwnerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	if key, ok := k.owner[keyType]; ok {
+		return key, nil, nil
+	}
+	return k.DB.OwnerKey(keyType)
+}
+
+// ManufacturerKey returns the manufacturer key of keyType opened from its key
+// URI, or else the one stored in the database.
+func (k *externalKeys) ManufacturerKey(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+	if key, ok := k.mfg[keyType]; ok {
+		return key.key, key.chain, nil
+	}
+	return k.DB.ManufacturerKey(keyType)
+}
+
+// openKeyURIs opens the keys of type=uri values, where type is a key type
+// name such as SECP384R1.
+func openKeyURIs(values []string) (map[protocol.KeyType]crypto.Signer, error) {
+	keys := make(map[protocol.KeyType]crypto.Signer)
+	for _, value := range values {
+		typeName, uri, ok := strings.Cut(value, "=")
+		if !ok || uri == "" {
+			return nil, fmt.Errorf("%q must be type=uri", value)
+		}
+		keyType, err := protocol.ParseKeyType(typeName)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := keys[keyType]; dup {
+			return nil, fmt.Errorf("key type %s is given twice", keyType)
+		}
+		key, err := signer.Open(uri)
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeyMatchesType(key.Public(), keyType) {
+			return nil, fmt.Errorf("key %s is not a %s key", uri, keyType)
+		}
+		keys[keyType] = key
+	}
+	return keys, nil
+}
+
+// readCertChains reads the PEM certificate chains of type=path values, where
+// type is a key type name such as SECP384R1.
+func readCertChains(values []string) (map[protocol.KeyType][]*x509.Certificate, error) {
+	chains := make(map[protocol.KeyType][]*x509.Certificate)
+	for _, value := range values {
+		typeName, path, ok := strings.Cut(value, "=")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("%q must be type=path", value)
+		}
+		keyType, err := protocol.ParseKeyType(typeName)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := chains[keyType]; dup {
+			return nil, fmt.Errorf("key type %s is given twice", keyType)
+		}
+		data, err := os.ReadFile(filepath.Clean(path))
+		if err != nil {
+			return nil, err
+		}
+		var chain []*x509.Certificate
+		for blk, rest := pem.Decode(data); blk != nil; blk, rest = pem.Decode(rest) {
+			if blk.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(blk.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing certificate %s: %w", path, err)
+			}
+			chain = append(chain, cert)
+		}
+		if len(chain) == 0 {
+			return nil, fmt.Errorf("no certificate found in %s", path)
+		}
+		chains[keyType] = chain
+	}
+	return chains, nil
+}
+
+// publicKeyMatchesType reports whether a key can be used as a key of keyType.
+func publicKeyMatchesType(pub crypto.PublicKey, keyType protocol.KeyType) bool {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		return (keyType == protocol.Secp256r1KeyType && pub.Curve == elliptic.P256()) ||
+			(keyType == protocol.Secp384r1KeyType && pub.Curve == elliptic.P384())
+	case *rsa.PublicKey:
+		switch keyType {
+		case protocol.Rsa2048RestrKeyType:
+			return pub.N.BitLen() == 2048
+		case protocol.RsaPkcsKeyType, protocol.RsaPssKeyType:
+			return pub.N.BitLen() >= 2048
+		}
+	}
+	return false
+}
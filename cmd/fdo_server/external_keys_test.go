@@ -0,0 +1,110 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestExternalKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeKey := func(name string, curve elliptic.Curve) (*ecdsa.PrivateKey, string) {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return key, path
+	}
+	ownerKey, ownerPath := writeKey("owner.key", elliptic.P384())
+	mfgKey, mfgPath := writeKey("mfg.key", elliptic.P256())
+
+	keys, err := newExternalKeys(nil, []string{"SECP384R1=" + ownerPath}, []string{"secp256r1=file://" + mfgPath}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _, err := keys.OwnerKey(protocol.Secp384r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ownerKey.PublicKey.Equal(owner.Public()) {
+		t.Error("owner key is not the key of the key URI")
+	}
+	mfg, chain, err := keys.ManufacturerKey(protocol.Secp256r1KeyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mfgKey.PublicKey.Equal(mfg.Public()) {
+		t.Error("manufacturer key is not the key of the key URI")
+	}
+	if len(chain) != 1 || !mfgKey.PublicKey.Equal(chain[0].PublicKey) {
+		t.Error("manufacturer key chain does not certify the key")
+	}
+
+	// A manufacturing certificate is used in place of a self-signed one, so
+	// that the chain is the same across restarts
+	writeCert := func(name string, key *ecdsa.PrivateKey) ([]*x509.Certificate, string) {
+		chain, err := selfSignedCA(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: chain[0].Raw}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return chain, path
+	}
+	mfgChain, mfgCertPath := writeCert("mfg.crt", mfgKey)
+	certified, err := newExternalKeys(nil, nil, []string{"SECP256R1=" + mfgPath}, []string{"SECP256R1=" + mfgCertPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, chain, err := certified.ManufacturerKey(protocol.Secp256r1KeyType); err != nil || len(chain) != 1 || !chain[0].Equal(mfgChain[0]) {
+		t.Errorf("manufacturer key chain is not the manufacturing certificate: %v", err)
+	}
+	otherKey, _ := writeKey("other.key", elliptic.P256())
+	_, otherCertPath := writeCert("other.crt", otherKey)
+	for _, certs := range [][]string{
+		{"SECP256R1=" + otherCertPath},
+		{"SECP384R1=" + mfgCertPath},
+		{"SECP256R1=" + ownerPath},
+		{"SECP256R1=" + mfgCertPath, "SECP256R1=" + mfgCertPath},
+		{mfgCertPath},
+	} {
+		if _, err := newExternalKeys(nil, nil, []string{"SECP256R1=" + mfgPath}, certs); err == nil {
+			t.Errorf("expected error with manufacturing certificates %q", certs)
+		}
+	}
+
+	for _, values := range [][]string{
+		{ownerPath},
+		{"SECP384R1="},
+		{"ED25519=" + ownerPath},
+		{"SECP256R1=" + ownerPath},
+		{"RSAPKCS=" + ownerPath},
+		{"SECP384R1=" + filepath.Join(dir, "missing.key")},
+		{"SECP384R1=pkcs11:token=fdo;object=owner"},
+		{"SECP384R1=" + ownerPath, "SECP384R1=" + ownerPath},
+	} {
+		if _, err := openKeyURIs(values); err == nil {
+			t.Errorf("expected error opening %q", values)
+		}
+	}
+}
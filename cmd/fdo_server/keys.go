@@ -0,0 +1,299 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// generateKeyTypes maps the key type names accepted by -generate-key to the
+// FDO key type of the generated key.
+var generateKeyTypes = map[string]protocol.KeyType{
+	"ec256":   protocol.Secp256r1KeyType,
+	"ec384":   protocol.Secp384r1KeyType,
+	"rsa2048": protocol.Rsa2048RestrKeyType,
+	"rsa3072": protocol.RsaPkcsKeyType,
+}
+
+func generateKeyTypeNames() string {
+	var names []string
+	for name := range generateKeyTypes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// generatePrivateKey generates a key for one of the generateKeyTypes names.
+func generatePrivateKey(name string) (crypto.Signer, error) {
+	switch name {
+	case "ec256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa3072":
+		return rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q: must be one of %s", name, generateKeyTypeNames())
+	}
+}
+
+// getPrivateKeyType returns the FDO key type the server uses for a private key.
+// RSA 3072 keys are reported as RSAPKCS, although they may also be used as
+// RSAPSS keys.
+func getPrivateKeyType(key crypto.PrivateKey) (protocol.KeyType, error) {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return protocol.Secp256r1KeyType, nil
+		case elliptic.P384():
+			return protocol.Secp384r1KeyType, nil
+		}
+		return 0, fmt.Errorf("unsupported EC curve: %s", key.Curve.Params().Name)
+	case *rsa.PrivateKey:
+		switch key.N.BitLen() {
+		case 2048:
+			return protocol.Rsa2048RestrKeyType, nil
+		case 3072:
+			return protocol.RsaPkcsKeyType, nil
+		}
+		return 0, fmt.Errorf("unsupported RSA key size: %d", key.N.BitLen())
+	default:
+		return 0, fmt.Errorf("unsupported private key type: %T", key)
+	}
+}
+
+// selfSignedCA returns the certificate chain of a manufacturer key: a
+// self-signed CA certificate of the key.
+func selfSignedCA(key crypto.Signer) ([]*x509.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(30 * 365 * 24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// writePEMFile writes PEM blocks to path, or to stdout if path is empty.
+func writePEMFile(path string, perm os.FileMode, blocks ...*pem.Block) error {
+	var out io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.OpenFile(filepath.Clean(path), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+	for _, blk := range blocks {
+		if err := pem.Encode(out, blk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func doGenerateKey() error {
+	key, err := generatePrivateKey(generateKey)
+	if err != nil {
+		return err
+	}
+	keyType, err := getPrivateKeyType(key)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writePEMFile(outPath, 0o600, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return fmt.Errorf("error writing private key: %w", err)
+	}
+
+	if outCertPath != "" {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "FDO " + keyType.String()},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(30 * 365 * 24 * time.Hour),
+			BasicConstraintsValid: true,
+		}
+		if err := writeSelfSignedCert(outCertPath, template, key); err != nil {
+			return err
+		}
+	}
+
+	slog.Debug("Generated key", "type", keyType.String(), "out", outPath)
+	return nil
+}
+
+func writeSelfSignedCert(path string, template *x509.Certificate, key crypto.Signer) error {
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return err
+	}
+	if err := writePEMFile(path, 0o644, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+		return fmt.Errorf("error writing certificate: %w", err)
+	}
+	return nil
+}
+
+// deviceCATemplate returns the certificate template of a self-signed device
+// CA. The same CA key and certificate must be shared by every manufacturing
+// and owner host of a deployment for device certificate chains to verify.
+func deviceCATemplate(subject string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: subject},
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil
+}
+
+func doGenerateDeviceCA() error {
+	if outCertPath == "" {
+		return errors.New("-out-cert is required to generate a device CA")
+	}
+	if deviceCAValidity <= 0 {
+		return errors.New("-device-ca-validity must be positive")
+	}
+
+	key, err := generatePrivateKey(generateDeviceCA)
+	if err != nil {
+		return err
+	}
+	keyType, err := getPrivateKeyType(key)
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := writePEMFile(outPath, 0o600, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return fmt.Errorf("error writing device CA key: %w", err)
+	}
+
+	template, err := deviceCATemplate(deviceCASubject, deviceCAValidity)
+	if err != nil {
+		return err
+	}
+	if err := writeSelfSignedCert(outCertPath, template, key); err != nil {
+		return err
+	}
+
+	slog.Debug("Generated device CA", "type", keyType.String(), "subject", deviceCASubject, "cert", outCertPath)
+	return nil
+}
+
+// ownerKeyTypes are the key types searched for a stored owner key matching a
+// public key.
+var ownerKeyTypes = []protocol.KeyType{
+	protocol.Secp256r1KeyType,
+	protocol.Secp384r1KeyType,
+	protocol.Rsa2048RestrKeyType,
+	protocol.RsaPkcsKeyType,
+	protocol.RsaPssKeyType,
+}
+
+// loadPublicKeyPEM reads a public key from a PEM encoded PKIX public key or
+// x.509 certificate file.
+func loadPublicKeyPEM(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return nil, fmt.Errorf("invalid PEM file: %s", path)
+	}
+	switch blk.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(blk.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	default:
+		return nil, fmt.Errorf("expected PEM block of public key or certificate type, found %s", blk.Type)
+	}
+}
+
+// matchOwnerKey returns the type of the owner key that has the given public
+// key, or an error if no owner key matches.
+func matchOwnerKey(pub crypto.PublicKey, ownerKey func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error)) (protocol.KeyType, error) {
+	for _, keyType := range ownerKeyTypes {
+		key, _, err := ownerKey(keyType)
+		if err != nil {
+			continue
+		}
+		if key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+			return keyType, nil
+		}
+	}
+	return 0, errors.New("public key does not match any owner key")
+}
+
+func doCheckOwnerKey(keys *externalKeys) error {
+	pub, err := loadPublicKeyPEM(checkOwnerKey)
+	if err != nil {
+		return fmt.Errorf("error reading owner key file: %w", err)
+	}
+	fingerprint, err := utils.PublicKeyFingerprint(pub)
+	if err != nil {
+		return err
+	}
+	keyType, err := matchOwnerKey(pub, keys.OwnerKey)
+	if err != nil {
+		return fmt.Errorf("%s (SHA-256 fingerprint %s): %w", checkOwnerKey, fingerprint, err)
+	}
+	slog.Info("Owner key matches", "path", checkOwnerKey, "type", keyType.String(), "fingerprint", fingerprint)
+	return nil
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestGenerateKey(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { generateKey, outPath, outCertPath = "", "", "" }()
+
+	for name, wantType := range generateKeyTypes {
+		t.Run(name, func(t *testing.T) {
+			generateKey = name
+			outPath = filepath.Join(dir, name+".key")
+			outCertPath = filepath.Join(dir, name+".crt")
+			if err := doGenerateKey(); err != nil {
+				t.Fatal(err)
+			}
+
+			keyPEM, err := os.ReadFile(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blk, _ := pem.Decode(keyPEM)
+			if blk == nil || blk.Type != "PRIVATE KEY" {
+				t.Fatalf("%s does not contain a PKCS#8 PEM private key", outPath)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyType, err := getPrivateKeyType(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyType != wantType {
+				t.Errorf("key type is %s, want %s", keyType, wantType)
+			}
+
+			certPEM, err := os.ReadFile(outCertPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			blk, _ = pem.Decode(certPEM)
+			if blk == nil {
+				t.Fatalf("%s does not contain a PEM certificate", outCertPath)
+			}
+			cert, err := x509.ParseCertificate(blk.Bytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+				t.Errorf("certificate is not self-signed by the generated key: %v", err)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		generateKey = "ec521"
+		outPath = filepath.Join(dir, "unsupported.key")
+		if err := doGenerateKey(); err == nil {
+			t.Error("expected an error for an unsupported key type")
+		}
+	})
+}
+
+func TestGenerateDeviceCA(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { generateDeviceCA, outPath, outCertPath = "", "", "" }()
+
+	generateDeviceCA = "ec384"
+	deviceCASubject = "Test Device CA"
+	deviceCAValidity = 24 * time.Hour
+	outPath = filepath.Join(dir, "device-ca.key")
+	outCertPath = filepath.Join(dir, "device-ca.crt")
+	if err := doGenerateDeviceCA(); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, _ := pem.Decode(keyPEM)
+	if blk == nil {
+		t.Fatalf("%s does not contain a PEM private key", outPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyType, err := getPrivateKeyType(key); err != nil || keyType != protocol.Secp384r1KeyType {
+		t.Errorf("key type is %s (%v), want %s", keyType, err, protocol.Secp384r1KeyType)
+	}
+
+	certPEM, err := os.ReadFile(outCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, _ = pem.Decode(certPEM)
+	if blk == nil {
+		t.Fatalf("%s does not contain a PEM certificate", outCertPath)
+	}
+	cert, err := x509.ParseCertificate(blk.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.IsCA {
+		t.Error("certificate is not a CA")
+	}
+	if cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		t.Error("certificate key usage does not include certificate signing")
+	}
+	if cert.Subject.CommonName != "Test Device CA" {
+		t.Errorf("subject is %q", cert.Subject.CommonName)
+	}
+	if !key.(interface{ Public() crypto.PublicKey }).Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(cert.PublicKey) {
+		t.Error("certificate does not match the generated key")
+	}
+}
+
+func TestMatchOwnerKey(t *testing.T) {
+	dir := t.TempDir()
+	defer func() { generateKey, outPath, outCertPath = "", "", "" }()
+
+	// Generate an owner key and a certificate for it, as given to the
+	// manufacturing server
+	generateKey = "ec384"
+	outPath = filepath.Join(dir, "owner.key")
+	outCertPath = filepath.Join(dir, "owner.crt")
+	if err := doGenerateKey(); err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blk, _ := pem.Decode(keyPEM)
+	parsed, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey := parsed.(crypto.Signer)
+
+	otherKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKeys := func(key crypto.Signer) func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+		return func(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+			if keyType != protocol.Secp384r1KeyType {
+				return nil, nil, errors.New("not found")
+			}
+			return key, nil, nil
+		}
+	}
+
+	certPub, err := loadPublicKeyPEM(outCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("matching", func(t *testing.T) {
+		keyType, err := matchOwnerKey(certPub, ownerKeys(ownerKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keyType != protocol.Secp384r1KeyType {
+			t.Errorf("matched key type %s", keyType)
+		}
+		certFingerprint, err := utils.PublicKeyFingerprint(certPub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFingerprint, err := utils.PublicKeyFingerprint(ownerKey.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certFingerprint != keyFingerprint {
+			t.Errorf("certificate fingerprint %s does not equal key fingerprint %s", certFingerprint, keyFingerprint)
+		}
+	})
+
+	t.Run("mismatching", func(t *testing.T) {
+		if _, err := matchOwnerKey(certPub, ownerKeys(otherKey)); err == nil {
+			t.Error("expected a mismatch error")
+		}
+		certFingerprint, _ := utils.PublicKeyFingerprint(certPub)
+		otherFingerprint, _ := utils.PublicKeyFingerprint(otherKey.Public())
+		if certFingerprint == otherFingerprint {
+			t.Error("different keys have the same fingerprint")
+		}
+	})
+}
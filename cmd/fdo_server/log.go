@@ -6,14 +6,33 @@ package main
 import (
 	"log/slog"
 	"os"
+	"time"
 
+	"github.com/fido-device-onboard/go-fdo-server/internal/logging"
 	"hermannm.dev/devlog"
 )
 
 var level slog.LevelVar
 
+var consoleHandler = devlog.NewHandler(os.Stdout, &devlog.Options{
+	Level: &level,
+})
+
 func init() {
-	slog.SetDefault(slog.New(devlog.NewHandler(os.Stdout, &devlog.Options{
-		Level: &level,
-	})))
+	slog.SetDefault(slog.New(consoleHandler))
+}
+
+// setupJSONLogs writes logs as JSON to a rotating file, in addition to the
+// console unless only is set. The returned function closes the file.
+func setupJSONLogs(path string, maxSize int64, maxAge time.Duration, maxBackups int, only bool) (func() error, error) {
+	file, err := logging.OpenRotatingFile(path, maxSize, maxAge, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	var handler slog.Handler = slog.NewJSONHandler(file, &slog.HandlerOptions{Level: &level})
+	if !only {
+		handler = logging.FanoutHandler{consoleHandler, handler}
+	}
+	slog.SetDefault(slog.New(handler))
+	return file.Close, nil
 }
@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/template"
 )
 
 var flags = flag.NewFlagSet("root", flag.ContinueOnError)
@@ -129,10 +130,117 @@ func validateFlags() error {
 		}
 	}
 
+	if mgmtAddr != "" {
+		host, port, err := net.SplitHostPort(mgmtAddr)
+		if err != nil {
+			return fmt.Errorf("invalid management address: %s", mgmtAddr)
+		}
+		if host != "" && net.ParseIP(host) == nil && !isValidHostname(host) {
+			return fmt.Errorf("invalid management hostname: %s", host)
+		}
+		if !isValidPort(port) {
+			return fmt.Errorf("invalid management port: %s", port)
+		}
+		if mgmtAddr == addr {
+			return fmt.Errorf("management address must differ from the listen address: %s", mgmtAddr)
+		}
+	}
+
+	if plainAddr != "" {
+		if !insecureTLS {
+			return fmt.Errorf("-plain-http requires -insecure-tls")
+		}
+		host, port, err := net.SplitHostPort(plainAddr)
+		if err != nil {
+			return fmt.Errorf("invalid plain HTTP address: %s", plainAddr)
+		}
+		if host != "" && net.ParseIP(host) == nil && !isValidHostname(host) {
+			return fmt.Errorf("invalid plain HTTP hostname: %s", host)
+		}
+		if !isValidPort(port) {
+			return fmt.Errorf("invalid plain HTTP port: %s", port)
+		}
+		if plainAddr == addr || plainAddr == mgmtAddr {
+			return fmt.Errorf("plain HTTP address must differ from the listen and management addresses: %s", plainAddr)
+		}
+	}
+
+	if jsonLogsFile != "" && !isValidPath(jsonLogsFile) {
+		return fmt.Errorf("invalid JSON log file path: %s", jsonLogsFile)
+	}
+
+	if jsonLogsOnly && jsonLogsFile == "" {
+		return fmt.Errorf("-json-logs-only requires -json-logs-to-file")
+	}
+
+	switch ownerKeyCheck {
+	case ownerKeyCheckOff, ownerKeyCheckWarn, ownerKeyCheckStrict:
+	default:
+		return fmt.Errorf("invalid owner key check: %s", ownerKeyCheck)
+	}
+
+	if ownerKeySample <= 0 {
+		return fmt.Errorf("invalid owner key check sample size: %d", ownerKeySample)
+	}
+
+	if maxSessions < 0 {
+		return fmt.Errorf("invalid maximum number of sessions: %d", maxSessions)
+	}
+
+	if maxSessionsPolicy != sessionCapReject && maxSessionsPolicy != sessionCapEvictOldest {
+		return fmt.Errorf("invalid session cap policy: %s", maxSessionsPolicy)
+	}
+
+	if maxResaleDepth < 0 {
+		return fmt.Errorf("invalid maximum resale depth: %d", maxResaleDepth)
+	}
+
+	for _, rawURL := range webhookURLs {
+		if u, err := url.ParseRequestURI(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid webhook URL: %s", rawURL)
+		}
+	}
+	if webhookSecret != "" && !isValidPath(webhookSecret) {
+		return fmt.Errorf("invalid webhook secret path: %s", webhookSecret)
+	}
+	if webhookAttempts < 1 {
+		return fmt.Errorf("invalid webhook max attempts: %d", webhookAttempts)
+	}
+	if webhookBackoff < 0 || webhookTimeout <= 0 {
+		return fmt.Errorf("invalid webhook retry backoff or timeout: %s, %s", webhookBackoff, webhookTimeout)
+	}
+
+	if len(apiClientCAs) > 0 && !insecureTLS {
+		return fmt.Errorf("api-client-ca depends on insecure-tls flag being set")
+	}
+
+	if ntpMaxSkew < 0 {
+		return fmt.Errorf("invalid NTP clock skew: %s", ntpMaxSkew)
+	}
+
 	if resaleKey != "" && (!isValidPath(resaleKey) || !fileExists(resaleKey)) {
 		return fmt.Errorf("invalid resale key path: %s", resaleKey)
 	}
 
+	if caSyncURL != "" {
+		if u, err := url.ParseRequestURI(caSyncURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid device CA sync URL: %s", caSyncURL)
+		}
+		if caSyncInterval <= 0 {
+			return fmt.Errorf("invalid device CA sync interval: %s", caSyncInterval)
+		}
+	}
+
+	if voucherMaxAge < 0 {
+		return fmt.Errorf("invalid voucher max age: %s", voucherMaxAge)
+	}
+	if voucherMaxPerInfo < 0 {
+		return fmt.Errorf("invalid voucher max per device info: %d", voucherMaxPerInfo)
+	}
+	if (voucherMaxAge > 0 || voucherMaxPerInfo > 0) && retentionInterval <= 0 {
+		return fmt.Errorf("invalid voucher retention interval: %s", retentionInterval)
+	}
+
 	if serverCertPath != "" && !isValidPath(serverCertPath) {
 		return fmt.Errorf("invalid server certificate path: %s", serverCertPath)
 	}
@@ -141,30 +249,104 @@ func validateFlags() error {
 		return fmt.Errorf("invalid server key path: %s", serverKeyPath)
 	}
 
+	if (serverCertPath == "") != (serverKeyPath == "") {
+		return fmt.Errorf("server certificate and key paths must be given together")
+	}
+
+	if outPath != "" && !isValidPath(outPath) {
+		return fmt.Errorf("invalid output path: %s", outPath)
+	}
+
+	if outCertPath != "" && !isValidPath(outCertPath) {
+		return fmt.Errorf("invalid output certificate path: %s", outCertPath)
+	}
+
+	if checkOwnerKey != "" && !isValidPath(checkOwnerKey) {
+		return fmt.Errorf("invalid owner key path: %s", checkOwnerKey)
+	}
+
+	for _, path := range trustedMfgCAs {
+		if !isValidPath(path) {
+			return fmt.Errorf("invalid manufacturer CA path: %s", path)
+		}
+	}
+
+	for _, path := range trustedDeviceCAs {
+		if !isValidPath(path) {
+			return fmt.Errorf("invalid device CA path: %s", path)
+		}
+	}
+
+	if exportDeviceCAs != "" && !isValidPath(exportDeviceCAs) {
+		return fmt.Errorf("invalid device CA export path: %s", exportDeviceCAs)
+	}
+
+	if importDeviceCAs != "" && (!isValidPath(importDeviceCAs) || !fileExists(importDeviceCAs)) {
+		return fmt.Errorf("invalid device CA import path: %s", importDeviceCAs)
+	}
+
 	if importVoucher != "" && !isValidPath(importVoucher) {
 		return fmt.Errorf("invalid import voucher path: %s", importVoucher)
 	}
 
+	if voucherCBORDir != "" && !isValidPath(voucherCBORDir) {
+		return fmt.Errorf("invalid voucher CBOR directory path: %s", voucherCBORDir)
+	}
+
+	if deviceSecretDir != "" && !isValidPath(deviceSecretDir) {
+		return fmt.Errorf("invalid device secret directory path: %s", deviceSecretDir)
+	}
+
 	if uploadDir != "" && (!isValidPath(uploadDir)) {
 		return fmt.Errorf("invalid upload directory path: %s", uploadDir)
 	}
 
-	for _, path := range downloads {
+	if err := validateDownloads(downloads); err != nil {
+		return err
+	}
+
+	if err := validateWgets(wgets); err != nil {
+		return err
+	}
+
+	if servicePlansPath != "" && (!isValidPath(servicePlansPath) || !fileExists(servicePlansPath)) {
+		return fmt.Errorf("invalid service info plans path: %s", servicePlansPath)
+	}
+
+	return nil
+}
+
+// validateDownloads checks the files of fdo.download, either of -download or
+// of a service info plan.
+func validateDownloads(paths []string) error {
+	for _, path := range paths {
 		if !isValidPath(path) {
 			return fmt.Errorf("invalid download path: %s", path)
 		}
 
+		// Files selected per device are checked when a device onboards
+		if isDownloadTemplate(path) {
+			if _, err := template.New("download").Parse(path); err != nil {
+				return fmt.Errorf("invalid download path template: %w", err)
+			}
+			continue
+		}
+
 		if !fileExists(path) {
 			return fmt.Errorf("file doesn't exist: %s", path)
 		}
 	}
+	return nil
+}
 
-	for _, path := range wgets {
+// validateWgets checks the URLs of fdo.wget, either of -wget or of a service
+// info plan.
+func validateWgets(urls []string) error {
+	for _, path := range urls {
 		if _, err := url.ParseRequestURI(path); err != nil {
 			return fmt.Errorf("invalid wget URL: %s", path)
 		}
 	}
-
 	return nil
 }
 
@@ -0,0 +1,326 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	ownersvi "github.com/fido-device-onboard/go-fdo-server/internal/serviceinfo"
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func init() {
+	ownersvi.Register("fdo.download", downloadModules)
+	ownersvi.Register("fdo.upload", uploadModules)
+	ownersvi.Register("fdo.wget", wgetModules)
+	ownersvi.Register("fdo.command", commandModules)
+	ownersvi.Register("fdo_sys", sysModules)
+}
+
+// downloadModules sends each download file of the service info plan of the
+// device. A file that cannot be opened fails TO2.
+func downloadModules(ctx context.Context, device ownersvi.Device, _ string) iter.Seq[serviceinfo.OwnerModule] {
+	return func(yield func(serviceinfo.OwnerModule) bool) {
+		for _, name := range servicePlanFromContext(ctx).Downloads {
+			file, err := resolveDownloadPath(ctx, device.GUID, name)
+			if err != nil {
+				slog.Error("Failing fdo.download", "guid", device.GUID, "name", name, "error", err)
+				yield(failedDownload{name: name, err: err})
+				return
+			}
+			f, err := openDownloadFile(file)
+			if err != nil {
+				slog.Error("Failing fdo.download", "guid", device.GUID, "name", file, "error", err)
+				yield(failedDownload{name: file, err: err})
+				return
+			}
+			defer func() { _ = f.Close() }()
+
+			if !yield(&fsim.DownloadContents[*downloadFile]{
+				Name:         file,
+				Contents:     f,
+				MustDownload: true,
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// uploadModules requests each upload file of the service info plan of the
+// device.
+func uploadModules(ctx context.Context, _ ownersvi.Device, _ string) iter.Seq[serviceinfo.OwnerModule] {
+	return func(yield func(serviceinfo.OwnerModule) bool) {
+		for _, name := range servicePlanFromContext(ctx).Uploads {
+			if !yield(&fsim.UploadRequest{
+				Dir:  uploadDir,
+				Name: name,
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// wgetModules has the device fetch each wget URL of the service info plan of
+// the device.
+func wgetModules(ctx context.Context, _ ownersvi.Device, _ string) iter.Seq[serviceinfo.OwnerModule] {
+	return func(yield func(serviceinfo.OwnerModule) bool) {
+		for _, urlString := range servicePlanFromContext(ctx).Wgets {
+			url, err := url.Parse(urlString)
+			if err != nil || url.Path == "" {
+				continue
+			}
+			if !yield(&fsim.WgetCommand{
+				Name: path.Base(url.Path),
+				URL:  url,
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// commandModules runs date on the device if the service info plan of the
+// device sets command date.
+func commandModules(ctx context.Context, _ ownersvi.Device, _ string) iter.Seq[serviceinfo.OwnerModule] {
+	return func(yield func(serviceinfo.OwnerModule) bool) {
+		if servicePlanFromContext(ctx).CommandDate {
+			yield(&fsim.RunCommand{
+				Command: "date",
+				Args:    []string{"--utc"},
+				Stdout:  os.Stdout,
+				Stderr:  os.Stderr,
+			})
+		}
+	}
+}
+
+// sysModules runs the fdo_sys steps of the service info plan of the device.
+func sysModules(ctx context.Context, _ ownersvi.Device, _ string) iter.Seq[serviceinfo.OwnerModule] {
+	return func(yield func(serviceinfo.OwnerModule) bool) {
+		if steps := servicePlanFromContext(ctx).sysSteps; len(steps) > 0 {
+			yield(&ownersvi.FdoSys{Steps: steps})
+		}
+	}
+}
+
+// sysSteps is parsed from -fdo-sys, in the order given.
+var sysSteps []ownersvi.SysStep
+
+// parseSysSteps parses fdo_sys steps and checks that the files they write
+// exist.
+func parseSysSteps(values []string) ([]ownersvi.SysStep, error) {
+	var steps []ownersvi.SysStep
+	for _, value := range values {
+		step, err := ownersvi.ParseSysStep(value)
+		if err != nil {
+			return nil, err
+		}
+		if step.Write != "" && (!isValidPath(step.Write) || !fileExists(step.Write)) {
+			return nil, fmt.Errorf("invalid fdo_sys step %q: file doesn't exist: %s", value, step.Write)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// customModule is a registered module that is not built in, selected with
+// -module.
+type customModule struct {
+	name   string
+	config string
+}
+
+// customModules is parsed from -module, in the order given.
+var customModules []customModule
+
+// parseCustomModules parses name[=config] values. Each module must be
+// registered and not built in, since built-in modules are configured by their
+// own flags.
+func parseCustomModules(values []string) ([]customModule, error) {
+	var modules []customModule
+	for _, value := range values {
+		name, config, _ := strings.Cut(value, "=")
+		if _, ok := ownersvi.Lookup(name); !ok {
+			return nil, fmt.Errorf("invalid module %q: unknown module %s (registered: %s)", value, name, strings.Join(ownersvi.Names(), ", "))
+		}
+		if slices.Contains(ownerModuleOrder, name) {
+			return nil, fmt.Errorf("invalid module %q: %s is built in and configured by its own flags", value, name)
+		}
+		if slices.ContainsFunc(modules, func(m customModule) bool { return m.name == name }) {
+			return nil, fmt.Errorf("invalid module %q: %s is selected twice", value, name)
+		}
+		modules = append(modules, customModule{name: name, config: config})
+	}
+	return modules, nil
+}
+
+// moduleConfig returns the configuration of a custom module of the plan.
+func (p *servicePlan) moduleConfig(name string) string {
+	for _, module := range p.customModules {
+		if module.name == name {
+			return module.config
+		}
+	}
+	return ""
+}
+
+// configuredModules returns the names of the service info modules that the
+// plan has operations configured for.
+func (p *servicePlan) configuredModules() []string {
+	var modules []string
+	if len(p.Downloads) > 0 {
+		modules = append(modules, "fdo.download")
+	}
+	if len(p.Uploads) > 0 {
+		modules = append(modules, "fdo.upload")
+	}
+	if len(p.Wgets) > 0 {
+		modules = append(modules, "fdo.wget")
+	}
+	if p.CommandDate {
+		modules = append(modules, "fdo.command")
+	}
+	if len(p.sysSteps) > 0 {
+		modules = append(modules, "fdo_sys")
+	}
+	for _, module := range p.customModules {
+		modules = append(modules, module.name)
+	}
+	return modules
+}
+
+// ownerModuleOrder is the order in which the operations of the built-in
+// service info modules are sent to devices unless -module-priority is used.
+// Custom modules follow in the order of -module.
+var ownerModuleOrder = []string{"fdo.download", "fdo.upload", "fdo.wget", "fdo.command", "fdo_sys"}
+
+// modulePriorities is parsed from -module-priority.
+var modulePriorities map[string]int
+
+// parseModulePriorities parses module=priority values.
+func parseModulePriorities(values []string) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, value := range values {
+		name, priority, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid module priority %q: must be module=priority", value)
+		}
+		if _, ok := ownersvi.Lookup(name); !ok {
+			return nil, fmt.Errorf("invalid module priority %q: unknown module %s", value, name)
+		}
+		n, err := strconv.Atoi(priority)
+		if err != nil {
+			return nil, fmt.Errorf("invalid module priority %q: %w", value, err)
+		}
+		priorities[name] = n
+	}
+	return priorities, nil
+}
+
+// prioritizedModules returns the modules of the plan in the order their
+// operations are sent: by descending priority, then in the default order.
+func (p *servicePlan) prioritizedModules() []string {
+	modules := slices.Clone(ownerModuleOrder)
+	for _, module := range p.customModules {
+		modules = append(modules, module.name)
+	}
+	slices.SortStableFunc(modules, func(a, b string) int {
+		return modulePriorities[b] - modulePriorities[a]
+	})
+	return modules
+}
+
+// missingRequiredModules logs the modules a device declared that its service
+// info plan has no configuration for and returns the -require-module modules
+// the device did not declare.
+func missingRequiredModules(guid protocol.GUID, plan *servicePlan, modules []string) []string {
+	configured := plan.configuredModules()
+	var unconfigured []string
+	for _, name := range modules {
+		if name != "devmod" && !slices.Contains(configured, name) {
+			unconfigured = append(unconfigured, name)
+		}
+	}
+	if len(unconfigured) > 0 {
+		slog.Debug("Device declared modules without owner configuration", "guid", guid, "modules", unconfigured)
+	}
+
+	var missing []string
+	for _, name := range requiredModules {
+		if !slices.Contains(modules, name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// missingModule fails TO2 for a device that does not support a module
+// required by the owner.
+type missingModule struct {
+	name string
+}
+
+func (m missingModule) HandleInfo(context.Context, string, io.Reader) error {
+	return fmt.Errorf("device does not support required module %s", m.name)
+}
+
+func (m missingModule) ProduceInfo(context.Context, *serviceinfo.Producer) (bool, bool, error) {
+	return false, false, fmt.Errorf("device does not support required module %s", m.name)
+}
+
+// ownerModulesFunc is the signature of the OwnerModules callback of the TO2
+// server.
+type ownerModulesFunc func(ctx context.Context, guid protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule]
+
+// withDeviceInventory stores the devmod and supported modules of each device
+// starting service info, so that they outlive its TO2 session.
+func withDeviceInventory(ownerModules ownerModulesFunc) ownerModulesFunc {
+	return func(ctx context.Context, guid protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		if err := db.RecordDevice(guid[:], db.Device{
+			OS:      devmod.Os,
+			Arch:    devmod.Arch,
+			Version: devmod.Version,
+			Device:  devmod.Device,
+			Serial:  hex.EncodeToString(devmod.Serial),
+			Modules: modules,
+		}); err != nil {
+			slog.Debug("Error recording device devmod", "guid", guid, "error", err)
+		}
+		return ownerModules(ctx, guid, info, chain, devmod, modules)
+	}
+}
+
+// withModuleEvents records each service info module started for a device on
+// its onboarding timeline.
+func withModuleEvents(ownerModules ownerModulesFunc) ownerModulesFunc {
+	return func(ctx context.Context, guid protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(yield func(string, serviceinfo.OwnerModule) bool) {
+			for name, module := range ownerModules(ctx, guid, info, chain, devmod, modules) {
+				if err := db.RecordDeviceEvent(guid[:], db.ModuleStartedEvent, name); err != nil {
+					slog.Debug("Error recording service info module", "guid", guid, "module", name, "error", err)
+				}
+				if !yield(name, module) {
+					return
+				}
+			}
+		}
+	}
+}
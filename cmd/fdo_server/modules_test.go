@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"testing"
+
+	ownersvi "github.com/fido-device-onboard/go-fdo-server/internal/serviceinfo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestOwnerModulesRequiredModule(t *testing.T) {
+	defer func() { requiredModules = nil }()
+
+	// yielded returns the names of the modules the owner starts for a device
+	// and whether any of them fails onboarding
+	yielded := func(deviceModules []string) (names []string, failed bool) {
+		for name, module := range ownerModules(context.Background(), protocol.GUID{}, "test-device", nil, serviceinfo.Devmod{}, deviceModules) {
+			names = append(names, name)
+			if _, _, err := module.ProduceInfo(context.Background(), &serviceinfo.Producer{}); err != nil {
+				failed = true
+			}
+		}
+		return names, failed
+	}
+
+	withoutDownload := []string{"devmod", "fdo.upload"}
+
+	t.Run("permissive", func(t *testing.T) {
+		requiredModules = nil
+		if _, failed := yielded(withoutDownload); failed {
+			t.Error("device without fdo.download was rejected in permissive mode")
+		}
+	})
+
+	t.Run("strict rejects missing module", func(t *testing.T) {
+		requiredModules = stringList{"fdo.download"}
+		names, failed := yielded(withoutDownload)
+		if !failed {
+			t.Error("device without fdo.download was not rejected in strict mode")
+		}
+		if len(names) != 1 || names[0] != "fdo.download" {
+			t.Errorf("unexpected modules %v", names)
+		}
+	})
+
+	t.Run("strict accepts supported module", func(t *testing.T) {
+		requiredModules = stringList{"fdo.download"}
+		if _, failed := yielded(append(withoutDownload, "fdo.download")); failed {
+			t.Error("device with fdo.download was rejected in strict mode")
+		}
+	})
+}
+
+func TestOwnerModulesPriority(t *testing.T) {
+	defer func() { uploadReqs, wgets, modulePriorities = nil, nil, nil }()
+	uploadReqs = stringList{"log.txt"}
+	wgets = stringList{"https://example.com/firmware.bin"}
+	deviceModules := []string{"devmod", "fdo.upload", "fdo.wget"}
+
+	order := func() (names []string) {
+		for name := range ownerModules(context.Background(), protocol.GUID{}, "test-device", nil, serviceinfo.Devmod{}, deviceModules) {
+			names = append(names, name)
+		}
+		return names
+	}
+
+	for _, test := range []struct {
+		name       string
+		priorities []string
+		want       []string
+	}{
+		{"default", nil, []string{"fdo.upload", "fdo.wget"}},
+		{"higher first", []string{"fdo.wget=10"}, []string{"fdo.wget", "fdo.upload"}},
+		{"negative last", []string{"fdo.upload=-1"}, []string{"fdo.wget", "fdo.upload"}},
+		{"equal keeps default", []string{"fdo.upload=5", "fdo.wget=5"}, []string{"fdo.upload", "fdo.wget"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if modulePriorities, err = parseModulePriorities(test.priorities); err != nil {
+				t.Fatal(err)
+			}
+			if got := order(); !slices.Equal(got, test.want) {
+				t.Errorf("got module order %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"fdo.wget", "fdo.unknown=1", "fdo.wget=high"} {
+		if _, err := parseModulePriorities([]string{invalid}); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+// fakeModule sends its configuration to the device and is done once the
+// device acknowledges it.
+type fakeModule struct {
+	config      string
+	sent, acked bool
+}
+
+func (m *fakeModule) HandleInfo(_ context.Context, messageName string, _ io.Reader) error {
+	if messageName != "ack" {
+		return errors.New("unexpected message " + messageName)
+	}
+	m.acked = true
+	return nil
+}
+
+func (m *fakeModule) ProduceInfo(_ context.Context, producer *serviceinfo.Producer) (bool, bool, error) {
+	switch {
+	case m.acked:
+		return false, true, nil
+	case !m.sent:
+		m.sent = true
+		return true, false, producer.WriteChunk("config", []byte(m.config))
+	default:
+		return false, false, nil
+	}
+}
+
+func TestOwnerModulesCustomModule(t *testing.T) {
+	defer func() { customModules = nil }()
+	if _, ok := ownersvi.Lookup("test.fake"); !ok {
+		ownersvi.Register("test.fake", func(_ context.Context, device ownersvi.Device, config string) iter.Seq[serviceinfo.OwnerModule] {
+			return func(yield func(serviceinfo.OwnerModule) bool) {
+				yield(&fakeModule{config: config + " " + device.Info})
+			}
+		})
+	}
+
+	var err error
+	if customModules, err = parseCustomModules([]string{"test.fake=hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(flagServicePlan().configuredModules(), "test.fake") {
+		t.Errorf("configured modules %v do not include the custom module", flagServicePlan().configuredModules())
+	}
+
+	modules := func(deviceModules []string) map[string]serviceinfo.OwnerModule {
+		started := make(map[string]serviceinfo.OwnerModule)
+		for name, module := range ownerModules(context.Background(), protocol.GUID{}, "test-device", nil, serviceinfo.Devmod{}, deviceModules) {
+			started[name] = module
+		}
+		return started
+	}
+	if started := modules([]string{"devmod"}); len(started) != 0 {
+		t.Errorf("modules %v started for a device without the custom module", started)
+	}
+
+	// Drive the module the way the TO2 server does until it is done
+	module, ok := modules([]string{"devmod", "test.fake"})["test.fake"].(*fakeModule)
+	if !ok {
+		t.Fatal("custom module was not started")
+	}
+	if module.config != "hello test-device" {
+		t.Errorf("module has config %q", module.config)
+	}
+	ctx, producer := context.Background(), &serviceinfo.Producer{}
+	if blockPeer, done, err := module.ProduceInfo(ctx, producer); err != nil || !blockPeer || done {
+		t.Fatalf("first ProduceInfo = %v, %v, %v", blockPeer, done, err)
+	}
+	if err := module.HandleInfo(ctx, "ack", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, done, err := module.ProduceInfo(ctx, producer); err != nil || !done {
+		t.Fatalf("module is not done after the device acknowledged: %v", err)
+	}
+
+	for _, invalid := range [][]string{{"test.unknown"}, {"fdo.download"}, {"test.fake", "test.fake=again"}} {
+		if _, err := parseCustomModules(invalid); err == nil {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/ownerinfo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/rvinfo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/to0"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/custom"
+	"github.com/fido-device-onboard/go-fdo/fdotest"
+	fdohttp "github.com/fido-device-onboard/go-fdo/http"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// onboardingServer is a server of one role of the onboarding test, serving
+// the FDO protocol of a handler built by newHandler on its own database.
+type onboardingServer struct {
+	*httptest.Server
+	DB   *sqlite.DB
+	Keys *externalKeys
+}
+
+// startOnboardingServer starts a server with RV info on a new in-memory
+// database, injecting the owner keys of the given key URIs in place of the
+// database keys.
+func startOnboardingServer(t *testing.T, ownerURIs []string, rvInfo [][]protocol.RvInstruction) *onboardingServer {
+	t.Helper()
+	state, err := openDatabase(inMemoryDB, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = state.Close() })
+	if err := db.NewState(state).Init(); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := newExternalKeys(state, ownerURIs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverState := &ServerState{RvInfo: rvInfo, DB: state, Keys: keys}
+	handler, err := newHandler(serverState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(api.NewHTTPHandler(handler, &serverState.RvInfo, state).ProtocolRoutes())
+	t.Cleanup(srv.Close)
+	return &onboardingServer{Server: srv, DB: state, Keys: keys}
+}
+
+// TestOnboarding onboards a device across a manufacturer, a rendezvous and an
+// owner server, each on a database of its own: the device is initialized by
+// the manufacturer, its voucher is imported by the owner, which registers it
+// at the rendezvous server, and the device finds the owner in TO1 and
+// completes TO2. The manufacturer and owner are given the same owner key, so
+// vouchers are extended to the key the owner signs with.
+func TestOnboarding(t *testing.T) {
+	defer func(logger *slog.Logger) { slog.SetDefault(logger) }(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(fdotest.TestingLog(t), &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer func(disabled bool) { disableAutoTO0 = disabled }(disableAutoTO0)
+	disableAutoTO0 = true
+
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerPath := filepath.Join(t.TempDir(), "owner.key")
+	if err := os.WriteFile(ownerPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ownerURIs := []string{"SECP384R1=file://" + ownerPath}
+
+	rendezvous := startOnboardingServer(t, nil, nil)
+	rvURL, err := url.Parse(rendezvous.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvPort, err := strconv.ParseUint(rvURL.Port(), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rvInfo, err := rvinfo.CreateRvInfo(false, rvURL.Hostname(), uint16(rvPort))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manufacturer := startOnboardingServer(t, ownerURIs, rvInfo)
+	// The owner is started last, as its database is the one of the package
+	// level functions, such as those storing owner info and importing vouchers
+	owner := startOnboardingServer(t, ownerURIs, rvInfo)
+	if err := db.InitDb(owner.DB); err != nil {
+		t.Fatal(err)
+	}
+	ownerURL, err := url.Parse(owner.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerPort, err := strconv.ParseUint(ownerURL.Port(), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ownerinfo.CreateRvTO2Addr(ownerURL.Hostname(), uint16(ownerPort), false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Device credential secrets
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+	hmacSha256, hmacSha384 := hmac.New(sha256.New, secret), hmac.New(sha512.New384, secret)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var cred *fdo.DeviceCredential
+	t.Run("DI", func(t *testing.T) {
+		csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject: pkix.Name{CommonName: "device.go-fdo-server"},
+		}, deviceKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(csrDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cred, err = fdo.DI(ctx, &fdohttp.Transport{BaseURL: manufacturer.URL}, custom.DeviceMfgInfo{
+			KeyType:      protocol.Secp384r1KeyType,
+			KeyEncoding:  protocol.X509KeyEnc,
+			SerialNumber: "onboarding-test",
+			DeviceInfo:   "gateway",
+			CertInfo:     cbor.X509CertificateRequest(*csr),
+		}, fdo.DIConfig{
+			HmacSha256: hmacSha256,
+			HmacSha384: hmacSha384,
+			Key:        deviceKey,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if cred == nil {
+		t.FailNow()
+	}
+	guid := cred.GUID
+
+	t.Run("voucher import", func(t *testing.T) {
+		voucher, err := db.NewState(manufacturer.DB).FetchVoucher(guid[:])
+		if err != nil {
+			t.Fatalf("manufacturer has no voucher of the device: %v", err)
+		}
+		var ov fdo.Voucher
+		if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+			t.Fatal(err)
+		}
+		voucherOwner, err := ov.OwnerPublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ownerKey.PublicKey.Equal(voucherOwner) {
+			t.Fatal("manufacturer did not extend the voucher to the owner key")
+		}
+		if _, err := db.ImportVoucher(voucher); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("TO0", func(t *testing.T) {
+		// The owner signs RV blobs with its injected keys, as set up by main
+		to0.SetOwnerKeys(owner.Keys)
+		defer to0.SetOwnerKeys(nil)
+		if err := to0.RegisterRvBlob(rvInfo, hex.EncodeToString(guid[:]), owner.DB); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var to1d *cose.Sign1[protocol.To1d, []byte]
+	t.Run("TO1", func(t *testing.T) {
+		blob, err := fdo.TO1(ctx, &fdohttp.Transport{BaseURL: rendezvous.URL}, *cred, deviceKey, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		to1d = blob
+	})
+	if to1d == nil {
+		t.FailNow()
+	}
+
+	t.Run("TO2", func(t *testing.T) {
+		// The device onboards at the owner address of the RV blob
+		addrs := to1d.Payload.Val.RV
+		if len(addrs) == 0 || addrs[0].IPAddress == nil || addrs[0].Port != uint16(ownerPort) {
+			t.Fatalf("RV blob addresses are %+v, want the owner at port %d", addrs, ownerPort)
+		}
+		ownerAddr := fmt.Sprintf("http://%s", net.JoinHostPort(addrs[0].IPAddress.String(), strconv.Itoa(int(addrs[0].Port))))
+
+		newCred, err := fdo.TO2(ctx, &fdohttp.Transport{BaseURL: ownerAddr}, to1d, fdo.TO2Config{
+			Cred:       *cred,
+			HmacSha256: hmacSha256,
+			HmacSha384: hmacSha384,
+			Key:        deviceKey,
+			Devmod: serviceinfo.Devmod{
+				Os:      runtime.GOOS,
+				Arch:    runtime.GOARCH,
+				Version: "test",
+				Device:  "gateway",
+				FileSep: "/",
+				Bin:     runtime.GOARCH,
+			},
+			KeyExchange: kex.ECDH384Suite,
+			CipherSuite: kex.A128GcmCipher,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		completed, err := db.IsTO2Completed(guid[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !completed {
+			t.Error("owner did not record the device as onboarded")
+		}
+		if _, err := db.FetchVoucher(newCred.GUID[:]); err != nil {
+			t.Errorf("owner has no voucher of the replacement GUID: %v", err)
+		}
+	})
+}
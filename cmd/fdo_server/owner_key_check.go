@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// Behaviors of -owner-key-check
+const (
+	ownerKeyCheckOff    = "off"
+	ownerKeyCheckWarn   = "warn"
+	ownerKeyCheckStrict = "strict"
+)
+
+// errOwnerKeyMismatch is returned when stored vouchers exist but none of the
+// sampled vouchers is owned by an owner key of the server.
+var errOwnerKeyMismatch = errors.New("no sampled voucher is owned by an owner key of this server")
+
+// checkOwnerKeyMatches returns errOwnerKeyMismatch if none of the voucher
+// owners is an owner key. Having no vouchers is not a mismatch.
+func checkOwnerKeyMatches(ownerKey func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error), voucherOwners []crypto.PublicKey) error {
+	if len(voucherOwners) == 0 {
+		return nil
+	}
+	var ownerKeys []crypto.PublicKey
+	for _, keyType := range ownerKeyTypes {
+		if key, _, err := ownerKey(keyType); err == nil && key != nil {
+			ownerKeys = append(ownerKeys, key.Public())
+		}
+	}
+	for _, owner := range voucherOwners {
+		if slices.ContainsFunc(ownerKeys, func(key crypto.PublicKey) bool {
+			return key.(interface{ Equal(crypto.PublicKey) bool }).Equal(owner)
+		}) {
+			return nil
+		}
+	}
+	return errOwnerKeyMismatch
+}
+
+// doOwnerKeyCheck samples up to n stored vouchers and checks that at least one
+// is owned by an owner key. A mismatch is logged in warn mode and returned in
+// strict mode, so that a server restored with the wrong keys does not start.
+func doOwnerKeyCheck(ownerKey func(protocol.KeyType) (crypto.Signer, []*x509.Certificate, error), mode string, n int) error {
+	if mode == ownerKeyCheckOff {
+		return nil
+	}
+	vouchers, err := db.SampleVouchers(n)
+	if err != nil {
+		return fmt.Errorf("error sampling vouchers: %w", err)
+	}
+	var owners []crypto.PublicKey
+	for _, voucher := range vouchers {
+		ov, err := db.ParseVoucher(voucher)
+		if err != nil {
+			slog.Debug("Skipping voucher in owner key check", "guid", fmt.Sprintf("%x", voucher.GUID), "error", err)
+			continue
+		}
+		owner, err := ov.OwnerPublicKey()
+		if err != nil || owner == nil {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+
+	err = checkOwnerKeyMatches(ownerKey, owners)
+	if err == nil {
+		return nil
+	}
+	if mode == ownerKeyCheckStrict {
+		return fmt.Errorf("%w (sampled %d vouchers)", err, len(owners))
+	}
+	slog.Warn("Owner key does not match stored vouchers", "sampled", len(owners), "error", err)
+	return nil
+}
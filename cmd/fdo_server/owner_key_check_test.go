@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestCheckOwnerKeyMatches(t *testing.T) {
+	ownerKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := generatePrivateKey("ec384")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := func(keyType protocol.KeyType) (crypto.Signer, []*x509.Certificate, error) {
+		if keyType != protocol.Secp384r1KeyType {
+			return nil, nil, errors.New("not found")
+		}
+		return ownerKey, nil, nil
+	}
+
+	for _, test := range []struct {
+		name   string
+		owners []crypto.PublicKey
+		want   error
+	}{
+		{"no vouchers", nil, nil},
+		{"matching key", []crypto.PublicKey{otherKey.Public(), ownerKey.Public()}, nil},
+		{"mismatching key", []crypto.PublicKey{otherKey.Public()}, errOwnerKeyMismatch},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkOwnerKeyMatches(keys, test.owners); !errors.Is(err, test.want) {
+				t.Errorf("checkOwnerKeyMatches() = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	ownersvi "github.com/fido-device-onboard/go-fdo-server/internal/serviceinfo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// servicePlan is the service info operations sent to the devices its rules
+// match, in place of those of the service info flags.
+type servicePlan struct {
+	Name        string          `json:"name"`
+	Match       servicePlanRule `json:"match"`
+	Downloads   []string        `json:"downloads"`
+	Uploads     []string        `json:"uploads"`
+	Wgets       []string        `json:"wgets"`
+	CommandDate bool            `json:"command_date"`
+	// FdoSys are fdo_sys steps given as with -fdo-sys
+	FdoSys []string `json:"fdo_sys"`
+	// Modules are custom modules given as name or name=config, as with -module
+	Modules []string `json:"modules"`
+
+	sysSteps      []ownersvi.SysStep
+	customModules []customModule
+}
+
+// servicePlanRule selects devices by their device info, devmod and GUID.
+// A device must match every field that is set, so a rule without fields
+// matches every device.
+type servicePlanRule struct {
+	// DeviceInfo is a path.Match pattern, such as "gateway-*"
+	DeviceInfo string   `json:"device_info"`
+	OS         string   `json:"os"`
+	Arch       string   `json:"arch"`
+	Version    string   `json:"version"`
+	Device     string   `json:"device"`
+	GUIDs      []string `json:"guids"`
+}
+
+// servicePlans is loaded from -serviceinfo-plans, in the order given.
+var servicePlans []servicePlan
+
+// loadServicePlans reads and checks the JSON array of service info plans in
+// the file at path.
+func loadServicePlans(path string) ([]servicePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service info plans: %w", err)
+	}
+	return parseServicePlans(data)
+}
+
+func parseServicePlans(data []byte) ([]servicePlan, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var plans []servicePlan
+	if err := dec.Decode(&plans); err != nil {
+		return nil, fmt.Errorf("invalid service info plans: %w", err)
+	}
+	var names []string
+	for i := range plans {
+		plan := &plans[i]
+		if plan.Name == "" {
+			return nil, fmt.Errorf("invalid service info plan %d: name is required", i+1)
+		}
+		if slices.Contains(names, plan.Name) {
+			return nil, fmt.Errorf("invalid service info plan %q: name is used twice", plan.Name)
+		}
+		names = append(names, plan.Name)
+		if err := plan.check(); err != nil {
+			return nil, fmt.Errorf("invalid service info plan %q: %w", plan.Name, err)
+		}
+	}
+	return plans, nil
+}
+
+// check validates the rule and operations of the plan and parses its custom
+// modules.
+func (p *servicePlan) check() error {
+	if _, err := path.Match(p.Match.DeviceInfo, ""); err != nil {
+		return fmt.Errorf("invalid device info pattern %q: %w", p.Match.DeviceInfo, err)
+	}
+	for i, guid := range p.Match.GUIDs {
+		if !utils.IsValidGUID(guid) {
+			return fmt.Errorf("invalid GUID %q", guid)
+		}
+		p.Match.GUIDs[i] = strings.ToLower(guid)
+	}
+	if err := validateDownloads(p.Downloads); err != nil {
+		return err
+	}
+	if err := validateWgets(p.Wgets); err != nil {
+		return err
+	}
+	var err error
+	if p.sysSteps, err = parseSysSteps(p.FdoSys); err != nil {
+		return err
+	}
+	p.customModules, err = parseCustomModules(p.Modules)
+	return err
+}
+
+// matches reports whether the rule of the plan selects the device.
+func (p *servicePlan) matches(device ownersvi.Device) bool {
+	rule := p.Match
+	if rule.DeviceInfo != "" {
+		if ok, _ := path.Match(rule.DeviceInfo, device.Info); !ok {
+			return false
+		}
+	}
+	for _, field := range []struct{ want, got string }{
+		{rule.OS, device.Devmod.Os},
+		{rule.Arch, device.Devmod.Arch},
+		{rule.Version, device.Devmod.Version},
+		{rule.Device, device.Devmod.Device},
+	} {
+		if field.want != "" && field.want != field.got {
+			return false
+		}
+	}
+	if len(rule.GUIDs) > 0 && !slices.Contains(rule.GUIDs, hex.EncodeToString(device.GUID[:])) {
+		return false
+	}
+	return true
+}
+
+// flagServicePlan is the plan of the devices that no service info plan
+// matches, configured by the service info flags.
+func flagServicePlan() *servicePlan {
+	return &servicePlan{
+		Downloads:     downloads,
+		Uploads:       uploadReqs,
+		Wgets:         wgets,
+		CommandDate:   cmdDate,
+		sysSteps:      sysSteps,
+		customModules: customModules,
+	}
+}
+
+// selectServicePlan returns the first service info plan that matches the
+// device, or else the plan of the service info flags.
+func selectServicePlan(device ownersvi.Device) *servicePlan {
+	for i := range servicePlans {
+		if plan := &servicePlans[i]; plan.matches(device) {
+			slog.Debug("Selected service info plan", "guid", device.GUID, "plan", plan.Name)
+			return plan
+		}
+	}
+	return flagServicePlan()
+}
+
+type servicePlanKey struct{}
+
+// withServicePlan returns a context carrying the service info plan of the
+// device in TO2, for the built-in module factories.
+func withServicePlan(ctx context.Context, plan *servicePlan) context.Context {
+	return context.WithValue(ctx, servicePlanKey{}, plan)
+}
+
+// servicePlanFromContext returns the service info plan carried by ctx, or the
+// plan of the service info flags.
+func servicePlanFromContext(ctx context.Context) *servicePlan {
+	if plan, ok := ctx.Value(servicePlanKey{}).(*servicePlan); ok {
+		return plan
+	}
+	return flagServicePlan()
+}
@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/fsim"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestParseServicePlans(t *testing.T) {
+	for _, test := range []struct {
+		name, plans, err string
+	}{
+		{"valid", `[{"name": "cameras", "match": {"device_info": "camera-*", "guids": ["0102030405060708090A0B0C0D0E0F10"]}, "wgets": ["https://example.com/fw.bin"]}]`, ""},
+		{"not an array", `{"name": "cameras"}`, "invalid service info plans"},
+		{"unknown field", `[{"name": "cameras", "match": {"model": "x"}}]`, "unknown field"},
+		{"missing name", `[{"match": {"os": "Linux"}}]`, "name is required"},
+		{"duplicate name", `[{"name": "a"}, {"name": "a"}]`, "name is used twice"},
+		{"bad pattern", `[{"name": "a", "match": {"device_info": "["}}]`, "invalid device info pattern"},
+		{"bad GUID", `[{"name": "a", "match": {"guids": ["xyz"]}}]`, "invalid GUID"},
+		{"bad wget", `[{"name": "a", "wgets": ["not a url"]}]`, "invalid wget URL"},
+		{"missing download", `[{"name": "a", "downloads": ["/nonexistent/file"]}]`, "file doesn't exist"},
+		{"unknown module", `[{"name": "a", "modules": ["no.such.module"]}]`, "unknown module"},
+		{"bad fdo_sys step", `[{"name": "a", "fdo_sys": ["fetch:/var/log/agent.log"]}]`, "invalid fdo_sys step"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			plans, err := parseServicePlans([]byte(test.plans))
+			if test.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := "0102030405060708090a0b0c0d0e0f10"; plans[0].Match.GUIDs[0] != want {
+					t.Errorf("GUID is %q, want %q", plans[0].Match.GUIDs[0], want)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("error is %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestOwnerModulesServicePlans(t *testing.T) {
+	defer func() { servicePlans, uploadReqs = nil, nil }()
+	uploadReqs = stringList{"log.txt"}
+
+	var err error
+	servicePlans, err = parseServicePlans([]byte(`[
+		{"name": "arm cameras", "match": {"device_info": "camera-*", "arch": "aarch64"}, "wgets": ["https://example.com/camera.bin"]},
+		{"name": "pinned", "match": {"guids": ["ab000000000000000000000000000000"]}, "wgets": ["https://example.com/pinned.bin"], "uploads": ["pinned.txt"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deviceModules := []string{"devmod", "fdo.upload", "fdo.wget"}
+	// operations returns the names of the files the owner sends or requests
+	operations := func(guid protocol.GUID, info string, devmod serviceinfo.Devmod) (names []string) {
+		for _, module := range ownerModules(context.Background(), guid, info, nil, devmod, deviceModules) {
+			switch module := module.(type) {
+			case *fsim.WgetCommand:
+				names = append(names, module.Name)
+			case *fsim.UploadRequest:
+				names = append(names, module.Name)
+			}
+		}
+		return names
+	}
+
+	for _, test := range []struct {
+		name   string
+		guid   protocol.GUID
+		info   string
+		devmod serviceinfo.Devmod
+		want   []string
+	}{
+		{"matching plan", protocol.GUID{0x01}, "camera-2", serviceinfo.Devmod{Arch: "aarch64"}, []string{"camera.bin"}},
+		{"partial match uses flags", protocol.GUID{0x02}, "camera-2", serviceinfo.Devmod{Arch: "x86_64"}, []string{"log.txt"}},
+		{"GUID list", protocol.GUID{0xab}, "gateway", serviceinfo.Devmod{}, []string{"pinned.txt", "pinned.bin"}},
+		{"no plan uses flags", protocol.GUID{0x03}, "gateway", serviceinfo.Devmod{}, []string{"log.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := operations(test.guid, test.info, test.devmod); !slices.Equal(got, test.want) {
+				t.Errorf("operations are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
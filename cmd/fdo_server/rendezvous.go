@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/logging"
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// rvBlobMetrics counts and logs the RV blob lookups of TO1, so that devices
+// failing TO1 because their owner never registered can be told apart from
+// other failures.
+type rvBlobMetrics struct {
+	fdo.RendezvousBlobPersistentState
+}
+
+func (s rvBlobMetrics) RVBlob(ctx context.Context, guid protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *fdo.Voucher, error) {
+	blob, ov, err := s.RendezvousBlobPersistentState.RVBlob(ctx, guid)
+	switch {
+	case err == nil:
+		metrics.TO1BlobHits.Add(1)
+		logging.Sampled().Debug("TO1 RV blob found", "guid", guid)
+	case errors.Is(err, fdo.ErrNotFound):
+		metrics.TO1BlobMisses.Add(1)
+		slog.Info("TO1 RV blob not found, device is not registered by TO0", "guid", guid)
+	default:
+		slog.Error("Error looking up TO1 RV blob", "guid", guid, "error", err)
+	}
+	return blob, ov, err
+}
+
+// autoTO0Config returns the RV blob store and the TO2 addresses with which RV
+// blobs of devices are registered after DI, so that TO1 can be tested. No RV
+// blob is registered if RV bypass is set or auto TO0 is disabled, such as when
+// TO0 is handled by an external registrar.
+func autoTO0Config(store fdo.AutoTO0, rvInfo [][]protocol.RvInstruction, bypass, disabled bool) (fdo.AutoTO0, []protocol.RvTO2Addr, error) {
+	if bypass || disabled {
+		return nil, nil, nil
+	}
+
+	var addrs []protocol.RvTO2Addr
+	for _, directive := range protocol.ParseDeviceRvInfo(rvInfo) {
+		if directive.Bypass {
+			continue
+		}
+
+		for _, url := range directive.URLs {
+			to1Host := url.Hostname()
+			to1Port, err := strconv.ParseUint(url.Port(), 10, 16)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing TO1 port to use for TO2: %w", err)
+			}
+			proto := protocol.HTTPTransport
+			if useTLS {
+				proto = protocol.HTTPSTransport
+			}
+			addrs = append(addrs, protocol.RvTO2Addr{
+				DNSAddress:        &to1Host,
+				Port:              uint16(to1Port),
+				TransportProtocol: proto,
+			})
+		}
+	}
+	return store, addrs, nil
+}
+
+// acceptVoucherMetrics counts and logs the TO0 registrations accepted or
+// rejected by accept, with the reason of rejections.
+func acceptVoucherMetrics(accept func(context.Context, fdo.Voucher) (bool, error)) func(context.Context, fdo.Voucher) (bool, error) {
+	return func(ctx context.Context, ov fdo.Voucher) (bool, error) {
+		guid := ov.Header.Val.GUID
+		ok, err := accept(ctx, ov)
+		if err != nil || !ok {
+			metrics.TO0Rejected.Add(1)
+			reason := "voucher not accepted"
+			if err != nil {
+				reason = err.Error()
+			}
+			slog.Info("TO0 registration rejected", "guid", guid, "reason", reason)
+			return ok, err
+		}
+		metrics.TO0Accepted.Add(1)
+		logging.Sampled().Debug("TO0 registration accepted", "guid", guid)
+		return true, nil
+	}
+}
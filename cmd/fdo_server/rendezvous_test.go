@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/cose"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// emptyRVBlobs has no registered RV blobs.
+type emptyRVBlobs struct {
+	fdo.RendezvousBlobPersistentState
+}
+
+func (emptyRVBlobs) RVBlob(context.Context, protocol.GUID) (*cose.Sign1[protocol.To1d, []byte], *fdo.Voucher, error) {
+	return nil, nil, fdo.ErrNotFound
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(logger) })
+	return &buf
+}
+
+func TestRVBlobMetricsMiss(t *testing.T) {
+	logs := captureLog(t)
+	hits, misses := metrics.TO1BlobHits.Load(), metrics.TO1BlobMisses.Load()
+
+	_, _, err := rvBlobMetrics{emptyRVBlobs{}}.RVBlob(context.Background(), protocol.GUID{0x70, 0x01})
+	if !errors.Is(err, fdo.ErrNotFound) {
+		t.Fatalf("got error %v, want %v", err, fdo.ErrNotFound)
+	}
+	if got := metrics.TO1BlobMisses.Load() - misses; got != 1 {
+		t.Errorf("TO1 blob misses increased by %d, want 1", got)
+	}
+	if got := metrics.TO1BlobHits.Load() - hits; got != 0 {
+		t.Errorf("TO1 blob hits increased by %d, want 0", got)
+	}
+	if !strings.Contains(logs.String(), "TO1 RV blob not found") {
+		t.Errorf("miss was not logged: %s", logs)
+	}
+}
+
+func TestAcceptVoucherMetrics(t *testing.T) {
+	logs := captureLog(t)
+	accepted, rejected := metrics.TO0Accepted.Load(), metrics.TO0Rejected.Load()
+
+	accept := acceptVoucherMetrics(func(_ context.Context, ov fdo.Voucher) (bool, error) {
+		switch ov.Header.Val.GUID {
+		case protocol.GUID{0x01}:
+			return true, nil
+		case protocol.GUID{0x02}:
+			return false, nil
+		default:
+			return false, errors.New("wait policy unavailable")
+		}
+	})
+	voucher := func(guid protocol.GUID) fdo.Voucher {
+		var ov fdo.Voucher
+		ov.Header.Val.GUID = guid
+		return ov
+	}
+	if ok, err := accept(context.Background(), voucher(protocol.GUID{0x01})); err != nil || !ok {
+		t.Fatalf("registration was rejected: %v", err)
+	}
+	if ok, _ := accept(context.Background(), voucher(protocol.GUID{0x02})); ok {
+		t.Fatal("registration was accepted")
+	}
+	if _, err := accept(context.Background(), voucher(protocol.GUID{0x03})); err == nil {
+		t.Fatal("registration error was not returned")
+	}
+
+	if got := metrics.TO0Accepted.Load() - accepted; got != 1 {
+		t.Errorf("TO0 accepted increased by %d, want 1", got)
+	}
+	if got := metrics.TO0Rejected.Load() - rejected; got != 2 {
+		t.Errorf("TO0 rejected increased by %d, want 2", got)
+	}
+	if !strings.Contains(logs.String(), "voucher not accepted") || !strings.Contains(logs.String(), "wait policy unavailable") {
+		t.Errorf("rejection reason was not logged: %s", logs)
+	}
+}
+
+// fakeAutoTO0 stands in for the RV blob store of auto TO0.
+type fakeAutoTO0 struct {
+	fdo.AutoTO0
+}
+
+func TestAutoTO0Config(t *testing.T) {
+	store := &fakeAutoTO0{}
+	for _, test := range []struct {
+		name     string
+		bypass   bool
+		disabled bool
+		want     bool
+	}{
+		{"enabled", false, false, true},
+		{"RV bypass", true, false, false},
+		{"disabled", false, true, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			autoTO0, addrs, err := autoTO0Config(store, nil, test.bypass, test.disabled)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if registers := autoTO0 != nil; registers != test.want {
+				t.Errorf("auto TO0 registers RV blobs is %v, want %v", registers, test.want)
+			}
+			if !test.want && addrs != nil {
+				t.Errorf("auto TO0 addresses are %v", addrs)
+			}
+		})
+	}
+}
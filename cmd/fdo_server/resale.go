@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// errVoucherNotFound is returned when reselling a GUID without a stored
+// voucher.
+var errVoucherNotFound = errors.New("voucher not found")
+
+// errDeviceOnboarded is returned when reselling the voucher of a device that
+// has already completed TO2 without -resale-force.
+var errDeviceOnboarded = errors.New("device has already completed TO2; use -resale-force to resell it anyway, e.g. after it was returned")
+
+// errResaleDepth is returned when reselling a voucher that already has the
+// maximum number of entries.
+var errResaleDepth = errors.New("voucher has reached the maximum resale depth")
+
+// resaleStore looks up the state checked before reselling a voucher.
+type resaleStore struct {
+	voucherExists  func(guid []byte) (bool, error)
+	isTO2Completed func(guid []byte) (bool, error)
+}
+
+var defaultResaleStore = resaleStore{
+	voucherExists:  voucherExists,
+	isTO2Completed: db.IsTO2Completed,
+}
+
+func voucherExists(guid []byte) (bool, error) {
+	_, err := db.FetchVoucher(guid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkResale rejects reselling a voucher that is not stored, so that it is
+// not reported as a failure of the resale protocol, and the voucher of an
+// onboarded device unless force is set. Reselling such a voucher is usually a
+// mistake, since the device is already in use by its current owner.
+func checkResale(store resaleStore, guid []byte, force bool) error {
+	exists, err := store.voucherExists(guid)
+	if err != nil {
+		return fmt.Errorf("error looking up voucher: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("voucher %x: %w", guid, errVoucherNotFound)
+	}
+
+	if force {
+		return nil
+	}
+	completed, err := store.isTO2Completed(guid)
+	if err != nil {
+		return fmt.Errorf("error checking onboarding status: %w", err)
+	}
+	if completed {
+		return fmt.Errorf("voucher %x: %w", guid, errDeviceOnboarded)
+	}
+	return nil
+}
+
+// checkResaleDepth rejects extending a voucher with entries entries if it is
+// at maxEntries, so that repeated resales cannot grow the voucher and the
+// cost of verifying it without bound. A maxEntries of 0 is no limit.
+func checkResaleDepth(guid []byte, entries, maxEntries int) error {
+	if maxEntries > 0 && entries >= maxEntries {
+		return fmt.Errorf("voucher %x has %d entries, the maximum of -max-resale-depth: %w", guid, entries, errResaleDepth)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckResale(t *testing.T) {
+	pending, onboarded := []byte{0x01}, []byte{0x02}
+	store := resaleStore{
+		voucherExists: func(guid []byte) (bool, error) {
+			return string(guid) == string(pending) || string(guid) == string(onboarded), nil
+		},
+		isTO2Completed: func(guid []byte) (bool, error) {
+			return string(guid) == string(onboarded), nil
+		},
+	}
+
+	for _, test := range []struct {
+		name    string
+		guid    []byte
+		force   bool
+		wantErr error
+	}{
+		{"pending", pending, false, nil},
+		{"completed", onboarded, false, errDeviceOnboarded},
+		{"completed forced", onboarded, true, nil},
+		{"unknown", []byte{0x03}, false, errVoucherNotFound},
+		{"unknown forced", []byte{0x03}, true, errVoucherNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkResale(store, test.guid, test.force)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	t.Run("lookup error", func(t *testing.T) {
+		lookupErr := errors.New("database is locked")
+		failing := store
+		failing.voucherExists = func([]byte) (bool, error) { return false, lookupErr }
+		err := checkResale(failing, pending, false)
+		if !errors.Is(err, lookupErr) || errors.Is(err, errVoucherNotFound) {
+			t.Errorf("got error %v, want %v", err, lookupErr)
+		}
+	})
+}
+
+func TestCheckResaleDepth(t *testing.T) {
+	guid := []byte{0x01}
+	for _, test := range []struct {
+		name       string
+		entries    int
+		maxEntries int
+		wantErr    error
+	}{
+		{"no limit", 100, 0, nil},
+		{"below limit", 1, 3, nil},
+		{"up to limit", 2, 3, nil},
+		{"at limit", 3, 3, errResaleDepth},
+		{"beyond limit", 4, 3, errResaleDepth},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkResaleDepth(guid, test.entries, test.maxEntries)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got error %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+)
+
+// runVoucherReaper removes the owner vouchers that the retention policy no
+// longer keeps, immediately and then at every interval until ctx is done.
+// Failed runs are logged and retried at the next interval.
+func runVoucherReaper(ctx context.Context, state *db.State, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		removed, err := state.ApplyRetention(ctx, time.Now())
+		if err != nil {
+			slog.Error("Error removing vouchers by the retention policy", "removed", removed, "error", err)
+		} else if removed > 0 {
+			slog.Info("Removed vouchers by the retention policy", "removed", removed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -4,6 +4,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -21,32 +22,37 @@ import (
 	"fmt"
 	"golang.org/x/time/rate"
 	"iter"
-	"log"
 	"log/slog"
 	"math/big"
 	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
-	"path"
 	"path/filepath"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
 	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
 	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/deviceinfo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/logging"
+	"github.com/fido-device-onboard/go-fdo-server/internal/ntp"
 	"github.com/fido-device-onboard/go-fdo-server/internal/ownerinfo"
 	"github.com/fido-device-onboard/go-fdo-server/internal/rvinfo"
+	ownersvi "github.com/fido-device-onboard/go-fdo-server/internal/serviceinfo"
 	"github.com/fido-device-onboard/go-fdo-server/internal/to0"
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+	"github.com/fido-device-onboard/go-fdo-server/internal/webhook"
 	"github.com/fido-device-onboard/go-fdo/cbor"
 	"github.com/fido-device-onboard/go-fdo/custom"
-	"github.com/fido-device-onboard/go-fdo/fsim"
 	transport "github.com/fido-device-onboard/go-fdo/http"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/serviceinfo"
@@ -60,25 +66,111 @@ const (
 var serverFlags = flag.NewFlagSet("server", flag.ContinueOnError)
 
 var (
-	useTLS           bool
-	addr             string
-	dbPath           string
-	dbPass           string
-	extAddr          string
-	resaleGUID       string
-	resaleKey        string
-	reuseCred        bool
-	rvBypass         bool
-	downloads        stringList
-	uploadDir        string
-	uploadReqs       stringList
-	insecureTLS      bool
-	serverCertPath   string
-	serverKeyPath    string
-	printOwnerPubKey string
-	importVoucher    string
-	cmdDate          bool
-	wgets            stringList
+	useTLS            bool
+	addr              string
+	mgmtAddr          string
+	plainAddr         string
+	production        bool
+	dbPath            string
+	dbPass            string
+	extAddr           string
+	resaleGUID        string
+	resaleKey         string
+	resaleForce       bool
+	maxResaleDepth    int
+	reuseCred         bool
+	rvBypass          bool
+	downloads         stringList
+	uploadDir         string
+	uploadReqs        stringList
+	insecureTLS       bool
+	serverCertPath    string
+	serverKeyPath     string
+	printOwnerPubKey  string
+	checkOwnerKey     string
+	ownerKeyCheck     string
+	ownerKeySample    int
+	bootstrapOwnerKey string
+	ownerKeyURIs      stringList
+	apiKeys           stringList
+	apiJWTSecret      string
+	webhookURLs       stringList
+	webhookSecret     string
+	webhookAttempts   int
+	webhookBackoff    time.Duration
+	webhookTimeout    time.Duration
+	apiClientCAs      stringList
+	metricsEnabled    bool
+	auditLogFile      string
+	mfgKeyURIs        stringList
+	mfgCertPaths      stringList
+	doctor            bool
+	importVoucher     string
+	exportDeviceCAs   string
+	importDeviceCAs   string
+	cmdDate           bool
+	fdoSysFlags       stringList
+	wgets             stringList
+	voucherConflict   string
+	rvBypassPolicy    string
+	tlsMismatch       string
+	importBatchSize   int
+	voucherCacheSize  int
+	maxMessageSize    int64
+	voucherCBORDir    string
+	deviceSecretDir   string
+	voucherURLAllow   stringList
+	voucherURLTime    time.Duration
+	voucherURLSize    int64
+	caURLAllow        stringList
+	caURLTime         time.Duration
+	caURLSize         int64
+	caSyncURL         string
+	caSyncInterval    time.Duration
+	caSyncPrune       bool
+	voucherMaxAge     time.Duration
+	voucherMaxPerInfo int
+	retentionInterval time.Duration
+	devInfoTrim       bool
+	devInfoMaxLen     int
+	devInfoASCII      bool
+	devInfoPattern    string
+	devInfoAllowlist  bool
+	diReplayWindow    time.Duration
+	trustedMfgCAs     stringList
+	trustedDeviceCAs  stringList
+	deviceCAGrace     time.Duration
+	selfSignedDevices bool
+	deviceCertEKUs    stringList
+	deviceCertAlgs    stringList
+	deviceCertCrit    bool
+	caImportPrints    int
+	requiredModules   stringList
+	modulePriority    stringList
+	moduleFlags       stringList
+	servicePlansPath  string
+	respHeaders       stringList
+	sessionsPerGUID   int
+	maxSessions       int
+	maxSessionsPolicy string
+	to2SessionTTL     time.Duration
+	to0Timeout        time.Duration
+	disableAutoTO0    bool
+	autoExtendImport  bool
+	debugSampleRate   uint64
+	jsonLogsFile      string
+	jsonLogsMaxSize   int64
+	jsonLogsMaxAge    time.Duration
+	jsonLogsBackups   int
+	jsonLogsOnly      bool
+	ntpServer         string
+	ntpMaxSkew        time.Duration
+	generateKey       string
+	generateDeviceCA  string
+	deviceCASubject   string
+	deviceCAValidity  time.Duration
+	outPath           string
+	outCertPath       string
 )
 
 var limiter = rate.NewLimiter(1, 5)
@@ -95,22 +187,108 @@ func (list *stringList) String() string {
 }
 
 func init() {
-	serverFlags.StringVar(&dbPath, "db", "", "SQLite database file path")
+	serverFlags.StringVar(&dbPath, "db", "", "SQLite database file `path`, or :memory: for a database discarded on exit")
 	serverFlags.StringVar(&dbPass, "db-pass", "", "SQLite database encryption-at-rest passphrase")
 	serverFlags.BoolVar(&debug, "debug", debug, "Print HTTP contents")
+	serverFlags.Uint64Var(&debugSampleRate, "debug-sample-rate", 1, "Emit one in every `n` debug logs of high-volume code paths")
+	serverFlags.StringVar(&auditLogFile, "audit-log", "", "Also append audit events of voucher and key operations as JSON lines to the file at `path`")
+	serverFlags.StringVar(&jsonLogsFile, "json-logs-to-file", "", "Also write logs as JSON to the file at `path`, rotating it by size")
+	serverFlags.Int64Var(&jsonLogsMaxSize, "json-logs-max-size", 100<<20, "Rotate the JSON log file once it would exceed `bytes` (0 for no limit)")
+	serverFlags.DurationVar(&jsonLogsMaxAge, "json-logs-max-age", 0, "Remove rotated JSON log files older than `duration` (0 for no limit)")
+	serverFlags.IntVar(&jsonLogsBackups, "json-logs-max-backups", 0, "Number of rotated JSON log files to keep (0 for no limit)")
+	serverFlags.BoolVar(&jsonLogsOnly, "json-logs-only", false, "Write logs only to the JSON log file instead of also to stdout")
+	serverFlags.StringVar(&ntpServer, "ntp-server", "", "Warn at startup if the local clock is skewed from the NTP server at `addr`ess")
+	serverFlags.DurationVar(&ntpMaxSkew, "ntp-max-skew", time.Minute, "Clock skew from the NTP server tolerated before warning")
+	serverFlags.BoolVar(&doctor, "doctor", false, "Diagnose common misconfigurations of the database and flags and exit")
+	serverFlags.BoolVar(&devInfoTrim, "device-info-trim", false, "Trim leading and trailing white space from device info at DI")
+	serverFlags.IntVar(&devInfoMaxLen, "device-info-max-length", 0, "Reject devices at DI whose device info is longer than `n` bytes (0 for no limit)")
+	serverFlags.BoolVar(&devInfoASCII, "device-info-printable", false, "Reject devices at DI whose device info contains characters other than printable ASCII")
+	serverFlags.StringVar(&devInfoPattern, "device-info-pattern", "", "Reject devices at DI whose device info does not match the `regexp`")
+	serverFlags.BoolVar(&devInfoAllowlist, "device-info-allowlist", false, "Reject devices at DI whose device info matches no entry of the allowlist managed at /api/v1/device-info-allowlist")
+	serverFlags.DurationVar(&diReplayWindow, "di-replay-window", 0, "Reject DI requests presenting a device key first presented longer than `duration` ago (0 to accept any)")
 	serverFlags.StringVar(&extAddr, "ext-http", "", "External `addr`ess devices should connect to (default \"127.0.0.1:${LISTEN_PORT}\")")
 	serverFlags.StringVar(&addr, "http", "localhost:8080", "The `addr`ess to listen on")
+	serverFlags.StringVar(&plainAddr, "plain-http", "", "Also serve the FDO protocol over plain HTTP at `addr`ess while -http serves HTTPS (requires -insecure-tls)")
+	serverFlags.Var(&apiKeys, "api-key", "Require management API clients to authenticate, accepting the bearer token in a file as `role=path`, where role is read or admin (flag may be used multiple times)")
+	serverFlags.Var(&webhookURLs, "webhook-url", "POST JSON notifications of TO2 completions and TO0 registration failures to `url` (flag may be used multiple times)")
+	serverFlags.StringVar(&webhookSecret, "webhook-secret", "", "Sign webhook notifications with HMAC-SHA256 using the secret in the file at `path`")
+	serverFlags.IntVar(&webhookAttempts, "webhook-max-attempts", 5, "Number of attempts to deliver a webhook notification")
+	serverFlags.DurationVar(&webhookBackoff, "webhook-retry-backoff", 5*time.Second, "Delay before retrying a webhook notification, doubled for each retry")
+	serverFlags.DurationVar(&webhookTimeout, "webhook-timeout", 10*time.Second, "Timeout of each attempt to deliver a webhook notification")
+	serverFlags.StringVar(&apiJWTSecret, "api-jwt-secret", "", "Require management API clients to authenticate, accepting HS256 JWTs signed with the secret in the file at `path` whose role claim is read or admin")
+	serverFlags.Var(&apiClientCAs, "api-client-ca", "Require management API clients to authenticate, accepting TLS client certificates issued by a CA of the PEM file as `role=path` (requires -insecure-tls, flag may be used multiple times)")
+	serverFlags.StringVar(&mgmtAddr, "mgmt-http", "", "Serve the management API on a separate `addr`ess, leaving only the FDO protocol on -http")
+	serverFlags.BoolVar(&metricsEnabled, "metrics", false, "Serve Prometheus metrics at /metrics alongside the management API")
+	serverFlags.BoolVar(&production, "production", false, "Reject owner info directing devices to loopback, private or link-local addresses")
 	serverFlags.StringVar(&resaleGUID, "resale-guid", "", "Voucher `guid` to extend for resale")
-	serverFlags.StringVar(&resaleKey, "resale-key", "", "The `path` to a PEM-encoded x.509 public key for the next owner")
+	serverFlags.StringVar(&resaleKey, "resale-key", "", "The `path` to a PEM-encoded x.509 public key or certificate for the next owner")
+	serverFlags.BoolVar(&resaleForce, "resale-force", false, "Resell the voucher even if the device has already completed TO2")
+	serverFlags.IntVar(&maxResaleDepth, "max-resale-depth", 0, "Refuse to resell vouchers that already have `n` entries (0 for no limit)")
 	serverFlags.BoolVar(&reuseCred, "reuse-cred", false, "Perform the Credential Reuse Protocol in TO2")
-	serverFlags.BoolVar(&insecureTLS, "insecure-tls", false, "Listen with a self-signed TLS certificate")
-	serverFlags.StringVar(&serverCertPath, "server-cert", "", "Path to server certificate")
-	serverFlags.StringVar(&serverKeyPath, "server-key", "", "Path to server private key")
+	serverFlags.BoolVar(&insecureTLS, "insecure-tls", false, "Listen with TLS, using a self-signed certificate stored in the database unless -server-cert and -server-key are given")
+	serverFlags.StringVar(&serverCertPath, "server-cert", "", "Serve TLS with the certificate at `path` (requires -server-key)")
+	serverFlags.StringVar(&serverKeyPath, "server-key", "", "Serve TLS with the private key at `path` (requires -server-cert)")
+	serverFlags.StringVar(&generateKey, "generate-key", "", "Generate a PKCS#8 PEM private key of `type` (ec256, ec384, rsa2048 or rsa3072) and exit")
+	serverFlags.StringVar(&generateDeviceCA, "generate-device-ca", "", "Generate a device CA private key of `type` and self-signed certificate, write them to -out and -out-cert, and exit")
+	serverFlags.StringVar(&deviceCASubject, "device-ca-subject", "FDO Device CA", "The common `name` of a generated device CA certificate")
+	serverFlags.DurationVar(&deviceCAValidity, "device-ca-validity", 10*365*24*time.Hour, "How long a generated device CA certificate is valid for")
+	serverFlags.StringVar(&outPath, "out", "", "The `path` to write generated keys to (default stdout)")
+	serverFlags.StringVar(&outCertPath, "out-cert", "", "The `path` to write a self-signed certificate for a generated key to")
 	serverFlags.StringVar(&printOwnerPubKey, "print-owner-public", "", "Print owner public key of `type` and exit")
+	serverFlags.StringVar(&bootstrapOwnerKey, "bootstrap-owner-key", "", "Ensure an owner key of `type` exists, generating it only if absent, print its public key and exit")
+	serverFlags.Var(&ownerKeyURIs, "owner-key", "Sign as owner with the key at a key URI, given as `type=uri` such as SECP384R1=file:///etc/fdo/owner.key, instead of the database owner key of type (flag may be used multiple times)")
+	serverFlags.Var(&mfgKeyURIs, "manufacturing-key", "Sign as manufacturer with the key at a key URI, given as `type=uri`, instead of the database manufacturer key of type (flag may be used multiple times)")
+	serverFlags.Var(&mfgCertPaths, "manufacturing-cert", "Certify the -manufacturing-key of a type with the PEM certificate chain at a path, leaf first, given as `type=path`; without it the key is given a new self-signed certificate at each start (flag may be used multiple times)")
+	serverFlags.StringVar(&ownerKeyCheck, "owner-key-check", ownerKeyCheckOff, "What to do at startup when no sampled voucher is owned by an owner key: off, warn or strict (refuse to start)")
+	serverFlags.IntVar(&ownerKeySample, "owner-key-check-sample", 100, "Number of stored vouchers sampled by -owner-key-check")
+	serverFlags.StringVar(&checkOwnerKey, "check-owner-key", "", "Check that the PEM-encoded public key or certificate at `path` matches an owner key and exit")
 	serverFlags.StringVar(&importVoucher, "import-voucher", "", "Import a PEM encoded voucher file at `path`")
+	serverFlags.StringVar(&exportDeviceCAs, "export-device-cas", "", "Write the trusted device CAs to a PEM bundle at `path` and exit")
+	serverFlags.StringVar(&importDeviceCAs, "import-device-cas", "", "Trust the device CAs of a PEM bundle at `path` and exit")
+	serverFlags.BoolVar(&autoExtendImport, "auto-extend-import", false, "Extend imported vouchers still owned by this server's manufacturer key to its owner key")
+	serverFlags.StringVar(&voucherConflict, "voucher-conflict", string(db.RejectConflicts), "How to import a voucher whose GUID is already stored with different contents: reject, overwrite or keep-newer")
+	serverFlags.StringVar(&rvBypassPolicy, "rv-bypass-policy", "warn", "How to import vouchers whose RV info sets RV bypass: allow, warn or reject")
+	serverFlags.StringVar(&tlsMismatch, "rvto2addr-tls-mismatch", "warn", "How to store owner info whose RVTO2Addr protocol does not match the server TLS mode: warn or reject")
+	serverFlags.IntVar(&importBatchSize, "voucher-import-batch-size", db.DefaultImportBatchSize, "Number of imported vouchers to commit per database transaction")
+	serverFlags.IntVar(&voucherCacheSize, "voucher-cache-size", 0, "Number of parsed vouchers to cache for device bundles and facets (0 disables the cache)")
+	serverFlags.StringVar(&voucherCBORDir, "voucher-cbor-dir", "", "Store the CBOR of vouchers as files in the directory at `path` and only their metadata in the database")
+	serverFlags.StringVar(&deviceSecretDir, "verify-voucher-hmac", "", "Reject imported vouchers whose header HMAC does not validate with the device secret in the file <guid>.secret of the directory at `path`")
+	serverFlags.Int64Var(&maxMessageSize, "max-message-size", api.DefaultMaxMessageSize, "Maximum size in `bytes` of FDO protocol message bodies (0 for no limit)")
+	serverFlags.Var(&voucherURLAllow, "voucher-url-allow", "Allow importing vouchers by URL from `host` (flag may be used multiple times)")
+	serverFlags.DurationVar(&voucherURLTime, "voucher-url-timeout", 30*time.Second, "Timeout for fetching a voucher imported by URL")
+	serverFlags.Int64Var(&voucherURLSize, "voucher-url-max-size", 1<<20, "Maximum size in `bytes` of a voucher imported by URL")
+	serverFlags.Var(&caURLAllow, "ca-url-allow", "Allow importing CA bundles by URL from `host` (flag may be used multiple times)")
+	serverFlags.DurationVar(&caURLTime, "ca-url-timeout", 30*time.Second, "Timeout for fetching a CA bundle imported by URL")
+	serverFlags.Int64Var(&caURLSize, "ca-url-max-size", 1<<20, "Maximum size in `bytes` of a CA bundle imported by URL")
+	serverFlags.StringVar(&caSyncURL, "device-ca-sync-url", "", "Periodically sync trusted device CAs with the PEM bundle at `url`")
+	serverFlags.DurationVar(&caSyncInterval, "device-ca-sync-interval", time.Hour, "Interval between syncs of trusted device CAs")
+	serverFlags.BoolVar(&caSyncPrune, "device-ca-sync-prune", false, "Stop trusting device CAs that are no longer in the synced bundle")
+	serverFlags.DurationVar(&voucherMaxAge, "voucher-max-age", 0, "Remove owner vouchers stored longer ago than `duration` (0 keeps them forever)")
+	serverFlags.IntVar(&voucherMaxPerInfo, "voucher-max-per-device-info", 0, "Keep only the newest `n` owner vouchers of each device info (0 keeps all)")
+	serverFlags.DurationVar(&retentionInterval, "voucher-retention-interval", time.Hour, "Interval between removals of owner vouchers by the retention policy")
+	serverFlags.Var(&trustedMfgCAs, "trust-manufacturer-ca", "Only import vouchers whose manufacturer chains to a CA certificate in the PEM `file` (flag may be used multiple times)")
+	serverFlags.Var(&trustedDeviceCAs, "trust-device-ca", "Only import vouchers whose device certificate chains to a CA certificate in the PEM `file` (flag may be used multiple times)")
+	serverFlags.BoolVar(&selfSignedDevices, "allow-self-signed-device-certs", false, "INSECURE: accept imported vouchers whose device certificate is self-signed instead of issued by a trusted device CA, for test devices only")
+	serverFlags.DurationVar(&deviceCAGrace, "device-ca-grace", 0, "Still accept device certificates of trusted device CAs that expired less than `duration` ago, during CA rotations")
+	serverFlags.Var(&deviceCertEKUs, "device-cert-key-usage", "Accept device certificates with the extended key `usage` (any, server-auth, client-auth, ...) instead of requiring server-auth (flag may be used multiple times)")
+	serverFlags.Var(&deviceCertAlgs, "device-cert-signature-alg", "Only accept device certificate chains signed with the `algorithm`, such as ECDSA-SHA256 (flag may be used multiple times)")
+	serverFlags.BoolVar(&deviceCertCrit, "device-cert-ignore-critical-ext", false, "Accept device certificates with unhandled critical extensions")
+	serverFlags.IntVar(&caImportPrints, "ca-import-fingerprints", handlers.DefaultCAImportFingerprints, "Maximum number of fingerprints listed in trusted CA import responses (0 for counts only, -1 for no limit)")
 	serverFlags.BoolVar(&cmdDate, "command-date", false, "Use fdo.command FSIM to have device run \"date --utc\"")
-	serverFlags.Var(&downloads, "download", "Use fdo.download FSIM for each `file` (flag may be used multiple times)")
+	serverFlags.Var(&fdoSysFlags, "fdo-sys", "Use fdo_sys FSIM for each `step`, in order: write:<file>[=<device file>], exec:<command> or exec_cb:<command> (flag may be used multiple times)")
+	serverFlags.Var(&downloads, "download", "Use fdo.download FSIM for each `file`, where {{.GUID}} or {{.ReplacementGUID}} in the path selects a file per device (flag may be used multiple times)")
 	serverFlags.StringVar(&uploadDir, "upload-dir", "uploads", "The directory `path` to put file uploads")
+	serverFlags.Var(&requiredModules, "require-module", "Fail onboarding of devices that do not support the service info `module` (flag may be used multiple times)")
+	serverFlags.StringVar(&servicePlansPath, "serviceinfo-plans", "", "JSON `file` of service info plans, each sending its own operations to the devices its rules match instead of those of the service info flags")
+	serverFlags.Var(&moduleFlags, "module", "Run the registered custom service info `module` on devices that support it, given as name or name=config (flag may be used multiple times)")
+	serverFlags.Var(&modulePriority, "module-priority", "Send the operations of a service info module before those of modules with lower priority, given as `module=priority` (default 0, flag may be used multiple times)")
+	serverFlags.Var(&respHeaders, "response-header", "Set the HTTP header `name:value` on every response, replacing the default security header of the same name (an empty value removes it, flag may be used multiple times)")
+	serverFlags.IntVar(&sessionsPerGUID, "sessions-per-guid", 0, "Maximum number of concurrent TO2 sessions of a device GUID (0 for no limit)")
+	serverFlags.IntVar(&maxSessions, "max-sessions", 0, "Maximum number of protocol sessions stored at once (0 for no limit)")
+	serverFlags.StringVar(&maxSessionsPolicy, "max-sessions-policy", sessionCapReject, "What to do with new sessions at -max-sessions: reject or evict-oldest")
+	serverFlags.DurationVar(&to2SessionTTL, "to2-session-ttl", 0, "End TO2 sessions older than `duration` on their next message (0 for no limit)")
+	serverFlags.BoolVar(&disableAutoTO0, "disable-auto-to0", false, "Do not register RV blobs of devices after DI, leaving TO0 to an external registrar")
+	serverFlags.DurationVar(&to0Timeout, "to0-timeout", 0, "Timeout of TO0 registration at each rendezvous server before trying the next one (0 for no timeout)")
 	serverFlags.Var(&uploadReqs, "upload", "Use fdo.upload FSIM for each `file` (flag may be used multiple times)")
 	serverFlags.Var(&wgets, "wget", "Use fdo.wget FSIM for each `url` (flag may be used multiple times)")
 
@@ -123,6 +301,15 @@ type Server struct {
 	handler http.Handler
 	useTLS  bool
 	state   *sqlite.DB
+
+	// mgmtAddr and mgmtHandler serve the management API on a separate
+	// listener, if set
+	mgmtAddr    string
+	mgmtHandler http.Handler
+
+	// plainAddr serves plainHandler over HTTP alongside TLS, if set
+	plainAddr    string
+	plainHandler http.Handler
 }
 
 // NewServer creates a new Server
@@ -130,38 +317,39 @@ func NewServer(addr string, extAddr string, handler http.Handler, useTLS bool, s
 	return &Server{addr: addr, extAddr: extAddr, handler: handler, useTLS: useTLS, state: state}
 }
 
-// Start starts the HTTP server
-func (s *Server) Start() error {
-	srv := &http.Server{
-		Handler:           s.handler,
-		ReadHeaderTimeout: 3 * time.Second,
-	}
-
-	// Channel to listen for interrupt or terminate signals
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-
-	// Goroutine to listen for signals and gracefully shut down the server
-	go func() {
-		<-stop
-		slog.Debug("Shutting down server...")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// WithManagement serves handler on a separate listener at addr.
+func (s *Server) WithManagement(addr string, handler http.Handler) *Server {
+	s.mgmtAddr, s.mgmtHandler = addr, handler
+	return s
+}
 
-		if err := srv.Shutdown(ctx); err != nil {
-			slog.Debug("Server forced to shutdown:", "err", err)
-		}
-	}()
+// WithPlainHTTP also serves the FDO protocol handler without TLS on a
+// separate listener at addr, so that devices can move to HTTPS gradually.
+func (s *Server) WithPlainHTTP(addr string, handler http.Handler) *Server {
+	s.plainAddr, s.plainHandler = addr, handler
+	return s
+}
 
-	// Listen and serve
-	lis, err := net.Listen("tcp", s.addr)
-	if err != nil {
-		return err
+// Start starts the HTTP server, and the management and plain HTTP servers if
+// configured. All of them shut down together on an interrupt or terminate
+// signal, or when any stops serving.
+func (s *Server) Start() error {
+	type listener struct {
+		name    string
+		addr    string
+		handler http.Handler
+		plain   bool
+		mgmt    bool
+	}
+	listeners := []listener{{"FDO", s.addr, s.handler, false, s.mgmtAddr == ""}}
+	if s.mgmtAddr != "" {
+		listeners = append(listeners, listener{"management", s.mgmtAddr, s.mgmtHandler, false, true})
+	}
+	if s.plainAddr != "" {
+		listeners = append(listeners, listener{"FDO", s.plainAddr, s.plainHandler, true, false})
 	}
-	defer func() { _ = lis.Close() }()
-	slog.Info("Listening", "local", lis.Addr().String(), "external", s.extAddr)
 
+	var tlsConfig *tls.Config
 	if s.useTLS {
 
 		preferredCipherSuites := []uint16{
@@ -171,60 +359,294 @@ func (s *Server) Start() error {
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, // TLS v1.2
 		}
 
-		if serverCertPath != "" && serverKeyPath != "" {
-			srv.TLSConfig = &tls.Config{
-				MinVersion:   tls.VersionTLS12,
-				CipherSuites: preferredCipherSuites,
+		// Without state, the certificate must come from -server-cert
+		var stateDB *sql.DB
+		if s.state != nil {
+			stateDB = s.state.DB()
+		}
+		cert, err := serverCertificate(stateDB)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+			CipherSuites: preferredCipherSuites,
+		}
+	}
+
+	// Listen on every address before serving, so that a bad address fails
+	// startup
+	servers := make([]*http.Server, len(listeners))
+	listens := make([]net.Listener, len(listeners))
+	serveTLS := make([]bool, len(listeners))
+	for i, l := range listeners {
+		lis, err := net.Listen("tcp", l.addr)
+		if err != nil {
+			for _, lis := range listens[:i] {
+				_ = lis.Close()
 			}
-			return srv.ServeTLS(lis, serverCertPath, serverKeyPath)
+			return err
+		}
+		defer func() { _ = lis.Close() }()
+		listens[i] = lis
+		servers[i] = &http.Server{
+			Handler:           l.handler,
+			ReadHeaderTimeout: 3 * time.Second,
+		}
+		if tlsConfig != nil && !l.plain {
+			servers[i].TLSConfig = tlsConfig
+			serveTLS[i] = true
+			// Client certificates are verified per role by the management
+			// API rather than in the handshake
+			if l.mgmt && api.ClientCertsEnabled() {
+				servers[i].TLSConfig = tlsConfig.Clone()
+				servers[i].TLSConfig.ClientAuth = tls.RequestClientCert
+			}
+		}
+		if l.plain {
+			slog.Info("Listening", "local", lis.Addr().String(), "scheme", "http")
+		} else if l.name == "FDO" {
+			slog.Info("Listening", "local", lis.Addr().String(), "external", s.extAddr)
 		} else {
-			cert, err := tlsCert(s.state.DB())
-			if err != nil {
-				return err
+			slog.Info("Listening", "local", lis.Addr().String(), "api", l.name)
+		}
+	}
+
+	// Channel to listen for interrupt or terminate signals
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	var once sync.Once
+	shutdown := func() {
+		once.Do(func() {
+			slog.Debug("Shutting down server...")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for _, srv := range servers {
+				if err := srv.Shutdown(ctx); err != nil {
+					slog.Debug("Server forced to shutdown:", "err", err)
+				}
 			}
-			srv.TLSConfig = &tls.Config{
-				MinVersion:   tls.VersionTLS12,
-				Certificates: []tls.Certificate{*cert},
-				CipherSuites: preferredCipherSuites,
+		})
+	}
+
+	// Goroutine to listen for signals and gracefully shut down the servers
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			shutdown()
+		case <-done:
+		}
+	}()
+
+	errs := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func() {
+			if serveTLS[i] {
+				errs <- srv.ServeTLS(listens[i], "", "")
+			} else {
+				errs <- srv.Serve(listens[i])
 			}
-			return srv.ServeTLS(lis, "", "")
+		}()
+	}
 
+	// The first server to stop, by a signal or an error, stops the others
+	err := <-errs
+	shutdown()
+	for range servers[1:] {
+		<-errs
+	}
+	return err
+}
+
+// setDeviceCertVerifyOptions relaxes or restricts the verification of device
+// certificate chains as configured. Without any option they are verified
+// strictly.
+func setDeviceCertVerifyOptions() error {
+	if len(deviceCertEKUs) == 0 && len(deviceCertAlgs) == 0 && !deviceCertCrit {
+		return nil
+	}
+	opts := &db.DeviceCertVerifyOptions{IgnoreCriticalExtensions: deviceCertCrit}
+	for _, name := range deviceCertEKUs {
+		usage, err := db.ParseExtKeyUsage(name)
+		if err != nil {
+			return err
 		}
+		opts.KeyUsages = append(opts.KeyUsages, usage)
 	}
-	return srv.Serve(lis)
+	for _, name := range deviceCertAlgs {
+		alg, err := db.ParseSignatureAlgorithm(name)
+		if err != nil {
+			return err
+		}
+		opts.SignatureAlgorithms = append(opts.SignatureAlgorithms, alg)
+	}
+	db.SetDeviceCertVerifyOptions(opts)
+	return nil
 }
 
 func server() error { //nolint:gocyclo
 	if debug {
 		level.Set(slog.LevelDebug)
 	}
+	logging.SetDebugSampleRate(debugSampleRate)
+	if jsonLogsFile != "" {
+		closeLogs, err := setupJSONLogs(jsonLogsFile, jsonLogsMaxSize, jsonLogsMaxAge, jsonLogsBackups, jsonLogsOnly)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = closeLogs() }()
+	}
+	if auditLogFile != "" {
+		closeAuditLog, err := setupAuditLog(auditLogFile)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = closeAuditLog() }()
+	}
+	if ntpServer != "" {
+		if _, err := ntp.CheckClock(ntpServer, ntpMaxSkew, 5*time.Second); err != nil {
+			slog.Warn("Unable to check the local clock", "error", err)
+		}
+	}
+
+	// If generating a key, do so and exit
+	if generateKey != "" {
+		return doGenerateKey()
+	}
+	if generateDeviceCA != "" {
+		return doGenerateDeviceCA()
+	}
 
 	if dbPath == "" {
 		return errors.New("db flag is required")
 	}
 
-	if dbPass == "" {
-		return errors.New("db password is empty")
+	// In-memory databases are not encrypted at rest
+	if dbPath != inMemoryDB {
+		if dbPass == "" {
+			return errors.New("db password is empty")
+		}
+
+		if err := validatePassword(dbPass); err != nil {
+			return err
+		}
 	}
 
-	err := validatePassword(dbPass)
+	conflictPolicy, err := db.ParseVoucherConflictPolicy(voucherConflict)
 	if err != nil {
 		return err
 	}
+	db.SetVoucherConflictPolicy(conflictPolicy)
+	bypassPolicy, err := db.ParseRVBypassPolicy(rvBypassPolicy)
+	if err != nil {
+		return err
+	}
+	db.SetRVBypassPolicy(bypassPolicy)
+	mismatchPolicy, err := ownerinfo.ParseTLSMismatchPolicy(tlsMismatch)
+	if err != nil {
+		return err
+	}
+	ownerinfo.SetTLSMismatchPolicy(mismatchPolicy)
+	if modulePriorities, err = parseModulePriorities(modulePriority); err != nil {
+		return err
+	}
+	if customModules, err = parseCustomModules(moduleFlags); err != nil {
+		return err
+	}
+	if sysSteps, err = parseSysSteps(fdoSysFlags); err != nil {
+		return err
+	}
+	if servicePlansPath != "" {
+		if servicePlans, err = loadServicePlans(servicePlansPath); err != nil {
+			return err
+		}
+	}
+	if err := setManagementAuth(); err != nil {
+		return err
+	}
+	if err := setupWebhooks(); err != nil {
+		return err
+	}
+	db.SetImportBatchSize(importBatchSize)
+	db.SetDeviceCAGracePeriod(deviceCAGrace)
+	db.SetAllowSelfSignedDeviceCerts(selfSignedDevices)
+	if selfSignedDevices {
+		slog.Warn("INSECURE: self-signed device certificates are accepted on voucher import, do not use in production")
+	}
+	if err := setDeviceCertVerifyOptions(); err != nil {
+		return err
+	}
+	db.SetVoucherCacheSize(voucherCacheSize)
+	api.SetMaxMessageSize(maxMessageSize)
+	api.SetMetricsEnabled(metricsEnabled)
+	for _, header := range respHeaders {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("invalid response header %q: must be name:value", header)
+		}
+		api.SetResponseHeader(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if voucherCBORDir != "" {
+		db.SetVoucherCBORStore(db.DirVoucherCBORStore(voucherCBORDir))
+	}
+	if deviceSecretDir != "" {
+		db.SetDeviceSecretSource(db.DirDeviceSecretSource(deviceSecretDir))
+	}
+	handlers.SetVoucherFetchConfig(voucherURLTime, voucherURLSize, voucherURLAllow)
+	handlers.SetCAImportFingerprints(caImportPrints)
+	handlers.SetCAFetchConfig(caURLTime, caURLSize, caURLAllow)
+
+	devInfoPolicy := deviceinfo.Policy{
+		TrimSpace:      devInfoTrim,
+		MaxLength:      devInfoMaxLen,
+		PrintableASCII: devInfoASCII,
+	}
+	if devInfoPattern != "" {
+		if devInfoPolicy.Pattern, err = regexp.Compile(devInfoPattern); err != nil {
+			return fmt.Errorf("invalid device info pattern: %w", err)
+		}
+	}
+	deviceinfo.SetPolicy(devInfoPolicy)
+	db.SetDeviceInfoAllowlistEnforced(devInfoAllowlist)
+	db.SetDIReplayWindow(diReplayWindow)
+	db.SetRetentionPolicy(db.RetentionPolicy{MaxAge: voucherMaxAge, MaxPerDeviceInfo: voucherMaxPerInfo})
 
-	state, err := sqlite.Open(dbPath, dbPass)
+	state, err := openDatabase(dbPath, dbPass)
 
 	if err != nil {
 		return err
 	}
+	keys, err := newExternalKeys(state, ownerKeyURIs, mfgKeyURIs, mfgCertPaths)
+	if err != nil {
+		return err
+	}
+	to0.SetOwnerKeys(keys)
+	api.SetOwnerKeys(keys)
+	api.SetMaxResaleDepth(maxResaleDepth)
+	// If bootstrapping an owner key, do so and exit
+	if bootstrapOwnerKey != "" {
+		return doBootstrapOwnerKey(state)
+	}
 	// If printing owner public key, do so and exit
 	if printOwnerPubKey != "" {
-		return doPrintOwnerPubKey(state)
+		return doPrintOwnerPubKey(keys)
+	}
+
+	// If checking an owner key, do so and exit
+	if checkOwnerKey != "" {
+		return doCheckOwnerKey(keys)
 	}
 
 	// If importing a voucher, do so and exit
 	if importVoucher != "" {
-		return doImportVoucher(state)
+		return doImportVoucher(keys)
 	}
 	useTLS = insecureTLS
 
@@ -243,13 +665,42 @@ func server() error { //nolint:gocyclo
 	}
 	port := uint16(portNum)
 
+	// Devices reach the plain HTTP listener at the external host
+	var plainPort uint16
+	if plainAddr != "" {
+		_, plainPortStr, err := net.SplitHostPort(plainAddr)
+		if err != nil {
+			return fmt.Errorf("invalid plain HTTP addr: %w", err)
+		}
+		plainPortNum, err := strconv.ParseUint(plainPortStr, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid plain HTTP port: %w", err)
+		}
+		plainPort = uint16(plainPortNum)
+	}
+
 	err = db.InitDb(state)
 	if err != nil {
 		return err
 	}
+	if err := addTrustedCAs(); err != nil {
+		return err
+	}
+
+	// If exporting or importing device CAs, do so and exit
+	if exportDeviceCAs != "" {
+		return doExportDeviceCAs()
+	}
+	if importDeviceCAs != "" {
+		return doImportDeviceCAs()
+	}
 
 	// set tls for TO0
 	to0.SetTo0Tls(useTLS)
+	to0.SetTo0Timeout(to0Timeout)
+	ownerinfo.SetServerTLS(useTLS)
+	ownerinfo.SetServerPlainHTTP(plainAddr != "")
+	ownerinfo.SetProductionMode(production)
 
 	// Retrieve RV info from DB
 	rvInfo, err := rvinfo.FetchRvInfo()
@@ -257,6 +708,11 @@ func server() error { //nolint:gocyclo
 		return err
 	}
 
+	// If diagnosing the configuration, do so and exit
+	if doctor {
+		return doDoctor(keys, rvInfo, host)
+	}
+
 	if rvInfo != nil {
 		rvBypass = rvinfo.HasRVBypass(rvInfo)
 	} else {
@@ -269,68 +725,130 @@ func server() error { //nolint:gocyclo
 		if err != nil {
 			return err
 		}
+		if plainPort != 0 {
+			plainRvInfo, err := rvinfo.CreateRvInfo(false, host, plainPort)
+			if err != nil {
+				return err
+			}
+			rvInfo = append(rvInfo, plainRvInfo...)
+		}
 	}
 
 	// CreateRvTO2Addr initializes new owner info and stores it with default values if not found in DB
-	err = ownerinfo.CreateRvTO2Addr(host, port, useTLS)
+	err = ownerinfo.CreateRvTO2Addr(host, port, useTLS, plainPort)
 	if err != nil {
 		return fmt.Errorf("failed to create and store rvTO2Addrs: %v", err)
 	}
+	// Owner info stored by an earlier run may not match the current TLS mode,
+	// and the default owner info may not be reachable in production
+	if rvTO2Addrs, err := ownerinfo.FetchOwnerInfo(); err == nil {
+		if _, err := ownerinfo.CheckTransportProtocols(rvTO2Addrs); err != nil {
+			return err
+		}
+		if err := ownerinfo.CheckReachableAddresses(rvTO2Addrs); err != nil {
+			return fmt.Errorf("%w (set -ext-http or update the owner info)", err)
+		}
+	}
 
 	// Invoke resale protocol if a GUID is specified
 	if resaleGUID != "" {
-		return resell(state)
+		return resell(keys)
 	}
 
-	return serveHTTP(rvInfo, state)
+	if autoExtendImport {
+		handlers.SetImportExtender(func(ov *fdo.Voucher) (*fdo.Voucher, error) {
+			return extendImport(keys, ov)
+		})
+	}
+
+	if caSyncURL != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go handlers.CASync{
+			Store:    db.TrustedDeviceCAs,
+			URL:      caSyncURL,
+			Interval: caSyncInterval,
+			Prune:    caSyncPrune,
+		}.Run(ctx)
+	}
+
+	if voucherMaxAge > 0 || voucherMaxPerInfo > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runVoucherReaper(ctx, db.NewState(state), retentionInterval)
+	}
+
+	return serveHTTP(rvInfo, keys)
 }
 
 type ServerState struct {
 	RvInfo [][]protocol.RvInstruction
 	DB     *sqlite.DB
+	// Keys serves the owner and manufacturer keys, which may be opened from
+	// key URIs rather than the database
+	Keys *externalKeys
 }
 
-func serveHTTP(rvInfo [][]protocol.RvInstruction, db *sqlite.DB) error {
+func serveHTTP(rvInfo [][]protocol.RvInstruction, keys *externalKeys) error {
 	state := &ServerState{
 		RvInfo: rvInfo,
-		DB:     db,
+		DB:     keys.DB,
+		Keys:   keys,
 	}
 	// Create FDO responder
 	handler, err := newHandler(state)
 	if err != nil {
 		return err
 	}
+	if err := doOwnerKeyCheck(state.Keys.OwnerKey, ownerKeyCheck, ownerKeySample); err != nil {
+		return err
+	}
 
 	// Handle messages
-	httpHandler := api.NewHTTPHandler(handler, &state.RvInfo, state.DB).RegisterRoutes()
+	routes := api.NewHTTPHandler(handler, &state.RvInfo, state.DB)
 	// Listen and serve
-	server := NewServer(addr, extAddr, httpHandler, useTLS, state.DB)
+	server := newServer(routes, state.DB)
+
+	if !api.AuthEnabled() {
+		slog.Warn("The management API is not authenticated, use -api-key, -api-jwt-secret or -api-client-ca to require credentials")
+	}
 
 	slog.Debug("Starting server on:", "addr", addr)
 	return server.Start()
 
 }
 
-func doPrintOwnerPubKey(state *sqlite.DB) error {
+// newServer returns a server of routes. The management API is served on
+// -mgmt-http if set and alongside the FDO protocol otherwise, but never on the
+// plain HTTP listener of -plain-http.
+func newServer(routes *api.HTTPHandler, state *sqlite.DB) *Server {
+	var server *Server
+	if mgmtAddr == "" {
+		server = NewServer(addr, extAddr, api.ResponseHeaderMiddleware(routes.RegisterRoutes()), useTLS, state)
+	} else {
+		server = NewServer(addr, extAddr, api.ResponseHeaderMiddleware(routes.ProtocolRoutes()), useTLS, state).
+			WithManagement(mgmtAddr, api.ResponseHeaderMiddleware(routes.ManagementRoutes()))
+	}
+
+	if plainAddr != "" {
+		server.WithPlainHTTP(plainAddr, api.ResponseHeaderMiddleware(routes.ProtocolRoutes()))
+	}
+	return server
+}
+
+func doPrintOwnerPubKey(keys *externalKeys) error {
 	keyType, err := protocol.ParseKeyType(printOwnerPubKey)
 	if err != nil {
 		return fmt.Errorf("%w: see usage", err)
 	}
-	key, _, err := state.OwnerKey(keyType)
+	key, _, err := keys.OwnerKey(keyType)
 	if err != nil {
 		return err
 	}
-	der, err := x509.MarshalPKIXPublicKey(key.Public())
-	if err != nil {
-		return err
-	}
-	return pem.Encode(os.Stdout, &pem.Block{
-		Type:  "PUBLIC KEY",
-		Bytes: der,
-	})
+	return writePublicKeyPEM(os.Stdout, key.Public())
 }
 
-func doImportVoucher(state *sqlite.DB) error {
+func doImportVoucher(keys *externalKeys) error {
 	// Parse voucher
 	pemVoucher, err := os.ReadFile(filepath.Clean(importVoucher))
 	if err != nil {
@@ -353,19 +871,186 @@ func doImportVoucher(state *sqlite.DB) error {
 	if err != nil {
 		return fmt.Errorf("error parsing owner public key from voucher: %w", err)
 	}
-	ownerKey, _, err := state.OwnerKey(ov.Header.Val.ManufacturerKey.Type)
+	ownerKey, _, err := keys.OwnerKey(ov.Header.Val.ManufacturerKey.Type)
 	if err != nil {
 		return fmt.Errorf("error getting owner key: %w", err)
 	}
+	ovBytes := blk.Bytes
+	var extendedDetail string
 	if !ownerKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(expectedPubKey) {
-		return fmt.Errorf("owner key in database does not match the owner of the voucher")
+		if !autoExtendImport {
+			return fmt.Errorf("owner key in database does not match the owner of the voucher")
+		}
+		extended, err := extendToOwner(keys, &ov, expectedPubKey, ownerKey)
+		if err != nil {
+			return err
+		}
+		if ovBytes, err = cbor.Marshal(extended); err != nil {
+			return fmt.Errorf("error marshaling extended voucher: %w", err)
+		}
+		if extendedDetail, err = audit.ExtensionDetail(expectedPubKey, ownerKey.Public()); err != nil {
+			return err
+		}
+		slog.Info("Extended imported voucher to owner key", "guid", hex.EncodeToString(ov.Header.Val.GUID[:]), "extension", extendedDetail)
 	}
 
 	// Store voucher
-	return state.AddVoucher(context.Background(), &ov)
+	if err := db.InitDb(keys.DB); err != nil {
+		return err
+	}
+	if err := addTrustedCAs(); err != nil {
+		return err
+	}
+	ctx := cliContext()
+	if _, err := db.ImportVoucher(db.Voucher{GUID: ov.Header.Val.GUID[:], CBOR: ovBytes}); err != nil {
+		audit.Record(ctx, audit.VoucherImported, ov.Header.Val.GUID[:], err, "")
+		return fmt.Errorf("error storing voucher: %w", err)
+	}
+	audit.Record(ctx, audit.VoucherImported, ov.Header.Val.GUID[:], nil, "")
+	if extendedDetail != "" {
+		audit.Record(ctx, audit.VoucherExtended, ov.Header.Val.GUID[:], nil, extendedDetail)
+		if err := db.RecordDeviceEvent(ov.Header.Val.GUID[:], db.VoucherExtendedEvent, extendedDetail); err != nil {
+			slog.Debug("Error recording voucher extension", "guid", hex.EncodeToString(ov.Header.Val.GUID[:]), "error", err)
+		}
+	}
+	return nil
+}
+
+// addTrustedCAs stores the CA certificates given by -trust-manufacturer-ca and
+// -trust-device-ca. Once any CA of a kind is trusted, vouchers that do not
+// chain to one are rejected at import.
+func addTrustedCAs() error {
+	for _, trusted := range []struct {
+		kind  string
+		store db.TrustedCertStore
+		paths []string
+	}{
+		{"manufacturer", db.TrustedManufacturerCAs, trustedMfgCAs},
+		{"device", db.TrustedDeviceCAs, trustedDeviceCAs},
+	} {
+		for _, path := range trusted.paths {
+			if _, err := importTrustedCAFile(trusted.kind, trusted.store, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importTrustedCAFile trusts the CA certificates of a PEM file and returns how
+// many were not trusted before.
+func importTrustedCAFile(kind string, store db.TrustedCertStore, path string) (int, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return 0, fmt.Errorf("error reading %s CA file: %w", kind, err)
+	}
+	var found bool
+	var imported int
+	for blk, rest := pem.Decode(data); blk != nil; blk, rest = pem.Decode(rest) {
+		if blk.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return 0, fmt.Errorf("error parsing %s CA %s: %w", kind, path, err)
+		}
+		inserted, err := store.Insert(cert)
+		if err != nil {
+			return 0, fmt.Errorf("error storing %s CA %s: %w", kind, path, err)
+		}
+		if inserted {
+			imported++
+		}
+		found = true
+	}
+	if !found {
+		return 0, fmt.Errorf("no certificate found in %s CA file: %s", kind, path)
+	}
+	return imported, nil
+}
+
+// exportTrustedCAFile writes the CA certificates of a store to a PEM file and
+// returns how many were written.
+func exportTrustedCAFile(store db.TrustedCertStore, path string) (int, error) {
+	certs, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	var bundle bytes.Buffer
+	for _, cert := range certs {
+		if err := pem.Encode(&bundle, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+			return 0, err
+		}
+	}
+	if err := os.WriteFile(filepath.Clean(path), bundle.Bytes(), 0o644); err != nil {
+		return 0, err
+	}
+	return len(certs), nil
+}
+
+func doExportDeviceCAs() error {
+	n, err := exportTrustedCAFile(db.TrustedDeviceCAs, exportDeviceCAs)
+	if err != nil {
+		return fmt.Errorf("error exporting device CAs: %w", err)
+	}
+	fmt.Printf("Exported %d device CAs to %s\n", n, exportDeviceCAs)
+	return nil
+}
+
+func doImportDeviceCAs() error {
+	n, err := importTrustedCAFile("device", db.TrustedDeviceCAs, importDeviceCAs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d new device CAs from %s\n", n, importDeviceCAs)
+	return nil
+}
+
+// extendToOwner extends a voucher that is still owned by the manufacturer key
+// of this server to its owner key. This only applies to deployments where the
+// manufacturer and owner share a database.
+func extendToOwner(keys *externalKeys, ov *fdo.Voucher, voucherOwner crypto.PublicKey, ownerKey crypto.Signer) (*fdo.Voucher, error) {
+	mfgKey, _, err := keys.ManufacturerKey(ov.Header.Val.ManufacturerKey.Type)
+	if err != nil {
+		return nil, fmt.Errorf("error getting manufacturer key: %w", err)
+	}
+	if !mfgKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(voucherOwner) {
+		return nil, fmt.Errorf("owner key in database does not match the owner of the voucher")
+	}
+	var extended *fdo.Voucher
+	switch ownerPub := ownerKey.Public().(type) {
+	case *ecdsa.PublicKey:
+		extended, err = fdo.ExtendVoucher(ov, mfgKey, ownerPub, nil)
+	case *rsa.PublicKey:
+		extended, err = fdo.ExtendVoucher(ov, mfgKey, ownerPub, nil)
+	default:
+		err = fmt.Errorf("unsupported key type: %T", ownerPub)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error extending voucher to owner key: %w", err)
+	}
+	return extended, nil
 }
 
-func resell(state *sqlite.DB) error {
+// extendImport extends a voucher imported through the management API to the
+// owner key when it is still owned by the manufacturer key of this server.
+// Vouchers already owned by the owner key are returned as they are.
+func extendImport(keys *externalKeys, ov *fdo.Voucher) (*fdo.Voucher, error) {
+	voucherOwner, err := ov.OwnerPublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing owner public key from voucher: %w", err)
+	}
+	ownerKey, _, err := keys.OwnerKey(ov.Header.Val.ManufacturerKey.Type)
+	if err != nil {
+		return nil, fmt.Errorf("error getting owner key: %w", err)
+	}
+	if ownerKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(voucherOwner) {
+		return ov, nil
+	}
+	return extendToOwner(keys, ov, voucherOwner, ownerKey)
+}
+
+func resell(keys *externalKeys) error {
 	// Parse resale-guid flag
 	guidBytes, err := hex.DecodeString(strings.ReplaceAll(resaleGUID, "-", ""))
 	if err != nil {
@@ -376,32 +1061,62 @@ func resell(state *sqlite.DB) error {
 	}
 	var guid protocol.GUID
 	copy(guid[:], guidBytes)
+	if err := checkResale(defaultResaleStore, guid[:], resaleForce); err != nil {
+		return err
+	}
 
 	// Parse next owner key
 	if resaleKey == "" {
 		return fmt.Errorf("resale-guid depends on resale-key flag being set")
 	}
-	keyBytes, err := os.ReadFile(filepath.Clean(resaleKey))
+	nextOwner, err := loadPublicKeyPEM(resaleKey)
 	if err != nil {
 		return fmt.Errorf("error reading next owner key file: %w", err)
 	}
-	blk, _ := pem.Decode(keyBytes)
-	if blk == nil {
-		return fmt.Errorf("invalid PEM file: %s", resaleKey)
+	// Operators compare this with the fingerprint printed by the next owner's
+	// -print-owner-public to catch extending vouchers to the wrong key
+	fingerprint, err := utils.PublicKeyFingerprint(nextOwner)
+	if err != nil {
+		return err
 	}
-	nextOwner, err := x509.ParsePKIXPublicKey(blk.Bytes)
+	fmt.Fprintf(os.Stderr, "Next owner key SHA-256 fingerprint: %s\n", fingerprint)
+
+	// The current owner key of the voucher signs the extension
+	voucher, err := db.FetchVoucher(guid[:])
 	if err != nil {
-		return fmt.Errorf("error parsing x.509 public key: %w", err)
+		return fmt.Errorf("error fetching voucher to resell: %w", err)
+	}
+	ov, err := db.ParseVoucher(voucher)
+	if err != nil {
+		return err
+	}
+	if err := checkResaleDepth(guid[:], len(ov.Entries), maxResaleDepth); err != nil {
+		return err
+	}
+	ownerPub, err := ov.OwnerPublicKey()
+	if err != nil {
+		return fmt.Errorf("error parsing owner public key from voucher: %w", err)
+	}
+
+	detail, err := audit.ExtensionDetail(ownerPub, nextOwner)
+	if err != nil {
+		return err
 	}
 
 	// Perform resale protocol
+	ctx := cliContext()
 	extended, err := (&fdo.TO2Server{
-		Vouchers:  state,
-		OwnerKeys: state,
-	}).Resell(context.TODO(), guid, nextOwner, nil)
+		Vouchers:  db.OwnerVouchers(keys.DB),
+		OwnerKeys: keys,
+	}).Resell(ctx, guid, nextOwner, nil)
+	audit.Record(ctx, audit.VoucherResold, guid[:], err, detail)
 	if err != nil {
 		return fmt.Errorf("resale protocol: %w", err)
 	}
+	fmt.Fprintf(os.Stderr, "Voucher %s\n", detail)
+	if err := db.RecordDeviceEvent(guid[:], db.VoucherExtendedEvent, detail); err != nil {
+		slog.Debug("Error recording voucher extension", "guid", hex.EncodeToString(guid[:]), "error", err)
+	}
 	ovBytes, err := cbor.Marshal(extended)
 	if err != nil {
 		return fmt.Errorf("resale protocol: error marshaling voucher: %w", err)
@@ -431,25 +1146,7 @@ func newHandler(state *ServerState) (*transport.Handler, error) {
 	if err != nil {
 		return nil, err
 	}
-	generateCA := func(key crypto.Signer) ([]*x509.Certificate, error) {
-		template := &x509.Certificate{
-			SerialNumber:          big.NewInt(1),
-			Subject:               pkix.Name{CommonName: "Test CA"},
-			NotBefore:             time.Now(),
-			NotAfter:              time.Now().Add(30 * 365 * 24 * time.Hour),
-			BasicConstraintsValid: true,
-			IsCA:                  true,
-		}
-		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
-		if err != nil {
-			return nil, err
-		}
-		cert, err := x509.ParseCertificate(der)
-		if err != nil {
-			return nil, err
-		}
-		return []*x509.Certificate{cert}, nil
-	}
+	generateCA := selfSignedCA
 	rsa2048Chain, err := generateCA(rsa2048MfgKey)
 	if err != nil {
 		return nil, err
@@ -515,65 +1212,76 @@ func newHandler(state *ServerState) (*transport.Handler, error) {
 		return nil, err
 	}
 
-	// Auto-register RV blob so that TO1 can be tested unless a TO0 address is
-	// given or RV bypass is set
-	var autoTO0 fdo.AutoTO0
-	var autoTO0Addrs []protocol.RvTO2Addr
-	if !rvBypass {
-		autoTO0 = state.DB
-
-		for _, directive := range protocol.ParseDeviceRvInfo(state.RvInfo) {
-			if directive.Bypass {
-				continue
-			}
+	autoTO0, autoTO0Addrs, err := autoTO0Config(state.Keys, state.RvInfo, rvBypass, disableAutoTO0)
+	if err != nil {
+		return nil, err
+	}
 
-			for _, url := range directive.URLs {
-				to1Host := url.Hostname()
-				to1Port, err := strconv.ParseUint(url.Port(), 10, 16)
-				if err != nil {
-					return nil, fmt.Errorf("error parsing TO1 port to use for TO2: %w", err)
-				}
-				proto := protocol.HTTPTransport
-				if useTLS {
-					proto = protocol.HTTPSTransport
-				}
-				autoTO0Addrs = append(autoTO0Addrs, protocol.RvTO2Addr{
-					DNSAddress:        &to1Host,
-					Port:              uint16(to1Port),
-					TransportProtocol: proto,
-				})
-			}
-		}
+	var sessions sessionState = state.DB
+	if sessionsPerGUID > 0 {
+		sessions = newGUIDSessionLimiter(sessions, sessionsPerGUID)
+	}
+	if to2SessionTTL > 0 {
+		sessions = newSessionTTL(sessions, to2SessionTTL)
 	}
+	if maxSessions > 0 {
+		sessions = newSessionCap(sessions, maxSessions, maxSessionsPolicy == sessionCapEvictOldest)
+	}
+	sessions = kexLogger{sessions}
+	replacementGUID = sessions.ReplacementGUID
 
+	var to2Vouchers fdo.OwnerVoucherPersistentState = db.OnboardingVouchers{OwnerVoucherPersistentState: db.OwnerVouchers(state.DB)}
+	to2Modules := withDeviceInventory(withModuleEvents(ownerModules))
+	if webhook.Enabled() {
+		devmods := newOnboardingDevmods()
+		to2Vouchers = completionWebhooks{OwnerVoucherPersistentState: to2Vouchers, devmods: devmods}
+		to2Modules = withDevmods(devmods, to2Modules)
+	}
 	return &transport.Handler{
-		Tokens: state.DB,
+		Tokens: sessions,
 		DIResponder: &fdo.DIServer[custom.DeviceMfgInfo]{
 			Session:               state.DB,
-			Vouchers:              state.DB,
-			SignDeviceCertificate: custom.SignDeviceCertificate(state.DB),
-			DeviceInfo: func(_ context.Context, info *custom.DeviceMfgInfo, _ []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
-				return info.DeviceInfo, info.KeyType, info.KeyEncoding, nil
+			Vouchers:              db.ManufacturerVouchers(state.DB),
+			SignDeviceCertificate: custom.SignDeviceCertificate(state.Keys),
+			DeviceInfo: func(_ context.Context, info *custom.DeviceMfgInfo, chain []*x509.Certificate) (string, protocol.KeyType, protocol.KeyEncoding, error) {
+				if len(chain) > 0 {
+					if err := db.CheckDIRequest(chain[0].PublicKey, time.Now()); err != nil {
+						slog.Debug("Rejecting device", "deviceInfo", info.DeviceInfo, "error", err)
+						return "", 0, 0, err
+					}
+				}
+				deviceInfo, err := deviceinfo.Normalize(info.DeviceInfo)
+				if err != nil {
+					slog.Debug("Rejecting device", "deviceInfo", info.DeviceInfo, "error", err)
+					return "", 0, 0, err
+				}
+				if err := db.CheckDeviceInfoAllowed(deviceInfo); err != nil {
+					slog.Debug("Rejecting device", "deviceInfo", deviceInfo, "error", err)
+					return "", 0, 0, err
+				}
+				return deviceInfo, info.KeyType, info.KeyEncoding, nil
 			},
-			AutoExtend:   state.DB,
+			AutoExtend:   state.Keys,
 			AutoTO0:      autoTO0,
 			AutoTO0Addrs: autoTO0Addrs,
 			RvInfo:       func(context.Context, *fdo.Voucher) ([][]protocol.RvInstruction, error) { return state.RvInfo, nil },
 		},
 		TO0Responder: &fdo.TO0Server{
-			Session: state.DB,
-			RVBlobs: state.DB,
+			Session:       state.DB,
+			RVBlobs:       state.DB,
+			AcceptVoucher: acceptVoucherMetrics(db.AcceptVoucher),
+			NegotiateTTL:  db.NegotiateTTL,
 		},
 		TO1Responder: &fdo.TO1Server{
 			Session: state.DB,
-			RVBlobs: state.DB,
+			RVBlobs: rvBlobMetrics{state.DB},
 		},
 		TO2Responder: &fdo.TO2Server{
-			Session:         state.DB,
-			Vouchers:        state.DB,
-			OwnerKeys:       state.DB,
+			Session:         sessions,
+			Vouchers:        to2Vouchers,
+			OwnerKeys:       state.Keys,
 			RvInfo:          func(context.Context, fdo.Voucher) ([][]protocol.RvInstruction, error) { return state.RvInfo, nil },
-			OwnerModules:    ownerModules,
+			OwnerModules:    to2Modules,
 			ReuseCredential: func(context.Context, fdo.Voucher) bool { return reuseCred },
 		},
 	}, nil
@@ -581,60 +1289,29 @@ func newHandler(state *ServerState) (*transport.Handler, error) {
 
 func ownerModules(ctx context.Context, guid protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule] {
 	return func(yield func(string, serviceinfo.OwnerModule) bool) {
-		if slices.Contains(modules, "fdo.download") {
-			for _, name := range downloads {
-				f, err := os.Open(filepath.Clean(name))
-				if err != nil {
-					log.Fatalf("error opening %q for download FSIM: %v", name, err)
-				}
-				defer func() { _ = f.Close() }()
-
-				if !yield("fdo.download", &fsim.DownloadContents[*os.File]{
-					Name:         name,
-					Contents:     f,
-					MustDownload: true,
-				}) {
-					return
-				}
-			}
+		device := ownersvi.Device{GUID: guid, Info: info, Chain: chain, Devmod: devmod}
+		plan := selectServicePlan(device)
+		if missing := missingRequiredModules(guid, plan, modules); len(missing) > 0 {
+			slog.Info("Rejecting device without required modules", "guid", guid, "missing", missing)
+			yield(missing[0], missingModule{name: missing[0]})
+			return
 		}
 
-		if slices.Contains(modules, "fdo.upload") {
-			for _, name := range uploadReqs {
-				if !yield("fdo.upload", &fsim.UploadRequest{
-					Dir:  uploadDir,
-					Name: name,
-				}) {
-					return
-				}
+		ctx = withServicePlan(ctx, plan)
+		for _, module := range plan.prioritizedModules() {
+			if !slices.Contains(modules, module) {
+				continue
 			}
-		}
-
-		if slices.Contains(modules, "fdo.wget") {
-			for _, urlString := range wgets {
-				url, err := url.Parse(urlString)
-				if err != nil || url.Path == "" {
-					continue
-				}
-				if !yield("fdo.wget", &fsim.WgetCommand{
-					Name: path.Base(url.Path),
-					URL:  url,
-				}) {
+			factory, ok := ownersvi.Lookup(module)
+			if !ok {
+				continue
+			}
+			for ownerModule := range factory(ctx, device, plan.moduleConfig(module)) {
+				if !yield(module, ownerModule) {
 					return
 				}
 			}
 		}
-
-		if cmdDate && slices.Contains(modules, "fdo.command") {
-			if !yield("fdo.command", &fsim.RunCommand{
-				Command: "date",
-				Args:    []string{"--utc"},
-				Stdout:  os.Stdout,
-				Stderr:  os.Stderr,
-			}) {
-				return
-			}
-		}
 	}
 }
 
@@ -661,6 +1338,25 @@ func validatePassword(dbPass string) error {
 	return nil
 }
 
+// serverCertificate returns the TLS certificate to serve with. The same policy
+// applies to every role: the certificate and key given by -server-cert and
+// -server-key if both are set, or else a self-signed certificate generated
+// once and stored in the database.
+func serverCertificate(db *sql.DB) (*tls.Certificate, error) {
+	switch {
+	case serverCertPath != "" && serverKeyPath != "":
+		cert, err := tls.LoadX509KeyPair(serverCertPath, serverKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("error loading server certificate: %w", err)
+		}
+		return &cert, nil
+	case serverCertPath != "" || serverKeyPath != "":
+		return nil, errors.New("-server-cert and -server-key must be used together")
+	default:
+		return tlsCert(db)
+	}
+}
+
 func tlsCert(db *sql.DB) (*tls.Certificate, error) {
 	// Ensure that the https table exists
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS https
@@ -0,0 +1,363 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/api"
+	"github.com/fido-device-onboard/go-fdo-server/api/handlers"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// newAutoExtendState returns a database with the owner and manufacturer keys
+// of this server and a voucher made by its manufacturer key that is not yet
+// extended to any owner.
+func newAutoExtendState(t *testing.T) (*sqlite.DB, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = state.Close() })
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+
+	ownerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "manufacturer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, mfgKey.Public(), mfgKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfgCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddOwnerKey(protocol.Secp256r1KeyType, ownerKey, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.AddManufacturerKey(protocol.Secp256r1KeyType, mfgKey, []*x509.Certificate{mfgCert}); err != nil {
+		t.Fatal(err)
+	}
+
+	mfgPub, err := protocol.NewPublicKey(protocol.Secp256r1KeyType, &mfgKey.PublicKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ov := fdo.Voucher{
+		Version: 101,
+		Header: cbor.Bstr[fdo.VoucherHeader]{Val: fdo.VoucherHeader{
+			Version:         101,
+			GUID:            protocol.GUID{0x39},
+			DeviceInfo:      "auto-extend",
+			ManufacturerKey: *mfgPub,
+		}},
+		Hmac:      protocol.Hmac{Algorithm: protocol.HmacSha256Hash, Value: make([]byte, 32)},
+		CertChain: &[]*cbor.X509Certificate{(*cbor.X509Certificate)(mfgCert)},
+	}
+	ovBytes, err := cbor.Marshal(&ov)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, ownerKey, pem.EncodeToMemory(&pem.Block{Type: "OWNERSHIP VOUCHER", Bytes: ovBytes})
+}
+
+// checkExtendedVoucher checks that the stored voucher with guid is extended
+// to the owner key.
+func checkExtendedVoucher(t *testing.T, guid protocol.GUID, ownerKey *ecdsa.PrivateKey) {
+	t.Helper()
+	stored, err := db.FetchVoucher(guid[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var extended fdo.Voucher
+	if err := cbor.Unmarshal(stored.CBOR, &extended); err != nil {
+		t.Fatal(err)
+	}
+	if len(extended.Entries) != 1 {
+		t.Fatalf("stored voucher has %d entries, want 1", len(extended.Entries))
+	}
+	owner, err := extended.OwnerPublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ownerKey.PublicKey.Equal(owner) {
+		t.Error("stored voucher is not extended to the owner key")
+	}
+	if err := extended.VerifyEntries(); err != nil {
+		t.Errorf("stored voucher entries are invalid: %v", err)
+	}
+}
+
+func TestImportVoucherAutoExtend(t *testing.T) {
+	defer func(path string, extend bool) { importVoucher, autoExtendImport = path, extend }(importVoucher, autoExtendImport)
+
+	for _, test := range []struct {
+		name   string
+		extend bool
+	}{
+		{"auto-extend off", false},
+		{"auto-extend on", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			state, ownerKey, ovPEM := newAutoExtendState(t)
+			importVoucher = filepath.Join(t.TempDir(), "ov.pem")
+			if err := os.WriteFile(importVoucher, ovPEM, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := newExternalKeys(state, nil, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			autoExtendImport = test.extend
+			err = doImportVoucher(keys)
+			guid := protocol.GUID{0x39}
+			if !test.extend {
+				if err == nil || !strings.Contains(err.Error(), "does not match the owner of the voucher") {
+					t.Fatalf("import of an unextended voucher: got error %v", err)
+				}
+				if _, err := db.FetchVoucher(guid[:]); err == nil {
+					t.Error("rejected voucher was stored")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkExtendedVoucher(t, guid, ownerKey)
+		})
+	}
+}
+
+func TestInsertVoucherAutoExtend(t *testing.T) {
+	state, ownerKey, ovPEM := newAutoExtendState(t)
+	keys, err := newExternalKeys(state, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetImportExtender(func(ov *fdo.Voucher) (*fdo.Voucher, error) {
+		return extendImport(keys, ov)
+	})
+	defer handlers.SetImportExtender(nil)
+
+	var rvInfo [][]protocol.RvInstruction
+	srv := httptest.NewServer(api.NewHTTPHandler(nil, &rvInfo, state).RegisterRoutes())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/api/v1/owner/vouchers", "application/x-pem-file", bytes.NewReader(ovPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status code is %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+	checkExtendedVoucher(t, protocol.GUID{0x39}, ownerKey)
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = lis.Close() }()
+	return lis.Addr().String()
+}
+
+func TestServerManagementListener(t *testing.T) {
+	fdoAddr, mgmtAddr := freeAddr(t), freeAddr(t)
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, name)
+		})
+	}
+	server := NewServer(fdoAddr, fdoAddr, named("fdo"), false, nil).WithManagement(mgmtAddr, named("mgmt"))
+
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+
+	get := func(addr string) (string, error) {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+	for addr, want := range map[string]string{fdoAddr: "fdo", mgmtAddr: "mgmt"} {
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+			got, err := get(addr)
+			if err == nil {
+				if got != want {
+					t.Errorf("%s served %q, want %q", addr, got, want)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not start: %v", addr, err)
+			}
+		}
+	}
+
+	// Both listeners shut down on SIGINT
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("server error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	for _, addr := range []string{fdoAddr, mgmtAddr} {
+		if _, err := get(addr); err == nil {
+			t.Errorf("%s is still serving", addr)
+		}
+	}
+}
+
+func TestServerPlainHTTPListener(t *testing.T) {
+	certPath, keyPath, _ := writeTestServerCert(t)
+	defer func(cert, key string) { serverCertPath, serverKeyPath = cert, key }(serverCertPath, serverKeyPath)
+	serverCertPath, serverKeyPath = certPath, keyPath
+
+	httpsAddr, httpAddr := freeAddr(t), freeAddr(t)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "fdo")
+	})
+	server := NewServer(httpsAddr, httpsAddr, handler, true, nil).WithPlainHTTP(httpAddr, handler)
+
+	done := make(chan error, 1)
+	go func() { done <- server.Start() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // self-signed test certificate
+	}}
+	get := func(url string) (string, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = resp.Body.Close() }()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+	urls := []string{"https://" + httpsAddr + "/", "http://" + httpAddr + "/"}
+	for _, url := range urls {
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+			got, err := get(url)
+			if err == nil {
+				if got != "fdo" {
+					t.Errorf("%s served %q, want %q", url, got, "fdo")
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s did not start: %v", url, err)
+			}
+		}
+	}
+	// The HTTPS listener does not serve plain HTTP
+	if resp, err := http.Get("http://" + httpsAddr + "/"); err == nil {
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s served plain HTTP with status %d", httpsAddr, resp.StatusCode)
+		}
+	}
+
+	// Both listeners shut down on SIGINT
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Errorf("server error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	for _, url := range urls {
+		if _, err := get(url); err == nil {
+			t.Errorf("%s is still serving", url)
+		}
+	}
+}
+
+func TestServerPlainHTTPRoutes(t *testing.T) {
+	defer func(mgmt, plain string) { mgmtAddr, plainAddr = mgmt, plain }(mgmtAddr, plainAddr)
+	mgmtAddr, plainAddr = "", freeAddr(t)
+
+	state, err := sqlite.Open(filepath.Join(t.TempDir(), "test.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = state.Close() }()
+	if err := db.InitDb(state); err != nil {
+		t.Fatal(err)
+	}
+	var rvInfo [][]protocol.RvInstruction
+	server := newServer(api.NewHTTPHandler(nil, &rvInfo, state), state)
+
+	// Without -mgmt-http the management API is served on -http, but not
+	// over plain HTTP
+	for _, test := range []struct {
+		name    string
+		handler http.Handler
+		want    int
+	}{
+		{"FDO listener", server.handler, http.StatusOK},
+		{"plain HTTP listener", server.plainHandler, http.StatusNotFound},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			test.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/owner/vouchers/count", nil))
+			if rec.Code != test.want {
+				t.Errorf("Status code is %v, want %v", rec.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// sessionIdleTimeout is how long a TO2 session without messages counts as
+// active. Devices send TO2 messages back to back, so a longer gap means the
+// device gave up on the session without ending it.
+const sessionIdleTimeout = time.Minute
+
+// errTooManySessions is returned when a device starts a TO2 session while
+// already at the limit of concurrent sessions for its GUID.
+var errTooManySessions = errors.New("too many concurrent TO2 sessions for device")
+
+// sessionState is the token and TO2 session state of the protocol handler.
+type sessionState interface {
+	protocol.TokenService
+	fdo.TO2SessionState
+}
+
+// guidSessionLimiter limits the number of concurrent TO2 sessions of each
+// device GUID. A session is bound to its GUID by SetGUID and ends when its
+// token is invalidated or it is idle for sessionIdleTimeout.
+type guidSessionLimiter struct {
+	sessionState
+	limit int
+
+	mu       sync.Mutex
+	guids    map[string]protocol.GUID
+	lastSeen map[string]time.Time
+}
+
+func newGUIDSessionLimiter(state sessionState, limit int) *guidSessionLimiter {
+	return &guidSessionLimiter{
+		sessionState: state,
+		limit:        limit,
+		guids:        make(map[string]protocol.GUID),
+		lastSeen:     make(map[string]time.Time),
+	}
+}
+
+func (l *guidSessionLimiter) TokenContext(ctx context.Context, token string) context.Context {
+	l.mu.Lock()
+	if _, ok := l.guids[token]; ok {
+		l.lastSeen[token] = time.Now()
+	}
+	l.mu.Unlock()
+	return l.sessionState.TokenContext(ctx, token)
+}
+
+func (l *guidSessionLimiter) SetGUID(ctx context.Context, guid protocol.GUID) error {
+	if token, ok := l.sessionState.TokenFromContext(ctx); ok && l.limit > 0 {
+		if err := l.acquire(token, guid); err != nil {
+			slog.Info("Rejecting TO2 session", "guid", guid, "limit", l.limit, "error", err)
+			return err
+		}
+	}
+	return l.sessionState.SetGUID(ctx, guid)
+}
+
+func (l *guidSessionLimiter) InvalidateToken(ctx context.Context) error {
+	if token, ok := l.sessionState.TokenFromContext(ctx); ok {
+		l.mu.Lock()
+		delete(l.guids, token)
+		delete(l.lastSeen, token)
+		l.mu.Unlock()
+	}
+	return l.sessionState.InvalidateToken(ctx)
+}
+
+// acquire binds a session to a GUID unless the GUID has reached the limit of
+// active sessions.
+func (l *guidSessionLimiter) acquire(token string, guid protocol.GUID) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	active := 0
+	for other, otherGUID := range l.guids {
+		if now.Sub(l.lastSeen[other]) > sessionIdleTimeout {
+			delete(l.guids, other)
+			delete(l.lastSeen, other)
+			continue
+		}
+		if other != token && otherGUID == guid {
+			active++
+		}
+	}
+	if active >= l.limit {
+		return fmt.Errorf("%w: %d active", errTooManySessions, active)
+	}
+	l.guids[token] = guid
+	l.lastSeen[token] = now
+	return nil
+}
+
+// errSessionExpired is returned for messages of a TO2 session that is older
+// than the session TTL.
+var errSessionExpired = errors.New("TO2 session expired")
+
+// sessionTTL ends TO2 sessions that are older than a TTL when their next
+// message arrives, so that a stalled device does not hold its session state
+// until the generic cleanup runs. Messages of an expired session are rejected
+// with an FDO error, as the TO2 server fails to read their session state.
+type sessionTTL struct {
+	sessionState
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+func newSessionTTL(state sessionState, ttl time.Duration) *sessionTTL {
+	return &sessionTTL{
+		sessionState: state,
+		ttl:          ttl,
+		now:          time.Now,
+		started:      make(map[string]time.Time),
+	}
+}
+
+func (s *sessionTTL) NewToken(ctx context.Context, proto protocol.Protocol) (string, error) {
+	token, err := s.sessionState.NewToken(ctx, proto)
+	if err != nil || proto != protocol.TO2Protocol {
+		return token, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	// Forget sessions that expired without sending another message
+	for other, started := range s.started {
+		if now.Sub(started) > s.ttl {
+			delete(s.started, other)
+		}
+	}
+	s.started[token] = now
+	return token, nil
+}
+
+type sessionExpiredKey struct{}
+
+func (s *sessionTTL) TokenContext(ctx context.Context, token string) context.Context {
+	ctx = s.sessionState.TokenContext(ctx, token)
+	s.mu.Lock()
+	started, ok := s.started[token]
+	expired := ok && s.now().Sub(started) > s.ttl
+	s.mu.Unlock()
+	if !expired {
+		return ctx
+	}
+
+	age := s.now().Sub(started)
+	slog.Info("Ending expired TO2 session", "age", age, "ttl", s.ttl)
+	if err := s.InvalidateToken(ctx); err != nil {
+		slog.Debug("Error ending expired TO2 session", "error", err)
+	}
+	return context.WithValue(ctx, sessionExpiredKey{}, fmt.Errorf("%w: started %s ago", errSessionExpired, age.Round(time.Second)))
+}
+
+// GUID fails for expired sessions, so that the TO2 server responds to their
+// messages that do not start a session with an error.
+func (s *sessionTTL) GUID(ctx context.Context) (protocol.GUID, error) {
+	if err, ok := ctx.Value(sessionExpiredKey{}).(error); ok {
+		return protocol.GUID{}, err
+	}
+	return s.sessionState.GUID(ctx)
+}
+
+// XSession fails for expired sessions, so that their encrypted messages are
+// rejected.
+func (s *sessionTTL) XSession(ctx context.Context) (kex.Suite, kex.Session, error) {
+	if err, ok := ctx.Value(sessionExpiredKey{}).(error); ok {
+		return "", nil, err
+	}
+	return s.sessionState.XSession(ctx)
+}
+
+func (s *sessionTTL) InvalidateToken(ctx context.Context) error {
+	if token, ok := s.sessionState.TokenFromContext(ctx); ok {
+		s.mu.Lock()
+		delete(s.started, token)
+		s.mu.Unlock()
+	}
+	return s.sessionState.InvalidateToken(ctx)
+}
+
+// errSessionCapReached is returned when a session is started while the number
+// of stored sessions is at the cap and the oldest session is not evicted.
+var errSessionCapReached = errors.New("too many stored sessions")
+
+// Behaviors of -max-sessions-policy
+const (
+	sessionCapReject      = "reject"
+	sessionCapEvictOldest = "evict-oldest"
+)
+
+// sessionCap bounds the number of sessions stored at once, so that a flood of
+// new sessions cannot exhaust the database. Sessions idle for
+// sessionIdleTimeout were given up by their device and are ended when a new
+// session needs room. When still at the cap, the new session is rejected or
+// the oldest session is ended to make room for it.
+type sessionCap struct {
+	sessionState
+	max   int
+	evict bool
+	now   func() time.Time
+
+	mu       sync.Mutex
+	started  map[string]time.Time
+	lastSeen map[string]time.Time
+}
+
+func newSessionCap(state sessionState, limit int, evict bool) *sessionCap {
+	return &sessionCap{
+		sessionState: state,
+		max:          limit,
+		evict:        evict,
+		now:          time.Now,
+		started:      make(map[string]time.Time),
+		lastSeen:     make(map[string]time.Time),
+	}
+}
+
+func (s *sessionCap) NewToken(ctx context.Context, proto protocol.Protocol) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if len(s.started) >= s.max {
+		for token, seen := range s.lastSeen {
+			if now.Sub(seen) > sessionIdleTimeout {
+				s.end(ctx, token)
+			}
+		}
+	}
+	if len(s.started) >= s.max {
+		if !s.evict {
+			slog.Warn("Rejecting session at the cap of stored sessions", "max", s.max)
+			return "", fmt.Errorf("%w: %d stored", errSessionCapReached, len(s.started))
+		}
+		var oldest string
+		for token, started := range s.started {
+			if oldest == "" || started.Before(s.started[oldest]) {
+				oldest = token
+			}
+		}
+		slog.Warn("Ending oldest session at the cap of stored sessions", "max", s.max, "age", now.Sub(s.started[oldest]).Round(time.Second))
+		s.end(ctx, oldest)
+	}
+
+	token, err := s.sessionState.NewToken(ctx, proto)
+	if err != nil {
+		return token, err
+	}
+	s.started[token] = now
+	s.lastSeen[token] = now
+	return token, nil
+}
+
+func (s *sessionCap) TokenContext(ctx context.Context, token string) context.Context {
+	s.mu.Lock()
+	if _, ok := s.started[token]; ok {
+		s.lastSeen[token] = s.now()
+	}
+	s.mu.Unlock()
+	return s.sessionState.TokenContext(ctx, token)
+}
+
+func (s *sessionCap) InvalidateToken(ctx context.Context) error {
+	if token, ok := s.sessionState.TokenFromContext(ctx); ok {
+		s.mu.Lock()
+		delete(s.started, token)
+		delete(s.lastSeen, token)
+		s.mu.Unlock()
+	}
+	return s.sessionState.InvalidateToken(ctx)
+}
+
+// end removes a stored session and stops tracking it. It must be called with
+// the lock held.
+func (s *sessionCap) end(ctx context.Context, token string) {
+	delete(s.started, token)
+	delete(s.lastSeen, token)
+	if err := s.sessionState.InvalidateToken(s.sessionState.TokenContext(ctx, token)); err != nil {
+		slog.Debug("Error ending session", "error", err)
+	}
+}
+
+// kexLogger logs the key exchange and cipher suites negotiated for each TO2
+// session and the service info MTU of the device, so that TO2 failures caused
+// by them can be diagnosed. Sessions are identified by a hash of their token,
+// since the token itself authenticates the device.
+type kexLogger struct {
+	sessionState
+}
+
+func (k kexLogger) SetXSession(ctx context.Context, suite kex.Suite, sess kex.Session) error {
+	attrs := append(k.sessionAttrs(ctx), "kex", suite, "cipher", cipherSuiteName(sess))
+	if err := k.sessionState.SetXSession(ctx, suite, sess); err != nil {
+		slog.Error("Error storing TO2 key exchange", append(attrs, "error", err)...)
+		return err
+	}
+	slog.Info("TO2 key exchange negotiated", attrs...)
+	return nil
+}
+
+func (k kexLogger) SetMTU(ctx context.Context, mtu uint16) error {
+	if err := k.sessionState.SetMTU(ctx, mtu); err != nil {
+		return err
+	}
+	slog.Debug("TO2 service info MTU set", append(k.sessionAttrs(ctx), "mtu", mtu)...)
+	return nil
+}
+
+func (k kexLogger) sessionAttrs(ctx context.Context) []any {
+	var attrs []any
+	if token, ok := k.sessionState.TokenFromContext(ctx); ok {
+		sum := sha256.Sum256([]byte(token))
+		attrs = append(attrs, "session", hex.EncodeToString(sum[:8]))
+	}
+	if guid, err := k.sessionState.GUID(ctx); err == nil {
+		attrs = append(attrs, "guid", guid)
+	}
+	return attrs
+}
+
+// cipherSuiteName returns the cipher suite of a key exchange session.
+func cipherSuiteName(sess kex.Session) string {
+	switch sess := sess.(type) {
+	case *kex.ECDHSession:
+		return sess.ID.String()
+	case *kex.DHSession:
+		return sess.ID.String()
+	case *kex.OAEPSession:
+		return sess.ID.String()
+	default:
+		return fmt.Sprintf("unknown (%T)", sess)
+	}
+}
@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/kex"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+type tokenKey struct{}
+
+// fakeSessions implements the token and session state methods used by
+// guidSessionLimiter. Other methods are not implemented.
+type fakeSessions struct {
+	sessionState
+}
+
+func (fakeSessions) TokenContext(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+func (fakeSessions) TokenFromContext(ctx context.Context) (string, bool) {
+	token, ok := ctx.Value(tokenKey{}).(string)
+	return token, ok
+}
+
+func (fakeSessions) SetGUID(context.Context, protocol.GUID) error { return nil }
+
+func (fakeSessions) InvalidateToken(context.Context) error { return nil }
+
+func (fakeSessions) SetXSession(context.Context, kex.Suite, kex.Session) error { return nil }
+
+func (fakeSessions) GUID(context.Context) (protocol.GUID, error) { return protocol.GUID{0x03}, nil }
+
+func TestGUIDSessionLimiter(t *testing.T) {
+	limiter := newGUIDSessionLimiter(fakeSessions{}, 1)
+	session := func(token string) context.Context {
+		return limiter.TokenContext(context.Background(), token)
+	}
+	device, other := protocol.GUID{0x01}, protocol.GUID{0x02}
+
+	first := session("first")
+	if err := limiter.SetGUID(first, device); err != nil {
+		t.Fatalf("first session rejected: %v", err)
+	}
+	if err := limiter.SetGUID(session("second"), device); !errors.Is(err, errTooManySessions) {
+		t.Errorf("concurrent session: got error %v, want %v", err, errTooManySessions)
+	}
+	if err := limiter.SetGUID(session("other"), other); err != nil {
+		t.Errorf("session of another device rejected: %v", err)
+	}
+
+	// Ending the session allows a new one
+	if err := limiter.InvalidateToken(first); err != nil {
+		t.Fatal(err)
+	}
+	third := session("third")
+	if err := limiter.SetGUID(third, device); err != nil {
+		t.Errorf("session after the previous one ended rejected: %v", err)
+	}
+
+	// So does abandoning it
+	limiter.lastSeen["third"] = time.Now().Add(-2 * sessionIdleTimeout)
+	if err := limiter.SetGUID(session("fourth"), device); err != nil {
+		t.Errorf("session after the previous one was abandoned rejected: %v", err)
+	}
+}
+
+func TestGUIDSessionLimiterUnlimited(t *testing.T) {
+	limiter := newGUIDSessionLimiter(fakeSessions{}, 0)
+	for _, token := range []string{"first", "second"} {
+		ctx := limiter.TokenContext(context.Background(), token)
+		if err := limiter.SetGUID(ctx, protocol.GUID{0x01}); err != nil {
+			t.Errorf("session %s rejected: %v", token, err)
+		}
+	}
+}
+
+// recordingSessions issues sequential tokens and records which were
+// invalidated.
+type recordingSessions struct {
+	fakeSessions
+	issued      int
+	invalidated map[string]bool
+}
+
+func (s *recordingSessions) NewToken(context.Context, protocol.Protocol) (string, error) {
+	s.issued++
+	return fmt.Sprintf("token%d", s.issued), nil
+}
+
+func (s *recordingSessions) InvalidateToken(ctx context.Context) error {
+	if token, ok := s.TokenFromContext(ctx); ok {
+		s.invalidated[token] = true
+	}
+	return nil
+}
+
+func TestSessionTTL(t *testing.T) {
+	inner := &recordingSessions{invalidated: make(map[string]bool)}
+	sessions := newSessionTTL(inner, time.Minute)
+	now := time.Now()
+	sessions.now = func() time.Time { return now }
+
+	stalled, err := sessions.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to1, err := sessions.NewToken(context.Background(), protocol.TO1Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// continuation returns the error of reading the session state of the
+	// next message of a session
+	continuation := func(token string) error {
+		_, err := sessions.GUID(sessions.TokenContext(context.Background(), token))
+		return err
+	}
+
+	// Continuations within the TTL are accepted
+	now = now.Add(30 * time.Second)
+	if err := continuation(stalled); err != nil {
+		t.Errorf("continuation within the TTL rejected: %v", err)
+	}
+
+	// A session started within the TTL of the current time is accepted
+	// even after an older one expires
+	now = now.Add(20 * time.Second)
+	fresh, err := sessions.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(40 * time.Second)
+	if err := continuation(fresh); err != nil {
+		t.Errorf("continuation of a fresh session rejected: %v", err)
+	}
+
+	// Past the TTL continuations are rejected and the session is removed
+	expired := sessions.TokenContext(context.Background(), stalled)
+	if _, err := sessions.GUID(expired); !errors.Is(err, errSessionExpired) {
+		t.Errorf("continuation past the TTL: got error %v, want %v", err, errSessionExpired)
+	}
+	if _, _, err := sessions.XSession(expired); !errors.Is(err, errSessionExpired) {
+		t.Errorf("encrypted continuation past the TTL: got error %v, want %v", err, errSessionExpired)
+	}
+	if !inner.invalidated[stalled] {
+		t.Error("expired session was not invalidated")
+	}
+	if _, ok := sessions.started[stalled]; ok {
+		t.Error("expired session is still tracked")
+	}
+
+	// Ending a session stops tracking it
+	if err := sessions.InvalidateToken(sessions.TokenContext(context.Background(), fresh)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sessions.started[fresh]; ok {
+		t.Error("ended session is still tracked")
+	}
+
+	// Sessions of other protocols have no TTL
+	if err := continuation(to1); err != nil {
+		t.Errorf("TO1 continuation rejected: %v", err)
+	}
+}
+
+func TestSessionCap(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		evict bool
+	}{
+		{"reject", false},
+		{"evict oldest", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			inner := &recordingSessions{invalidated: make(map[string]bool)}
+			sessions := newSessionCap(inner, 2, test.evict)
+			now := time.Now()
+			sessions.now = func() time.Time { return now }
+
+			var tokens []string
+			for range 2 {
+				token, err := sessions.NewToken(context.Background(), protocol.TO2Protocol)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens = append(tokens, token)
+				now = now.Add(time.Second)
+			}
+
+			token, err := sessions.NewToken(context.Background(), protocol.TO2Protocol)
+			if test.evict {
+				if err != nil {
+					t.Fatalf("session past the cap rejected: %v", err)
+				}
+				if !inner.invalidated[tokens[0]] || inner.invalidated[tokens[1]] {
+					t.Errorf("invalidated sessions are %v, want only %s", inner.invalidated, tokens[0])
+				}
+				if _, ok := sessions.started[token]; !ok || len(sessions.started) != 2 {
+					t.Errorf("stored sessions are %v", sessions.started)
+				}
+			} else {
+				if !errors.Is(err, errSessionCapReached) {
+					t.Fatalf("session past the cap: got error %v, want %v", err, errSessionCapReached)
+				}
+				if len(inner.invalidated) != 0 || inner.issued != 2 {
+					t.Errorf("%d sessions issued and %v invalidated", inner.issued, inner.invalidated)
+				}
+			}
+
+			// Ending a session makes room for a new one
+			if err := sessions.InvalidateToken(sessions.TokenContext(context.Background(), tokens[1])); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := sessions.NewToken(context.Background(), protocol.TO2Protocol); err != nil {
+				t.Errorf("session after ending one rejected: %v", err)
+			}
+		})
+	}
+}
+
+func TestSessionCapIdle(t *testing.T) {
+	inner := &recordingSessions{invalidated: make(map[string]bool)}
+	sessions := newSessionCap(inner, 1, false)
+	now := time.Now()
+	sessions.now = func() time.Time { return now }
+
+	idle, err := sessions.NewToken(context.Background(), protocol.TO2Protocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions given up by their device make room even when rejecting
+	now = now.Add(sessionIdleTimeout + time.Second)
+	if _, err := sessions.NewToken(context.Background(), protocol.TO2Protocol); err != nil {
+		t.Errorf("session after an idle one rejected: %v", err)
+	}
+	if !inner.invalidated[idle] {
+		t.Error("idle session was not invalidated")
+	}
+}
+
+func TestKexLogger(t *testing.T) {
+	logs := captureLog(t)
+	sessions := kexLogger{fakeSessions{}}
+	ctx := sessions.TokenContext(context.Background(), "secret-token")
+
+	sess := &kex.ECDHSession{SessionCrypter: kex.SessionCrypter{ID: kex.A256GcmCipher}}
+	if err := sessions.SetXSession(ctx, kex.ECDH384Suite, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	out := logs.String()
+	for _, want := range []string{"TO2 key exchange negotiated", "kex=ECDH384", "cipher=" + kex.A256GcmCipher.String(), "session=", "guid="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log does not contain %q: %s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret-token") {
+		t.Errorf("log contains the session token: %s", out)
+	}
+}
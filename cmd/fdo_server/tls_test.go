@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestServerCert(t *testing.T) (certPath, keyPath string, certDER []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err = x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certPath, keyPath = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath, certDER
+}
+
+func TestServerCertificate(t *testing.T) {
+	certPath, keyPath, certDER := writeTestServerCert(t)
+	defer func(cert, key string) { serverCertPath, serverKeyPath = cert, key }(serverCertPath, serverKeyPath)
+
+	t.Run("files", func(t *testing.T) {
+		serverCertPath, serverKeyPath = certPath, keyPath
+		cert, err := serverCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cert.Certificate) != 1 || !bytes.Equal(cert.Certificate[0], certDER) {
+			t.Error("Certificate was not loaded from -server-cert")
+		}
+	})
+
+	for _, test := range []struct {
+		name      string
+		cert, key string
+	}{
+		{"cert only", certPath, ""},
+		{"key only", "", keyPath},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			serverCertPath, serverKeyPath = test.cert, test.key
+			if _, err := serverCertificate(nil); err == nil {
+				t.Error("expected an error without both certificate and key")
+			}
+			if err := validateFlags(); err == nil {
+				t.Error("expected flag validation to fail without both certificate and key")
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		serverCertPath, serverKeyPath = certPath, filepath.Join(t.TempDir(), "missing.key")
+		if _, err := serverCertificate(nil); err == nil {
+			t.Error("expected an error loading a missing key")
+		}
+	})
+}
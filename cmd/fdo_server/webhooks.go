@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/webhook"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// devmodRetention is how long the devmod of a device in TO2 is kept for its
+// completion notification. Devices that do not complete TO2 by then gave up on
+// their session.
+const devmodRetention = time.Hour
+
+// setupWebhooks configures the receivers of onboarding notifications from
+// the webhook flags.
+func setupWebhooks() error {
+	cfg := webhook.Config{
+		URLs:        webhookURLs,
+		MaxAttempts: webhookAttempts,
+		Backoff:     webhookBackoff,
+		Timeout:     webhookTimeout,
+	}
+	if webhookSecret != "" {
+		secret, err := readSecretFile(webhookSecret)
+		if err != nil {
+			return fmt.Errorf("error reading webhook secret: %w", err)
+		}
+		cfg.Secret = secret
+	}
+	webhook.Configure(cfg)
+	return nil
+}
+
+// onboardingDevmods keeps the devmod of devices in TO2, which is only known
+// while service info is exchanged, until they complete TO2.
+type onboardingDevmods struct {
+	mu      sync.Mutex
+	devmods map[protocol.GUID]webhook.Devmod
+	seen    map[protocol.GUID]time.Time
+}
+
+func newOnboardingDevmods() *onboardingDevmods {
+	return &onboardingDevmods{
+		devmods: make(map[protocol.GUID]webhook.Devmod),
+		seen:    make(map[protocol.GUID]time.Time),
+	}
+}
+
+func (d *onboardingDevmods) put(guid protocol.GUID, devmod webhook.Devmod) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for other, seen := range d.seen {
+		if now.Sub(seen) > devmodRetention {
+			delete(d.devmods, other)
+			delete(d.seen, other)
+		}
+	}
+	d.devmods[guid] = devmod
+	d.seen[guid] = now
+}
+
+// take returns and forgets the devmod of a device, if known.
+func (d *onboardingDevmods) take(guid protocol.GUID) *webhook.Devmod {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	devmod, ok := d.devmods[guid]
+	if !ok {
+		return nil
+	}
+	delete(d.devmods, guid)
+	delete(d.seen, guid)
+	return &devmod
+}
+
+// withDevmods records the devmod of each device starting service info, for
+// its completion notification.
+func withDevmods(devmods *onboardingDevmods, ownerModules ownerModulesFunc) ownerModulesFunc {
+	return func(ctx context.Context, guid protocol.GUID, info string, chain []*x509.Certificate, devmod serviceinfo.Devmod, modules []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		devmods.put(guid, webhook.Devmod{
+			OS:      devmod.Os,
+			Arch:    devmod.Arch,
+			Version: devmod.Version,
+			Device:  devmod.Device,
+			Modules: modules,
+		})
+		return ownerModules(ctx, guid, info, chain, devmod, modules)
+	}
+}
+
+// completionWebhooks notifies the webhook receivers when a device completes
+// TO2, once its extended voucher is stored.
+type completionWebhooks struct {
+	fdo.OwnerVoucherPersistentState
+	devmods *onboardingDevmods
+}
+
+func (c completionWebhooks) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	if err := c.OwnerVoucherPersistentState.ReplaceVoucher(ctx, guid, ov); err != nil {
+		return err
+	}
+	newGUID := ov.Header.Val.GUID
+	webhook.Notify(webhook.Event{
+		Type:       webhook.TO2Completed,
+		GUID:       hex.EncodeToString(guid[:]),
+		NewGUID:    hex.EncodeToString(newGUID[:]),
+		DeviceInfo: ov.Header.Val.DeviceInfo,
+		Devmod:     c.devmods.take(guid),
+	})
+	return nil
+}
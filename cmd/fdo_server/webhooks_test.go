@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/webhook"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// fakeVouchers implements ReplaceVoucher of the owner voucher state. Other
+// methods are not implemented.
+type fakeVouchers struct {
+	fdo.OwnerVoucherPersistentState
+}
+
+func (fakeVouchers) ReplaceVoucher(context.Context, protocol.GUID, *fdo.Voucher) error { return nil }
+
+func TestCompletionWebhooks(t *testing.T) {
+	received := make(chan webhook.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		received <- event
+	}))
+	defer srv.Close()
+	webhook.Configure(webhook.Config{URLs: []string{srv.URL}, MaxAttempts: 1, Timeout: time.Second})
+	defer webhook.Configure(webhook.Config{})
+
+	oldGUID := protocol.GUID{0x01}
+	newGUID := protocol.GUID{0x02}
+	devmods := newOnboardingDevmods()
+	modules := withDevmods(devmods, func(context.Context, protocol.GUID, string, []*x509.Certificate, serviceinfo.Devmod, []string) iter.Seq2[string, serviceinfo.OwnerModule] {
+		return func(func(string, serviceinfo.OwnerModule) bool) {}
+	})
+	for range modules(context.Background(), oldGUID, "device", nil, serviceinfo.Devmod{Os: "linux", Arch: "amd64"}, []string{"devmod", "fdo.download"}) {
+	}
+
+	var ov fdo.Voucher
+	ov.Header.Val.GUID = newGUID
+	ov.Header.Val.DeviceInfo = "device"
+	vouchers := completionWebhooks{OwnerVoucherPersistentState: fakeVouchers{}, devmods: devmods}
+	if err := vouchers.ReplaceVoucher(context.Background(), oldGUID, &ov); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-received:
+		if event.Type != webhook.TO2Completed || event.GUID != "01000000000000000000000000000000" ||
+			event.NewGUID != "02000000000000000000000000000000" || event.DeviceInfo != "device" {
+			t.Errorf("unexpected event %+v", event)
+		}
+		if event.Devmod == nil || event.Devmod.OS != "linux" || len(event.Devmod.Modules) != 2 {
+			t.Errorf("unexpected devmod %+v", event.Devmod)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not delivered")
+	}
+	if devmods.take(oldGUID) != nil {
+		t.Error("devmod of a completed device was kept")
+	}
+}
@@ -6,15 +6,16 @@ require (
 	github.com/fido-device-onboard/go-fdo v0.0.0-20250113134913-619c960aa37e
 	github.com/fido-device-onboard/go-fdo/fsim v0.0.0-20250113134913-619c960aa37e
 	github.com/fido-device-onboard/go-fdo/sqlite v0.0.0-20250113134913-619c960aa37e
+	github.com/miekg/pkcs11 v1.1.2
+	github.com/ncruces/go-sqlite3 v0.22.0
+	github.com/tetratelabs/wazero v1.8.2
 	golang.org/x/time v0.9.0
 	hermannm.dev/devlog v0.5.0
 )
 
 require (
-	github.com/ncruces/go-sqlite3 v0.22.0 // indirect
 	github.com/ncruces/julianday v1.0.0 // indirect
 	github.com/neilotoole/jsoncolor v0.7.1 // indirect
-	github.com/tetratelabs/wazero v1.8.2 // indirect
 	golang.org/x/crypto v0.32.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
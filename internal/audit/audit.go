@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package audit records who imported, extended, resold or removed vouchers
+// and changed owner keys. Events are stored by the Store set with SetStore,
+// which the database package provides, and optionally written as JSON lines
+// to a log set with SetLog.
+package audit
+
+import (
+	"context"
+	"crypto"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/utils"
+)
+
+// Operations recorded in the audit log
+const (
+	VoucherImported = "voucher_import"
+	VoucherExtended = "voucher_extend"
+	VoucherResold   = "voucher_resell"
+	VoucherReplaced = "voucher_replace"
+	VoucherRemoved  = "voucher_remove"
+	VoucherExpired  = "voucher_expire"
+	OwnerKeyCreated = "owner_key_create"
+	OwnerKeyUpdated = "owner_key_update"
+)
+
+// Results of audited operations
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// SystemActor is the actor of operations not made on behalf of a client, such
+// as the replacement of a voucher at the end of TO2.
+const SystemActor = "system"
+
+// Event is an audited operation. ID orders events and is assigned by the
+// Store.
+type Event struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	GUID      string    `json:"guid,omitempty"`
+	Result    string    `json:"result"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// Store stores audit events, which are never changed or removed once stored.
+type Store interface {
+	RecordAuditEvent(Event) error
+}
+
+var (
+	mu    sync.Mutex
+	store Store
+	log   *json.Encoder
+)
+
+// SetStore stores the events recorded from now on in s.
+func SetStore(s Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	store = s
+}
+
+// SetLog also writes the events recorded from now on to w as JSON lines. A
+// nil w stops writing them.
+func SetLog(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if w == nil {
+		log = nil
+		return
+	}
+	log = json.NewEncoder(w)
+}
+
+type actorKey struct{}
+
+// WithActor returns a context of operations made by actor, such as the
+// authenticated client of a request.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the actor of ctx, or SystemActor if it has none.
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok {
+		return actor
+	}
+	return SystemActor
+}
+
+// Record records an operation on the voucher with guid, which may be nil for
+// operations on keys. The operation failed if err is not nil, in which case
+// the error is recorded as its detail. Failures to record the event are
+// logged, so that auditing never fails the operation itself.
+func Record(ctx context.Context, operation string, guid []byte, err error, detail string) {
+	event := Event{
+		Time:      time.Now().UTC(),
+		Actor:     Actor(ctx),
+		Operation: operation,
+		GUID:      hex.EncodeToString(guid),
+		Result:    Success,
+		Detail:    detail,
+	}
+	if err != nil {
+		event.Result = Failure
+		event.Detail = err.Error()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if store != nil {
+		if err := store.RecordAuditEvent(event); err != nil {
+			slog.Error("Error recording audit event", "operation", operation, "guid", event.GUID, "error", err)
+		}
+	}
+	if log != nil {
+		if err := log.Encode(event); err != nil {
+			slog.Error("Error writing audit log", "operation", operation, "guid", event.GUID, "error", err)
+		}
+	}
+}
+
+// ExtensionDetail describes which key signed a voucher extension and the key
+// it was extended to by their fingerprints, so that extensions can be traced
+// to owner keys during key rotation.
+func ExtensionDetail(signer, nextOwner crypto.PublicKey) (string, error) {
+	signerFingerprint, err := utils.PublicKeyFingerprint(signer)
+	if err != nil {
+		return "", err
+	}
+	nextFingerprint, err := utils.PublicKeyFingerprint(nextOwner)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("signed by key %s for owner key %s", signerFingerprint, nextFingerprint), nil
+}
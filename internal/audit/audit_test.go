@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+type memoryStore []Event
+
+func (m *memoryStore) RecordAuditEvent(event Event) error {
+	*m = append(*m, event)
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	var store memoryStore
+	var log bytes.Buffer
+	SetStore(&store)
+	SetLog(&log)
+	defer SetStore(nil)
+	defer SetLog(nil)
+
+	ctx := WithActor(context.Background(), "api-key:01020304")
+	Record(ctx, VoucherImported, []byte{0x01, 0x02}, nil, "")
+	Record(context.Background(), VoucherRemoved, []byte{0x01, 0x02}, errors.New("not found"), "")
+
+	if len(store) != 2 {
+		t.Fatalf("stored %d events, want 2", len(store))
+	}
+	if e := store[0]; e.Actor != "api-key:01020304" || e.Operation != VoucherImported || e.GUID != "0102" || e.Result != Success {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := store[1]; e.Actor != SystemActor || e.Result != Failure || e.Detail != "not found" {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	dec := json.NewDecoder(&log)
+	for i := range store {
+		var logged Event
+		if err := dec.Decode(&logged); err != nil {
+			t.Fatal(err)
+		}
+		if logged.Operation != store[i].Operation || logged.Result != store[i].Result {
+			t.Errorf("logged event %+v differs from stored %+v", logged, store[i])
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package cbordiag renders CBOR in Extended Diagnostic Notation (RFC 8610
+// appendix G) for debugging.
+package cbordiag
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds nesting so that malicious input cannot exhaust the stack.
+const maxDepth = 64
+
+var errUnexpectedEOF = errors.New("unexpected end of CBOR data")
+
+// Diagnose renders a single CBOR data item in diagnostic notation. Byte
+// strings which contain exactly one well-formed CBOR array or map, such as the
+// bstr-wrapped voucher header, are shown as embedded CBOR (<<...>>).
+func Diagnose(data []byte) (string, error) {
+	d := &decoder{data: data}
+	var sb strings.Builder
+	if err := d.item(&sb, 0); err != nil {
+		return "", err
+	}
+	if d.off != len(d.data) {
+		return "", fmt.Errorf("%d trailing bytes after CBOR data item", len(d.data)-d.off)
+	}
+	return sb.String(), nil
+}
+
+type decoder struct {
+	data []byte
+	off  int
+}
+
+const indefinite = math.MaxUint64
+
+// head reads the initial byte and argument of a data item.
+func (d *decoder) head() (major byte, info byte, arg uint64, err error) {
+	if d.off >= len(d.data) {
+		return 0, 0, 0, errUnexpectedEOF
+	}
+	b := d.data[d.off]
+	d.off++
+	major, info = b>>5, b&0x1f
+
+	var n int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		n = 1
+	case info == 25:
+		n = 2
+	case info == 26:
+		n = 4
+	case info == 27:
+		n = 8
+	case info == 31 && major >= 2 && major <= 5:
+		return major, info, indefinite, nil
+	case info == 31 && major == 7:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("invalid additional information %d for major type %d", info, major)
+	}
+	if len(d.data)-d.off < n {
+		return 0, 0, 0, errUnexpectedEOF
+	}
+	var buf [8]byte
+	copy(buf[8-n:], d.data[d.off:d.off+n])
+	d.off += n
+	return major, info, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// isBreak consumes a break stop code if it is next.
+func (d *decoder) isBreak() (bool, error) {
+	if d.off >= len(d.data) {
+		return false, errUnexpectedEOF
+	}
+	if d.data[d.off] == 0xff {
+		d.off++
+		return true, nil
+	}
+	return false, nil
+}
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.off) {
+		return nil, errUnexpectedEOF
+	}
+	b := d.data[d.off : d.off+int(n)]
+	d.off += int(n)
+	return b, nil
+}
+
+func (d *decoder) item(sb *strings.Builder, depth int) error { //nolint:gocyclo
+	if depth > maxDepth {
+		return errors.New("CBOR nesting too deep")
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return err
+	}
+
+	switch major {
+	case 0:
+		sb.WriteString(strconv.FormatUint(arg, 10))
+
+	case 1:
+		if arg == math.MaxUint64 {
+			sb.WriteString("-18446744073709551616")
+		} else {
+			sb.WriteString("-" + strconv.FormatUint(arg+1, 10))
+		}
+
+	case 2, 3:
+		if arg == indefinite {
+			sb.WriteString("(_ ")
+			for i := 0; ; i++ {
+				if done, err := d.isBreak(); err != nil {
+					return err
+				} else if done {
+					break
+				}
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				chunkMajor, _, n, err := d.head()
+				if err != nil {
+					return err
+				}
+				if chunkMajor != major || n == indefinite {
+					return errors.New("invalid indefinite length string chunk")
+				}
+				if err := d.str(sb, major, n, depth); err != nil {
+					return err
+				}
+			}
+			sb.WriteString(")")
+			return nil
+		}
+		return d.str(sb, major, arg, depth)
+
+	case 4:
+		sb.WriteString("[")
+		if arg == indefinite {
+			sb.WriteString("_ ")
+		}
+		for i := uint64(0); arg == indefinite || i < arg; i++ {
+			if arg == indefinite {
+				if done, err := d.isBreak(); err != nil {
+					return err
+				} else if done {
+					break
+				}
+			}
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := d.item(sb, depth+1); err != nil {
+				return err
+			}
+		}
+		sb.WriteString("]")
+
+	case 5:
+		sb.WriteString("{")
+		if arg == indefinite {
+			sb.WriteString("_ ")
+		}
+		for i := uint64(0); arg == indefinite || i < arg; i++ {
+			if arg == indefinite {
+				if done, err := d.isBreak(); err != nil {
+					return err
+				} else if done {
+					break
+				}
+			}
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			if err := d.item(sb, depth+1); err != nil {
+				return err
+			}
+			sb.WriteString(": ")
+			if err := d.item(sb, depth+1); err != nil {
+				return err
+			}
+		}
+		sb.WriteString("}")
+
+	case 6:
+		sb.WriteString(strconv.FormatUint(arg, 10) + "(")
+		if err := d.item(sb, depth+1); err != nil {
+			return err
+		}
+		sb.WriteString(")")
+
+	case 7:
+		return simple(sb, info, arg)
+	}
+	return nil
+}
+
+func (d *decoder) str(sb *strings.Builder, major byte, n uint64, depth int) error {
+	b, err := d.bytes(n)
+	if err != nil {
+		return err
+	}
+	if major == 3 {
+		quoted, err := json.Marshal(string(b))
+		if err != nil {
+			return err
+		}
+		sb.Write(quoted)
+		return nil
+	}
+
+	// Show byte strings wrapping a CBOR array or map as embedded CBOR
+	if len(b) > 0 && (b[0]>>5 == 4 || b[0]>>5 == 5) {
+		embedded := &decoder{data: b}
+		var inner strings.Builder
+		if err := embedded.item(&inner, depth+1); err == nil && embedded.off == len(b) {
+			sb.WriteString("<<" + inner.String() + ">>")
+			return nil
+		}
+	}
+	sb.WriteString("h'" + hex.EncodeToString(b) + "'")
+	return nil
+}
+
+func simple(sb *strings.Builder, info byte, arg uint64) error {
+	switch info {
+	case 20:
+		sb.WriteString("false")
+	case 21:
+		sb.WriteString("true")
+	case 22:
+		sb.WriteString("null")
+	case 23:
+		sb.WriteString("undefined")
+	case 24:
+		sb.WriteString("simple(" + strconv.FormatUint(arg, 10) + ")")
+	case 25:
+		float(sb, float64(halfToFloat32(uint16(arg))))
+	case 26:
+		float(sb, float64(math.Float32frombits(uint32(arg))))
+	case 27:
+		float(sb, math.Float64frombits(arg))
+	case 31:
+		return errors.New("unexpected break stop code")
+	default:
+		sb.WriteString("simple(" + strconv.FormatUint(uint64(info), 10) + ")")
+	}
+	return nil
+}
+
+func float(sb *strings.Builder, f float64) {
+	switch {
+	case math.IsNaN(f):
+		sb.WriteString("NaN")
+	case math.IsInf(f, 1):
+		sb.WriteString("Infinity")
+	case math.IsInf(f, -1):
+		sb.WriteString("-Infinity")
+	default:
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		sb.WriteString(s)
+	}
+}
+
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		// Zero or subnormal
+		f := float32(mant) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	default:
+		return math.Float32frombits(sign | (exp+112)<<23 | mant<<13)
+	}
+}
@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package cbordiag
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	for _, test := range []struct {
+		cbor string
+		want string
+	}{
+		{"00", "0"},
+		{"1903e8", "1000"},
+		{"20", "-1"},
+		{"3903e7", "-1000"},
+		{"f4", "false"},
+		{"f5", "true"},
+		{"f6", "null"},
+		{"f7", "undefined"},
+		{"f93e00", "1.5"},
+		{"fb3ff0000000000000", "1.0"},
+		{"f97c00", "Infinity"},
+		{"4401020304", "h'01020304'"},
+		{"6449455446", `"IETF"`},
+		{"62225c", `"\"\\"`},
+		{"83010203", "[1, 2, 3]"},
+		{"9f018202039f0405ffff", "[_ 1, [2, 3], [_ 4, 5]]"},
+		{"a26161016162820203", `{"a": 1, "b": [2, 3]}`},
+		{"c074323031332d30332d32315432303a30343a30305a", `0("2013-03-21T20:04:00Z")`},
+		{"5f42010243030405ff", "(_ h'0102', h'030405')"},
+		// bstr-wrapped array, as used for the voucher header
+		{"4583186501f6", "<<[101, 1, null]>>"},
+	} {
+		data, err := hex.DecodeString(test.cbor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Diagnose(data)
+		if err != nil {
+			t.Errorf("%s: %v", test.cbor, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.cbor, got, test.want)
+		}
+	}
+}
+
+func TestDiagnoseInvalid(t *testing.T) {
+	for _, cbor := range []string{
+		"",       // empty
+		"18",     // missing argument
+		"830102", // short array
+		"0000",   // trailing data
+		"ff",     // unexpected break
+		"5f01ff", // invalid chunk
+	} {
+		data, _ := hex.DecodeString(cbor)
+		if got, err := Diagnose(data); err == nil {
+			t.Errorf("%s: expected an error, got %s", cbor, got)
+		}
+	}
+}
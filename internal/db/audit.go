@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// createAuditEventsTable creates the audit log. Triggers reject changes to
+// stored events, so that the log is append-only.
+func createAuditEventsTable(db *sql.DB) error {
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			at INTEGER NOT NULL,
+			actor TEXT NOT NULL,
+			operation TEXT NOT NULL,
+			guid BLOB,
+			result TEXT NOT NULL,
+			detail TEXT
+		);`,
+		"CREATE INDEX IF NOT EXISTS audit_events_guid ON audit_events(guid)",
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit events cannot be changed'); END;`,
+		`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit events cannot be removed'); END;`,
+	} {
+		if _, err := timed(db).Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordAuditEvent stores an audit event. It implements audit.Store.
+func (s *State) RecordAuditEvent(event audit.Event) error {
+	guid, err := hex.DecodeString(event.GUID)
+	if err != nil {
+		return err
+	}
+	var guidArg any
+	if len(guid) > 0 {
+		guidArg = guid
+	}
+	_, err = s.conn().Exec("INSERT INTO audit_events (at, actor, operation, guid, result, detail) VALUES (?, ?, ?, ?, ?, ?)",
+		event.Time.UnixMicro(), event.Actor, event.Operation, guidArg, event.Result, event.Detail)
+	return err
+}
+
+// AuditFilter selects audit events. Zero fields select all events.
+type AuditFilter struct {
+	GUID      []byte
+	Operation string
+	// After selects events with a greater ID, to page through the log
+	After int64
+	Limit int
+}
+
+// FetchAuditEvents returns the audit events selected by filter ordered by ID.
+func FetchAuditEvents(filter AuditFilter) ([]audit.Event, error) {
+	var where []string
+	var args []any
+	if filter.GUID != nil {
+		where = append(where, "guid = ?")
+		args = append(args, filter.GUID)
+	}
+	if filter.Operation != "" {
+		where = append(where, "operation = ?")
+		args = append(args, filter.Operation)
+	}
+	where = append(where, "id > ?")
+	args = append(args, filter.After)
+	query := "SELECT id, at, actor, operation, guid, result, detail FROM audit_events WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := timed(db).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []audit.Event{}
+	for rows.Next() {
+		var event audit.Event
+		var at int64
+		var guid []byte
+		var detail sql.NullString
+		if err := rows.Scan(&event.ID, &at, &event.Actor, &event.Operation, &guid, &event.Result, &detail); err != nil {
+			return nil, err
+		}
+		event.Time = time.UnixMicro(at).UTC()
+		event.GUID = hex.EncodeToString(guid)
+		event.Detail = detail.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// auditedVouchers wraps the owner voucher state of the FDO protocol to audit
+// vouchers removed by resale and replaced at the end of TO2.
+type auditedVouchers struct {
+	fdo.OwnerVoucherPersistentState
+}
+
+func (v auditedVouchers) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	err := v.OwnerVoucherPersistentState.ReplaceVoucher(ctx, guid, ov)
+	newGUID := ov.Header.Val.GUID
+	audit.Record(ctx, audit.VoucherReplaced, guid[:], err, "replaced by voucher "+hex.EncodeToString(newGUID[:]))
+	return err
+}
+
+func (v auditedVouchers) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	ov, err := v.OwnerVoucherPersistentState.RemoveVoucher(ctx, guid)
+	audit.Record(ctx, audit.VoucherRemoved, guid[:], err, "")
+	return ov, err
+}
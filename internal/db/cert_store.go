@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+)
+
+// TrustedCertStore stores trusted CA certificates in a table keyed by
+// certificate fingerprint.
+type TrustedCertStore struct {
+	table string
+}
+
+var (
+	// TrustedManufacturerCAs are the CAs that voucher manufacturer
+	// certificate chains must chain to on import.
+	TrustedManufacturerCAs = TrustedCertStore{table: "trusted_manufacturer_cas"}
+	// TrustedDeviceCAs are the CAs that voucher device certificate chains
+	// must chain to on import.
+	TrustedDeviceCAs = TrustedCertStore{table: "trusted_device_cas"}
+)
+
+// CertFingerprint returns the hex encoded SHA-256 hash of a certificate, which
+// identifies trusted CA certificates.
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s TrustedCertStore) createTable(db *sql.DB) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		fingerprint TEXT PRIMARY KEY,
+		der BLOB NOT NULL
+	);`, s.table)
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Insert trusts a CA certificate. Inserting a certificate that is already
+// trusted is a no-op. The returned bool reports whether the certificate was
+// newly trusted.
+func (s TrustedCertStore) Insert(cert *x509.Certificate) (bool, error) {
+	result, err := timed(db).Exec("INSERT OR IGNORE INTO "+s.table+" (fingerprint, der) VALUES (?, ?)",
+		CertFingerprint(cert), cert.Raw)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// List returns all trusted CA certificates ordered by fingerprint.
+func (s TrustedCertStore) List() ([]*x509.Certificate, error) {
+	return s.list(db)
+}
+
+func (s TrustedCertStore) list(db querier) ([]*x509.Certificate, error) {
+	return s.query(db, "SELECT der FROM "+s.table+" ORDER BY fingerprint")
+}
+
+// ListByFingerprintPrefix returns the trusted CA certificates whose
+// fingerprint starts with the lowercase hex prefix, ordered by fingerprint.
+// Since fingerprints are the primary key, the prefix is matched using the
+// index.
+func (s TrustedCertStore) ListByFingerprintPrefix(prefix string) ([]*x509.Certificate, error) {
+	return s.query(db, "SELECT der FROM "+s.table+" WHERE fingerprint GLOB ? ORDER BY fingerprint", prefix+"*")
+}
+
+func (s TrustedCertStore) query(db querier, query string, args ...any) ([]*x509.Certificate, error) {
+	rows, err := timed(db).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs []*x509.Certificate
+	for rows.Next() {
+		var der []byte
+		if err := rows.Scan(&der); err != nil {
+			return nil, err
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, rows.Err()
+}
+
+// Get returns the trusted CA certificate with the given fingerprint, or
+// sql.ErrNoRows.
+func (s TrustedCertStore) Get(fingerprint string) (*x509.Certificate, error) {
+	var der []byte
+	if err := timed(db).QueryRow("SELECT der FROM "+s.table+" WHERE fingerprint = ?", fingerprint).Scan(&der); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Delete stops trusting the CA certificate with the given fingerprint. It
+// returns sql.ErrNoRows if it was not trusted.
+func (s TrustedCertStore) Delete(fingerprint string) error {
+	result, err := timed(db).Exec("DELETE FROM "+s.table+" WHERE fingerprint = ?", fingerprint)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Pool loads the trusted CA certificates into a pool. It returns a nil pool
+// when no CA is trusted.
+func (s TrustedCertStore) Pool() (*x509.CertPool, error) {
+	return s.pool(db)
+}
+
+func (s TrustedCertStore) pool(db querier) (*x509.CertPool, error) {
+	certs, err := s.list(db)
+	if err != nil || len(certs) == 0 {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// Sync reconciles the store with a set of CA certificates, trusting the ones
+// that are not trusted yet and, if prune is set, no longer trusting the ones
+// that are not in the set. It returns how many CAs were added and removed.
+func (s TrustedCertStore) Sync(certs []*x509.Certificate, prune bool) (added, removed int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	keep := make(map[string]bool, len(certs))
+	for _, cert := range certs {
+		fingerprint := CertFingerprint(cert)
+		keep[fingerprint] = true
+		result, err := tx.Exec("INSERT OR IGNORE INTO "+s.table+" (fingerprint, der) VALUES (?, ?)", fingerprint, cert.Raw)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return 0, 0, err
+		} else if n > 0 {
+			added++
+		}
+	}
+
+	if prune {
+		trusted, err := s.list(tx)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, cert := range trusted {
+			fingerprint := CertFingerprint(cert)
+			if keep[fingerprint] {
+				continue
+			}
+			if _, err := tx.Exec("DELETE FROM "+s.table+" WHERE fingerprint = ?", fingerprint); err != nil {
+				return 0, 0, err
+			}
+			removed++
+		}
+	}
+	return added, removed, tx.Commit()
+}
@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// VoucherConflictPolicy decides what happens when an imported voucher has the
+// GUID of a stored voucher but different contents.
+type VoucherConflictPolicy string
+
+const (
+	// RejectConflicts fails the import and keeps the stored voucher.
+	RejectConflicts VoucherConflictPolicy = "reject"
+	// OverwriteConflicts replaces the stored voucher with the imported one.
+	OverwriteConflicts VoucherConflictPolicy = "overwrite"
+	// KeepNewerConflicts keeps whichever voucher has more ownership entries,
+	// i.e. the one that has been extended further.
+	KeepNewerConflicts VoucherConflictPolicy = "keep-newer"
+)
+
+// ErrVoucherExists is returned when a different voucher with the same GUID is
+// already stored and the conflict policy forbids replacing it.
+var ErrVoucherExists = errors.New("voucher already exists (not overwriting)")
+
+var conflictPolicy = RejectConflicts
+
+func ParseVoucherConflictPolicy(s string) (VoucherConflictPolicy, error) {
+	switch policy := VoucherConflictPolicy(s); policy {
+	case RejectConflicts, OverwriteConflicts, KeepNewerConflicts:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid voucher conflict policy %q: must be one of reject, overwrite, keep-newer", s)
+	}
+}
+
+func SetVoucherConflictPolicy(policy VoucherConflictPolicy) {
+	conflictPolicy = policy
+}
+
+// ImportVoucher stores a voucher, applying the configured conflict policy when
+// a voucher with the same GUID already exists. Re-importing identical bytes is
+// a no-op. Vouchers from manufacturers or devices that are not trusted are
+// rejected with ErrUntrustedManufacturer or ErrUntrustedDevice, and vouchers
+// whose header HMAC does not validate with ErrInvalidVoucherHMAC. Vouchers
+// whose RV info sets RV bypass are handled by the RV bypass policy. The returned
+// bool reports whether the database was modified.
+func ImportVoucher(voucher Voucher) (bool, error) {
+	stored, err := DefaultState().ImportVoucher(voucher)
+	if err != nil {
+		countVoucherImports(nil, err)
+	} else {
+		countVoucherImports([]bool{stored}, nil)
+	}
+	return stored, err
+}
+
+// ImportVoucher is like the package level ImportVoucher but uses the
+// database of s.
+func (s *State) ImportVoucher(voucher Voucher) (bool, error) {
+	if err := verifyVoucherTrust(s.conn(), voucher.CBOR); err != nil {
+		return false, err
+	}
+	if err := verifyVoucherHMAC(voucher.CBOR); err != nil {
+		return false, err
+	}
+	if err := checkRVBypass(voucher.CBOR); err != nil {
+		return false, err
+	}
+
+	existing, err := s.FetchVoucher(voucher.GUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := s.InsertVoucher(voucher); err != nil {
+			return false, err
+		}
+		return true, s.recordDeviceEvent(voucher.GUID, VoucherImportedEvent, "")
+	} else if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(existing.CBOR, voucher.CBOR) {
+		return false, nil
+	}
+
+	switch conflictPolicy {
+	case OverwriteConflicts:
+		return true, s.UpdateVoucher(voucher)
+	case KeepNewerConflicts:
+		newer, err := isNewerVoucher(voucher.CBOR, existing.CBOR)
+		if err != nil {
+			return false, err
+		}
+		if !newer {
+			return false, nil
+		}
+		return true, s.UpdateVoucher(voucher)
+	default:
+		return false, ErrVoucherExists
+	}
+}
+
+func isNewerVoucher(imported, stored []byte) (bool, error) {
+	var importedOV, storedOV fdo.Voucher
+	if err := cbor.Unmarshal(imported, &importedOV); err != nil {
+		return false, fmt.Errorf("error parsing imported voucher: %w", err)
+	}
+	if err := cbor.Unmarshal(stored, &storedOV); err != nil {
+		return false, fmt.Errorf("error parsing stored voucher: %w", err)
+	}
+	return len(importedOV.Entries) > len(storedOV.Entries), nil
+}
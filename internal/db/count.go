@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// VoucherFilter selects stored vouchers. The zero value selects all vouchers.
+type VoucherFilter struct {
+	// DeviceInfo matches the device info of the voucher exactly
+	DeviceInfo string
+	// Search matches a case-insensitive substring of the hex GUID or the
+	// device info of the voucher
+	Search string
+	// Completed, if set, matches whether the device has completed TO2
+	Completed *bool
+}
+
+// where returns the SQL condition on owner_vouchers of the filters that do
+// not need the device info.
+func (filter VoucherFilter) where() string {
+	if filter.Completed == nil {
+		return "1"
+	}
+	where := `EXISTS (SELECT 1 FROM device_onboarding d WHERE d.to2_completed = 1
+		AND (d.guid = owner_vouchers.guid OR d.new_guid = owner_vouchers.guid))`
+	if !*filter.Completed {
+		where = "NOT " + where
+	}
+	return where
+}
+
+// matchesDeviceInfo reports whether a voucher matching where also matches the
+// device info and search filters.
+func (filter VoucherFilter) matchesDeviceInfo(guid []byte, deviceInfo string) bool {
+	if filter.DeviceInfo != "" && deviceInfo != filter.DeviceInfo {
+		return false
+	}
+	search := strings.ToLower(filter.Search)
+	return search == "" || strings.Contains(hex.EncodeToString(guid), search) ||
+		strings.Contains(strings.ToLower(deviceInfo), search)
+}
+
+// CountVouchers returns the number of stored vouchers matching filter. Without
+// device info or search filters it is a single COUNT query. Otherwise the
+// device info of each candidate voucher is read, but no vouchers are kept in
+// memory.
+func CountVouchers(filter VoucherFilter) (int, error) {
+	if filter.DeviceInfo == "" && filter.Search == "" {
+		var count int
+		err := timed(db).QueryRow("SELECT COUNT(*) FROM owner_vouchers WHERE " + filter.where()).Scan(&count)
+		return count, err
+	}
+
+	var count int
+	err := forEachDeviceInfo(timed(db), filter.where(), func(guid []byte, deviceInfo string) {
+		if filter.matchesDeviceInfo(guid, deviceInfo) {
+			count++
+		}
+	})
+	return count, err
+}
@@ -7,44 +7,58 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
 )
 
 var db *sql.DB
 
+// InitDb creates the tables of the server and makes the database the one used
+// by the package level functions.
 func InitDb(state *sqlite.DB) error {
-	db = state.DB()
-	if err := createRvTable(); err != nil {
-		slog.Error("Failed to create table")
-		return err
-	}
-	if err := createOwnerInfoTable(); err != nil {
-		slog.Error("Failed to create table")
+	s := NewState(state)
+	if err := s.Init(); err != nil {
 		return err
 	}
+	db = s.db
+	audit.SetStore(s)
 	return nil
 }
 
-func createRvTable() error {
+func createRvTable(db *sql.DB) error {
 	query := `CREATE TABLE IF NOT EXISTS rvinfo (
 		id INTEGER PRIMARY KEY CHECK (id = 1),
 		value TEXT
 	);`
-	_, err := db.Exec(query)
+	_, err := timed(db).Exec(query)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func createOwnerInfoTable() error {
+func createOwnerInfoTable(db *sql.DB) error {
 	query := `CREATE TABLE IF NOT EXISTS owner_info (
 		id INTEGER PRIMARY KEY CHECK (id = 1),
 		value TEXT
 	);`
-	_, err := db.Exec(query)
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func createRvInfoOverridesTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS rvinfo_overrides (
+		guid BLOB PRIMARY KEY,
+		value TEXT,
+		created_at INTEGER
+	);`
+	_, err := timed(db).Exec(query)
 	if err != nil {
 		return err
 	}
@@ -52,13 +66,87 @@ func createOwnerInfoTable() error {
 }
 
 func FetchVoucher(guid []byte) (Voucher, error) {
+	return DefaultState().FetchVoucher(guid)
+}
+
+func (s *State) FetchVoucher(guid []byte) (Voucher, error) {
 	var voucher Voucher
-	err := db.QueryRow("SELECT guid, cbor FROM owner_vouchers WHERE guid = ?", guid).Scan(&voucher.GUID, &voucher.CBOR)
-	return voucher, err
+	err := s.conn().QueryRow("SELECT guid, cbor FROM owner_vouchers WHERE guid = ?", guid).Scan(&voucher.GUID, &voucher.CBOR)
+	if err != nil {
+		return voucher, err
+	}
+	return voucher, externalVoucherCBOR(&voucher)
+}
+
+// FetchVouchers returns all stored vouchers ordered by GUID.
+func FetchVouchers() ([]Voucher, error) {
+	rows, err := timed(db).Query("SELECT guid, cbor FROM owner_vouchers ORDER BY guid")
+	if err != nil {
+		return nil, err
+	}
+	return scanVouchers(rows)
+}
+
+// SampleVouchers returns up to n stored vouchers chosen at random.
+func SampleVouchers(n int) ([]Voucher, error) {
+	rows, err := timed(db).Query("SELECT guid, cbor FROM owner_vouchers ORDER BY RANDOM() LIMIT ?", n)
+	if err != nil {
+		return nil, err
+	}
+	return scanVouchers(rows)
+}
+
+// FetchVoucherGUIDs returns which of the given GUIDs have a stored voucher,
+// in one query.
+func FetchVoucherGUIDs(guids [][]byte) (map[string]bool, error) {
+	found := make(map[string]bool)
+	if len(guids) == 0 {
+		return found, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(guids)), ",")
+	args := make([]any, len(guids))
+	for i, guid := range guids {
+		args[i] = guid
+	}
+	rows, err := timed(db).Query("SELECT guid FROM owner_vouchers WHERE guid IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guid []byte
+		if err := rows.Scan(&guid); err != nil {
+			return nil, err
+		}
+		found[string(guid)] = true
+	}
+	return found, rows.Err()
+}
+
+func scanVouchers(rows *sql.Rows) ([]Voucher, error) {
+	defer rows.Close()
+
+	var vouchers []Voucher
+	for rows.Next() {
+		var voucher Voucher
+		if err := rows.Scan(&voucher.GUID, &voucher.CBOR); err != nil {
+			return nil, err
+		}
+		if err := externalVoucherCBOR(&voucher); err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, voucher)
+	}
+	return vouchers, rows.Err()
 }
 
 func FetchOwnerKeys() ([]OwnerKey, error) {
-	rows, err := db.Query("SELECT type, pkcs8, x509_chain FROM owner_keys")
+	return DefaultState().FetchOwnerKeys()
+}
+
+func (s *State) FetchOwnerKeys() ([]OwnerKey, error) {
+	rows, err := s.conn().Query("SELECT type, pkcs8, x509_chain FROM owner_keys")
 	if err != nil {
 		return nil, err
 	}
@@ -76,13 +164,31 @@ func FetchOwnerKeys() ([]OwnerKey, error) {
 }
 
 func InsertVoucher(voucher Voucher) error {
-	_, err := db.Exec("INSERT INTO owner_vouchers (guid, cbor) VALUES (?, ?)", voucher.GUID, voucher.CBOR)
+	return DefaultState().InsertVoucher(voucher)
+}
+
+func (s *State) InsertVoucher(voucher Voucher) error {
+	var err error
+	if voucherCBORStore != nil {
+		err = s.putExternalVoucher(voucher, true)
+	} else {
+		_, err = s.conn().Exec("INSERT INTO owner_vouchers (guid, cbor) VALUES (?, ?)", voucher.GUID, voucher.CBOR)
+	}
+	if err != nil {
+		return err
+	}
+	// Record when the voucher was stored, for its retention
+	_, err = s.conn().Exec("INSERT OR REPLACE INTO voucher_expiry (guid, stored_at) VALUES (?, ?)", voucher.GUID, time.Now().Unix())
 	return err
 }
 
 func UpdateOwnerKeys(ownerKeys []OwnerKey) error {
+	return DefaultState().UpdateOwnerKeys(ownerKeys)
+}
+
+func (s *State) UpdateOwnerKeys(ownerKeys []OwnerKey) error {
 	for _, ownerKey := range ownerKeys {
-		_, err := db.Exec("UPDATE owner_keys SET pkcs8 = ?, x509_chain = ? WHERE type = ?", ownerKey.PKCS8, ownerKey.X509Chain, ownerKey.Type)
+		_, err := s.conn().Exec("UPDATE owner_keys SET pkcs8 = ?, x509_chain = ? WHERE type = ?", ownerKey.PKCS8, ownerKey.X509Chain, ownerKey.Type)
 		if err != nil {
 			return err
 		}
@@ -93,7 +199,7 @@ func UpdateOwnerKeys(ownerKeys []OwnerKey) error {
 func CheckDataExists(tableName string) (bool, error) {
 	var count int
 	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE id = 1", tableName)
-	err := db.QueryRow(query).Scan(&count)
+	err := timed(db).QueryRow(query).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("error counting rows: %w", err)
 	}
@@ -106,7 +212,7 @@ func InsertData(data Data, tableName string) error {
 		return fmt.Errorf("error marshalling value: %w", err)
 	}
 	query := fmt.Sprintf("INSERT INTO %s (id, value) VALUES (1, ?)", tableName)
-	_, err = db.Exec(query, string(value))
+	_, err = timed(db).Exec(query, string(value))
 	if err != nil {
 		return fmt.Errorf("error inserting data: %w", err)
 	}
@@ -119,7 +225,7 @@ func UpdateDataInDB(data Data, tableName string) error {
 		return fmt.Errorf("error marshalling value: %w", err)
 	}
 	query := fmt.Sprintf("UPDATE %s SET value = ? WHERE id = 1", tableName)
-	_, err = db.Exec(query, string(value))
+	_, err = timed(db).Exec(query, string(value))
 	if err != nil {
 		return fmt.Errorf("error updating data: %w", err)
 	}
@@ -130,7 +236,7 @@ func FetchData(tableName string) (Data, error) {
 	var data Data
 	var value string
 	query := fmt.Sprintf("SELECT value FROM %s WHERE id = 1", tableName)
-	err := db.QueryRow(query).Scan(&value)
+	err := timed(db).QueryRow(query).Scan(&value)
 	if err != nil {
 		return data, err
 	}
@@ -141,3 +247,43 @@ func FetchData(tableName string) (Data, error) {
 
 	return data, nil
 }
+
+func UpdateVoucher(voucher Voucher) error {
+	return DefaultState().UpdateVoucher(voucher)
+}
+
+func (s *State) UpdateVoucher(voucher Voucher) error {
+	invalidateVoucher(voucher.GUID)
+	if voucherCBORStore != nil {
+		return s.putExternalVoucher(voucher, false)
+	}
+	_, err := s.conn().Exec("UPDATE owner_vouchers SET cbor = ? WHERE guid = ?", voucher.CBOR, voucher.GUID)
+	return err
+}
+
+// InsertRvInfoOverride records the RV info used to re-register the RV blob of
+// a device instead of the RV info the server was configured with.
+func InsertRvInfoOverride(guid []byte, data Data) error {
+	value, err := json.Marshal(data.Value)
+	if err != nil {
+		return fmt.Errorf("error marshalling value: %w", err)
+	}
+	_, err = timed(db).Exec("INSERT OR REPLACE INTO rvinfo_overrides (guid, value, created_at) VALUES (?, ?, ?)", guid, string(value), time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("error inserting rvinfo override: %w", err)
+	}
+	return nil
+}
+
+func FetchRvInfoOverride(guid []byte) (Data, error) {
+	var data Data
+	var value string
+	err := timed(db).QueryRow("SELECT value FROM rvinfo_overrides WHERE guid = ?", guid).Scan(&value)
+	if err != nil {
+		return data, err
+	}
+	if err := json.Unmarshal([]byte(value), &data.Value); err != nil {
+		return data, err
+	}
+	return data, nil
+}
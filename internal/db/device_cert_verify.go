@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+)
+
+// DeviceCertVerifyOptions relaxes or restricts the verification of device
+// certificate chains of imported vouchers, to accommodate device PKIs that
+// the default verification rejects. Host names are never verified, since
+// device certificates are not bound to host names.
+type DeviceCertVerifyOptions struct {
+	// KeyUsages lists the extended key usages accepted for the device
+	// certificate, such as x509.ExtKeyUsageAny. If empty, the device
+	// certificate must allow server authentication.
+	KeyUsages []x509.ExtKeyUsage
+	// SignatureAlgorithms lists the signature algorithms allowed for the
+	// certificates of the chain. If empty, any algorithm is allowed.
+	SignatureAlgorithms []x509.SignatureAlgorithm
+	// IgnoreCriticalExtensions accepts certificates with critical extensions
+	// that are not handled, such as vendor specific ones.
+	IgnoreCriticalExtensions bool
+}
+
+// deviceCertVerifyOptions is nil to verify device certificate chains like the
+// FDO library does.
+var deviceCertVerifyOptions *DeviceCertVerifyOptions
+
+// SetDeviceCertVerifyOptions sets how device certificate chains are verified.
+// Nil options, the default, verify them strictly.
+func SetDeviceCertVerifyOptions(opts *DeviceCertVerifyOptions) {
+	deviceCertVerifyOptions = opts
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":              x509.ExtKeyUsageAny,
+	"server-auth":      x509.ExtKeyUsageServerAuth,
+	"client-auth":      x509.ExtKeyUsageClientAuth,
+	"code-signing":     x509.ExtKeyUsageCodeSigning,
+	"email-protection": x509.ExtKeyUsageEmailProtection,
+	"time-stamping":    x509.ExtKeyUsageTimeStamping,
+	"ocsp-signing":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// ParseExtKeyUsage parses an extended key usage name, such as any or
+// client-auth.
+func ParseExtKeyUsage(s string) (x509.ExtKeyUsage, error) {
+	usage, ok := extKeyUsages[s]
+	if !ok {
+		names := make([]string, 0, len(extKeyUsages))
+		for name := range extKeyUsages {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return 0, fmt.Errorf("invalid extended key usage %q: must be one of %s", s, strings.Join(names, ", "))
+	}
+	return usage, nil
+}
+
+// ParseSignatureAlgorithm parses a signature algorithm name as printed by
+// x509.SignatureAlgorithm, such as ECDSA-SHA256.
+func ParseSignatureAlgorithm(s string) (x509.SignatureAlgorithm, error) {
+	for alg := x509.MD2WithRSA; alg <= x509.PureEd25519; alg++ {
+		if strings.EqualFold(alg.String(), s) {
+			return alg, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("invalid signature algorithm %q", s)
+}
+
+// verifyDeviceCertChain verifies the device certificate chain of a voucher
+// against roots with the configured options.
+func verifyDeviceCertChain(ov *fdo.Voucher, roots *x509.CertPool) error {
+	if deviceCertVerifyOptions == nil {
+		return ov.VerifyDeviceCertChain(roots)
+	}
+	if ov.CertChain == nil {
+		return nil
+	}
+	return deviceCertVerifyOptions.verify(certChain(ov), roots, time.Now())
+}
+
+// verify verifies a device certificate chain, leaf first, against roots at
+// the given time.
+func (o *DeviceCertVerifyOptions) verify(chain []*x509.Certificate, roots *x509.CertPool, at time.Time) error {
+	if len(chain) == 0 {
+		return errors.New("empty device certificate chain")
+	}
+	if len(o.SignatureAlgorithms) > 0 {
+		for _, cert := range chain {
+			if !slices.Contains(o.SignatureAlgorithms, cert.SignatureAlgorithm) {
+				return fmt.Errorf("certificate %q is signed with disallowed algorithm %s", cert.Subject, cert.SignatureAlgorithm)
+			}
+		}
+	}
+	if o.IgnoreCriticalExtensions {
+		chain = slices.Clone(chain)
+		for i, cert := range chain {
+			relaxed := *cert
+			relaxed.UnhandledCriticalExtensions = nil
+			chain[i] = &relaxed
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   at,
+		KeyUsages:     o.KeyUsages,
+	})
+	return err
+}
+
+func certChain(ov *fdo.Voucher) []*x509.Certificate {
+	if ov.CertChain == nil {
+		return nil
+	}
+	chain := make([]*x509.Certificate, len(*ov.CertChain))
+	for i, cert := range *ov.CertChain {
+		chain[i] = (*x509.Certificate)(cert)
+	}
+	return chain
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// ErrDeviceInfoNotAllowed is returned for device info that matches no entry of
+// the enforced allowlist.
+var ErrDeviceInfoNotAllowed = errors.New("device info is not on the allowlist")
+
+// ErrInvalidAllowlistEntry is returned when storing an allowlist entry that is
+// empty or not a valid pattern.
+var ErrInvalidAllowlistEntry = errors.New("invalid device info allowlist entry")
+
+var enforceDeviceInfoAllowlist bool
+
+// SetDeviceInfoAllowlistEnforced makes CheckDeviceInfoAllowed reject device
+// info that matches no entry of the stored allowlist. An empty allowlist then
+// rejects every device.
+func SetDeviceInfoAllowlistEnforced(enforced bool) {
+	enforceDeviceInfoAllowlist = enforced
+}
+
+func createDeviceInfoAllowlistTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS device_info_allowlist (
+		entry TEXT PRIMARY KEY
+	);`
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FetchDeviceInfoAllowlist returns the entries of the device info allowlist in
+// lexical order.
+func FetchDeviceInfoAllowlist() ([]string, error) {
+	rows, err := timed(db).Query("SELECT entry FROM device_info_allowlist ORDER BY entry")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []string{}
+	for rows.Next() {
+		var entry string
+		if err := rows.Scan(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// UpdateDeviceInfoAllowlist replaces the device info allowlist. Each entry is
+// an exact device info value or a pattern in the syntax of path.Match, such as
+// "gateway-*".
+func UpdateDeviceInfoAllowlist(entries []string) error {
+	for _, entry := range entries {
+		if entry == "" {
+			return fmt.Errorf("%w: empty entry", ErrInvalidAllowlistEntry)
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return fmt.Errorf("%w: %q: %v", ErrInvalidAllowlistEntry, entry, err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec("DELETE FROM device_info_allowlist"); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO device_info_allowlist (entry) VALUES (?)", entry); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CheckDeviceInfoAllowed returns ErrDeviceInfoNotAllowed if the allowlist is
+// enforced and no entry matches info.
+func CheckDeviceInfoAllowed(info string) error {
+	if !enforceDeviceInfoAllowlist {
+		return nil
+	}
+	entries, err := FetchDeviceInfoAllowlist()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if matched, _ := path.Match(entry, info); matched || entry == info {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrDeviceInfoNotAllowed, info)
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+func createDevicesTable(db *sql.DB) error {
+	for _, query := range []string{
+		`CREATE TABLE IF NOT EXISTS devices (
+			guid BLOB PRIMARY KEY,
+			os TEXT NOT NULL,
+			arch TEXT NOT NULL,
+			version TEXT NOT NULL,
+			device TEXT NOT NULL,
+			serial TEXT,
+			modules TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);`,
+		"CREATE INDEX IF NOT EXISTS devices_os_arch ON devices(os, arch)",
+	} {
+		if _, err := timed(db).Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordDevice stores the devmod and supported modules reported by the device
+// with guid, replacing those of a previous onboarding.
+func RecordDevice(guid []byte, device Device) error {
+	return DefaultState().recordDevice(guid, device)
+}
+
+func (s *State) recordDevice(guid []byte, device Device) error {
+	modules, err := json.Marshal(device.Modules)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn().Exec(`INSERT OR REPLACE INTO devices (guid, os, arch, version, device, serial, modules, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		guid, device.OS, device.Arch, device.Version, device.Device, device.Serial, string(modules), time.Now().Unix())
+	return err
+}
+
+// DeviceFilter selects devices by their devmod. Zero fields select all
+// devices.
+type DeviceFilter struct {
+	OS      string
+	Arch    string
+	Version string
+	// After selects devices with a greater GUID, to page through devices
+	After []byte
+	Limit int
+}
+
+const deviceColumns = "guid, os, arch, version, device, serial, modules, updated_at"
+
+// FetchDevices returns the devices selected by filter ordered by GUID.
+func FetchDevices(filter DeviceFilter) ([]Device, error) {
+	var where []string
+	var args []any
+	for _, field := range []struct{ column, value string }{
+		{"os", filter.OS},
+		{"arch", filter.Arch},
+		{"version", filter.Version},
+	} {
+		if field.value != "" {
+			where = append(where, field.column+" = ?")
+			args = append(args, field.value)
+		}
+	}
+	if filter.After != nil {
+		where = append(where, "guid > ?")
+		args = append(args, filter.After)
+	}
+	query := "SELECT " + deviceColumns + " FROM devices"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY guid"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := timed(db).Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// FetchDevice returns the device with the given original or replacement
+// GUID. A device that has not reported its devmod yet is not found and
+// sql.ErrNoRows is returned.
+func FetchDevice(guid []byte) (Device, error) {
+	// Devmod is recorded under the GUID a device onboarded with
+	row := timed(db).QueryRow("SELECT "+deviceColumns+` FROM devices
+		WHERE guid = ? OR guid IN (SELECT guid FROM device_onboarding WHERE new_guid = ?)
+		ORDER BY updated_at DESC LIMIT 1`, guid, guid)
+	return scanDevice(row)
+}
+
+func scanDevice(row interface{ Scan(...any) error }) (Device, error) {
+	var device Device
+	var guid []byte
+	var serial sql.NullString
+	var modules string
+	var updatedAt int64
+	if err := row.Scan(&guid, &device.OS, &device.Arch, &device.Version, &device.Device, &serial, &modules, &updatedAt); err != nil {
+		return Device{}, err
+	}
+	if err := json.Unmarshal([]byte(modules), &device.Modules); err != nil {
+		return Device{}, err
+	}
+	device.GUID = hex.EncodeToString(guid)
+	device.Serial = serial.String
+	device.UpdatedAt = time.Unix(updatedAt, 0).UTC()
+	return device, nil
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrStaleDIRequest is returned for a DI request presenting a device key that
+// was first presented longer ago than the anti-replay window.
+var ErrStaleDIRequest = errors.New("DI request is outside the anti-replay window")
+
+var diReplayWindow time.Duration
+
+// SetDIReplayWindow bounds how long DI requests presenting the same device key
+// are accepted. DI messages carry no timestamp, so the window starts when the
+// device key is first presented; retries of DI within it are accepted, while
+// replays of captured DI material after it are rejected. A window of 0, the
+// default, accepts DI requests regardless of age.
+func SetDIReplayWindow(window time.Duration) {
+	diReplayWindow = window
+}
+
+func createDIRequestsTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS di_requests (
+		key_hash BLOB PRIMARY KEY,
+		first_seen INTEGER NOT NULL
+	);`
+	_, err := timed(db).Exec(query)
+	return err
+}
+
+// CheckDIRequest records when the device key of a DI request received at was
+// first presented and returns ErrStaleDIRequest if that is longer ago than
+// the anti-replay window.
+func CheckDIRequest(deviceKey crypto.PublicKey, at time.Time) error {
+	return DefaultState().checkDIRequest(deviceKey, at)
+}
+
+func (s *State) checkDIRequest(deviceKey crypto.PublicKey, at time.Time) error {
+	if diReplayWindow <= 0 {
+		return nil
+	}
+	der, err := x509.MarshalPKIXPublicKey(deviceKey)
+	if err != nil {
+		return fmt.Errorf("error marshaling device key: %w", err)
+	}
+	keyHash := sha256.Sum256(der)
+
+	if _, err := s.conn().Exec("INSERT OR IGNORE INTO di_requests (key_hash, first_seen) VALUES (?, ?)",
+		keyHash[:], at.UnixMilli()); err != nil {
+		return err
+	}
+	var firstSeen int64
+	if err := s.conn().QueryRow("SELECT first_seen FROM di_requests WHERE key_hash = ?", keyHash[:]).Scan(&firstSeen); err != nil {
+		return err
+	}
+	if age := at.Sub(time.UnixMilli(firstSeen)); age > diReplayWindow {
+		return fmt.Errorf("%w: device key first presented %s ago", ErrStaleDIRequest, age.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+// ExportVouchers calls fn with each stored voucher matching filter, ordered by
+// GUID, and stops at the first error of fn. Vouchers are read one at a time,
+// with CBOR kept in an external store loaded as they are read, so that
+// exporting a fleet does not hold all vouchers in memory.
+func ExportVouchers(filter VoucherFilter, fn func(Voucher) error) error {
+	return DefaultState().ExportVouchers(filter, fn)
+}
+
+func (s *State) ExportVouchers(filter VoucherFilter, fn func(Voucher) error) error {
+	q := s.conn()
+	metadata, err := fetchVoucherMetadata(q)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Query("SELECT guid, cbor FROM owner_vouchers WHERE " + filter.where() + " ORDER BY guid")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	filterDeviceInfo := filter.DeviceInfo != "" || filter.Search != ""
+	for rows.Next() {
+		var voucher Voucher
+		if err := rows.Scan(&voucher.GUID, &voucher.CBOR); err != nil {
+			return err
+		}
+		if filterDeviceInfo {
+			deviceInfo, ok := metadata[string(voucher.GUID)]
+			if !ok {
+				ov, err := ParseVoucher(voucher)
+				if err != nil {
+					return err
+				}
+				deviceInfo = ov.Header.Val.DeviceInfo
+			}
+			if !filter.matchesDeviceInfo(voucher.GUID, deviceInfo) {
+				continue
+			}
+		}
+		if err := externalVoucherCBOR(&voucher); err != nil {
+			return err
+		}
+		if err := fn(voucher); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,225 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// VoucherCBORStore keeps the signed CBOR of vouchers outside of the database,
+// so that the database only holds voucher metadata and a leaked database does
+// not expose the vouchers themselves.
+type VoucherCBORStore interface {
+	// PutVoucherCBOR stores or replaces the CBOR of a voucher.
+	PutVoucherCBOR(guid, cbor []byte) error
+	// VoucherCBOR returns the CBOR of a voucher.
+	VoucherCBOR(guid []byte) ([]byte, error)
+	// DeleteVoucherCBOR removes the CBOR of a voucher, if stored.
+	DeleteVoucherCBOR(guid []byte) error
+}
+
+var voucherCBORStore VoucherCBORStore
+
+// SetVoucherCBORStore makes vouchers stored from now on keep their CBOR in
+// store, with only their metadata in the database. Vouchers stored before
+// remain readable. A nil store stores CBOR in the database again.
+func SetVoucherCBORStore(store VoucherCBORStore) {
+	voucherCBORStore = store
+}
+
+func createVoucherMetadataTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS voucher_metadata (
+		guid BLOB PRIMARY KEY,
+		device_info TEXT NOT NULL
+	);`
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// putExternalVoucher stores the metadata of a voucher in the database and its
+// CBOR in the external store. The owner_vouchers row is kept, without CBOR,
+// so that GUID queries work the same in both modes.
+func (s *State) putExternalVoucher(voucher Voucher, insert bool) error {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+		return fmt.Errorf("error parsing voucher: %w", err)
+	}
+
+	if insert {
+		if _, err := s.conn().Exec("INSERT INTO owner_vouchers (guid, cbor) VALUES (?, ?)", voucher.GUID, []byte{}); err != nil {
+			return err
+		}
+	} else if _, err := s.conn().Exec("UPDATE owner_vouchers SET cbor = ? WHERE guid = ?", []byte{}, voucher.GUID); err != nil {
+		return err
+	}
+	if _, err := s.conn().Exec("INSERT OR REPLACE INTO voucher_metadata (guid, device_info) VALUES (?, ?)",
+		voucher.GUID, ov.Header.Val.DeviceInfo); err != nil {
+		return err
+	}
+	return voucherCBORStore.PutVoucherCBOR(voucher.GUID, voucher.CBOR)
+}
+
+// externalVoucherCBOR loads the CBOR of a voucher that was stored without it.
+func externalVoucherCBOR(voucher *Voucher) error {
+	if len(voucher.CBOR) > 0 {
+		return nil
+	}
+	if voucherCBORStore == nil {
+		return fmt.Errorf("voucher %x is stored in an external CBOR store, but none is configured", voucher.GUID)
+	}
+	data, err := voucherCBORStore.VoucherCBOR(voucher.GUID)
+	if err != nil {
+		return fmt.Errorf("error loading voucher %x from external CBOR store: %w", voucher.GUID, err)
+	}
+	voucher.CBOR = data
+	return nil
+}
+
+// deleteVoucher removes a voucher and its metadata and external CBOR.
+func (s *State) deleteVoucher(guid []byte) error {
+	invalidateVoucher(guid)
+	if _, err := s.conn().Exec("DELETE FROM owner_vouchers WHERE guid = ?", guid); err != nil {
+		return err
+	}
+	if _, err := s.conn().Exec("DELETE FROM voucher_metadata WHERE guid = ?", guid); err != nil {
+		return err
+	}
+	if voucherCBORStore == nil {
+		return nil
+	}
+	return voucherCBORStore.DeleteVoucherCBOR(guid)
+}
+
+// DirVoucherCBORStore stores the CBOR of each voucher in a file named by its
+// GUID in a directory.
+type DirVoucherCBORStore string
+
+func (d DirVoucherCBORStore) path(guid []byte) string {
+	return filepath.Join(string(d), hex.EncodeToString(guid)+".cbor")
+}
+
+func (d DirVoucherCBORStore) PutVoucherCBOR(guid, cbor []byte) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(string(d), ".voucher-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(cbor); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(guid))
+}
+
+func (d DirVoucherCBORStore) VoucherCBOR(guid []byte) ([]byte, error) {
+	return os.ReadFile(d.path(guid))
+}
+
+func (d DirVoucherCBORStore) DeleteVoucherCBOR(guid []byte) error {
+	if err := os.Remove(d.path(guid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// OwnerVouchers returns the owner voucher state used by the FDO protocol
+// servers and clients. When a VoucherCBORStore is set, vouchers are stored
+// through it, otherwise state stores them itself. Removed and replaced
+// vouchers are audited.
+func OwnerVouchers(state *sqlite.DB) fdo.OwnerVoucherPersistentState {
+	if voucherCBORStore == nil {
+		return auditedVouchers{state}
+	}
+	return auditedVouchers{externalVouchers{NewState(state)}}
+}
+
+// ManufacturerVouchers returns the voucher state used by DI. Vouchers that DI
+// extends to an owner key are stored as owner vouchers, so when a
+// VoucherCBORStore is set they are stored through it like those of
+// OwnerVouchers. Vouchers that are not extended are left to state.
+func ManufacturerVouchers(state *sqlite.DB) fdo.ManufacturerVoucherPersistentState {
+	if voucherCBORStore == nil {
+		return state
+	}
+	return externalManufacturerVouchers{state, externalVouchers{NewState(state)}}
+}
+
+type externalManufacturerVouchers struct {
+	fdo.ManufacturerVoucherPersistentState
+	owner externalVouchers
+}
+
+func (v externalManufacturerVouchers) NewVoucher(ctx context.Context, ov *fdo.Voucher) error {
+	if len(ov.Entries) == 0 {
+		return v.ManufacturerVoucherPersistentState.NewVoucher(ctx, ov)
+	}
+	return v.owner.AddVoucher(ctx, ov)
+}
+
+// externalVouchers implements the owner voucher state of go-fdo on top of a
+// State, so that vouchers added by the protocol keep their CBOR externally.
+type externalVouchers struct {
+	state *State
+}
+
+func (v externalVouchers) AddVoucher(_ context.Context, ov *fdo.Voucher) error {
+	data, err := cbor.Marshal(ov)
+	if err != nil {
+		return err
+	}
+	guid := ov.Header.Val.GUID
+	return v.state.InsertVoucher(Voucher{GUID: guid[:], CBOR: data})
+}
+
+func (v externalVouchers) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	if _, err := v.RemoveVoucher(ctx, guid); err != nil {
+		return err
+	}
+	return v.AddVoucher(ctx, ov)
+}
+
+func (v externalVouchers) RemoveVoucher(ctx context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	ov, err := v.Voucher(ctx, guid)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.state.deleteVoucher(guid[:]); err != nil {
+		return nil, err
+	}
+	return ov, nil
+}
+
+func (v externalVouchers) Voucher(_ context.Context, guid protocol.GUID) (*fdo.Voucher, error) {
+	voucher, err := v.state.FetchVoucher(guid[:])
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fdo.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+		return nil, fmt.Errorf("error parsing voucher: %w", err)
+	}
+	return &ov, nil
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"cmp"
+	"slices"
+)
+
+// FetchDeviceInfoFacets returns the distinct device info values of stored
+// vouchers with their counts, most common first.
+func FetchDeviceInfoFacets() ([]DeviceInfoFacet, error) {
+	counts := make(map[string]int)
+	if err := forEachDeviceInfo(timed(db), "1", func(_ []byte, deviceInfo string) {
+		counts[deviceInfo]++
+	}); err != nil {
+		return nil, err
+	}
+
+	facets := make([]DeviceInfoFacet, 0, len(counts))
+	for deviceInfo, count := range counts {
+		facets = append(facets, DeviceInfoFacet{DeviceInfo: deviceInfo, Count: count})
+	}
+	slices.SortFunc(facets, func(a, b DeviceInfoFacet) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.DeviceInfo, b.DeviceInfo)
+	})
+	return facets, nil
+}
+
+// forEachDeviceInfo calls fn with the GUID and device info of each voucher
+// stored in q matching the where clause. Device info is read from the voucher
+// metadata of vouchers stored without CBOR. Otherwise it is stored in the
+// voucher header rather than in its own column, so each voucher is decoded.
+func forEachDeviceInfo(q querier, where string, fn func(guid []byte, deviceInfo string)) error {
+	metadata, err := fetchVoucherMetadata(q)
+	if err != nil {
+		return err
+	}
+	rows, err := q.Query("SELECT guid, cbor FROM owner_vouchers WHERE " + where)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var voucher Voucher
+		if err := rows.Scan(&voucher.GUID, &voucher.CBOR); err != nil {
+			return err
+		}
+		if deviceInfo, ok := metadata[string(voucher.GUID)]; ok {
+			fn(voucher.GUID, deviceInfo)
+			continue
+		}
+		ov, err := ParseVoucher(voucher)
+		if err != nil {
+			return err
+		}
+		fn(voucher.GUID, ov.Header.Val.DeviceInfo)
+	}
+	return rows.Err()
+}
+
+// fetchVoucherMetadata returns the device info of the vouchers stored without
+// CBOR, keyed by GUID. It is read on its own rather than joined with
+// owner_vouchers, as the SQLite driver reads the columns that follow the empty
+// CBOR of these vouchers as empty.
+func fetchVoucherMetadata(q querier) (map[string]string, error) {
+	rows, err := q.Query("SELECT guid, device_info FROM voucher_metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metadata := make(map[string]string)
+	for rows.Next() {
+		var guid []byte
+		var deviceInfo string
+		if err := rows.Scan(&guid, &deviceInfo); err != nil {
+			return nil, err
+		}
+		metadata[string(guid)] = deviceInfo
+	}
+	return metadata, rows.Err()
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"fmt"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+)
+
+// DefaultImportBatchSize is the number of vouchers imported per transaction
+// unless SetImportBatchSize is called.
+const DefaultImportBatchSize = 100
+
+var importBatchSize = DefaultImportBatchSize
+
+// SetImportBatchSize sets the number of vouchers ImportVouchers commits per
+// transaction. A size of 1 or less imports every voucher on its own.
+func SetImportBatchSize(size int) {
+	importBatchSize = size
+}
+
+// VoucherImportError attributes a failed ImportVouchers to the voucher at
+// Index.
+type VoucherImportError struct {
+	Index int
+	Err   error
+}
+
+func (e *VoucherImportError) Error() string {
+	return fmt.Sprintf("voucher %d: %v", e.Index, e.Err)
+}
+
+func (e *VoucherImportError) Unwrap() error { return e.Err }
+
+// ImportVouchers imports vouchers in order like ImportVoucher, committing them
+// in batches of the configured size. The returned slice reports for each
+// imported voucher whether the database was modified. When a voucher fails,
+// the vouchers before it are kept, the rest are not imported and the error is
+// a *VoucherImportError.
+func (s *State) ImportVouchers(vouchers []Voucher) (stored []bool, err error) {
+	defer func() { countVoucherImports(stored, err) }()
+	stored = make([]bool, 0, len(vouchers))
+	for start := 0; start < len(vouchers); start += max(importBatchSize, 1) {
+		batch := vouchers[start:min(start+max(importBatchSize, 1), len(vouchers))]
+
+		if len(batch) > 1 {
+			if batchStored, err := s.importBatch(batch); err == nil {
+				stored = append(stored, batchStored...)
+				continue
+			}
+			// The batch was rolled back, so import it again one voucher at a
+			// time to find which voucher failed
+		}
+
+		for i, voucher := range batch {
+			ok, err := s.ImportVoucher(voucher)
+			if err != nil {
+				return stored, &VoucherImportError{Index: start + i, Err: err}
+			}
+			stored = append(stored, ok)
+		}
+	}
+	return stored, nil
+}
+
+// importBatch imports vouchers in a single transaction.
+func (s *State) importBatch(vouchers []Voucher) ([]bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	txState := &State{db: s.db, tx: tx}
+	stored := make([]bool, len(vouchers))
+	for i, voucher := range vouchers {
+		if stored[i], err = txState.ImportVoucher(voucher); err != nil {
+			return nil, err
+		}
+	}
+	return stored, tx.Commit()
+}
+
+// countVoucherImports records the results of importing vouchers in metrics.
+// Batches that were rolled back are only counted once imported again.
+func countVoucherImports(stored []bool, err error) {
+	for _, ok := range stored {
+		if ok {
+			metrics.CountVoucherImport("stored")
+		} else {
+			metrics.CountVoucherImport("unchanged")
+		}
+	}
+	if err != nil {
+		metrics.CountVoucherImport("rejected")
+	}
+}
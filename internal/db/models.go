@@ -3,6 +3,8 @@
 
 package db
 
+import "time"
+
 type Data struct {
 	Value interface{} `json:"value"`
 }
@@ -17,3 +19,36 @@ type OwnerKey struct {
 	PKCS8     []byte `json:"pkcs8"`
 	X509Chain []byte `json:"x509_chain"`
 }
+
+type DeviceOnboarding struct {
+	GUID           []byte     `json:"guid"`
+	NewGUID        []byte     `json:"new_guid"`
+	TO2Completed   bool       `json:"to2_completed"`
+	TO2CompletedAt *time.Time `json:"to2_completed_at,omitempty"`
+}
+
+// TimelineEvent is an event in the onboarding of a device.
+type TimelineEvent struct {
+	Event  string    `json:"event"`
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type DeviceInfoFacet struct {
+	DeviceInfo string `json:"device_info"`
+	Count      int    `json:"count"`
+}
+
+// Device is the devmod a device reported in its last TO2 and the service info
+// modules it supports.
+type Device struct {
+	GUID    string `json:"guid"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Version string `json:"version"`
+	Device  string `json:"device"`
+	// Serial is the hex encoded serial number, if reported
+	Serial    string    `json:"serial,omitempty"`
+	Modules   []string  `json:"modules"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func createDeviceOnboardingTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS device_onboarding (
+		guid BLOB PRIMARY KEY,
+		new_guid BLOB,
+		to2_completed INTEGER NOT NULL DEFAULT 0,
+		to2_completed_at INTEGER
+	);`
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RecordTO2Completed marks the device with the given GUID as onboarded. newGUID
+// is the GUID the device was assigned during TO2, which may equal guid when
+// credentials are reused.
+func RecordTO2Completed(guid, newGUID []byte) error {
+	_, err := timed(db).Exec(`INSERT INTO device_onboarding (guid, new_guid, to2_completed, to2_completed_at)
+		VALUES (?, ?, 1, ?)
+		ON CONFLICT (guid) DO UPDATE SET new_guid = excluded.new_guid, to2_completed = 1, to2_completed_at = excluded.to2_completed_at`,
+		guid, newGUID, time.Now().Unix())
+	return err
+}
+
+// SetTO2Completed overrides whether the device with the given original or
+// replacement GUID has completed TO2. Marking a device without a record as
+// completed records it with its GUID unchanged.
+func SetTO2Completed(guid []byte, completed bool) error {
+	if completed {
+		result, err := timed(db).Exec(`UPDATE device_onboarding SET to2_completed = 1, to2_completed_at = ?
+			WHERE guid = ? OR new_guid = ?`, time.Now().Unix(), guid, guid)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		return RecordTO2Completed(guid, guid)
+	}
+	_, err := timed(db).Exec(`UPDATE device_onboarding SET to2_completed = 0, to2_completed_at = NULL
+		WHERE guid = ? OR new_guid = ?`, guid, guid)
+	return err
+}
+
+// FetchDeviceOnboarding returns the onboarding state of a device by its
+// original or replacement GUID. A device without a record has not completed
+// TO2 and sql.ErrNoRows is returned.
+func FetchDeviceOnboarding(guid []byte) (DeviceOnboarding, error) {
+	var onboarding DeviceOnboarding
+	var completedAt sql.NullInt64
+	err := timed(db).QueryRow(`SELECT guid, new_guid, to2_completed, to2_completed_at FROM device_onboarding
+		WHERE guid = ? OR new_guid = ? LIMIT 1`, guid, guid).
+		Scan(&onboarding.GUID, &onboarding.NewGUID, &onboarding.TO2Completed, &completedAt)
+	if err != nil {
+		return onboarding, err
+	}
+	if completedAt.Valid {
+		t := time.Unix(completedAt.Int64, 0).UTC()
+		onboarding.TO2CompletedAt = &t
+	}
+	return onboarding, nil
+}
+
+// FetchDeviceOnboardings returns the onboarding states of the devices with the
+// given original or replacement GUIDs in one query, keyed by the GUIDs as
+// given. GUIDs of devices without a record are missing from the result.
+func FetchDeviceOnboardings(guids [][]byte) (map[string]DeviceOnboarding, error) {
+	onboardings := make(map[string]DeviceOnboarding)
+	if len(guids) == 0 {
+		return onboardings, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(guids)), ",")
+	args := make([]any, 0, 2*len(guids))
+	for range 2 {
+		for _, guid := range guids {
+			args = append(args, guid)
+		}
+	}
+	rows, err := timed(db).Query(`SELECT guid, new_guid, to2_completed, to2_completed_at FROM device_onboarding
+		WHERE guid IN (`+placeholders+`) OR new_guid IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var onboarding DeviceOnboarding
+		var completedAt sql.NullInt64
+		if err := rows.Scan(&onboarding.GUID, &onboarding.NewGUID, &onboarding.TO2Completed, &completedAt); err != nil {
+			return nil, err
+		}
+		if completedAt.Valid {
+			t := time.Unix(completedAt.Int64, 0).UTC()
+			onboarding.TO2CompletedAt = &t
+		}
+		onboardings[string(onboarding.GUID)] = onboarding
+		if onboarding.NewGUID != nil {
+			onboardings[string(onboarding.NewGUID)] = onboarding
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return onboardings, nil
+}
+
+// IsTO2Completed reports whether the device with the given original or
+// replacement GUID has completed TO2.
+func IsTO2Completed(guid []byte) (bool, error) {
+	onboarding, err := FetchDeviceOnboarding(guid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return onboarding.TO2Completed, nil
+}
+
+// OnboardingVouchers wraps the voucher state used by the TO2 server to record
+// when a device completes onboarding.
+type OnboardingVouchers struct {
+	fdo.OwnerVoucherPersistentState
+}
+
+// ReplaceVoucher stores the voucher extended at the end of TO2 and records the
+// device as onboarded.
+func (s OnboardingVouchers) ReplaceVoucher(ctx context.Context, guid protocol.GUID, ov *fdo.Voucher) error {
+	invalidateVoucher(guid[:])
+	if err := s.OwnerVoucherPersistentState.ReplaceVoucher(ctx, guid, ov); err != nil {
+		return err
+	}
+	newGUID := ov.Header.Val.GUID
+	return RecordTO2Completed(guid[:], newGUID[:])
+}
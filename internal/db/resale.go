@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"log/slog"
+
+	"github.com/fido-device-onboard/go-fdo"
+)
+
+// ResellVoucher extends the stored voucher with guid by extend and removes it
+// in one transaction. The voucher is only removed once it was extended, and
+// it cannot be changed in between, such as by TO2 or an import, so that a
+// failed or raced extension never loses the voucher. It returns the extended
+// voucher.
+func (s *State) ResellVoucher(guid []byte, extend func(*fdo.Voucher) (*fdo.Voucher, error)) (*fdo.Voucher, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	txState := &State{db: s.db, tx: tx}
+
+	voucher, err := txState.FetchVoucher(guid)
+	if err != nil {
+		return nil, err
+	}
+	ov, err := ParseVoucher(voucher)
+	if err != nil {
+		return nil, err
+	}
+	extended, err := extend(ov)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"owner_vouchers", "voucher_metadata", "voucher_expiry"} {
+		if _, err := txState.conn().Exec("DELETE FROM "+table+" WHERE guid = ?", guid); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	invalidateVoucher(guid)
+
+	// The CBOR of the removed voucher is no longer referenced, so failing to
+	// delete it does not fail the resale
+	if voucherCBORStore != nil {
+		if err := voucherCBORStore.DeleteVoucherCBOR(guid); err != nil {
+			slog.Warn("Error deleting CBOR of resold voucher", "guid", guid, "error", err)
+		}
+	}
+	return extended, nil
+}
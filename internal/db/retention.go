@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/audit"
+)
+
+// RetentionPolicy bounds how long owner vouchers are kept. Zero fields keep
+// vouchers forever.
+type RetentionPolicy struct {
+	// MaxAge removes vouchers stored longer ago
+	MaxAge time.Duration
+	// MaxPerDeviceInfo keeps only the newest vouchers of each device info
+	MaxPerDeviceInfo int
+}
+
+var retentionPolicy RetentionPolicy
+
+// SetRetentionPolicy sets the policy by which ApplyRetention removes vouchers
+// and their expiration times are computed.
+func SetRetentionPolicy(policy RetentionPolicy) {
+	retentionPolicy = policy
+}
+
+// VoucherExpiry is when a stored voucher expires under the max age of the
+// retention policy.
+type VoucherExpiry struct {
+	GUID      string    `json:"guid"`
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createVoucherExpiryTable creates the table of voucher storage times. The
+// owner_vouchers table is created by go-fdo, so the times are kept apart from
+// it, keyed by GUID.
+func createVoucherExpiryTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS voucher_expiry (
+		guid BLOB PRIMARY KEY,
+		stored_at INTEGER NOT NULL,
+		expires_at INTEGER
+	);`
+	_, err := timed(db).Exec(query)
+	return err
+}
+
+// trackVoucherExpiry records now as the storage time of vouchers not tracked
+// yet, such as those added by DI, forgets removed vouchers and sets the
+// expiration times of the max age of the retention policy.
+func (s *State) trackVoucherExpiry(now time.Time) error {
+	if _, err := s.conn().Exec(`INSERT INTO voucher_expiry (guid, stored_at)
+		SELECT guid, ? FROM owner_vouchers WHERE guid NOT IN (SELECT guid FROM voucher_expiry)`, now.Unix()); err != nil {
+		return err
+	}
+	if _, err := s.conn().Exec("DELETE FROM voucher_expiry WHERE guid NOT IN (SELECT guid FROM owner_vouchers)"); err != nil {
+		return err
+	}
+	var maxAge any
+	if retentionPolicy.MaxAge > 0 {
+		maxAge = int64(retentionPolicy.MaxAge / time.Second)
+	}
+	_, err := s.conn().Exec("UPDATE voucher_expiry SET expires_at = stored_at + ?", maxAge)
+	return err
+}
+
+// FetchExpiringVouchers returns the vouchers that expire before the given
+// time, soonest first. No voucher expires without a max age.
+func FetchExpiringVouchers(before time.Time) ([]VoucherExpiry, error) {
+	return DefaultState().FetchExpiringVouchers(before, time.Now())
+}
+
+func (s *State) FetchExpiringVouchers(before, now time.Time) ([]VoucherExpiry, error) {
+	if err := s.trackVoucherExpiry(now); err != nil {
+		return nil, err
+	}
+	rows, err := s.conn().Query(`SELECT guid, stored_at, expires_at FROM voucher_expiry
+		WHERE expires_at IS NOT NULL AND expires_at < ? ORDER BY expires_at, guid`, before.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	expiring := []VoucherExpiry{}
+	for rows.Next() {
+		var guid []byte
+		var storedAt, expiresAt int64
+		if err := rows.Scan(&guid, &storedAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		expiring = append(expiring, VoucherExpiry{
+			GUID:      hex.EncodeToString(guid),
+			StoredAt:  time.Unix(storedAt, 0).UTC(),
+			ExpiresAt: time.Unix(expiresAt, 0).UTC(),
+		})
+	}
+	return expiring, rows.Err()
+}
+
+// ApplyRetention removes the vouchers that expired by now and, past the max
+// per device info, the oldest vouchers of each device info. Removals are
+// audited with the reason. It returns the number of removed vouchers.
+func (s *State) ApplyRetention(ctx context.Context, now time.Time) (int, error) {
+	if err := s.trackVoucherExpiry(now); err != nil {
+		return 0, err
+	}
+	policy := retentionPolicy
+
+	removals := make(map[string]string)
+	var order [][]byte
+	remove := func(guid []byte, reason string) {
+		if _, ok := removals[string(guid)]; !ok {
+			order = append(order, guid)
+		}
+		removals[string(guid)] = reason
+	}
+
+	if policy.MaxAge > 0 {
+		rows, err := s.conn().Query("SELECT guid FROM voucher_expiry WHERE expires_at <= ? ORDER BY expires_at", now.Unix())
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+			var guid []byte
+			if err := rows.Scan(&guid); err != nil {
+				rows.Close()
+				return 0, err
+			}
+			remove(guid, fmt.Sprintf("older than max age of %s", policy.MaxAge))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+	}
+
+	if policy.MaxPerDeviceInfo > 0 {
+		excess, err := s.excessVouchers(policy.MaxPerDeviceInfo)
+		if err != nil {
+			return 0, err
+		}
+		for _, guid := range excess {
+			if _, ok := removals[string(guid)]; !ok {
+				remove(guid, fmt.Sprintf("more than %d vouchers for its device info", policy.MaxPerDeviceInfo))
+			}
+		}
+	}
+
+	removed := 0
+	for _, guid := range order {
+		err := s.deleteVoucher(guid)
+		if err == nil {
+			_, err = s.conn().Exec("DELETE FROM voucher_expiry WHERE guid = ?", guid)
+		}
+		audit.Record(ctx, audit.VoucherExpired, guid, err, removals[string(guid)])
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// excessVouchers returns the vouchers of each device info beyond the newest
+// limit vouchers.
+func (s *State) excessVouchers(limit int) ([][]byte, error) {
+	storedAt := make(map[string]int64)
+	rows, err := s.conn().Query("SELECT guid, stored_at FROM voucher_expiry")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var guid []byte
+		var at int64
+		if err := rows.Scan(&guid, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		storedAt[string(guid)] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byDeviceInfo := make(map[string][][]byte)
+	if err := forEachDeviceInfo(s.conn(), "1", func(guid []byte, deviceInfo string) {
+		byDeviceInfo[deviceInfo] = append(byDeviceInfo[deviceInfo], guid)
+	}); err != nil {
+		return nil, err
+	}
+
+	var excess [][]byte
+	for _, guids := range byDeviceInfo {
+		if len(guids) <= limit {
+			continue
+		}
+		// Newest first, by GUID for vouchers stored at the same time
+		slices.SortFunc(guids, func(a, b []byte) int {
+			if c := cmp.Compare(storedAt[string(b)], storedAt[string(a)]); c != 0 {
+				return c
+			}
+			return cmp.Compare(string(a), string(b))
+		})
+		excess = append(excess, guids[limit:]...)
+	}
+	return excess, nil
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// RVBypassPolicy decides how vouchers whose RV info sets RV bypass are
+// imported. Devices of such vouchers contact the owner directly, so they
+// cannot be steered through rendezvous.
+type RVBypassPolicy string
+
+const (
+	// AllowRVBypass imports RV bypass vouchers like any other.
+	AllowRVBypass RVBypassPolicy = "allow"
+	// WarnRVBypass imports RV bypass vouchers with a warning.
+	WarnRVBypass RVBypassPolicy = "warn"
+	// RejectRVBypass fails the import of RV bypass vouchers.
+	RejectRVBypass RVBypassPolicy = "reject"
+)
+
+// ErrRVBypassVoucher is returned when importing a voucher whose RV info sets
+// RV bypass while the RV bypass policy rejects them.
+var ErrRVBypassVoucher = errors.New("voucher RV info uses RV bypass")
+
+var rvBypassPolicy = WarnRVBypass
+
+func ParseRVBypassPolicy(s string) (RVBypassPolicy, error) {
+	switch policy := RVBypassPolicy(s); policy {
+	case AllowRVBypass, WarnRVBypass, RejectRVBypass:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid RV bypass policy %q: must be one of allow, warn, reject", s)
+	}
+}
+
+func SetRVBypassPolicy(policy RVBypassPolicy) {
+	rvBypassPolicy = policy
+}
+
+// RVBypassWarning returns a warning for an imported voucher whose RV info sets
+// RV bypass if the RV bypass policy warns about them, and "" otherwise.
+func RVBypassWarning(ovCBOR []byte) string {
+	if rvBypassPolicy != WarnRVBypass {
+		return ""
+	}
+	ov, bypass, err := parseRVBypass(ovCBOR)
+	if err != nil || !bypass {
+		return ""
+	}
+	return fmt.Sprintf("voucher %s uses RV bypass", hex.EncodeToString(ov.Header.Val.GUID[:]))
+}
+
+// checkRVBypass applies the RV bypass policy to an imported voucher.
+func checkRVBypass(ovCBOR []byte) error {
+	if rvBypassPolicy == AllowRVBypass {
+		return nil
+	}
+	ov, bypass, err := parseRVBypass(ovCBOR)
+	if err != nil || !bypass {
+		return err
+	}
+	guid := ov.Header.Val.GUID
+	if rvBypassPolicy == RejectRVBypass {
+		return fmt.Errorf("%w: %x", ErrRVBypassVoucher, guid[:])
+	}
+	slog.Warn("Importing voucher with RV bypass", "GUID", hex.EncodeToString(guid[:]))
+	return nil
+}
+
+func parseRVBypass(ovCBOR []byte) (*fdo.Voucher, bool, error) {
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(ovCBOR, &ov); err != nil {
+		return nil, false, fmt.Errorf("error parsing voucher: %w", err)
+	}
+	for _, directive := range ov.Header.Val.RvInfo {
+		for _, instruction := range directive {
+			if instruction.Variable == protocol.RVBypass {
+				return &ov, true, nil
+			}
+		}
+	}
+	return &ov, false, nil
+}
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
+	"github.com/fido-device-onboard/go-fdo/sqlite"
+)
+
+// State stores vouchers in an explicit database. Unlike the package level
+// functions, which use the database given to InitDb, a State can be injected
+// into handlers, so handlers on different databases may run concurrently.
+type State struct {
+	db *sql.DB
+	// tx is set while the State is used inside a transaction
+	tx *sql.Tx
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// conn returns the transaction of s, if any, or else its database.
+func (s *State) conn() querier {
+	if s.tx != nil {
+		return timed(s.tx)
+	}
+	return timed(s.db)
+}
+
+// timedQuerier records the duration of each statement it runs. Rows of a
+// query are read after it returns, so only the time to start the query is
+// recorded for them.
+type timedQuerier struct {
+	q querier
+}
+
+// timed returns q recording the durations of its statements in metrics.
+func timed(q querier) querier {
+	return timedQuerier{q: q}
+}
+
+func (t timedQuerier) Exec(query string, args ...any) (sql.Result, error) {
+	defer metrics.ObserveDBQuery(queryLabel(query), time.Now())
+	return t.q.Exec(query, args...)
+}
+
+func (t timedQuerier) Query(query string, args ...any) (*sql.Rows, error) {
+	defer metrics.ObserveDBQuery(queryLabel(query), time.Now())
+	return t.q.Query(query, args...)
+}
+
+func (t timedQuerier) QueryRow(query string, args ...any) *sql.Row {
+	defer metrics.ObserveDBQuery(queryLabel(query), time.Now())
+	return t.q.QueryRow(query, args...)
+}
+
+// queryLabel labels a statement by its kind and the table it is on, such as
+// "select owner_vouchers", so that metrics have a small number of series.
+func queryLabel(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	kind := fields[0]
+	for i, field := range fields[:len(fields)-1] {
+		if field == "from" || field == "into" || (field == "update" && i == 0) {
+			table, _, _ := strings.Cut(fields[i+1], "(")
+			return kind + " " + table
+		}
+	}
+	return kind
+}
+
+// NewState returns the State of a database. Init must have been called on a
+// State of the same database, or InitDb with it, before it is used.
+func NewState(state *sqlite.DB) *State {
+	return &State{db: state.DB()}
+}
+
+// DefaultState returns the State of the database given to InitDb.
+func DefaultState() *State {
+	return &State{db: db}
+}
+
+// Init creates the tables of the server that are not created by go-fdo.
+func (s *State) Init() error {
+	for _, create := range []func(*sql.DB) error{
+		createRvTable,
+		createOwnerInfoTable,
+		createRvInfoOverridesTable,
+		createDeviceOnboardingTable,
+		createVoucherMetadataTable,
+		createWaitPolicyTable,
+		createDeviceEventsTable,
+		createDeviceInfoAllowlistTable,
+		createDIRequestsTable,
+		createAuditEventsTable,
+		createVoucherExpiryTable,
+		createDevicesTable,
+		TrustedManufacturerCAs.createTable,
+		TrustedDeviceCAs.createTable,
+	} {
+		if err := create(s.db); err != nil {
+			slog.Error("Failed to create table")
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrUnavailable is returned by Ping when the database cannot be reached.
+var ErrUnavailable = errors.New("database is unavailable")
+
+// Ping checks that the database can be reached, so that failed queries can be
+// told apart from a database that is closed, never initialized or whose
+// connection is lost.
+func (s *State) Ping() error {
+	if s.db == nil {
+		return fmt.Errorf("%w: not initialized", ErrUnavailable)
+	}
+	if err := s.db.Ping(); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"time"
+)
+
+// Events of the onboarding timeline of a device
+const (
+	VoucherImportedEvent = "voucher_imported"
+	VoucherExtendedEvent = "voucher_extended"
+	TO0RegisteredEvent   = "to0_registered"
+	ModuleStartedEvent   = "module_started"
+	TO2CompletedEvent    = "to2_completed"
+)
+
+func createDeviceEventsTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS device_events (
+		guid BLOB NOT NULL,
+		event TEXT NOT NULL,
+		detail TEXT,
+		at INTEGER NOT NULL
+	);`
+	if _, err := timed(db).Exec(query); err != nil {
+		return err
+	}
+	_, err := timed(db).Exec("CREATE INDEX IF NOT EXISTS device_events_guid ON device_events(guid)")
+	return err
+}
+
+// RecordDeviceEvent adds an event with an optional detail to the onboarding
+// timeline of the device with guid.
+func RecordDeviceEvent(guid []byte, event, detail string) error {
+	return DefaultState().recordDeviceEvent(guid, event, detail)
+}
+
+func (s *State) recordDeviceEvent(guid []byte, event, detail string) error {
+	_, err := s.conn().Exec("INSERT INTO device_events (guid, event, detail, at) VALUES (?, ?, ?, ?)",
+		guid, event, detail, time.Now().Unix())
+	return err
+}
+
+// FetchDeviceTimeline returns the onboarding events of the device with the
+// given original or replacement GUID in chronological order. Completion of
+// TO2 comes from the onboarding record of the device, so that it reflects
+// manual overrides. A device without events has an empty timeline.
+func FetchDeviceTimeline(guid []byte) ([]TimelineEvent, error) {
+	// Events are recorded under both GUIDs of an onboarded device
+	oldGUID, newGUID := guid, guid
+	var completedAt *time.Time
+	onboarding, err := FetchDeviceOnboarding(guid)
+	if err == nil {
+		oldGUID, newGUID = onboarding.GUID, onboarding.NewGUID
+		if onboarding.TO2Completed {
+			completedAt = onboarding.TO2CompletedAt
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	rows, err := timed(db).Query(`SELECT event, detail, at FROM device_events WHERE guid = ? OR guid = ?
+		ORDER BY at, rowid`, oldGUID, newGUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []TimelineEvent{}
+	for rows.Next() {
+		var event TimelineEvent
+		var detail sql.NullString
+		var at int64
+		if err := rows.Scan(&event.Event, &detail, &at); err != nil {
+			return nil, err
+		}
+		event.Detail = detail.String
+		event.Time = time.Unix(at, 0).UTC()
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if completedAt != nil {
+		events = append(events, TimelineEvent{
+			Event:  TO2CompletedEvent,
+			Detail: "new GUID " + hex.EncodeToString(onboarding.NewGUID),
+			Time:   *completedAt,
+		})
+		// Events of the same second keep their recorded order
+		slices.SortStableFunc(events, func(a, b TimelineEvent) int {
+			return a.Time.Compare(b.Time)
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,128 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// VoucherCacheStats reports the use of the parsed voucher cache.
+type VoucherCacheStats struct {
+	Entries int
+	Hits    uint64
+	Misses  uint64
+}
+
+// voucherCache is an LRU cache of parsed vouchers keyed by GUID. Entries also
+// keep the CBOR they were parsed from, so that a voucher changed without
+// invalidating its entry, such as by the TO2 server, is parsed again.
+type voucherCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+	stats   VoucherCacheStats
+}
+
+type voucherCacheEntry struct {
+	guid string
+	cbor []byte
+	ov   *fdo.Voucher
+}
+
+var parsedVouchers = &voucherCache{order: list.New(), entries: make(map[string]*list.Element)}
+
+// SetVoucherCacheSize caches up to size parsed vouchers for read-only uses,
+// such as device bundles and facets. A size of 0, the default, disables the
+// cache.
+func SetVoucherCacheSize(size int) {
+	parsedVouchers.mu.Lock()
+	defer parsedVouchers.mu.Unlock()
+	parsedVouchers.size = size
+	parsedVouchers.evict()
+}
+
+// FetchVoucherCacheStats returns the number of cached vouchers and the hits
+// and misses of the parsed voucher cache.
+func FetchVoucherCacheStats() VoucherCacheStats {
+	parsedVouchers.mu.Lock()
+	defer parsedVouchers.mu.Unlock()
+	stats := parsedVouchers.stats
+	stats.Entries = parsedVouchers.order.Len()
+	return stats
+}
+
+// ParseVoucher returns the parsed CBOR of a stored voucher, from the cache if
+// it was parsed before. The returned voucher may be shared and must not be
+// modified.
+func ParseVoucher(voucher Voucher) (*fdo.Voucher, error) {
+	if ov := parsedVouchers.get(voucher); ov != nil {
+		return ov, nil
+	}
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(voucher.CBOR, &ov); err != nil {
+		return nil, fmt.Errorf("error parsing voucher %x: %w", voucher.GUID, err)
+	}
+	parsedVouchers.put(voucher, &ov)
+	return &ov, nil
+}
+
+// invalidateVoucher removes the parsed voucher with guid from the cache. It
+// is called whenever a voucher is deleted, replaced or extended.
+func invalidateVoucher(guid []byte) {
+	c := parsedVouchers
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[string(guid)]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, string(guid))
+	}
+}
+
+func (c *voucherCache) get(voucher Voucher) *fdo.Voucher {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return nil
+	}
+	elem, ok := c.entries[string(voucher.GUID)]
+	if !ok || !bytes.Equal(elem.Value.(*voucherCacheEntry).cbor, voucher.CBOR) {
+		c.stats.Misses++
+		return nil
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*voucherCacheEntry).ov
+}
+
+func (c *voucherCache) put(voucher Voucher, ov *fdo.Voucher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size <= 0 {
+		return
+	}
+	entry := &voucherCacheEntry{guid: string(voucher.GUID), cbor: voucher.CBOR, ov: ov}
+	if elem, ok := c.entries[entry.guid]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.guid] = c.order.PushFront(entry)
+	c.evict()
+}
+
+// evict removes the least recently used entries beyond the cache size.
+func (c *voucherCache) evict() {
+	for c.order.Len() > max(c.size, 0) {
+		elem := c.order.Back()
+		c.order.Remove(elem)
+		delete(c.entries, elem.Value.(*voucherCacheEntry).guid)
+	}
+}
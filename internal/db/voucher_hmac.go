@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+)
+
+// ErrInvalidVoucherHMAC is returned when importing a voucher whose header HMAC
+// does not validate with the HMAC secret of its device.
+var ErrInvalidVoucherHMAC = errors.New("voucher header HMAC is invalid")
+
+// DeviceSecretSource provides the HMAC secrets that devices were issued at DI.
+// Only deployments that run manufacturing and keep the device secrets, such as
+// combined manufacturer and owner servers, can provide them.
+type DeviceSecretSource interface {
+	// DeviceSecret returns the HMAC secret of the device with guid.
+	DeviceSecret(guid []byte) ([]byte, error)
+}
+
+var deviceSecretSource DeviceSecretSource
+
+// SetDeviceSecretSource makes imported vouchers be rejected with
+// ErrInvalidVoucherHMAC unless their header HMAC validates with the device
+// secret from source. A nil source, the default, skips HMAC verification.
+func SetDeviceSecretSource(source DeviceSecretSource) {
+	deviceSecretSource = source
+}
+
+// verifyVoucherHMAC checks the header HMAC of a voucher with the secret of its
+// device, if a DeviceSecretSource is set.
+func verifyVoucherHMAC(ovCBOR []byte) error {
+	if deviceSecretSource == nil {
+		return nil
+	}
+
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(ovCBOR, &ov); err != nil {
+		return fmt.Errorf("error parsing voucher: %w", err)
+	}
+	guid := ov.Header.Val.GUID
+	secret, err := deviceSecretSource.DeviceSecret(guid[:])
+	if err != nil {
+		return fmt.Errorf("%w: no secret for device %x: %v", ErrInvalidVoucherHMAC, guid[:], err)
+	}
+	if err := ov.VerifyHeader(hmac.New(sha256.New, secret), hmac.New(sha512.New384, secret)); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidVoucherHMAC, err)
+	}
+	return nil
+}
+
+// DirDeviceSecretSource reads the HMAC secret of each device from a file named
+// by its GUID in a directory.
+type DirDeviceSecretSource string
+
+func (d DirDeviceSecretSource) DeviceSecret(guid []byte) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), hex.EncodeToString(guid)+".secret"))
+}
@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ErrUntrustedManufacturer is returned when importing a voucher whose
+// manufacturer certificate chain does not chain to a trusted manufacturer CA.
+var ErrUntrustedManufacturer = errors.New("voucher manufacturer is not trusted")
+
+// ErrUntrustedDevice is returned when importing a voucher whose device
+// certificate chain does not chain to a trusted device CA.
+var ErrUntrustedDevice = errors.New("voucher device certificate is not trusted")
+
+// verifyVoucherTrust checks that the manufacturer key of a voucher is a
+// certificate chain issued by a trusted manufacturer CA and that its device
+// certificate chain is issued by a trusted device CA. Either check is skipped
+// while no CA of its kind is trusted.
+func verifyVoucherTrust(db querier, ovCBOR []byte) error {
+	mfgRoots, err := TrustedManufacturerCAs.pool(db)
+	if err != nil {
+		return err
+	}
+	deviceRoots, err := TrustedDeviceCAs.pool(db)
+	if err != nil {
+		return err
+	}
+	if mfgRoots == nil && deviceRoots == nil {
+		return nil
+	}
+
+	var ov fdo.Voucher
+	if err := cbor.Unmarshal(ovCBOR, &ov); err != nil {
+		return fmt.Errorf("error parsing voucher: %w", err)
+	}
+	if mfgRoots != nil {
+		if err := verifyManufacturer(&ov, mfgRoots); err != nil {
+			return err
+		}
+	}
+	if deviceRoots != nil {
+		if err := verifyDeviceCertChain(&ov, deviceRoots); err != nil {
+			if selfSigned := selfSignedDeviceCert(&ov); selfSigned != nil {
+				slog.Warn("INSECURE: accepting self-signed device certificate not issued by a trusted device CA",
+					"GUID", hex.EncodeToString(ov.Header.Val.GUID[:]), "subject", selfSigned.Subject.String())
+				return nil
+			}
+			ca := deviceCAInGracePeriod(db, &ov)
+			if ca == nil {
+				return fmt.Errorf("%w: %v", ErrUntrustedDevice, err)
+			}
+			slog.Warn("Accepting device certificate chain of an expired device CA in its grace period",
+				"GUID", hex.EncodeToString(ov.Header.Val.GUID[:]), "ca", ca.Subject.String(), "expired", ca.NotAfter)
+		}
+	}
+	return nil
+}
+
+var allowSelfSignedDeviceCerts bool
+
+// SetAllowSelfSignedDeviceCerts makes imports accept vouchers whose device
+// certificate chain is a single self-signed certificate, even though it is
+// not issued by a trusted device CA. This is insecure and only meant for test
+// devices without a device PKI. It is disabled by default.
+func SetAllowSelfSignedDeviceCerts(allow bool) {
+	allowSelfSignedDeviceCerts = allow
+}
+
+// selfSignedDeviceCert returns the device certificate of a voucher if self
+// signed device certificates are allowed and the device certificate chain
+// consists of only a self-signed certificate, or nil.
+func selfSignedDeviceCert(ov *fdo.Voucher) *x509.Certificate {
+	chain := certChain(ov)
+	if !allowSelfSignedDeviceCerts || len(chain) != 1 {
+		return nil
+	}
+	cert := chain[0]
+	if !bytes.Equal(cert.RawIssuer, cert.RawSubject) || cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) != nil {
+		return nil
+	}
+	return cert
+}
+
+var deviceCAGracePeriod time.Duration
+
+// SetDeviceCAGracePeriod makes device certificate chains issued by a trusted
+// device CA that expired less than grace ago still be accepted, to smooth CA
+// rotations. A grace period of 0, the default, accepts only unexpired CAs.
+func SetDeviceCAGracePeriod(grace time.Duration) {
+	deviceCAGracePeriod = grace
+}
+
+// deviceCAInGracePeriod returns the trusted device CA in its grace period that
+// issued the device certificate chain of a voucher, or nil. The chain is
+// verified as of the expiry of the CA.
+func deviceCAInGracePeriod(db querier, ov *fdo.Voucher) *x509.Certificate {
+	if deviceCAGracePeriod <= 0 || ov.CertChain == nil || len(*ov.CertChain) == 0 {
+		return nil
+	}
+	cas, err := TrustedDeviceCAs.list(db)
+	if err != nil {
+		slog.Debug("Error listing trusted device CAs", "error", err)
+		return nil
+	}
+
+	verifyOpts := deviceCertVerifyOptions
+	if verifyOpts == nil {
+		verifyOpts = &DeviceCertVerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	}
+	chain := certChain(ov)
+	now := time.Now()
+	for _, ca := range cas {
+		if !now.After(ca.NotAfter) || now.Sub(ca.NotAfter) > deviceCAGracePeriod {
+			continue
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(ca)
+		if err := verifyOpts.verify(chain, roots, ca.NotAfter); err == nil {
+			return ca
+		}
+	}
+	return nil
+}
+
+func verifyManufacturer(ov *fdo.Voucher, roots *x509.CertPool) error {
+	mfgKey := ov.Header.Val.ManufacturerKey
+	if mfgKey.Encoding != protocol.X5ChainKeyEnc {
+		return fmt.Errorf("%w: manufacturer key is not a certificate chain", ErrUntrustedManufacturer)
+	}
+	var chain []*cbor.X509Certificate
+	if err := cbor.Unmarshal(mfgKey.Body, &chain); err != nil {
+		return fmt.Errorf("error parsing manufacturer certificate chain: %w", err)
+	}
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: manufacturer certificate chain is empty", ErrUntrustedManufacturer)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, cert := range chain[1:] {
+		opts.Intermediates.AddCert((*x509.Certificate)(cert))
+	}
+	if _, err := (*x509.Certificate)(chain[0]).Verify(opts); err != nil {
+		return fmt.Errorf("%w: %v", ErrUntrustedManufacturer, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+
+	"github.com/fido-device-onboard/go-fdo"
+)
+
+// WaitPolicy bounds how long the rendezvous server keeps an RV blob
+// registered by TO0, in seconds.
+type WaitPolicy struct {
+	MinWaitSecs uint32 `json:"min_wait_secs"`
+	MaxWaitSecs uint32 `json:"max_wait_secs"`
+}
+
+// DefaultWaitPolicy accepts any wait time requested by the owner.
+var DefaultWaitPolicy = WaitPolicy{MinWaitSecs: 0, MaxWaitSecs: math.MaxUint32}
+
+// ErrInvalidWaitPolicy is returned when storing a wait policy whose minimum
+// exceeds its maximum.
+var ErrInvalidWaitPolicy = errors.New("min_wait_secs must not exceed max_wait_secs")
+
+// Clamp returns the wait time granted for a requested wait time.
+func (p WaitPolicy) Clamp(requestedSecs uint32) uint32 {
+	return min(max(requestedSecs, p.MinWaitSecs), p.MaxWaitSecs)
+}
+
+func createWaitPolicyTable(db *sql.DB) error {
+	query := `CREATE TABLE IF NOT EXISTS rv_wait_policy (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		min_wait_secs INTEGER NOT NULL,
+		max_wait_secs INTEGER NOT NULL
+	);`
+	_, err := timed(db).Exec(query)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// FetchWaitPolicy returns the stored wait policy or DefaultWaitPolicy.
+func FetchWaitPolicy() (WaitPolicy, error) {
+	var policy WaitPolicy
+	err := timed(db).QueryRow("SELECT min_wait_secs, max_wait_secs FROM rv_wait_policy WHERE id = 1").
+		Scan(&policy.MinWaitSecs, &policy.MaxWaitSecs)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultWaitPolicy, nil
+	}
+	return policy, err
+}
+
+// UpdateWaitPolicy stores the wait policy used for vouchers registered from
+// now on.
+func UpdateWaitPolicy(policy WaitPolicy) error {
+	if policy.MinWaitSecs > policy.MaxWaitSecs {
+		return ErrInvalidWaitPolicy
+	}
+	_, err := timed(db).Exec("INSERT OR REPLACE INTO rv_wait_policy (id, min_wait_secs, max_wait_secs) VALUES (1, ?, ?)",
+		policy.MinWaitSecs, policy.MaxWaitSecs)
+	return err
+}
+
+// AcceptVoucher accepts vouchers registered by TO0 while the wait policy can
+// be read, as their wait time could not be bounded otherwise.
+func AcceptVoucher(_ context.Context, _ fdo.Voucher) (bool, error) {
+	if _, err := FetchWaitPolicy(); err != nil {
+		return false, fmt.Errorf("error reading wait policy: %w", err)
+	}
+	return true, nil
+}
+
+// NegotiateTTL grants the wait time requested by TO0 within the bounds of the
+// stored wait policy.
+func NegotiateTTL(requestedSecs uint32, _ fdo.Voucher) uint32 {
+	policy, err := FetchWaitPolicy()
+	if err != nil {
+		slog.Error("Error reading wait policy, granting the requested wait time", "error", err)
+		return requestedSecs
+	}
+	return policy.Clamp(requestedSecs)
+}
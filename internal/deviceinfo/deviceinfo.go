@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package deviceinfo normalizes and validates the device info string that
+// devices send during DI before it is stored in their voucher.
+package deviceinfo
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalid is returned for device info that does not conform to the policy.
+var ErrInvalid = errors.New("invalid device info")
+
+// Policy configures device info normalization and validation. The zero value
+// accepts any device info unchanged.
+type Policy struct {
+	// TrimSpace removes leading and trailing white space
+	TrimSpace bool
+	// MaxLength is the maximum length in bytes, or 0 for no limit
+	MaxLength int
+	// PrintableASCII restricts device info to printable ASCII characters
+	PrintableASCII bool
+	// Pattern, if set, must match the device info
+	Pattern *regexp.Regexp
+}
+
+var policy Policy
+
+// SetPolicy sets the policy applied by Normalize.
+func SetPolicy(p Policy) {
+	policy = p
+}
+
+// Normalize applies the configured policy to device info.
+func Normalize(info string) (string, error) {
+	return policy.Normalize(info)
+}
+
+// Normalize returns the normalized device info, or an error wrapping
+// ErrInvalid if it does not conform to the policy.
+func (p Policy) Normalize(info string) (string, error) {
+	if p.TrimSpace {
+		info = strings.TrimSpace(info)
+	}
+	if p.MaxLength > 0 && len(info) > p.MaxLength {
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalid, p.MaxLength)
+	}
+	if p.PrintableASCII {
+		for i := 0; i < len(info); i++ {
+			if c := info[i]; c < 0x20 || c > 0x7e {
+				return "", fmt.Errorf("%w: contains byte 0x%02x", ErrInvalid, c)
+			}
+		}
+	}
+	if p.Pattern != nil && !p.Pattern.MatchString(info) {
+		return "", fmt.Errorf("%w: does not match pattern %s", ErrInvalid, p.Pattern)
+	}
+	return info, nil
+}
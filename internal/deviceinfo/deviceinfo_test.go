@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package deviceinfo
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestPolicyNormalize(t *testing.T) {
+	strict := Policy{
+		TrimSpace:      true,
+		MaxLength:      32,
+		PrintableASCII: true,
+		Pattern:        regexp.MustCompile(`^[a-z0-9-]+$`),
+	}
+
+	for _, test := range []struct {
+		name   string
+		policy Policy
+		info   string
+		want   string
+		reject bool
+	}{
+		{"normal", strict, "edge-gateway-01", "edge-gateway-01", false},
+		{"trimmed", strict, "  edge-gateway-01\n", "edge-gateway-01", false},
+		{"over-long", strict, strings.Repeat("a", 33), "", true},
+		{"control character", strict, "edge\x1b[2Jgateway", "", true},
+		{"non-ASCII", strict, "édge-gateway", "", true},
+		{"pattern mismatch", strict, "Edge Gateway", "", true},
+		{"invalid UTF-8", strict, "edge\xff", "", true},
+		{"permissive default", Policy{}, "  Any Device Info\t", "  Any Device Info\t", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.policy.Normalize(test.info)
+			if test.reject {
+				if !errors.Is(err, ErrInvalid) {
+					t.Errorf("expected %q to be rejected, got %q, %v", test.info, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package logging
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// FanoutHandler passes records to several handlers, such as a console and a
+// file handler.
+type FanoutHandler []slog.Handler
+
+func (h FanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, next := range h {
+		if next.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h FanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, next := range h {
+		if next.Enabled(ctx, r.Level) {
+			errs = append(errs, next.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h FanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(FanoutHandler, len(h))
+	for i, next := range h {
+		handlers[i] = next.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h FanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(FanoutHandler, len(h))
+	for i, next := range h {
+		handlers[i] = next.WithGroup(name)
+	}
+	return handlers
+}
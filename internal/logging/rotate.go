@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the suffix of rotated log files, which sorts in
+// chronological order.
+const backupTimeFormat = "20060102T150405.000000000"
+
+// RotatingFile is a log file which is renamed with a timestamp suffix and
+// replaced by a new file once writing to it would exceed its maximum size.
+type RotatingFile struct {
+	path string
+	// maxSize in bytes of the file before it is rotated (0 for no limit)
+	maxSize int64
+	// maxAge of rotated files before they are removed (0 for no limit)
+	maxAge time.Duration
+	// maxBackups is the number of rotated files kept (0 for no limit)
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating it if
+// needed.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p to the file, first rotating it if p would make it exceed
+// its maximum size. A single write larger than the maximum size is written
+// whole to an empty file.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the file. Later writes fail.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	f.file = nil
+	backup := f.path + "." + time.Now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(f.path, backup); err != nil {
+		return fmt.Errorf("error rotating log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// Backups returns the paths of the rotated files, newest first.
+func (f *RotatingFile) Backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, path := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(path, f.path+".")); err == nil {
+			backups = append(backups, path)
+		}
+	}
+	slices.Sort(backups)
+	slices.Reverse(backups)
+	return backups, nil
+}
+
+// prune removes the rotated files beyond the maximum number of backups or
+// older than the maximum age. Errors are ignored, since there is no log to
+// report them to.
+func (f *RotatingFile) prune() {
+	backups, err := f.Backups()
+	if err != nil {
+		return
+	}
+	for i, path := range backups {
+		rotatedAt, _ := time.Parse(backupTimeFormat, strings.TrimPrefix(path, f.path+"."))
+		if (f.maxBackups > 0 && i >= f.maxBackups) || (f.maxAge > 0 && time.Since(rotatedAt) > f.maxAge) {
+			_ = os.Remove(path)
+		}
+	}
+}
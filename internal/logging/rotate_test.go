@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	file, err := OpenRotatingFile(path, 1024, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	logger := slog.New(slog.NewJSONHandler(file, nil))
+	logger.Info("first record", "i", -1)
+
+	// The first record is written to the configured file as JSON
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]any
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatalf("log file is not JSON: %v", err)
+	}
+	if record["msg"] != "first record" {
+		t.Errorf("logged message is %v", record["msg"])
+	}
+
+	for i := range 100 {
+		logger.Info("record exceeding the size limit", "i", i)
+	}
+
+	backups, err := file.Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("kept %d rotated files, want 2", len(backups))
+	}
+	for _, path := range append(backups, path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1024 {
+			t.Errorf("%s is %d bytes, larger than the size limit", path, info.Size())
+		}
+	}
+
+	// The newest records are in the current file
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var last map[string]any
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("log file is not JSON lines: %v", err)
+		}
+	}
+	if last["i"] != float64(99) {
+		t.Errorf("last record of the current file is %v", last)
+	}
+}
+
+func TestFanoutHandler(t *testing.T) {
+	debug := &countingHandler{records: make(map[slog.Level]int)}
+	info := &countingHandler{records: make(map[slog.Level]int)}
+	logger := slog.New(FanoutHandler{debug, levelHandler{info, slog.LevelInfo}})
+	logger.Debug("debug")
+	logger.With("key", "value").Info("info")
+
+	if debug.records[slog.LevelDebug] != 1 || debug.records[slog.LevelInfo] != 1 {
+		t.Errorf("first handler got %v", debug.records)
+	}
+	if info.records[slog.LevelDebug] != 0 || info.records[slog.LevelInfo] != 1 {
+		t.Errorf("second handler got %v", info.records)
+	}
+}
+
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h levelHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// SamplingHandler wraps a slog.Handler and emits only one in every N debug
+// records. Records above debug level are always emitted.
+type SamplingHandler struct {
+	next    slog.Handler
+	rate    uint64
+	counter *atomic.Uint64
+}
+
+// NewSamplingHandler returns a handler emitting one in every rate debug
+// records. A rate of 0 or 1 disables sampling.
+func NewSamplingHandler(next slog.Handler, rate uint64) *SamplingHandler {
+	return &SamplingHandler{next: next, rate: rate, counter: new(atomic.Uint64)}
+}
+
+func (h *SamplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *SamplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if h.rate > 1 && r.Level <= slog.LevelDebug {
+		if (h.counter.Add(1)-1)%h.rate != 0 {
+			return nil
+		}
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *SamplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &SamplingHandler{next: h.next.WithAttrs(attrs), rate: h.rate, counter: h.counter}
+}
+
+func (h *SamplingHandler) WithGroup(name string) slog.Handler {
+	return &SamplingHandler{next: h.next.WithGroup(name), rate: h.rate, counter: h.counter}
+}
+
+var (
+	sampleRate    atomic.Uint64
+	sampleCounter atomic.Uint64
+)
+
+// SetDebugSampleRate sets how many debug records of hot paths are emitted:
+// one in every rate. A rate of 0 or 1 emits all of them.
+func SetDebugSampleRate(rate uint64) {
+	sampleRate.Store(rate)
+}
+
+// Sampled returns a logger for hot paths, such as per-voucher logging of
+// protocol handlers, whose debug records are sampled at the configured rate.
+// Each record is sampled on its own, so an event should be logged as a
+// single record rather than spread over several.
+func Sampled() *slog.Logger {
+	return slog.New(&SamplingHandler{
+		next:    slog.Default().Handler(),
+		rate:    sampleRate.Load(),
+		counter: &sampleCounter,
+	})
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+type countingHandler struct {
+	records map[slog.Level]int
+}
+
+func (h *countingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *countingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.records[r.Level]++
+	return nil
+}
+func (h *countingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *countingHandler) WithGroup(string) slog.Handler      { return h }
+
+func TestSamplingHandler(t *testing.T) {
+	for _, test := range []struct {
+		rate      uint64
+		wantDebug int
+	}{
+		{0, 1000},
+		{1, 1000},
+		{10, 100},
+		{7, 143},
+	} {
+		counter := &countingHandler{records: make(map[slog.Level]int)}
+		logger := slog.New(NewSamplingHandler(counter, test.rate))
+		for i := range 1000 {
+			logger.Debug("hot path", "i", i)
+			logger.With("key", "value").Debug("hot path with attrs", "i", i)
+		}
+		logger.Info("always logged")
+
+		if got := counter.records[slog.LevelDebug]; got != 2*test.wantDebug {
+			t.Errorf("rate %d: emitted %d debug records, want %d", test.rate, got, 2*test.wantDebug)
+		}
+		if got := counter.records[slog.LevelInfo]; got != 1 {
+			t.Errorf("rate %d: emitted %d info records, want 1", test.rate, got)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package metrics counts server events for operators.
+package metrics
+
+import "sync/atomic"
+
+// ProtocolErrors counts FDO protocol messages answered with an error.
+var ProtocolErrors atomic.Uint64
+
+// Rendezvous outcomes: TO1 lookups of RV blobs that found or missed a blob,
+// and TO0 registrations that were accepted or rejected.
+var (
+	TO1BlobHits   atomic.Uint64
+	TO1BlobMisses atomic.Uint64
+	TO0Accepted   atomic.Uint64
+	TO0Rejected   atomic.Uint64
+)
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DurationBuckets are the upper bounds, in seconds, of the buckets of
+// duration histograms.
+var DurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics exported by WritePrometheus in addition to the counters above
+var (
+	messages = newCounterVec("fdo_messages_total",
+		"FDO protocol messages handled by phase, message type and result.", "phase", "msg_type", "result")
+	messageDuration = newHistogramVec("fdo_message_duration_seconds",
+		"Time to handle FDO protocol messages by phase and message type.", "phase", "msg_type")
+	voucherImports = newCounterVec("fdo_voucher_imports_total",
+		"Vouchers imported by result: stored, unchanged or rejected.", "result")
+	to0Registrations = newCounterVec("fdo_to0_registrations_total",
+		"RV blob registrations of this owner at rendezvous servers by result.", "result")
+	dbQueryDuration = newHistogramVec("fdo_db_query_duration_seconds",
+		"Time to run database statements by statement kind and table.", "query")
+)
+
+// Phase returns the FDO protocol phase of a message type.
+func Phase(msgType uint8) string {
+	switch {
+	case msgType >= 10 && msgType <= 13:
+		return "DI"
+	case msgType >= 20 && msgType <= 23:
+		return "TO0"
+	case msgType >= 30 && msgType <= 33:
+		return "TO1"
+	case msgType >= 60 && msgType <= 71:
+		return "TO2"
+	default:
+		return "unknown"
+	}
+}
+
+// ObserveMessage records the handling of an FDO protocol message of msgType
+// that took d and was answered with an error if failed.
+func ObserveMessage(msgType uint8, failed bool, d time.Duration) {
+	phase, msg := Phase(msgType), strconv.Itoa(int(msgType))
+	result := "success"
+	if failed {
+		result = "error"
+	}
+	messages.add(1, phase, msg, result)
+	messageDuration.observe(d.Seconds(), phase, msg)
+}
+
+// CountVoucherImport records the result of importing a voucher: stored,
+// unchanged or rejected.
+func CountVoucherImport(result string) {
+	voucherImports.add(1, result)
+}
+
+// CountTO0Registration records the result of registering an RV blob at a
+// rendezvous server.
+func CountTO0Registration(failed bool) {
+	if failed {
+		to0Registrations.add(1, "failure")
+		return
+	}
+	to0Registrations.add(1, "success")
+}
+
+// ObserveDBQuery records the duration of a database statement labeled by
+// query, such as "select owner_vouchers", since start.
+func ObserveDBQuery(query string, start time.Time) {
+	dbQueryDuration.observe(time.Since(start).Seconds(), query)
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition
+// format.
+func WritePrometheus(w io.Writer) error {
+	for _, c := range []struct {
+		name, help string
+		value      uint64
+	}{
+		{"fdo_protocol_errors_total", "FDO protocol messages answered with an error.", ProtocolErrors.Load()},
+		{"fdo_rv_to1_blob_hits_total", "TO1 lookups that found an RV blob.", TO1BlobHits.Load()},
+		{"fdo_rv_to1_blob_misses_total", "TO1 lookups that found no RV blob.", TO1BlobMisses.Load()},
+		{"fdo_rv_to0_accepted_total", "TO0 registrations accepted by this rendezvous server.", TO0Accepted.Load()},
+		{"fdo_rv_to0_rejected_total", "TO0 registrations rejected by this rendezvous server.", TO0Rejected.Load()},
+	} {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value); err != nil {
+			return err
+		}
+	}
+	for _, c := range []*counterVec{messages, voucherImports, to0Registrations} {
+		if err := c.write(w); err != nil {
+			return err
+		}
+	}
+	for _, h := range []*histogramVec{messageDuration, dbQueryDuration} {
+		if err := h.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// labelKey joins label values into a map key. Label values never contain the
+// separator.
+func labelKey(values []string) string { return strings.Join(values, "\xff") }
+
+// formatLabels formats the labels of a series, with extra appended as is.
+func formatLabels(names []string, key string, extra string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", names[i], value))
+		}
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, counts: make(map[string]uint64)}
+}
+
+func (c *counterVec) add(n uint64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[labelKey(values)] += n
+}
+
+func (c *counterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.counts)) {
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", c.name, formatLabels(c.labels, key, ""), c.counts[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// histogramVec is a histogram of DurationBuckets partitioned by label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	// buckets counts the observations of each bucket, not cumulatively
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, series: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(values)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{buckets: make([]uint64, len(DurationBuckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(DurationBuckets, v); i < len(DurationBuckets) {
+		s.buckets[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *histogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range DurationBuckets {
+			cumulative += s.buckets[i]
+			le := fmt.Sprintf("le=%q", strconv.FormatFloat(bound, 'g', -1, 64))
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, le), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, formatLabels(h.labels, key, `le="+Inf"`), s.count,
+			h.name, formatLabels(h.labels, key, ""), strconv.FormatFloat(s.sum, 'g', -1, 64),
+			h.name, formatLabels(h.labels, key, ""), s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
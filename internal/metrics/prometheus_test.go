@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	ObserveMessage(10, false, 3*time.Millisecond)
+	ObserveMessage(10, false, 30*time.Millisecond)
+	ObserveMessage(61, true, time.Minute)
+	CountVoucherImport("stored")
+	CountTO0Registration(true)
+	ObserveDBQuery("select owner_vouchers", time.Now())
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE fdo_protocol_errors_total counter",
+		`fdo_messages_total{phase="DI",msg_type="10",result="success"} 2`,
+		`fdo_messages_total{phase="TO2",msg_type="61",result="error"} 1`,
+		"# TYPE fdo_message_duration_seconds histogram",
+		`fdo_message_duration_seconds_bucket{phase="DI",msg_type="10",le="0.001"} 0`,
+		`fdo_message_duration_seconds_bucket{phase="DI",msg_type="10",le="0.005"} 1`,
+		`fdo_message_duration_seconds_bucket{phase="DI",msg_type="10",le="0.05"} 2`,
+		`fdo_message_duration_seconds_bucket{phase="TO2",msg_type="61",le="10"} 0`,
+		`fdo_message_duration_seconds_bucket{phase="TO2",msg_type="61",le="+Inf"} 1`,
+		`fdo_message_duration_seconds_count{phase="DI",msg_type="10"} 2`,
+		`fdo_message_duration_seconds_sum{phase="TO2",msg_type="61"} 60`,
+		`fdo_voucher_imports_total{result="stored"} 1`,
+		`fdo_to0_registrations_total{result="failure"} 1`,
+		`fdo_db_query_duration_seconds_count{query="select owner_vouchers"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing line %q in\n%s", line, out)
+		}
+	}
+}
+
+func TestPhase(t *testing.T) {
+	for msgType, want := range map[uint8]string{
+		10: "DI", 13: "DI", 20: "TO0", 32: "TO1", 60: "TO2", 71: "TO2", 255: "unknown",
+	} {
+		if got := Phase(msgType); got != want {
+			t.Errorf("Phase(%d) = %s, want %s", msgType, got, want)
+		}
+	}
+}
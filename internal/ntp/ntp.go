@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package ntp checks the local clock against an NTP server. Certificate
+// validity and TTLs depend on the local clock, which the server cannot fix but
+// can at least report as skewed.
+package ntp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// DefaultPort is used for NTP server addresses without a port.
+const DefaultPort = "123"
+
+// ntpEpoch is the start of the NTP era 0, 1900-01-01.
+var ntpEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Offset queries the NTP server at addr with SNTP and returns how far the
+// local clock is behind the clock of the server. A negative offset means that
+// the local clock is ahead.
+func Offset(addr string, timeout time.Duration) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+	conn, err := net.DialTimeout("udp", addr, timeout)
+	if err != nil {
+		return 0, fmt.Errorf("error connecting to NTP server %s: %w", addr, err)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+
+	// Client request of NTP version 4
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	sent := time.Now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, fmt.Errorf("error querying NTP server %s: %w", addr, err)
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, fmt.Errorf("error reading NTP response from %s: %w", addr, err)
+	}
+	if n < 48 {
+		return 0, fmt.Errorf("short NTP response of %d bytes from %s", n, addr)
+	}
+	if mode := resp[0] & 7; mode != 4 {
+		return 0, fmt.Errorf("unexpected NTP response mode %d from %s", mode, addr)
+	}
+	if resp[1] == 0 {
+		return 0, fmt.Errorf("NTP server %s sent a kiss-of-death response", addr)
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]) {
+		return 0, errors.New("NTP response does not match the request")
+	}
+
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// CheckClock logs a warning if the local clock is skewed by more than
+// maxSkew from the clock of the NTP server at addr. A failed query is
+// returned as an error, since it says nothing about the local clock.
+func CheckClock(addr string, maxSkew, timeout time.Duration) (time.Duration, error) {
+	offset, err := Offset(addr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	if offset.Abs() > maxSkew {
+		slog.Warn("Local clock is skewed from NTP time; certificate validity and TTL checks may fail",
+			"server", addr, "offset", offset, "max", maxSkew)
+	} else {
+		slog.Debug("Local clock matches NTP time", "server", addr, "offset", offset)
+	}
+	return offset, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	d := t.Sub(ntpEpoch)
+	secs := uint64(d / time.Second)
+	frac := uint64(d%time.Second) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+func fromNTPTime(v uint64) time.Time {
+	secs, frac := v>>32, v&0xffffffff
+	return ntpEpoch.Add(time.Duration(secs)*time.Second + time.Duration(frac*uint64(time.Second)>>32))
+}
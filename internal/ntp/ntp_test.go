@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package ntp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveNTP answers one SNTP request with the local time shifted by skew.
+func serveNTP(t *testing.T, skew time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		req := make([]byte, 48)
+		n, addr, err := conn.ReadFrom(req)
+		if err != nil || n < 48 {
+			return
+		}
+		resp := make([]byte, 48)
+		resp[0] = 4<<3 | 4
+		resp[1] = 2
+		copy(resp[24:32], req[40:48])
+		now := toNTPTime(time.Now().Add(skew))
+		binary.BigEndian.PutUint64(resp[32:], now)
+		binary.BigEndian.PutUint64(resp[40:], now)
+		_, _ = conn.WriteTo(resp, addr)
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestCheckClock(t *testing.T) {
+	logger := slog.Default()
+	defer slog.SetDefault(logger)
+
+	for _, test := range []struct {
+		name string
+		skew time.Duration
+		warn bool
+	}{
+		{"in sync", 0, false},
+		{"behind", time.Hour, true},
+		{"ahead", -time.Hour, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+			offset, err := CheckClock(serveNTP(t, test.skew), time.Minute, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (offset - test.skew).Abs() > time.Second {
+				t.Errorf("offset is %v, want %v", offset, test.skew)
+			}
+			if warned := strings.Contains(buf.String(), "level=WARN"); warned != test.warn {
+				t.Errorf("warning logged is %v: %s", warned, buf.String())
+			}
+		})
+	}
+}
+
+func TestOffsetTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := Offset(conn.LocalAddr().String(), 100*time.Millisecond); err == nil {
+		t.Error("expected error for an unresponsive NTP server")
+	}
+}
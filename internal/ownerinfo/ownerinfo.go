@@ -93,11 +93,10 @@ func ParseRvTO2Addr(ownerData []interface{}) ([]protocol.RvTO2Addr, error) {
 	return rvTO2Addrs, nil
 }
 
-func CreateRvTO2Addr(host string, port uint16, useTLS bool) error {
-	var rvTO2Addrs [][]interface{}
-
-	ip := net.ParseIP(host)
-
+// CreateRvTO2Addr stores owner info directing devices to host and port, using
+// HTTPS if useTLS is set, unless owner info is already stored. If plainPort
+// is not 0, devices are also directed to HTTP on plainPort.
+func CreateRvTO2Addr(host string, port uint16, useTLS bool, plainPort uint16) error {
 	var proto protocol.TransportProtocol
 	if useTLS {
 		proto = protocol.HTTPSTransport
@@ -105,20 +104,9 @@ func CreateRvTO2Addr(host string, port uint16, useTLS bool) error {
 		proto = protocol.HTTPTransport
 	}
 
-	if ip != nil {
-		rvTO2Addrs = append(rvTO2Addrs, []interface{}{
-			ip.String(),
-			nil,
-			port,
-			proto,
-		})
-	} else {
-		rvTO2Addrs = append(rvTO2Addrs, []interface{}{
-			nil,
-			host,
-			port,
-			proto,
-		})
+	rvTO2Addrs := [][]interface{}{rvTO2AddrEntry(host, port, proto)}
+	if plainPort != 0 {
+		rvTO2Addrs = append(rvTO2Addrs, rvTO2AddrEntry(host, plainPort, protocol.HTTPTransport))
 	}
 
 	err := StoreRvTO2Addrs(rvTO2Addrs)
@@ -129,6 +117,23 @@ func CreateRvTO2Addr(host string, port uint16, useTLS bool) error {
 	return nil
 }
 
+func rvTO2AddrEntry(host string, port uint16, proto protocol.TransportProtocol) []interface{} {
+	if ip := net.ParseIP(host); ip != nil {
+		return []interface{}{
+			ip.String(),
+			nil,
+			port,
+			proto,
+		}
+	}
+	return []interface{}{
+		nil,
+		host,
+		port,
+		proto,
+	}
+}
+
 func StoreRvTO2Addrs(rvTO2Addrs [][]interface{}) error {
 	var ownerData db.Data
 	ownerData.Value = rvTO2Addrs
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package ownerinfo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// ErrUnreachableAddress is returned in production mode for an RVTO2Addr that
+// devices outside of the owner host or network cannot reach.
+var ErrUnreachableAddress = errors.New("RVTO2Addr is not reachable by devices")
+
+var productionMode bool
+
+// SetProductionMode records whether the server runs in production, where
+// owner info must direct devices to addresses they can reach. Development
+// mode, the default, accepts any address.
+func SetProductionMode(production bool) {
+	productionMode = production
+}
+
+// CheckReachableAddresses returns ErrUnreachableAddress in production mode
+// for the first RVTO2Addr with a loopback, private, link-local or unspecified
+// IP address, or a DNS name of localhost. Other DNS names are not resolved.
+func CheckReachableAddresses(addrs []protocol.RvTO2Addr) error {
+	if !productionMode {
+		return nil
+	}
+	for _, addr := range addrs {
+		if reason := unreachableReason(addr); reason != "" {
+			return fmt.Errorf("%w: %s is %s", ErrUnreachableAddress, rvTO2Host(addr), reason)
+		}
+	}
+	return nil
+}
+
+func unreachableReason(addr protocol.RvTO2Addr) string {
+	if addr.DNSAddress != nil {
+		name := strings.ToLower(strings.TrimSuffix(*addr.DNSAddress, "."))
+		if name == "localhost" || strings.HasSuffix(name, ".localhost") {
+			return "a loopback name"
+		}
+	}
+	if addr.IPAddress == nil {
+		return ""
+	}
+	ip := *addr.IPAddress
+	switch {
+	case ip.IsLoopback():
+		return "a loopback address"
+	case ip.IsPrivate():
+		return "a private address"
+	case ip.IsLinkLocalUnicast():
+		return "a link-local address"
+	case ip.IsUnspecified():
+		return "an unspecified address"
+	}
+	return ""
+}
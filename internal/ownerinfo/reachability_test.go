@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package ownerinfo
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestCheckReachableAddresses(t *testing.T) {
+	defer SetProductionMode(false)
+
+	ipAddr := func(s string) []protocol.RvTO2Addr {
+		ip := net.ParseIP(s)
+		return []protocol.RvTO2Addr{{IPAddress: &ip, Port: 8043, TransportProtocol: protocol.HTTPSTransport}}
+	}
+	dnsAddr := func(s string) []protocol.RvTO2Addr {
+		return []protocol.RvTO2Addr{{DNSAddress: &s, Port: 8043, TransportProtocol: protocol.HTTPSTransport}}
+	}
+	for _, test := range []struct {
+		name       string
+		production bool
+		addrs      []protocol.RvTO2Addr
+		reject     bool
+	}{
+		{"loopback in dev", false, ipAddr("127.0.0.1"), false},
+		{"loopback", true, ipAddr("127.0.0.1"), true},
+		{"ipv6 loopback", true, ipAddr("::1"), true},
+		{"private", true, ipAddr("192.168.1.10"), true},
+		{"link-local", true, ipAddr("169.254.0.1"), true},
+		{"unspecified", true, ipAddr("0.0.0.0"), true},
+		{"public", true, ipAddr("203.0.113.10"), false},
+		{"localhost", true, dnsAddr("localhost"), true},
+		{"localhost subdomain", true, dnsAddr("owner.localhost."), true},
+		{"dns name", true, dnsAddr("owner.example.com"), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			SetProductionMode(test.production)
+			err := CheckReachableAddresses(test.addrs)
+			if rejected := errors.Is(err, ErrUnreachableAddress); rejected != test.reject {
+				t.Errorf("error is %v, want rejected %v", err, test.reject)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package ownerinfo
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+// TLSMismatchPolicy decides how owner info whose RVTO2Addr transport protocol
+// does not match the TLS mode of the server is stored. Devices steered to the
+// wrong scheme fail TO2.
+type TLSMismatchPolicy string
+
+const (
+	// WarnTLSMismatch stores mismatching owner info with a warning.
+	WarnTLSMismatch TLSMismatchPolicy = "warn"
+	// RejectTLSMismatch fails storing mismatching owner info.
+	RejectTLSMismatch TLSMismatchPolicy = "reject"
+)
+
+// ErrTLSMismatch is returned when the transport protocol of an RVTO2Addr does
+// not match the TLS mode of the server while the policy rejects them.
+var ErrTLSMismatch = errors.New("RVTO2Addr transport protocol does not match the server TLS mode")
+
+var (
+	tlsMismatchPolicy = WarnTLSMismatch
+	serverTLS         bool
+	serverPlainHTTP   bool
+)
+
+func ParseTLSMismatchPolicy(s string) (TLSMismatchPolicy, error) {
+	switch policy := TLSMismatchPolicy(s); policy {
+	case WarnTLSMismatch, RejectTLSMismatch:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid TLS mismatch policy %q: must be one of warn, reject", s)
+	}
+}
+
+func SetTLSMismatchPolicy(policy TLSMismatchPolicy) {
+	tlsMismatchPolicy = policy
+}
+
+// SetServerTLS records whether the server listens with TLS.
+func SetServerTLS(useTLS bool) {
+	serverTLS = useTLS
+}
+
+// SetServerPlainHTTP records whether the server also serves HTTP while
+// listening with TLS, in which case neither transport protocol mismatches.
+func SetServerPlainHTTP(plain bool) {
+	serverPlainHTTP = plain
+}
+
+// CheckTransportProtocols applies the TLS mismatch policy to RVTO2Addrs. It
+// returns a warning for each address using HTTP while the server serves HTTPS
+// or the other way round, or ErrTLSMismatch if the policy rejects them.
+// Transport protocols other than HTTP and HTTPS are not checked.
+func CheckTransportProtocols(addrs []protocol.RvTO2Addr) ([]string, error) {
+	if serverTLS && serverPlainHTTP {
+		return nil, nil
+	}
+	want, wrong := "http", "https"
+	wrongProto := protocol.HTTPSTransport
+	if serverTLS {
+		want, wrong = "https", "http"
+		wrongProto = protocol.HTTPTransport
+	}
+
+	var warnings []string
+	for _, addr := range addrs {
+		if addr.TransportProtocol != wrongProto {
+			continue
+		}
+		host := rvTO2Host(addr)
+		if tlsMismatchPolicy == RejectTLSMismatch {
+			return nil, fmt.Errorf("%w: %s uses %s instead of %s", ErrTLSMismatch, host, wrong, want)
+		}
+		slog.Warn("RVTO2Addr transport protocol does not match the server TLS mode", "addr", host, "protocol", wrong, "want", want)
+		warnings = append(warnings, fmt.Sprintf("RVTO2Addr %s uses %s but the server serves %s", host, wrong, want))
+	}
+	return warnings, nil
+}
+
+func rvTO2Host(addr protocol.RvTO2Addr) string {
+	host := ""
+	if addr.DNSAddress != nil {
+		host = *addr.DNSAddress
+	} else if addr.IPAddress != nil {
+		host = addr.IPAddress.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(addr.Port)))
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package ownerinfo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestCheckTransportProtocols(t *testing.T) {
+	defer SetServerTLS(false)
+	defer SetTLSMismatchPolicy(WarnTLSMismatch)
+
+	host := "owner.example.com"
+	addr := func(proto protocol.TransportProtocol) []protocol.RvTO2Addr {
+		return []protocol.RvTO2Addr{{DNSAddress: &host, Port: 8043, TransportProtocol: proto}}
+	}
+	for _, test := range []struct {
+		name      string
+		serverTLS bool
+		proto     protocol.TransportProtocol
+		policy    TLSMismatchPolicy
+		warnings  int
+		reject    bool
+	}{
+		{"http without TLS", false, protocol.HTTPTransport, RejectTLSMismatch, 0, false},
+		{"https with TLS", true, protocol.HTTPSTransport, RejectTLSMismatch, 0, false},
+		{"https without TLS warns", false, protocol.HTTPSTransport, WarnTLSMismatch, 1, false},
+		{"http with TLS warns", true, protocol.HTTPTransport, WarnTLSMismatch, 1, false},
+		{"https without TLS rejected", false, protocol.HTTPSTransport, RejectTLSMismatch, 0, true},
+		{"http with TLS rejected", true, protocol.HTTPTransport, RejectTLSMismatch, 0, true},
+		{"tcp is not checked", true, protocol.TCPTransport, RejectTLSMismatch, 0, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			SetServerTLS(test.serverTLS)
+			SetTLSMismatchPolicy(test.policy)
+			warnings, err := CheckTransportProtocols(addr(test.proto))
+			if rejected := errors.Is(err, ErrTLSMismatch); rejected != test.reject {
+				t.Fatalf("error is %v, want rejected %v", err, test.reject)
+			}
+			if len(warnings) != test.warnings {
+				t.Errorf("warnings are %q, want %d", warnings, test.warnings)
+			}
+		})
+	}
+}
+
+func TestParseTLSMismatchPolicy(t *testing.T) {
+	for _, s := range []string{"warn", "reject"} {
+		if policy, err := ParseTLSMismatchPolicy(s); err != nil || string(policy) != s {
+			t.Errorf("ParseTLSMismatchPolicy(%q) = %q, %v", s, policy, err)
+		}
+	}
+	if _, err := ParseTLSMismatchPolicy("allow"); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+}
+
+func TestCheckTransportProtocolsPlainHTTP(t *testing.T) {
+	defer SetServerTLS(false)
+	defer SetServerPlainHTTP(false)
+	defer SetTLSMismatchPolicy(WarnTLSMismatch)
+
+	SetServerTLS(true)
+	SetServerPlainHTTP(true)
+	SetTLSMismatchPolicy(RejectTLSMismatch)
+	host := "owner.example.com"
+	warnings, err := CheckTransportProtocols([]protocol.RvTO2Addr{
+		{DNSAddress: &host, Port: 8043, TransportProtocol: protocol.HTTPSTransport},
+		{DNSAddress: &host, Port: 8080, TransportProtocol: protocol.HTTPTransport},
+	})
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("serving both schemes: warnings %q, error %v", warnings, err)
+	}
+}
@@ -4,6 +4,7 @@
 package rvinfo
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
@@ -43,34 +44,79 @@ func RetrieveRvInfo(rvInfo *[][]protocol.RvInstruction) error {
 		return fmt.Errorf("error fetching rvData after POST: %w", err)
 	}
 
-	parsedData, ok := rvData.Value.([]interface{})
+	return ParseRvInfo(rvData.Value, rvInfo)
+}
+
+// ErrMalformedRvInfo is wrapped by the errors for RV info that is not shaped
+// like the JSON form accepted by the rvinfo endpoint.
+var ErrMalformedRvInfo = errors.New("malformed RV info")
+
+// DirectiveError reports an RV directive that failed to parse.
+type DirectiveError struct {
+	// Directive is the index of the directive
+	Directive int
+	// Pair is the index of the variable-value pair that failed to parse, or
+	// -1 if the directive itself is malformed
+	Pair int
+	Err  error
+}
+
+func (e *DirectiveError) Error() string {
+	if e.Pair < 0 {
+		return fmt.Sprintf("RV directive %d: %v", e.Directive, e.Err)
+	}
+	return fmt.Sprintf("RV directive %d, pair %d: %v", e.Directive, e.Pair, e.Err)
+}
+
+func (e *DirectiveError) Unwrap() error { return e.Err }
+
+// ParseRvInfo converts RV info in its JSON form, as accepted by the rvinfo
+// endpoint, into RV instructions. Every directive is parsed, and if any fails
+// rvInfo is left unchanged and the returned error joins a *DirectiveError for
+// each failed directive, so that no directive is silently dropped.
+func ParseRvInfo(value interface{}, rvInfo *[][]protocol.RvInstruction) error {
+	parsedData, ok := value.([]interface{})
 	if !ok || len(parsedData) == 0 {
-		return fmt.Errorf("error parsing rvData after POST: %v", rvData.Value)
+		return fmt.Errorf("%w: expected a non-empty array of directives, found %v", ErrMalformedRvInfo, value)
 	}
 
+	var parsed [][]protocol.RvInstruction
+	var errs []error
 	for rvDirectiveIndex, rvDirective := range parsedData {
 		rvMap, err := ParseRvMap(rvDirectiveIndex, rvDirective)
 		if err != nil {
-			slog.Debug("Error parsing item", "index", rvDirectiveIndex, "error", err)
+			errs = append(errs, err)
 			continue
 		}
-		if err := UpdateRvInfo(rvInfo, rvDirectiveIndex, rvMap); err != nil {
-			return fmt.Errorf("error updating RVInfo: %w", err)
+		if err := UpdateRvInfo(&parsed, rvDirectiveIndex, rvMap); err != nil {
+			errs = append(errs, &DirectiveError{Directive: rvDirectiveIndex, Pair: -1, Err: err})
 		}
 	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	*rvInfo = parsed
 	return nil
 }
 
+// ParseRvMap parses a directive into its RV variables and values. Values of
+// the variables that are converted into RV instructions are type checked. It
+// returns a *DirectiveError if the directive is malformed.
 func ParseRvMap(rvDirectiveIndex int, rvDirective interface{}) (map[protocol.RvVar]interface{}, error) {
 	rvMap := make(map[protocol.RvVar]interface{})
 	nestedItems, ok := rvDirective.([]interface{})
 	if !ok {
-		return nil, fmt.Errorf("error parsing item: %v", rvDirective)
+		return nil, &DirectiveError{Directive: rvDirectiveIndex, Pair: -1,
+			Err: fmt.Errorf("%w: expected an array of pairs, found %v", ErrMalformedRvInfo, rvDirective)}
 	}
 	for rvPairIndex, rvPair := range nestedItems {
+		pairError := func(format string, args ...any) error {
+			return &DirectiveError{Directive: rvDirectiveIndex, Pair: rvPairIndex,
+				Err: fmt.Errorf("%w: "+format, append([]any{ErrMalformedRvInfo}, args...)...)}
+		}
 		keyValue, ok := rvPair.([]interface{})
-		if !ok || len(keyValue) < 1 {
-			return nil, fmt.Errorf("error parsing pair %d in item: %v", rvPairIndex, rvPair)
+		if !ok || len(keyValue) < 1 || len(keyValue) > 2 {
+			return nil, pairError("expected a [variable, value] array, found %v", rvPair)
 		}
 		key := keyValue[0]
 		var value interface{} = nil
@@ -79,8 +125,11 @@ func ParseRvMap(rvDirectiveIndex int, rvDirective interface{}) (map[protocol.RvV
 		}
 
 		keyRvVar, ok := key.(float64)
-		if !ok {
-			return nil, fmt.Errorf("error converting key to float64 in pair %d: %v", rvPairIndex, key)
+		if !ok || keyRvVar != float64(uint8(keyRvVar)) {
+			return nil, pairError("variable is not a number: %v", key)
+		}
+		if err := checkRvValue(protocol.RvVar(keyRvVar), value); err != nil {
+			return nil, pairError("%v", err)
 		}
 
 		rvMap[protocol.RvVar(keyRvVar)] = value
@@ -89,6 +138,37 @@ func ParseRvMap(rvDirectiveIndex int, rvDirective interface{}) (map[protocol.RvV
 	return rvMap, nil
 }
 
+// checkRvValue checks the type of the values that UpdateRvInfo converts into
+// RV instructions. Values of other variables are not used.
+func checkRvValue(key protocol.RvVar, value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	switch key {
+	case protocol.RVProtocol:
+		if n, ok := value.(float64); !ok || n != float64(uint8(n)) {
+			return fmt.Errorf("protocol is not a number from 0 to 255: %v", value)
+		}
+	case protocol.RVDevPort, protocol.RVOwnerPort, protocol.RVDelaysec:
+		if n, ok := value.(float64); !ok || n != float64(uint16(n)) {
+			return fmt.Errorf("value of variable %d is not a number from 0 to 65535: %v", key, value)
+		}
+	case protocol.RVDns:
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("DNS name is not a string: %v", value)
+		}
+	case protocol.RVIPAddress:
+		host, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("IP address is not a string: %v", value)
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid IP address: %q", host)
+		}
+	}
+	return nil
+}
+
 func UpdateRvInfo(rvInfo *[][]protocol.RvInstruction, index int, rvMap map[protocol.RvVar]interface{}) error {
 	var newRvInfo [][]protocol.RvInstruction
 
@@ -101,6 +181,12 @@ func UpdateRvInfo(rvInfo *[][]protocol.RvInstruction, index int, rvMap map[proto
 		newRvInfo = append(newRvInfo, make([]protocol.RvInstruction, 0))
 	}
 
+	for key, value := range rvMap {
+		if err := checkRvValue(key, value); err != nil {
+			return err
+		}
+	}
+
 	if rvMap[protocol.RVProtocol] == nil {
 		newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVProtocol, Value: utils.MustMarshal(protocol.RVProtHTTP)})
 	} else {
@@ -116,11 +202,12 @@ func UpdateRvInfo(rvInfo *[][]protocol.RvInstruction, index int, rvMap map[proto
 		newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVDns, Value: utils.MustMarshal(rvMap[protocol.RVDns].(string))})
 	}
 
-	host := rvMap[protocol.RVIPAddress].(string)
-	if host == "" {
-		newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: utils.MustMarshal(net.IP{127, 0, 0, 1})})
-	} else if hostIP := net.ParseIP(host); hostIP.To4() != nil || hostIP.To16() != nil {
-		newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: utils.MustMarshal(hostIP)})
+	if host, ok := rvMap[protocol.RVIPAddress].(string); ok {
+		if host == "" {
+			newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: utils.MustMarshal(net.IP{127, 0, 0, 1})})
+		} else {
+			newRvInfo[index] = append(newRvInfo[index], protocol.RvInstruction{Variable: protocol.RVIPAddress, Value: utils.MustMarshal(net.ParseIP(host))})
+		}
 	}
 
 	if rvMap[protocol.RVDevPort] != nil {
@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package rvinfo
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func TestParseRvInfo(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		json string
+		// directives are the indexes of the directives that fail to parse
+		directives []int
+	}{
+		{"valid", `[[[2,"127.0.0.1"],[3,8041]],[[5,"rv.example.com"],[12,1],[3,8041]]]`, nil},
+		{"directive not an array", `[[[2,"127.0.0.1"]],"directive"]`, []int{1}},
+		{"pair not an array", `[[[5,"rv.example.com"],5]]`, []int{0}},
+		{"empty pair", `[[[]]]`, []int{0}},
+		{"string variable", `[[["3",8041]]]`, []int{0}},
+		{"port out of range", `[[[3,70000]]]`, []int{0}},
+		{"port not a number", `[[[3,"8041"]]]`, []int{0}},
+		{"invalid IP address", `[[[2,"not an address"]]]`, []int{0}},
+		{"DNS not a string", `[[[5,["rv.example.com"]]]]`, []int{0}},
+		{"several failures", `[[[3,"8041"]],[[2,"127.0.0.1"]],[[5,1]]]`, []int{0, 2}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var value interface{}
+			if err := json.Unmarshal([]byte(test.json), &value); err != nil {
+				t.Fatal(err)
+			}
+			original := [][]protocol.RvInstruction{{{Variable: protocol.RVBypass}}}
+			rvInfo := original
+
+			err := ParseRvInfo(value, &rvInfo)
+			if len(test.directives) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(rvInfo) != 2 {
+					t.Errorf("parsed %d directives, want 2", len(rvInfo))
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrMalformedRvInfo) {
+				t.Fatalf("error %v is not ErrMalformedRvInfo", err)
+			}
+			var failed []int
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var directiveErr *DirectiveError
+				if !errors.As(err, &directiveErr) {
+					t.Fatalf("error %v is not a DirectiveError", err)
+				}
+				failed = append(failed, directiveErr.Directive)
+			}
+			if len(failed) != len(test.directives) {
+				t.Fatalf("failed directives are %v, want %v", failed, test.directives)
+			}
+			for i := range failed {
+				if failed[i] != test.directives[i] {
+					t.Errorf("failed directives are %v, want %v", failed, test.directives)
+				}
+			}
+			if len(rvInfo) != 1 || rvInfo[0][0].Variable != protocol.RVBypass {
+				t.Errorf("RV info was changed to %v", rvInfo)
+			}
+		})
+	}
+
+	t.Run("not an array", func(t *testing.T) {
+		var rvInfo [][]protocol.RvInstruction
+		if err := ParseRvInfo(map[string]interface{}{}, &rvInfo); !errors.Is(err, ErrMalformedRvInfo) {
+			t.Errorf("error %v is not ErrMalformedRvInfo", err)
+		}
+	})
+}
@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// SysStep is an operation of the fdo_sys module: a file to write on the device
+// or a command to run there.
+type SysStep struct {
+	// Write is the path of a file sent to the device as DeviceFile
+	Write      string
+	DeviceFile string
+	// Exec is a command and its arguments to run on the device
+	Exec []string
+	// Callback runs Exec with exec_cb, so that the device reports its
+	// completion with status_cb instead of running it before replying
+	Callback bool
+}
+
+// ParseSysStep parses a step given as write:<file>[=<device file>],
+// exec:<command line> or exec_cb:<command line>. The device file defaults to
+// the base name of the file, and command lines are split on white space.
+func ParseSysStep(value string) (SysStep, error) {
+	kind, arg, _ := strings.Cut(value, ":")
+	switch kind {
+	case "write":
+		file, deviceFile, _ := strings.Cut(arg, "=")
+		if file == "" {
+			return SysStep{}, fmt.Errorf("invalid fdo_sys step %q: file is required", value)
+		}
+		if deviceFile == "" {
+			deviceFile = filepath.Base(file)
+		}
+		return SysStep{Write: file, DeviceFile: deviceFile}, nil
+	case "exec", "exec_cb":
+		command := strings.Fields(arg)
+		if len(command) == 0 {
+			return SysStep{}, fmt.Errorf("invalid fdo_sys step %q: command is required", value)
+		}
+		return SysStep{Exec: command, Callback: kind == "exec_cb"}, nil
+	default:
+		return SysStep{}, fmt.Errorf("invalid fdo_sys step %q: must be write:<file>[=<device file>], exec:<command> or exec_cb:<command>", value)
+	}
+}
+
+// sysStatus is the body of status_cb: whether the command run by exec_cb
+// completed, its exit code and the seconds until the device reports again.
+type sysStatus struct {
+	Completed  bool
+	ResultCode int
+	WaitSec    uint
+}
+
+// defaultSysChunkSize is the size of file chunks, as in fdo.download.
+const defaultSysChunkSize = 1014
+
+// FdoSys implements the owner side of the fdo_sys module, which many device
+// clients support instead of fdo.download and fdo.command. Steps are run in
+// order: files are sent with filedesc and write, and commands with exec or,
+// if they may run for long, exec_cb. A command run by exec_cb is polled by
+// acknowledging each status_cb of the device until it reports completion.
+// A command that exits with a nonzero code fails TO2.
+type FdoSys struct {
+	Steps []SysStep
+	// ChunkSize is the maximum size of each write, defaulting to 1014
+	ChunkSize int
+
+	// Internal state
+	started bool
+	step    int
+	file    *os.File
+	waiting bool
+	status  *sysStatus
+	sent    bool
+}
+
+var _ serviceinfo.OwnerModule = (*FdoSys)(nil)
+
+// HandleInfo implements serviceinfo.OwnerModule.
+func (m *FdoSys) HandleInfo(_ context.Context, messageName string, messageBody io.Reader) error {
+	switch messageName {
+	case "active":
+		var deviceActive bool
+		if err := cbor.NewDecoder(messageBody).Decode(&deviceActive); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !deviceActive {
+			m.cleanup()
+			return errors.New("device service info module is not active")
+		}
+		return nil
+
+	case "status_cb":
+		var status sysStatus
+		if err := cbor.NewDecoder(messageBody).Decode(&status); err != nil {
+			return fmt.Errorf("error decoding message %s: %w", messageName, err)
+		}
+		if !m.waiting {
+			return errors.New("status_cb received without a command run by exec_cb")
+		}
+		if !status.Completed {
+			m.status = &status
+			return nil
+		}
+		m.waiting = false
+		if status.ResultCode != 0 {
+			return fmt.Errorf("command %q exited with code %d", strings.Join(m.Steps[m.step-1].Exec, " "), status.ResultCode)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported message %q", messageName)
+	}
+}
+
+// ProduceInfo implements serviceinfo.OwnerModule.
+func (m *FdoSys) ProduceInfo(_ context.Context, producer *serviceinfo.Producer) (blockPeer, moduleDone bool, _ error) {
+	moduleDone, err := m.produceInfo(producer)
+	if err != nil {
+		m.cleanup()
+	}
+	return false, moduleDone, err
+}
+
+func (m *FdoSys) produceInfo(producer *serviceinfo.Producer) (moduleDone bool, _ error) {
+	if !m.started {
+		if err := writeMessage(producer, "active", true); err != nil {
+			return false, err
+		}
+		m.started = true
+	}
+
+	// Acknowledge the status of a running command for the device to report
+	// again after the wait it asked for
+	if m.status != nil {
+		if err := writeMessage(producer, "status_cb", m.status); err != nil {
+			return false, err
+		}
+		m.status = nil
+		return false, nil
+	}
+	if m.waiting {
+		return false, nil
+	}
+
+	for m.step < len(m.Steps) {
+		step := m.Steps[m.step]
+		if step.Write != "" {
+			complete, err := m.writeFile(producer, step)
+			if err != nil || !complete {
+				return false, err
+			}
+			m.step++
+			continue
+		}
+
+		messageName := "exec"
+		if step.Callback {
+			messageName = "exec_cb"
+		}
+		if ok, err := tryWriteMessage(producer, messageName, step.Exec); err != nil || !ok {
+			return false, err
+		}
+		m.step++
+		if step.Callback {
+			m.waiting = true
+			return false, nil
+		}
+	}
+
+	// The replies of the device to the last messages are only handled by
+	// this module while it is not done
+	if !m.sent {
+		m.sent = true
+		return false, nil
+	}
+	return true, nil
+}
+
+// writeFile sends as much of the file of a write step as fits, reporting
+// whether all of it was sent.
+func (m *FdoSys) writeFile(producer *serviceinfo.Producer, step SysStep) (complete bool, _ error) {
+	if m.file == nil {
+		f, err := os.Open(step.Write)
+		if err != nil {
+			return false, fmt.Errorf("error opening %q for fdo_sys: %w", step.Write, err)
+		}
+		if ok, err := tryWriteMessage(producer, "filedesc", step.DeviceFile); err != nil || !ok {
+			_ = f.Close()
+			return false, err
+		}
+		m.file = f
+	}
+
+	chunkSize := defaultSysChunkSize
+	if m.ChunkSize > 0 {
+		chunkSize = m.ChunkSize
+	}
+	chunk := make([]byte, chunkSize)
+	for {
+		// 3 bytes for each of the double encoded byte array headers
+		available := producer.Available("write") - 6
+		if available < 1 {
+			return false, nil
+		}
+		n, err := m.file.Read(chunk[:min(available, chunkSize)])
+		if errors.Is(err, io.EOF) {
+			_ = m.file.Close()
+			m.file = nil
+			return true, nil
+		} else if err != nil {
+			return false, fmt.Errorf("error reading %q for fdo_sys: %w", step.Write, err)
+		}
+		if err := writeMessage(producer, "write", chunk[:n]); err != nil {
+			return false, err
+		}
+	}
+}
+
+// tryWriteMessage writes a message if it fits, reporting whether it did.
+func tryWriteMessage(producer *serviceinfo.Producer, messageName string, v any) (bool, error) {
+	messageBody, err := cbor.Marshal(v)
+	if err != nil {
+		return false, err
+	}
+	if len(messageBody) > producer.Available(messageName) {
+		return false, nil
+	}
+	return true, producer.WriteChunk(messageName, messageBody)
+}
+
+// writeMessage writes a message that must fit.
+func writeMessage(producer *serviceinfo.Producer, messageName string, v any) error {
+	ok, err := tryWriteMessage(producer, messageName, v)
+	if err == nil && !ok {
+		err = fmt.Errorf("not enough buffer space to send %s", messageName)
+	}
+	return err
+}
+
+func (m *FdoSys) cleanup() {
+	if m.file != nil {
+		_ = m.file.Close()
+		m.file = nil
+	}
+}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestParseSysStep(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		want  SysStep
+		err   string
+	}{
+		{"write:/srv/fdo/agent.sh", SysStep{Write: "/srv/fdo/agent.sh", DeviceFile: "agent.sh"}, ""},
+		{"write:agent.sh=/opt/agent/run.sh", SysStep{Write: "agent.sh", DeviceFile: "/opt/agent/run.sh"}, ""},
+		{"exec:sh  /opt/agent/run.sh", SysStep{Exec: []string{"sh", "/opt/agent/run.sh"}}, ""},
+		{"exec_cb:sh install.sh", SysStep{Exec: []string{"sh", "install.sh"}, Callback: true}, ""},
+		{"write:", SysStep{}, "file is required"},
+		{"exec: ", SysStep{}, "command is required"},
+		{"fetch:/var/log/agent.log", SysStep{}, "must be write"},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			step, err := ParseSysStep(test.value)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error is %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if step.Write != test.want.Write || step.DeviceFile != test.want.DeviceFile ||
+				!slices.Equal(step.Exec, test.want.Exec) || step.Callback != test.want.Callback {
+				t.Errorf("step is %+v, want %+v", step, test.want)
+			}
+		})
+	}
+}
+
+// sysRound runs ProduceInfo once and returns the messages produced, keyed
+// without the module name, and whether the module is done.
+func sysRound(t *testing.T, m *FdoSys) (messages []*serviceinfo.KV, done bool) {
+	t.Helper()
+	producer := serviceinfo.NewProducer("fdo_sys", serviceinfo.DefaultMTU)
+	blockPeer, done, err := m.ProduceInfo(context.Background(), producer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blockPeer {
+		t.Error("module blocked the device")
+	}
+	if size := serviceinfo.ArraySizeCBOR(producer.ServiceInfo()); size > serviceinfo.DefaultMTU {
+		t.Errorf("service info of %d bytes exceeds the MTU", size)
+	}
+	for _, kv := range producer.ServiceInfo() {
+		messages = append(messages, &serviceinfo.KV{Key: strings.TrimPrefix(kv.Key, "fdo_sys:"), Val: kv.Val})
+	}
+	return messages, done
+}
+
+func sysStatusBody(t *testing.T, completed bool, code int, wait uint) *bytes.Reader {
+	t.Helper()
+	body, err := cbor.Marshal(sysStatus{Completed: completed, ResultCode: code, WaitSec: wait})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(body)
+}
+
+func TestFdoSysWriteAndExec(t *testing.T) {
+	contents := make([]byte, 5000)
+	if _, err := rand.Read(contents); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "agent.bin")
+	if err := os.WriteFile(file, contents, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := &FdoSys{Steps: []SysStep{
+		{Write: file, DeviceFile: "/opt/agent.bin"},
+		{Exec: []string{"chmod", "+x", "/opt/agent.bin"}},
+	}}
+
+	var names []string
+	var written []byte
+	var deviceFile string
+	var exec []string
+	for rounds := 0; ; rounds++ {
+		if rounds > 10 {
+			t.Fatal("module is not done")
+		}
+		messages, done := sysRound(t, m)
+		if done {
+			if len(messages) > 0 {
+				t.Errorf("module sent %d messages when done", len(messages))
+			}
+			break
+		}
+		for _, kv := range messages {
+			if len(names) == 0 || names[len(names)-1] != kv.Key {
+				names = append(names, kv.Key)
+			}
+			var err error
+			switch kv.Key {
+			case "filedesc":
+				err = cbor.Unmarshal(kv.Val, &deviceFile)
+			case "write":
+				var chunk []byte
+				err = cbor.Unmarshal(kv.Val, &chunk)
+				written = append(written, chunk...)
+			case "exec":
+				err = cbor.Unmarshal(kv.Val, &exec)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.HandleInfo(context.Background(), "active", bytes.NewReader([]byte{0xf5})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"active", "filedesc", "write", "exec"}; !slices.Equal(names, want) {
+		t.Errorf("messages are %v, want %v", names, want)
+	}
+	if deviceFile != "/opt/agent.bin" {
+		t.Errorf("device file is %q", deviceFile)
+	}
+	if !bytes.Equal(written, contents) {
+		t.Errorf("device received %d bytes, want the %d bytes of the file", len(written), len(contents))
+	}
+	if !slices.Equal(exec, []string{"chmod", "+x", "/opt/agent.bin"}) {
+		t.Errorf("exec is %v", exec)
+	}
+}
+
+func TestFdoSysExecCallback(t *testing.T) {
+	ctx := context.Background()
+	m := &FdoSys{Steps: []SysStep{{Exec: []string{"sh", "install.sh"}, Callback: true}}}
+
+	messages, done := sysRound(t, m)
+	if done || len(messages) != 2 || messages[1].Key != "exec_cb" {
+		t.Fatalf("first round is %v, done %v", messages, done)
+	}
+
+	// The owner acknowledges each status of the running command
+	if err := m.HandleInfo(ctx, "status_cb", sysStatusBody(t, false, 0, 30)); err != nil {
+		t.Fatal(err)
+	}
+	messages, done = sysRound(t, m)
+	if done || len(messages) != 1 || messages[0].Key != "status_cb" {
+		t.Fatalf("acknowledgement is %v, done %v", messages, done)
+	}
+	var ack sysStatus
+	if err := cbor.Unmarshal(messages[0].Val, &ack); err != nil {
+		t.Fatal(err)
+	}
+	if ack.Completed || ack.WaitSec != 30 {
+		t.Errorf("acknowledged status is %+v", ack)
+	}
+	if messages, done = sysRound(t, m); done || len(messages) != 0 {
+		t.Fatalf("module did not wait for the command: %v, done %v", messages, done)
+	}
+
+	if err := m.HandleInfo(ctx, "status_cb", sysStatusBody(t, true, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	sysRound(t, m)
+	if _, done = sysRound(t, m); !done {
+		t.Error("module is not done after the command completed")
+	}
+}
+
+func TestFdoSysFailures(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("command fails", func(t *testing.T) {
+		m := &FdoSys{Steps: []SysStep{{Exec: []string{"false"}, Callback: true}}}
+		sysRound(t, m)
+		err := m.HandleInfo(ctx, "status_cb", sysStatusBody(t, true, 1, 0))
+		if err == nil || !strings.Contains(err.Error(), "exited with code 1") {
+			t.Errorf("error is %v", err)
+		}
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		m := &FdoSys{Steps: []SysStep{{Exec: []string{"true"}}}}
+		sysRound(t, m)
+		if err := m.HandleInfo(ctx, "status_cb", sysStatusBody(t, true, 0, 0)); err == nil {
+			t.Error("status_cb without exec_cb was accepted")
+		}
+	})
+
+	t.Run("inactive device", func(t *testing.T) {
+		m := &FdoSys{Steps: []SysStep{{Exec: []string{"true"}}}}
+		sysRound(t, m)
+		if err := m.HandleInfo(ctx, "active", bytes.NewReader([]byte{0xf4})); err == nil {
+			t.Error("inactive device was accepted")
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		m := &FdoSys{Steps: []SysStep{{Write: filepath.Join(t.TempDir(), "missing"), DeviceFile: "missing"}}}
+		producer := serviceinfo.NewProducer("fdo_sys", serviceinfo.DefaultMTU)
+		if _, _, err := m.ProduceInfo(ctx, producer); err == nil {
+			t.Error("missing file did not fail")
+		}
+	})
+}
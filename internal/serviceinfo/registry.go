@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package serviceinfo makes the owner service info modules of the server
+// extensible without forking it. A module registers a Factory under its
+// name, usually from an init function of a file built into the server, in
+// the same way as database/sql drivers. The built-in modules register
+// through the same mechanism.
+package serviceinfo
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"iter"
+	"slices"
+	"sync"
+
+	"github.com/fido-device-onboard/go-fdo/protocol"
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+// Device describes the device onboarded in TO2.
+type Device struct {
+	GUID   protocol.GUID
+	Info   string
+	Chain  []*x509.Certificate
+	Devmod serviceinfo.Devmod
+}
+
+// Factory returns the owner modules to run, in order, for a device that
+// supports the module. config is the configuration the module was selected
+// with, which is empty if it was given none. Each owner module is run to
+// completion before the next is produced.
+type Factory func(ctx context.Context, device Device, config string) iter.Seq[serviceinfo.OwnerModule]
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes the module with name available to the owner. It panics if
+// factory is nil or a module of the same name is already registered.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if factory == nil {
+		panic("serviceinfo: Register factory is nil")
+	}
+	if _, dup := factories[name]; dup {
+		panic(fmt.Sprintf("serviceinfo: Register called twice for module %s", name))
+	}
+	factories[name] = factory
+}
+
+// Lookup returns the factory of the module with name, if registered.
+func Lookup(name string) (Factory, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the names of the registered modules in sorted order.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package serviceinfo
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"testing"
+
+	"github.com/fido-device-onboard/go-fdo/serviceinfo"
+)
+
+func TestRegister(t *testing.T) {
+	factory := func(context.Context, Device, string) iter.Seq[serviceinfo.OwnerModule] {
+		return func(func(serviceinfo.OwnerModule) bool) {}
+	}
+	Register("test.b", factory)
+	Register("test.a", factory)
+
+	if _, ok := Lookup("test.a"); !ok {
+		t.Error("registered module not found")
+	}
+	if _, ok := Lookup("test.c"); ok {
+		t.Error("unregistered module found")
+	}
+	if names := Names(); !slices.Equal(names, []string{"test.a", "test.b"}) {
+		t.Errorf("names are %v", names)
+	}
+
+	for name, factory := range map[string]Factory{"test.a": factory, "test.nil": nil} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %s did not panic", name)
+				}
+			}()
+			Register(name, factory)
+		}()
+	}
+}
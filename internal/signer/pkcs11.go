@@ -0,0 +1,301 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+//go:build pkcs11
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+// The pkcs11 provider is built in with the pkcs11 build tag, as it loads the
+// PKCS#11 library of the HSM with cgo.
+func init() {
+	Register("pkcs11", openPKCS11)
+}
+
+// pkcs11Key signs with an ECDSA or RSA private key kept in a PKCS#11 token.
+// Its session is used for one operation at a time.
+type pkcs11Key struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	handle  pkcs11.ObjectHandle
+	public  crypto.PublicKey
+
+	mu sync.Mutex
+}
+
+var _ crypto.Signer = (*pkcs11Key)(nil)
+
+// openPKCS11 opens the private key of a pkcs11 key URI and reads its public
+// key from the public key object of the same label and ID.
+func openPKCS11(uri *url.URL) (crypto.Signer, error) {
+	key, err := parsePKCS11URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	ctx := pkcs11.New(key.Module)
+	if ctx == nil {
+		return nil, fmt.Errorf("error loading PKCS#11 module %s", key.Module)
+	}
+	if err := ctx.Initialize(); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED)) {
+		return nil, fmt.Errorf("error initializing PKCS#11 module: %w", err)
+	}
+	session, err := openTokenSession(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := findKeyObject(ctx, session, pkcs11.CKO_PRIVATE_KEY, key)
+	if err != nil {
+		return nil, err
+	}
+	keyType, err := keyAttribute(ctx, session, handle, pkcs11.CKA_KEY_TYPE)
+	if err != nil {
+		return nil, err
+	}
+	pubHandle, err := findKeyObject(ctx, session, pkcs11.CKO_PUBLIC_KEY, key)
+	if err != nil {
+		return nil, err
+	}
+	var public crypto.PublicKey
+	switch ulong(keyType) {
+	case pkcs11.CKK_EC:
+		public, err = ecPublicKey(ctx, session, pubHandle)
+	case pkcs11.CKK_RSA:
+		public, err = rsaPublicKey(ctx, session, pubHandle)
+	default:
+		err = fmt.Errorf("unsupported PKCS#11 key type %d", ulong(keyType))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Key{ctx: ctx, session: session, handle: handle, public: public}, nil
+}
+
+// openTokenSession opens a session on the token with the label of the key and
+// logs in with its PIN.
+func openTokenSession(ctx *pkcs11.Ctx, key pkcs11URI) (pkcs11.SessionHandle, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("error listing PKCS#11 slots: %w", err)
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil {
+			return 0, fmt.Errorf("error reading PKCS#11 token info: %w", err)
+		}
+		if strings.TrimRight(info.Label, " \x00") != key.Token {
+			continue
+		}
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return 0, fmt.Errorf("error opening PKCS#11 session: %w", err)
+		}
+		if key.PIN != "" {
+			if err := ctx.Login(session, pkcs11.CKU_USER, key.PIN); err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+				_ = ctx.CloseSession(session)
+				return 0, fmt.Errorf("error logging in to PKCS#11 token %s: %w", key.Token, err)
+			}
+		}
+		return session, nil
+	}
+	return 0, fmt.Errorf("PKCS#11 token %s not found", key.Token)
+}
+
+// findKeyObject returns the only object of class with the label and ID of
+// the key.
+func findKeyObject(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, key pkcs11URI) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_CLASS, class)}
+	if key.Object != "" {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_LABEL, key.Object))
+	}
+	if key.ID != nil {
+		template = append(template, pkcs11.NewAttribute(pkcs11.CKA_ID, key.ID))
+	}
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, fmt.Errorf("error finding PKCS#11 key: %w", err)
+	}
+	handles, _, err := ctx.FindObjects(session, 2)
+	if finalErr := ctx.FindObjectsFinal(session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error finding PKCS#11 key: %w", err)
+	}
+	kind := "private"
+	if class == pkcs11.CKO_PUBLIC_KEY {
+		kind = "public"
+	}
+	switch len(handles) {
+	case 0:
+		return 0, fmt.Errorf("PKCS#11 %s key %q not found", kind, key.Object)
+	case 1:
+		return handles[0], nil
+	default:
+		return 0, fmt.Errorf("PKCS#11 %s key %q is not unique", kind, key.Object)
+	}
+}
+
+func keyAttribute(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle, typ uint) ([]byte, error) {
+	attrs, err := ctx.GetAttributeValue(session, handle, []*pkcs11.Attribute{pkcs11.NewAttribute(typ, nil)})
+	if err != nil {
+		return nil, fmt.Errorf("error reading PKCS#11 key attribute: %w", err)
+	}
+	return attrs[0].Value, nil
+}
+
+// ulong decodes a CK_ULONG attribute value, which is in native byte order.
+func ulong(value []byte) uint {
+	switch len(value) {
+	case 4:
+		return uint(binary.NativeEndian.Uint32(value))
+	case 8:
+		return uint(binary.NativeEndian.Uint64(value))
+	default:
+		return 0
+	}
+}
+
+var (
+	oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+)
+
+func ecPublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (*ecdsa.PublicKey, error) {
+	params, err := keyAttribute(ctx, session, handle, pkcs11.CKA_EC_PARAMS)
+	if err != nil {
+		return nil, err
+	}
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("error parsing PKCS#11 EC parameters: %w", err)
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidP384):
+		curve = elliptic.P384()
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#11 EC curve %s", oid)
+	}
+
+	// The point is an uncompressed point wrapped in an OCTET STRING
+	encoded, err := keyAttribute(ctx, session, handle, pkcs11.CKA_EC_POINT)
+	if err != nil {
+		return nil, err
+	}
+	var point []byte
+	if _, err := asn1.Unmarshal(encoded, &point); err != nil {
+		return nil, fmt.Errorf("error parsing PKCS#11 EC point: %w", err)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	if len(point) != 1+2*size || point[0] != 4 {
+		return nil, errors.New("PKCS#11 EC point is not an uncompressed point of its curve")
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(point[1 : 1+size]),
+		Y:     new(big.Int).SetBytes(point[1+size:]),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("PKCS#11 EC point is not on its curve")
+	}
+	return pub, nil
+}
+
+func rsaPublicKey(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, handle pkcs11.ObjectHandle) (*rsa.PublicKey, error) {
+	modulus, err := keyAttribute(ctx, session, handle, pkcs11.CKA_MODULUS)
+	if err != nil {
+		return nil, err
+	}
+	exponent, err := keyAttribute(ctx, session, handle, pkcs11.CKA_PUBLIC_EXPONENT)
+	if err != nil {
+		return nil, err
+	}
+	e := new(big.Int).SetBytes(exponent)
+	if !e.IsInt64() || e.Int64() > 1<<31-1 {
+		return nil, errors.New("PKCS#11 RSA public exponent is too large")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(e.Int64())}, nil
+}
+
+// Public implements crypto.Signer.
+func (k *pkcs11Key) Public() crypto.PublicKey { return k.public }
+
+// Sign implements crypto.Signer. ECDSA signatures are returned ASN.1 encoded,
+// as by ecdsa.PrivateKey, and RSA keys sign with PSS when opts is a
+// *rsa.PSSOptions and PKCS #1 v1.5 otherwise.
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mechanism *pkcs11.Mechanism
+	message := digest
+	switch k.public.(type) {
+	case *ecdsa.PublicKey:
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)
+	case *rsa.PublicKey:
+		hash, mgf, prefix, err := pkcs11Hash(opts.HashFunc())
+		if err != nil {
+			return nil, err
+		}
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			saltLength := pss.SaltLength
+			if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+				saltLength = opts.HashFunc().Size()
+			}
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, pkcs11.NewPSSParams(hash, mgf, uint(saltLength)))
+		} else {
+			mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+			message = append(prefix, digest...)
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.ctx.SignInit(k.session, []*pkcs11.Mechanism{mechanism}, k.handle); err != nil {
+		return nil, fmt.Errorf("error signing with PKCS#11 key: %w", err)
+	}
+	sig, err := k.ctx.Sign(k.session, message)
+	if err != nil {
+		return nil, fmt.Errorf("error signing with PKCS#11 key: %w", err)
+	}
+	if _, ok := k.public.(*ecdsa.PublicKey); ok {
+		// The token returns r and s concatenated
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:half]),
+			S: new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
+
+// pkcs11Hash returns the PKCS#11 hash and MGF1 mechanisms of a hash and the
+// DigestInfo prefix of its PKCS #1 v1.5 signatures.
+func pkcs11Hash(hash crypto.Hash) (mech, mgf uint, prefix []byte, _ error) {
+	switch hash {
+	case crypto.SHA256:
+		return pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256,
+			[]byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}, nil
+	case crypto.SHA384:
+		return pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384,
+			[]byte{0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30}, nil
+	default:
+		return 0, 0, nil, fmt.Errorf("unsupported hash for PKCS#11 RSA signature: %s", hash)
+	}
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package signer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pkcs11ModuleEnv names the environment variable giving the PKCS#11 module of
+// key URIs without a module-path attribute.
+const pkcs11ModuleEnv = "FDO_PKCS11_MODULE"
+
+// pkcs11URI is the key of a pkcs11 key URI, as defined by RFC 7512, such as
+// pkcs11:token=fdo;object=owner?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=/etc/fdo/pin.
+type pkcs11URI struct {
+	// Module is the path of the PKCS#11 library
+	Module string
+	// Token is the label of the token holding the key
+	Token string
+	// Object and ID are the label and ID of the key, at least one of which is
+	// given
+	Object string
+	ID     []byte
+	// PIN logs in to the token, if given
+	PIN string
+}
+
+// parsePKCS11URI parses the path attributes token, object, id and type and
+// the query attributes module-path, pin-value and pin-source of a pkcs11 key
+// URI. The module defaults to the value of FDO_PKCS11_MODULE, and pin-source
+// is the path of a file holding the PIN.
+func parsePKCS11URI(uri *url.URL) (pkcs11URI, error) {
+	var key pkcs11URI
+	path := uri.Opaque
+	if path == "" {
+		path = uri.Path
+	}
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		name, escaped, ok := strings.Cut(attr, "=")
+		if !ok {
+			return key, fmt.Errorf("invalid pkcs11 URI attribute %q", attr)
+		}
+		value, err := url.PathUnescape(escaped)
+		if err != nil {
+			return key, fmt.Errorf("invalid pkcs11 URI attribute %q: %w", attr, err)
+		}
+		switch name {
+		case "token":
+			key.Token = value
+		case "object":
+			key.Object = value
+		case "id":
+			key.ID = []byte(value)
+		case "type":
+			if value != "private" {
+				return key, fmt.Errorf("pkcs11 URI type must be private, not %q", value)
+			}
+		}
+	}
+	if key.Token == "" {
+		return key, errors.New("pkcs11 URI has no token")
+	}
+	if key.Object == "" && key.ID == nil {
+		return key, errors.New("pkcs11 URI has no object or id")
+	}
+
+	query := uri.Query()
+	key.Module = query.Get("module-path")
+	if key.Module == "" {
+		key.Module = os.Getenv(pkcs11ModuleEnv)
+	}
+	if key.Module == "" {
+		return key, fmt.Errorf("pkcs11 URI has no module-path and %s is not set", pkcs11ModuleEnv)
+	}
+	key.PIN = query.Get("pin-value")
+	if source := query.Get("pin-source"); source != "" {
+		source = strings.TrimPrefix(source, "file:")
+		pin, err := os.ReadFile(filepath.Clean(source))
+		if err != nil {
+			return key, fmt.Errorf("error reading pkcs11 PIN: %w", err)
+		}
+		key.PIN = strings.TrimSpace(string(pin))
+	}
+	return key, nil
+}
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package signer
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePKCS11URI(t *testing.T) {
+	pinFile := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinFile, []byte("1234\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(pkcs11ModuleEnv, "/usr/lib/softhsm/libsofthsm2.so")
+
+	for _, test := range []struct {
+		uri  string
+		want pkcs11URI
+		err  string
+	}{
+		{
+			uri:  "pkcs11:token=fdo;object=owner;type=private?module-path=/opt/hsm.so&pin-value=5678",
+			want: pkcs11URI{Module: "/opt/hsm.so", Token: "fdo", Object: "owner", PIN: "5678"},
+		},
+		{
+			uri:  "pkcs11:token=fdo%20keys;id=%01%02?pin-source=file:" + pinFile,
+			want: pkcs11URI{Module: "/usr/lib/softhsm/libsofthsm2.so", Token: "fdo keys", ID: []byte{1, 2}, PIN: "1234"},
+		},
+		{uri: "pkcs11:object=owner", err: "no token"},
+		{uri: "pkcs11:token=fdo", err: "no object or id"},
+		{uri: "pkcs11:token=fdo;object=owner;type=public", err: "type must be private"},
+		{uri: "pkcs11:token=fdo;owner", err: "invalid pkcs11 URI attribute"},
+		{uri: "pkcs11:token=fdo;object=owner?pin-source=" + filepath.Join(t.TempDir(), "missing"), err: "error reading pkcs11 PIN"},
+	} {
+		t.Run(test.uri, func(t *testing.T) {
+			uri, err := url.Parse(test.uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := parsePKCS11URI(uri)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("error is %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Module != test.want.Module || key.Token != test.want.Token || key.Object != test.want.Object ||
+				!bytes.Equal(key.ID, test.want.ID) || key.PIN != test.want.PIN {
+				t.Errorf("key is %+v, want %+v", key, test.want)
+			}
+		})
+	}
+
+	t.Run("no module", func(t *testing.T) {
+		t.Setenv(pkcs11ModuleEnv, "")
+		if _, err := parsePKCS11URI(&url.URL{Scheme: "pkcs11", Opaque: "token=fdo;object=owner"}); err == nil || !strings.Contains(err.Error(), "no module-path") {
+			t.Errorf("error is %v", err)
+		}
+	})
+}
@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package signer opens the owner and manufacturer keys of the server from
+// key URIs, so that they need not be stored in the database. Each URI scheme
+// is served by a Provider. The file scheme is built in, the pkcs11 scheme for
+// keys kept in an HSM is built in with the pkcs11 build tag, and providers for
+// other schemes are registered by files built into the server, in the same
+// way as database/sql drivers.
+package signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Provider opens the signer identified by a key URI of its scheme.
+type Provider func(uri *url.URL) (crypto.Signer, error)
+
+// ErrNoProvider is returned when opening a key URI of a scheme without a
+// registered provider.
+var ErrNoProvider = errors.New("no signer provider for key URI scheme")
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{"file": openFile}
+)
+
+// Register makes a provider available for the key URIs of scheme. It panics
+// if provider is nil or the scheme already has a provider.
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	if provider == nil {
+		panic("signer: Register provider is nil")
+	}
+	if _, dup := providers[scheme]; dup {
+		panic("signer: Register called twice for scheme " + scheme)
+	}
+	providers[scheme] = provider
+}
+
+// Schemes returns the schemes with a registered provider in sorted order.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(providers))
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+	slices.Sort(schemes)
+	return schemes
+}
+
+// Open returns the signer identified by a key URI, such as
+// file:///etc/fdo/owner.key or pkcs11:token=fdo;object=owner. A URI without a
+// scheme is the path of a file.
+func Open(uri string) (crypto.Signer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key URI %q: %w", uri, err)
+	}
+	if u.Scheme == "" {
+		u = &url.URL{Scheme: "file", Path: uri}
+	}
+
+	mu.RLock()
+	provider, ok := providers[u.Scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s (registered: %s)", ErrNoProvider, u.Scheme, strings.Join(Schemes(), ", "))
+	}
+	key, err := provider(u)
+	if err != nil {
+		return nil, fmt.Errorf("error opening key %s: %w", uri, err)
+	}
+	return key, nil
+}
+
+// openFile reads a PEM-encoded PKCS#8, EC or PKCS#1 private key.
+func openFile(uri *url.URL) (crypto.Signer, error) {
+	path := uri.Path
+	if path == "" {
+		path = uri.Opaque
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	blk, _ := pem.Decode(data)
+	if blk == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+
+	var key any
+	switch blk.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(blk.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(blk.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(blk.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %s", blk.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "owner.key")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, uri := range []string{path, "file://" + path} {
+		opened, err := Open(uri)
+		if err != nil {
+			t.Fatalf("Open(%q): %v", uri, err)
+		}
+		if !key.PublicKey.Equal(opened.Public()) {
+			t.Errorf("Open(%q) returned a different key", uri)
+		}
+	}
+
+	if _, err := Open("tpm:owner"); !errors.Is(err, ErrNoProvider) {
+		t.Errorf("got error %v for a scheme without provider", err)
+	}
+
+	// Registered providers serve their scheme
+	Register("test", func(uri *url.URL) (crypto.Signer, error) {
+		if uri.Opaque != "owner" {
+			return nil, errors.New("unknown key")
+		}
+		return key, nil
+	})
+	if opened, err := Open("test:owner"); err != nil || !key.PublicKey.Equal(opened.Public()) {
+		t.Errorf("Open with registered provider: %v", err)
+	}
+	if _, err := Open("test:other"); err == nil {
+		t.Error("expected provider error")
+	}
+}
@@ -6,29 +6,84 @@ package to0
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/fido-device-onboard/go-fdo"
+	"github.com/fido-device-onboard/go-fdo-server/internal/db"
+	"github.com/fido-device-onboard/go-fdo-server/internal/logging"
+	"github.com/fido-device-onboard/go-fdo-server/internal/metrics"
 	"github.com/fido-device-onboard/go-fdo-server/internal/ownerinfo"
 	"github.com/fido-device-onboard/go-fdo-server/internal/rvinfo"
 	"github.com/fido-device-onboard/go-fdo-server/internal/tls"
+	"github.com/fido-device-onboard/go-fdo-server/internal/webhook"
 	"github.com/fido-device-onboard/go-fdo/protocol"
 	"github.com/fido-device-onboard/go-fdo/sqlite"
 )
 
-var useTLS bool
+var (
+	useTLS    bool
+	timeout   time.Duration
+	ownerKeys fdo.OwnerKeyPersistentState
+)
 
 func SetTo0Tls(value bool) {
 	useTLS = value
 }
 
+// SetTo0Timeout bounds each attempt to register an RV blob at one rendezvous
+// server, so that an unresponsive server does not delay the next. A timeout
+// of 0, the default, lets attempts run until the transport gives up.
+func SetTo0Timeout(d time.Duration) {
+	timeout = d
+}
+
+// SetOwnerKeys sets the owner keys that sign RV blobs. When unset, the owner
+// keys are those stored in the database.
+func SetOwnerKeys(keys fdo.OwnerKeyPersistentState) {
+	ownerKeys = keys
+}
+
+// ErrRegistrationInProgress is returned when an RV blob of the device is
+// already being registered, so that overlapping requests do not register it
+// twice.
+var ErrRegistrationInProgress = errors.New("RV blob registration of the device is already in progress")
+
+// inFlightGUIDs is the set of GUIDs whose RV blob is being registered.
+type inFlightGUIDs struct {
+	mu    sync.Mutex
+	guids map[protocol.GUID]struct{}
+}
+
+var registering = &inFlightGUIDs{guids: make(map[protocol.GUID]struct{})}
+
+// exclusive runs fn unless it is already running for guid, in which case it
+// returns ErrRegistrationInProgress. The GUID is released when fn returns.
+func (f *inFlightGUIDs) exclusive(guid protocol.GUID, fn func() error) error {
+	f.mu.Lock()
+	if _, ok := f.guids[guid]; ok {
+		f.mu.Unlock()
+		return fmt.Errorf("%w: %x", ErrRegistrationInProgress, guid[:])
+	}
+	f.guids[guid] = struct{}{}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.guids, guid)
+		f.mu.Unlock()
+	}()
+	return fn()
+}
+
 func RegisterRvBlob(RvInfo [][]protocol.RvInstruction, to0Guid string, state *sqlite.DB) error {
 
-	to0Addr1, to0Addr2, err := rvinfo.GetRVIPAddress(RvInfo)
+	to0Addrs, err := rvAddrs(RvInfo)
 	if err != nil {
-		fmt.Println("Error:", err)
 		return fmt.Errorf("error parsing TO0 Address from RV Info: %w", err)
 	}
 
@@ -43,29 +98,97 @@ func RegisterRvBlob(RvInfo [][]protocol.RvInstruction, to0Guid string, state *sq
 	var guid protocol.GUID
 	copy(guid[:], guidBytes)
 
+	return registering.exclusive(guid, func() error {
+		err := registerRvBlob(to0Addrs, guid, state)
+		if err != nil {
+			webhook.Notify(webhook.Event{Type: webhook.TO0Failed, GUID: hex.EncodeToString(guid[:]), Error: err.Error()})
+		}
+		return err
+	})
+}
+
+func registerRvBlob(to0Addrs []string, guid protocol.GUID, state *sqlite.DB) error {
 	// Retrieve owner info from DB
 	to2Addrs, err := ownerinfo.FetchOwnerInfo()
 	if err != nil {
 		return fmt.Errorf("error fetching ownerinfo: %w", err)
 	}
 
-	refresh, err := (&fdo.TO0Client{
-		Vouchers:  state,
-		OwnerKeys: state,
-	}).RegisterBlob(context.Background(), tls.TlsTransport(to0Addr1, nil, useTLS), guid, to2Addrs)
+	var keys fdo.OwnerKeyPersistentState = state
+	if ownerKeys != nil {
+		keys = ownerKeys
+	}
+	client := &fdo.TO0Client{
+		Vouchers:  db.OwnerVouchers(state),
+		OwnerKeys: keys,
+	}
+	to0Addr, refresh, err := registerWithFailover(to0Addrs, timeout, func(ctx context.Context, addr string) (uint32, error) {
+		return client.RegisterBlob(ctx, tls.TlsTransport(addr, nil, useTLS), guid, to2Addrs)
+	})
+	metrics.CountTO0Registration(err != nil)
 	if err != nil {
-		slog.Debug("failed to", "connect", to0Addr1)
-		slog.Debug("trying to", "connect", to0Addr2)
-		refresh, err = (&fdo.TO0Client{
-			Vouchers:  state,
-			OwnerKeys: state,
-		}).RegisterBlob(context.Background(), tls.TlsTransport(to0Addr2, nil, useTLS), guid, to2Addrs)
+		return fmt.Errorf("error performing to0: %w", err)
+	}
+
+	logging.Sampled().Debug("to0 refresh", "addr", to0Addr, "duration", time.Duration(refresh)*time.Second)
+
+	if err := db.RecordDeviceEvent(guid[:], db.TO0RegisteredEvent, fmt.Sprintf("%s for %s", to0Addr, time.Duration(refresh)*time.Second)); err != nil {
+		slog.Debug("Error recording TO0 registration", "guid", hex.EncodeToString(guid[:]), "error", err)
+	}
+
+	return nil
+}
+
+// rvAddrs returns the addresses of the rendezvous servers of RV info in the
+// order of its directives, so that backup servers follow the primary one.
+// Directives without a usable address, such as those for RV bypass, are
+// skipped.
+func rvAddrs(rvInfo [][]protocol.RvInstruction) ([]string, error) {
+	var addrs []string
+	var lastErr error
+	for _, directive := range rvInfo {
+		addr1, addr2, err := rvinfo.GetRVIPAddress([][]protocol.RvInstruction{directive})
 		if err != nil {
-			return fmt.Errorf("error performing to0: %w", err)
+			lastErr = err
+			continue
 		}
+		for _, addr := range []string{addr1, addr2} {
+			if addr != "" && !slices.Contains(addrs, addr) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("no rendezvous directive")
+		}
+		return nil, lastErr
 	}
+	return addrs, nil
+}
 
-	slog.Debug("to0 refresh", "duration", time.Duration(refresh)*time.Second)
+// registerWithFailover attempts to register at each address in order, each
+// with its own timeout, and stops at the first success. It returns the
+// address at which registration succeeded and the refresh time in seconds.
+func registerWithFailover(addrs []string, timeout time.Duration, register func(ctx context.Context, addr string) (uint32, error)) (string, uint32, error) {
+	attempt := func(addr string) (uint32, error) {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return register(ctx, addr)
+	}
 
-	return nil
+	var errs []error
+	for _, addr := range addrs {
+		refresh, err := attempt(addr)
+		if err == nil {
+			return addr, refresh, nil
+		}
+		slog.Debug("Error registering RV blob", "addr", addr, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+	return "", 0, errors.Join(errs...)
 }
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package to0
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fido-device-onboard/go-fdo/cbor"
+	"github.com/fido-device-onboard/go-fdo/protocol"
+)
+
+func rvDirective(t *testing.T, dns string, port uint16) []protocol.RvInstruction {
+	host, err := cbor.Marshal(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerPort, err := cbor.Marshal(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto, err := cbor.Marshal(protocol.RVProtHTTP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []protocol.RvInstruction{
+		{Variable: protocol.RVDns, Value: host},
+		{Variable: protocol.RVOwnerPort, Value: ownerPort},
+		{Variable: protocol.RVProtocol, Value: proto},
+	}
+}
+
+func TestRvAddrs(t *testing.T) {
+	rvInfo := [][]protocol.RvInstruction{
+		rvDirective(t, "primary.example.com", 8041),
+		rvDirective(t, "backup.example.com", 8042),
+	}
+	addrs, err := rvAddrs(rvInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://primary.example.com:8041", "http://backup.example.com:8042"}; !slices.Equal(addrs, want) {
+		t.Errorf("rendezvous addresses are %q, want %q", addrs, want)
+	}
+}
+
+func TestRegisterWithFailover(t *testing.T) {
+	primary, backup := "http://primary.example.com:8041", "http://backup.example.com:8042"
+	addrs := []string{primary, backup}
+
+	for _, test := range []struct {
+		name    string
+		primary func(ctx context.Context) error
+	}{
+		{"failing primary", func(context.Context) error { return errors.New("connection refused") }},
+		{"unresponsive primary", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var attempted []string
+			used, refresh, err := registerWithFailover(addrs, 50*time.Millisecond, func(ctx context.Context, addr string) (uint32, error) {
+				attempted = append(attempted, addr)
+				if addr == primary {
+					return 0, test.primary(ctx)
+				}
+				return 3600, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if used != backup || refresh != 3600 {
+				t.Errorf("registered at %s for %d seconds, want the backup", used, refresh)
+			}
+			if !slices.Equal(attempted, []string{primary, backup}) {
+				t.Errorf("attempted %q", attempted)
+			}
+		})
+	}
+
+	t.Run("all failing", func(t *testing.T) {
+		_, _, err := registerWithFailover(addrs, time.Second, func(context.Context, string) (uint32, error) {
+			return 0, errors.New("connection refused")
+		})
+		if err == nil {
+			t.Error("expected error when every rendezvous server fails")
+		}
+	})
+}
+
+func TestExclusiveRegistration(t *testing.T) {
+	f := &inFlightGUIDs{guids: make(map[protocol.GUID]struct{})}
+	guid, other := protocol.GUID{0x01}, protocol.GUID{0x02}
+
+	// Registrations of the same GUID overlapping a long running one are
+	// rejected, while those of other GUIDs run concurrently
+	var running, registered atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = f.exclusive(guid, func() error {
+			registered.Add(1)
+			running.Add(1)
+			close(started)
+			<-release
+			running.Add(-1)
+			return nil
+		})
+	}()
+	<-started
+
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- f.exclusive(guid, func() error {
+				registered.Add(1)
+				return nil
+			})
+		}()
+	}
+	for range 10 {
+		if err := <-errs; !errors.Is(err, ErrRegistrationInProgress) {
+			t.Errorf("overlapping registration error is %v", err)
+		}
+	}
+	if err := f.exclusive(other, func() error { return nil }); err != nil {
+		t.Errorf("registration of another GUID failed: %v", err)
+	}
+	if n := running.Load(); n != 1 {
+		t.Errorf("%d registrations running", n)
+	}
+	close(release)
+	wg.Wait()
+	if n := registered.Load(); n != 1 {
+		t.Errorf("GUID registered %d times", n)
+	}
+
+	// The GUID is released once the registration completes, even if it failed
+	if err := f.exclusive(guid, func() error { return errors.New("connection refused") }); err == nil || errors.Is(err, ErrRegistrationInProgress) {
+		t.Errorf("registration after completion error is %v", err)
+	}
+	if err := f.exclusive(guid, func() error { return nil }); err != nil {
+		t.Errorf("registration after failure error is %v", err)
+	}
+}
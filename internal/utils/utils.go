@@ -4,6 +4,10 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"log/slog"
 	"regexp"
 
@@ -63,3 +67,15 @@ func IsValidGUID(guidHex string) bool {
 	re := regexp.MustCompile("^[a-fA-F0-9]{32}$")
 	return re.MatchString(guidHex)
 }
+
+// PublicKeyFingerprint returns the hex encoded SHA-256 hash of the PKIX
+// encoding of a public key. It is the same for a key and any certificate
+// issued for it, so operators can compare keys across hosts.
+func PublicKeyFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+// Package webhook notifies external services of onboarding events, such as
+// fleet managers that enroll devices once they complete TO2.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	TO2Completed = "to2.completed"
+	TO0Failed    = "to0.failed"
+)
+
+// Headers of notifications
+const (
+	EventHeader     = "X-FDO-Event"
+	SignatureHeader = "X-FDO-Signature"
+)
+
+// maxPendingDeliveries bounds the deliveries in progress, including their
+// retries. Notifications beyond it are dropped, so that an unreachable
+// receiver cannot exhaust the server.
+const maxPendingDeliveries = 256
+
+// Devmod is the part of the devmod service info of a device that describes
+// it.
+type Devmod struct {
+	OS      string   `json:"os"`
+	Arch    string   `json:"arch"`
+	Version string   `json:"version"`
+	Device  string   `json:"device"`
+	Modules []string `json:"modules,omitempty"`
+}
+
+// Event is the JSON payload of a notification.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// GUID is the GUID of the device before TO2, as hex
+	GUID string `json:"guid"`
+	// NewGUID is the GUID of the device after TO2, as hex
+	NewGUID    string  `json:"new_guid,omitempty"`
+	DeviceInfo string  `json:"device_info,omitempty"`
+	Devmod     *Devmod `json:"devmod,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Config configures the receivers of notifications and the retries of failed
+// deliveries.
+type Config struct {
+	URLs []string
+	// Secret signs the body of notifications with HMAC-SHA256, if set
+	Secret []byte
+	// MaxAttempts is the number of attempts to deliver a notification
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each retry
+	Backoff time.Duration
+	// Timeout bounds each attempt
+	Timeout time.Duration
+}
+
+var (
+	mu      sync.RWMutex
+	config  Config
+	client  = http.DefaultClient
+	pending = make(chan struct{}, maxPendingDeliveries)
+)
+
+// Configure sets the receivers of notifications. A config without URLs
+// disables notifications.
+func Configure(cfg Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = cfg
+	client = &http.Client{Timeout: cfg.Timeout}
+}
+
+// Enabled reports whether notifications are sent.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(config.URLs) > 0
+}
+
+// Notify sends event to each receiver in the background. Failed deliveries
+// are retried with exponential backoff and logged once they give up.
+func Notify(event Event) {
+	mu.RLock()
+	cfg, c := config, client
+	mu.RUnlock()
+	if len(cfg.URLs) == 0 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Error encoding webhook notification", "event", event.Type, "error", err)
+		return
+	}
+	for _, url := range cfg.URLs {
+		select {
+		case pending <- struct{}{}:
+		default:
+			slog.Warn("Dropping webhook notification, too many pending deliveries", "event", event.Type, "guid", event.GUID, "url", url)
+			continue
+		}
+		go func() {
+			defer func() { <-pending }()
+			deliver(c, cfg, url, event.Type, body)
+		}()
+	}
+}
+
+// Signature returns the value of the signature header of a notification with
+// body signed by secret, so that receivers can verify it.
+func Signature(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func deliver(c *http.Client, cfg Config, url, eventType string, body []byte) {
+	backoff := cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := post(c, cfg, url, eventType, body)
+		if err == nil {
+			slog.Debug("Delivered webhook notification", "event", eventType, "url", url, "attempt", attempt)
+			return
+		}
+		if !retry || attempt >= cfg.MaxAttempts {
+			slog.Error("Error delivering webhook notification", "event", eventType, "url", url, "attempts", attempt, "error", err)
+			return
+		}
+		slog.Debug("Retrying webhook notification", "event", eventType, "url", url, "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a notification once. It reports whether a failed delivery may
+// succeed when retried.
+func post(c *http.Client, cfg Config, url, eventType string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	if len(cfg.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Signature(cfg.Secret, body))
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver responded %s", resp.Status)
+	default:
+		return false, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+}
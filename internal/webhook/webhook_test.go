@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: (C) 2024 Intel Corporation
+// SPDX-License-Identifier: Apache 2.0
+
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	secret := []byte("webhook secret")
+	received := make(chan Event, 1)
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first attempt to exercise retries
+		if attempts.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got := r.Header.Get(SignatureHeader); got != Signature(secret, body) {
+			t.Errorf("signature is %q", got)
+		}
+		if got := r.Header.Get(EventHeader); got != TO2Completed {
+			t.Errorf("event header is %q", got)
+		}
+		var event Event
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+			return
+		}
+		received <- event
+	}))
+	defer srv.Close()
+
+	Configure(Config{URLs: []string{srv.URL}, Secret: secret, MaxAttempts: 3, Backoff: time.Millisecond, Timeout: time.Second})
+	defer Configure(Config{})
+
+	Notify(Event{Type: TO2Completed, GUID: "01", NewGUID: "02", DeviceInfo: "device", Devmod: &Devmod{OS: "linux"}})
+
+	select {
+	case event := <-received:
+		if event.GUID != "01" || event.NewGUID != "02" || event.Devmod == nil || event.Devmod.OS != "linux" || event.Time.IsZero() {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not delivered")
+	}
+	if n := attempts.Load(); n != 2 {
+		t.Errorf("delivered after %d attempts, want 2", n)
+	}
+}
+
+func TestPostRetry(t *testing.T) {
+	for status, wantRetry := range map[int]bool{
+		http.StatusInternalServerError: true,
+		http.StatusTooManyRequests:     true,
+		http.StatusBadRequest:          false,
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		retry, err := post(srv.Client(), Config{}, srv.URL, TO0Failed, []byte("{}"))
+		srv.Close()
+		if err == nil || retry != wantRetry {
+			t.Errorf("status %d: retry %v, error %v", status, retry, err)
+		}
+	}
+}